@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtime_pprof "runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/divergence"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/features"
+	"github.com/lian/gdax-bookmap/trading"
+	"github.com/lian/gdax-bookmap/tsdb"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// AdminServer serves debugging endpoints on a local address.
+type AdminServer struct {
+	Addr    string
+	DumpDir string
+	Mux     *http.ServeMux
+	Support *Support
+
+	captureMu sync.Mutex
+}
+
+func NewAdminServer(addr, dumpDir string) *AdminServer {
+	s := &AdminServer{
+		Addr:    addr,
+		DumpDir: dumpDir,
+		Mux:     http.NewServeMux(),
+	}
+
+	s.Mux.HandleFunc("/debug/pprof/", pprof.Index)
+	s.Mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.Mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.Mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.Mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.Mux.HandleFunc("/debug/capture", s.handleCapture)
+	s.Mux.HandleFunc("/debug/bundle", s.handleBundle)
+	s.Mux.HandleFunc("/trading/latency", s.handleLatency)
+	s.Mux.HandleFunc("/trading/portfolio", s.handlePortfolio)
+	s.Mux.HandleFunc("/trading/budget", s.handleBudget)
+	s.Mux.HandleFunc("/bandwidth", s.handleBandwidth)
+	s.Mux.HandleFunc("/divergence", s.handleDivergence)
+	s.Mux.HandleFunc("/malformed", s.handleMalformed)
+	s.Mux.HandleFunc("/features", s.handleFeatures)
+
+	return s
+}
+
+func (s *AdminServer) Run() {
+	fmt.Println("admin server listening on", s.Addr)
+	if err := http.ListenAndServe(s.Addr, s.Mux); err != nil {
+		fmt.Println("admin server", err)
+	}
+}
+
+// handleCapture records a cpu profile or execution trace into DumpDir and
+// responds with the file path, e.g. /debug/capture?kind=trace&seconds=30
+func (s *AdminServer) handleCapture(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = "cpu"
+	}
+	seconds, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+	if err != nil || seconds <= 0 {
+		seconds = 30
+	}
+
+	path, err := s.Capture(kind, time.Duration(seconds)*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, path)
+}
+
+// handleBundle writes a support bundle and responds with its path.
+func (s *AdminServer) handleBundle(w http.ResponseWriter, r *http.Request) {
+	if s.Support == nil {
+		http.Error(w, "support bundles not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	path, err := s.Support.WriteBundle("request")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Println("admin: support bundle written to", path)
+	fmt.Fprintln(w, path)
+}
+
+// handleLatency responds with the order latency percentiles in milliseconds
+// per exchange, collected while BINANCE_API_KEY is set.
+func (s *AdminServer) handleLatency(w http.ResponseWriter, r *http.Request) {
+	if tracker == nil {
+		http.Error(w, "trading not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(tracker.Latency.Stats())
+}
+
+// handleBudget responds with the orders allowed, queued and rejected by the
+// order rate budget per exchange, see Control.Submit.
+func (s *AdminServer) handleBudget(w http.ResponseWriter, r *http.Request) {
+	if orderBudget == nil {
+		http.Error(w, "trading not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(orderBudget.Stats())
+}
+
+// handlePortfolio responds with the balances and positions of the user
+// streams summed over the exchanges and valued in USD, as of the last
+// second of the viewer.
+func (s *AdminServer) handlePortfolio(w http.ResponseWriter, r *http.Request) {
+	portfolio, ok := lastPortfolio.Load().(*trading.Portfolio)
+	if !ok {
+		http.Error(w, "portfolio not built yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(portfolio)
+}
+
+// handleBandwidth responds with the bytes moved by the websocket of every
+// platform and the rates of the current connections in bytes per second.
+func (s *AdminServer) handleBandwidth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(common.Meter.Stats())
+}
+
+// handleMalformed responds with how many fields of the venue messages did
+// not look like documented, by platform and field, see -parse.
+func (s *AdminServer) handleMalformed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(common.Malformed.Stats())
+}
+
+// handleFeatures responds with the features and whether they are on, a
+// POST switches one first, e.g. /features?name=parse.lenient&enabled=true
+func (s *AdminServer) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		query := r.URL.Query()
+		enabled, err := strconv.ParseBool(query.Get("enabled"))
+		if err != nil {
+			http.Error(w, "expected enabled=true or enabled=false", http.StatusBadRequest)
+			return
+		}
+		if err := features.Set(query.Get("name"), enabled); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		fmt.Println("admin: feature", query.Get("name"), "enabled", enabled)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(features.List())
+}
+
+// handleDivergence responds with the last window of every comparison of
+// two recordings of one product, see -binance-depth-variant, or with the
+// stored windows of one comparison, e.g.
+// /divergence?a=Binance-BTC-USDT&b=Binance-BTC-USDT@100ms&from=2018-01-02T15:00:00Z
+func (s *AdminServer) handleDivergence(w http.ResponseWriter, r *http.Request) {
+	windows := []*divergence.Window{}
+	query := r.URL.Query()
+	if a, b := query.Get("a"), query.Get("b"); a != "" && b != "" && len(comparators) > 0 {
+		var from time.Time
+		if value := query.Get("from"); value != "" {
+			var err error
+			if from, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var err error
+		if windows, err = divergence.ListWindows(comparators[0].DB, a, b, from, time.Time{}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		for _, c := range comparators {
+			if last := c.Last(); last != nil {
+				windows = append(windows, last)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(windows)
+}
+
+// ServeDB adds the endpoints reading the recordings once the database is
+// open
+//
+//	/impact?product=GDAX-BTC-USD&side=buy&size=10&at=2018-01-02T15:04:05Z
+//	    estimates a buy of 10 against the book at that time, without at
+//	    against the live book
+//	/level?product=GDAX-BTC-USD&price=7000&from=...&to=...
+//	    size over time of one price level, to defaults to now
+//	/series?product=GDAX-BTC-USD&metric=quality.score&from=...&step=24h&agg=min
+//	    points of a derived metric, downsampled when step is given, the
+//	    metrics of the product without metric
+func (s *AdminServer) ServeDB(db *bolt.DB) {
+	s.Mux.HandleFunc("/level", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		price, err := strconv.ParseFloat(query.Get("price"), 64)
+		if err != nil || price <= 0 {
+			http.Error(w, "price must be a positive number", http.StatusBadRequest)
+			return
+		}
+		from, err := time.Parse(time.RFC3339, query.Get("from"))
+		if err != nil {
+			http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		to := time.Now()
+		if value := query.Get("to"); value != "" {
+			if to, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		points, err := util.LevelSeries(db, query.Get("product"), price, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(points)
+	})
+
+	s.Mux.HandleFunc("/series", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		product := query.Get("product")
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if query.Get("metric") == "" {
+			enc.Encode(tsdb.Metrics(db, product))
+			return
+		}
+
+		var from, to time.Time
+		var step time.Duration
+		var err error
+		if value := query.Get("from"); value != "" {
+			if from, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if value := query.Get("to"); value != "" {
+			if to, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if value := query.Get("step"); value != "" {
+			if step, err = time.ParseDuration(value); err != nil {
+				http.Error(w, "step: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		agg, err := tsdb.ParseAggregate(query.Get("agg"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		points, err := tsdb.Query(db, product, query.Get("metric"), from, to, step, agg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		enc.Encode(points)
+	})
+
+	s.Mux.HandleFunc("/impact", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		size, err := strconv.ParseFloat(query.Get("size"), 64)
+		if err != nil || size <= 0 {
+			http.Error(w, "size must be a positive number", http.StatusBadRequest)
+			return
+		}
+		side := query.Get("side")
+		if side == "" {
+			side = "buy"
+		}
+		if side != "buy" && side != "sell" {
+			http.Error(w, "side must be buy or sell", http.StatusBadRequest)
+			return
+		}
+		at := time.Now()
+		if value := query.Get("at"); value != "" {
+			if at, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		book, _, err := util.BookAt(db, query.Get("product"), at)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		estimate := book.MarketOrder(side == "buy", size)
+		if estimate == nil {
+			http.Error(w, "book is empty", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(estimate)
+	})
+}
+
+func (s *AdminServer) Capture(kind string, duration time.Duration) (string, error) {
+	if kind != "cpu" && kind != "trace" {
+		return "", fmt.Errorf("unknown capture kind %q", kind)
+	}
+
+	// only one profile or trace can run at a time
+	s.captureMu.Lock()
+	defer s.captureMu.Unlock()
+
+	path := filepath.Join(s.DumpDir, fmt.Sprintf("bookmap-%s-%s.out", kind, time.Now().Format("20060102-150405")))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if kind == "cpu" {
+		if err := runtime_pprof.StartCPUProfile(file); err != nil {
+			return "", err
+		}
+		time.Sleep(duration)
+		runtime_pprof.StopCPUProfile()
+	} else {
+		if err := trace.Start(file); err != nil {
+			return "", err
+		}
+		time.Sleep(duration)
+		trace.Stop()
+	}
+
+	fmt.Println("admin: captured", kind, "to", path)
+	return path, nil
+}
+
+// WatchHeap writes a heap profile every time the heap grows past the
+// threshold, which is then raised by half to avoid flooding the disk.
+func WatchHeap(threshold uint64, dumpDir string) {
+	var stats runtime.MemStats
+	for range time.Tick(30 * time.Second) {
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc < threshold {
+			continue
+		}
+
+		path := filepath.Join(dumpDir, fmt.Sprintf("bookmap-heap-%s.out", time.Now().Format("20060102-150405")))
+		if err := writeHeapProfile(path); err != nil {
+			fmt.Println("heap snapshot", err)
+		} else {
+			fmt.Println("heap", stats.HeapAlloc>>20, "MB, snapshot written to", path)
+		}
+		threshold += threshold / 2
+	}
+}
+
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return runtime_pprof.WriteHeapProfile(file)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	opengl_bookmap "github.com/lian/gdax-bookmap/opengl/bookmap"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// the recording up to an alert is committed this long after it
+const alertScreenshotDelay = 2 * time.Second
+
+// AlertScreenshots renders the graph of a product offscreen whenever one
+// of its alerts fires, the minutes before the alert with its label
+// annotated, and optionally a clip of one frame per second after it.
+type AlertScreenshots struct {
+	DB    *bolt.DB
+	Infos []*product_info.Info
+	Dir   string
+	// history shown before the alert
+	Window time.Duration
+	// seconds of frames after the alert, 0 only takes the screenshot
+	Clip int
+	// alerts of a product this soon after the last captured one are skipped
+	Cooldown time.Duration
+	Width    int
+	Height   int
+
+	last map[string]time.Time
+}
+
+func NewAlertScreenshots(db *bolt.DB, infos []*product_info.Info, dir string) *AlertScreenshots {
+	return &AlertScreenshots{
+		DB:       db,
+		Infos:    infos,
+		Dir:      dir,
+		Window:   10 * time.Minute,
+		Cooldown: time.Minute,
+		Width:    1600,
+		Height:   900,
+		last:     map[string]time.Time{},
+	}
+}
+
+func (a *AlertScreenshots) Run() {
+	if err := os.MkdirAll(a.Dir, 0755); err != nil {
+		fmt.Println("alert screenshots", err)
+		return
+	}
+	topics := []string{}
+	infos := map[string]*product_info.Info{}
+	for _, info := range a.Infos {
+		topics = append(topics, util.AlertTopic(info.DatabaseKey))
+		infos[info.DatabaseKey] = info
+	}
+
+	for {
+		sub := util.Events.Subscribe(topics, 256)
+		for ev := range sub.C {
+			info := infos[strings.TrimPrefix(ev.Topic, "alert.")]
+			if info == nil {
+				continue
+			}
+			t := orderbook.UnpackTimeKey(ev.Key)
+			if t.Sub(a.last[info.DatabaseKey]) < a.Cooldown {
+				continue
+			}
+			a.last[info.DatabaseKey] = t
+			go a.Capture(info, t, string(ev.Data))
+		}
+		fmt.Println("alert screenshots fell behind, resubscribing")
+	}
+}
+
+// Capture writes alert-<product>-<time>.png and the frames of the clip as
+// alert-<product>-<time>-<second>.png, waiting for the recording of every
+// frame to be stored.
+func (a *AlertScreenshots) Capture(info *product_info.Info, t time.Time, label string) {
+	name := fmt.Sprintf("alert-%s-%s", strings.ToLower(info.DatabaseKey), t.UTC().Format("20060102-150405"))
+	for second := 0; second <= a.Clip; second += 1 {
+		end := t.Add(time.Duration(second) * time.Second)
+		time.Sleep(time.Until(end.Add(alertScreenshotDelay)))
+
+		path := filepath.Join(a.Dir, name+".png")
+		if second > 0 {
+			path = filepath.Join(a.Dir, fmt.Sprintf("%s-%02d.png", name, second))
+		}
+		if err := a.render(info, t, end, label, path); err != nil {
+			fmt.Println("alert screenshots", info.DatabaseKey, err)
+			return
+		}
+		if second == 0 {
+			fmt.Println("alert screenshot", path)
+		}
+	}
+}
+
+func (a *AlertScreenshots) render(info *product_info.Info, t, end time.Time, label, path string) error {
+	bm := opengl_bookmap.NewHeadless(float64(a.Width), float64(a.Height), *info, a.DB)
+	if !bm.RenderView(end.Add(-a.Window), end) {
+		return fmt.Errorf("nothing recorded before %s", end)
+	}
+	lines := []string{
+		fmt.Sprintf("%s  alert at %s UTC", info.DatabaseKey, t.UTC().Format("2006-01-02 15:04:05")),
+		label,
+	}
+	if end.After(t) {
+		lines = append(lines, fmt.Sprintf("+%s", end.Sub(t)))
+	}
+	bm.Annotate(lines...)
+	return writePNG(path, bm.Image)
+}
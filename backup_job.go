@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/episodes"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// BackupJob verifies the previous UTC day of every product once a day,
+// stores its quality score, optionally adds it to the level index and
+// scans it for high volatility episodes, writes
+// the day into an archive file and copies it to Dest, a directory or
+// s3://bucket/prefix. The outcome of every product is bookmarked at the
+// end of the day, so it also goes out as alert.
+type BackupJob struct {
+	DB    *bolt.DB
+	Infos []*product_info.Info
+	Dest  string
+	At    string // time of day in UTC, e.g. 00:30
+	// longer times without packets count as gaps of the day
+	MaxSilence time.Duration
+	// also add the day to the per level index
+	IndexLevels bool
+	// also scan the day for high volatility episodes
+	Episodes bool
+}
+
+func NewBackupJob(db *bolt.DB, infos []*product_info.Info, dest string) *BackupJob {
+	return &BackupJob{
+		DB:         db,
+		Infos:      infos,
+		Dest:       dest,
+		At:         "00:30",
+		MaxSilence: time.Minute,
+	}
+}
+
+func (j *BackupJob) Run() {
+	for {
+		t, err := nextDaily(j.At, time.Now())
+		if err != nil {
+			fmt.Println("backup job", err)
+			return
+		}
+		time.Sleep(time.Until(t))
+		j.RunOnce(t)
+	}
+}
+
+// RunOnce backs up the day before now, returns the first error after all
+// products were tried.
+func (j *BackupJob) RunOnce(now time.Time) error {
+	day := now.UTC().Truncate(util.QualityDay).Add(-util.QualityDay)
+	end := day.Add(util.QualityDay)
+
+	var first error
+	for _, info := range j.Infos {
+		q, result, err := j.backup(info.DatabaseKey, day)
+		var label string
+		if err != nil {
+			label = i18n.Sprintf("backup %s failed: %s", day.Format("2006-01-02"), err)
+			if first == nil {
+				first = err
+			}
+		} else {
+			label = i18n.Sprintf("backup %s score %.1f %d packets", day.Format("2006-01-02"), q.Score, result.Packets)
+		}
+		fmt.Println("backup job", info.DatabaseKey, label)
+		if err := util.AddBookmark(j.DB, info.DatabaseKey, end, label); err != nil {
+			fmt.Println("backup job", err)
+		}
+	}
+	return first
+}
+
+func (j *BackupJob) backup(product string, day time.Time) (*util.Quality, *util.ArchiveResult, error) {
+	q, err := util.RateDay(j.DB, product, day, j.MaxSilence)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := util.PutQuality(j.DB, product, q); err != nil {
+		return nil, nil, err
+	}
+	if j.IndexLevels {
+		if _, err := util.IndexLevels(j.DB, product, day); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if j.Episodes {
+		if _, err := episodes.NewScanner(j.DB).Run(product, day, day.Add(util.QualityDay)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	name := fmt.Sprintf("%s-%s.bma", strings.ToLower(product), day.Format("2006-01-02"))
+	from, to := day, day.Add(util.QualityDay-time.Nanosecond)
+
+	if !strings.HasPrefix(j.Dest, "s3://") {
+		if err := os.MkdirAll(j.Dest, 0755); err != nil {
+			return nil, nil, err
+		}
+		result, err := util.ExportArchive(j.DB, filepath.Join(j.Dest, name), product, from, to)
+		return q, result, err
+	}
+
+	path := filepath.Join(os.TempDir(), name)
+	// left over by a failed upload
+	os.Remove(path)
+	result, err := util.ExportArchive(j.DB, path, product, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(path)
+	if err := util.S3Upload(j.Dest, name, path); err != nil {
+		return nil, nil, err
+	}
+	result.Out = strings.TrimSuffix(j.Dest, "/") + "/" + name
+	return q, result, nil
+}
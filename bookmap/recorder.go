@@ -0,0 +1,208 @@
+package bookmap
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/exchanges"
+	_ "github.com/lian/gdax-bookmap/exchanges/builtin"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// events a subscriber may fall behind before its channel is closed
+const subscriptionQueue = 8192
+
+type Config struct {
+	// database file, empty records into a temporary file removed by Close
+	Path string
+	// encrypts a new database or unlocks an encrypted one
+	Passphrase string
+	// products by registered platform, e.g. {"gdax": {"BTC-USD"}}
+	Products map[string][]string
+	// workers maintaining the books, 0 uses one per CPU
+	Shards int
+}
+
+// Recorder records the books and trades of venues into a database, like
+// the gdax-bookmap app does without a window.
+type Recorder struct {
+	db      *bolt.DB
+	scratch bool
+	// events of db only, other recorders of the process publish on theirs
+	bus        *util.Bus
+	infos      []*product_info.Info
+	connectors []exchanges.Connector
+	started    bool
+}
+
+// NewRecorder opens the database and connects nothing until Start.
+func NewRecorder(cfg Config) (*Recorder, error) {
+	r := &Recorder{infos: []*product_info.Info{}, bus: util.NewBus()}
+	var err error
+	if cfg.Path == "" {
+		r.db, err = util.OpenScratchDB()
+		r.scratch = true
+	} else {
+		r.db, err = util.OpenDB(cfg.Path, []string{}, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	util.SetBus(r.db, r.bus)
+	if cfg.Passphrase != "" {
+		if err := util.EnableEncryption(r.db, cfg.Passphrase); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+
+	products := map[string][]string{}
+	for platform, names := range cfg.Products {
+		if _, ok := exchanges.FactoryOf(platform); !ok {
+			r.Close()
+			return nil, fmt.Errorf("unknown platform %q, see exchanges.Registered", platform)
+		}
+		products[strings.ToLower(platform)] = names
+	}
+	shards := util.NewShards(cfg.Shards, 1024)
+	for _, platform := range exchanges.Registered() {
+		names, ok := products[platform]
+		if !ok {
+			continue
+		}
+		factory, _ := exchanges.FactoryOf(platform)
+		r.add(factory(exchanges.Settings{
+			Products:     names,
+			DB:           r.db,
+			Shards:       shards,
+			PollInterval: 10 * time.Second,
+		}))
+	}
+	if len(r.infos) == 0 {
+		r.Close()
+		return nil, fmt.Errorf("no known products in %v", cfg.Products)
+	}
+	return r, nil
+}
+
+func (r *Recorder) add(c exchanges.Connector, infos []*product_info.Info) {
+	r.infos = append(r.infos, infos...)
+	r.connectors = append(r.connectors, c)
+}
+
+// Start connects to the venues, once. The connections reconnect on their
+// own and run until Close.
+func (r *Recorder) Start() {
+	if r.started {
+		return
+	}
+	r.started = true
+	for _, c := range r.connectors {
+		util.Go(c.Run)
+	}
+}
+
+func (r *Recorder) Products() []Product {
+	products := make([]Product, len(r.infos))
+	for i, info := range r.infos {
+		products[i] = productOf(info)
+	}
+	return products
+}
+
+func (r *Recorder) known(product string) error {
+	for _, info := range r.infos {
+		if info.DatabaseKey == product {
+			return nil
+		}
+	}
+	return fmt.Errorf("product %s is not recorded", product)
+}
+
+// Book returns the best depth levels per side (0 for all) of the last
+// stored book of a product.
+func (r *Recorder) Book(product string, depth int) (*Book, error) {
+	if err := r.known(product); err != nil {
+		return nil, err
+	}
+	book, last, err := util.BookAt(r.db, product, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return BookOf(product, last, book, depth), nil
+}
+
+// Books returns the last stored book of every product which has one yet,
+// by product key.
+func (r *Recorder) Books() map[string]*Book {
+	books := map[string]*Book{}
+	for _, info := range r.infos {
+		if book, err := r.Book(info.DatabaseKey, 0); err == nil {
+			books[info.DatabaseKey] = book
+		}
+	}
+	return books
+}
+
+// Subscription delivers the events of its products as they are stored. C
+// is closed after Close or when the subscriber fell too far behind, then
+// Book and a new Subscribe start over.
+type Subscription struct {
+	C   <-chan *Event
+	bus *util.Bus
+	sub *util.Subscription
+}
+
+// Subscribe receives the packets stored for products from now on, all
+// products when none are given.
+func (r *Recorder) Subscribe(products ...string) *Subscription {
+	if len(products) == 0 {
+		for _, info := range r.infos {
+			products = append(products, info.DatabaseKey)
+		}
+	}
+	topics := []string{}
+	for _, product := range products {
+		topics = append(topics, util.ProductTopics(product)...)
+	}
+	sub := r.bus.Subscribe(topics, subscriptionQueue)
+	c := make(chan *Event, subscriptionQueue)
+	util.Go(func() {
+		defer close(c)
+		for ev := range sub.C {
+			e := EventOf(ev.Bucket, orderbook.UnpackTimeKey(ev.Key), ev.Data)
+			if e == nil {
+				continue
+			}
+			select {
+			case c <- e:
+			default:
+				r.bus.Unsubscribe(sub)
+				return
+			}
+		}
+	})
+	return &Subscription{C: c, bus: r.bus, sub: sub}
+}
+
+func (s *Subscription) Close() {
+	s.bus.Unsubscribe(s.sub)
+}
+
+// Close stops the venue connections and closes the database. Packets the
+// connections still flush while stopping are dropped.
+func (r *Recorder) Close() error {
+	for _, c := range r.connectors {
+		c.Stop()
+	}
+	util.SetBus(r.db, nil)
+	if r.scratch {
+		util.CloseScratchDB(r.db)
+		return nil
+	}
+	return r.db.Close()
+}
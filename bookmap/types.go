@@ -0,0 +1,149 @@
+// Package bookmap is the API for Go programs embedding the recorder, see
+// NewRecorder, and the replay of its database files, see the replay
+// package. Its types stay the same between versions, the packages they are
+// built on may change.
+package bookmap
+
+import (
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+)
+
+// Product is a recorded product, Key names it in every call, e.g.
+// GDAX-BTC-USD.
+type Product struct {
+	Key      string `json:"key"`
+	Platform string `json:"platform"`
+	ID       string `json:"id"`
+	Base     string `json:"base"`
+	Quote    string `json:"quote"`
+	// price increment and smallest order size
+	Tick    float64 `json:"tick"`
+	MinSize float64 `json:"min_size"`
+}
+
+func productOf(info *product_info.Info) Product {
+	return Product{
+		Key:      info.DatabaseKey,
+		Platform: info.Platform,
+		ID:       info.ID,
+		Base:     info.BaseCurrency,
+		Quote:    info.QuoteCurrency,
+		Tick:     float64(info.QuoteIncrement),
+		MinSize:  float64(info.BaseMinSize),
+	}
+}
+
+type Level struct {
+	Price float64 `json:"price"`
+	Size  float64 `json:"size"`
+}
+
+// Book is an order book at Time, best levels first.
+type Book struct {
+	Product string    `json:"product"`
+	Time    time.Time `json:"time"`
+	Bids    []Level   `json:"bids"`
+	Asks    []Level   `json:"asks"`
+	// last mark price of futures at Time, nil for spot
+	Mark *MarkPrice `json:"mark,omitempty"`
+}
+
+// BookOf copies the best depth levels per side of a replayed book, all
+// levels when depth is 0.
+func BookOf(product string, t time.Time, book *orderbook.Book, depth int) *Book {
+	book.Sort()
+	b := &Book{Product: product, Time: t, Bids: []Level{}, Asks: []Level{}}
+	for i := len(book.Bid) - 1; i >= 0 && (depth <= 0 || len(b.Bids) < depth); i -= 1 {
+		if book.Bid[i].Quantity > 0 {
+			b.Bids = append(b.Bids, Level{Price: book.Bid[i].Price, Size: book.Bid[i].Quantity})
+		}
+	}
+	for i := 0; i < len(book.Ask) && (depth <= 0 || len(b.Asks) < depth); i += 1 {
+		if book.Ask[i].Quantity > 0 {
+			b.Asks = append(b.Asks, Level{Price: book.Ask[i].Price, Size: book.Ask[i].Quantity})
+		}
+	}
+	if m := book.Mark; m != nil {
+		b.Mark = &MarkPrice{Mark: m.Mark, Index: m.Index, FundingRate: m.FundingRate, NextFunding: m.NextFunding}
+	}
+	return b
+}
+
+type Trade struct {
+	// side of the book the trade took, bid or ask
+	Side  string  `json:"side"`
+	Price float64 `json:"price"`
+	Size  float64 `json:"size"`
+}
+
+// MarkPrice is the mark price and funding of a futures contract.
+type MarkPrice struct {
+	Mark        float64   `json:"mark"`
+	Index       float64   `json:"index"`
+	FundingRate float64   `json:"funding_rate"`
+	NextFunding time.Time `json:"next_funding"`
+}
+
+// Event is one recorded packet of a product.
+type Event struct {
+	Product string    `json:"product"`
+	Time    time.Time `json:"time"`
+	// the book was replaced, Bids and Asks hold all of its levels
+	Sync bool `json:"sync,omitempty"`
+	// changed levels, size 0 removes the level
+	Bids []Level `json:"bids,omitempty"`
+	Asks []Level `json:"asks,omitempty"`
+	// the recording has a gap here, the book is unknown until the next sync
+	// unless Bridged
+	Gap bool `json:"gap,omitempty"`
+	// the book is kept over the gap, the next event brings it up to date
+	Bridged bool       `json:"bridged,omitempty"`
+	Trade   *Trade     `json:"trade,omitempty"`
+	Mark    *MarkPrice `json:"mark,omitempty"`
+}
+
+// EventOf decodes a stored packet, nil for packets only the viewer uses.
+func EventOf(product string, t time.Time, data []byte) *Event {
+	if len(data) == 0 {
+		return nil
+	}
+	ev := &Event{Product: product, Time: t}
+	switch data[0] {
+	case orderbook.SyncPacket, orderbook.DegradedSyncPacket:
+		_, bids, asks := orderbook.UnpackSync(data)
+		ev.Sync = true
+		ev.Bids, ev.Asks = levelsOf(bids), levelsOf(asks)
+	case orderbook.DiffPacket:
+		_, _, bids, asks := orderbook.UnpackDiff(data)
+		ev.Bids, ev.Asks = levelsOf(bids), levelsOf(asks)
+	case orderbook.GapPacket:
+		ev.Gap = true
+		_, ev.Bridged = orderbook.UnpackBridgedGap(data)
+	case orderbook.TradePacket:
+		side, price, size := orderbook.UnpackTrade(data)
+		ev.Trade = &Trade{Side: "bid", Price: price, Size: size}
+		if side == orderbook.AskSide {
+			ev.Trade.Side = "ask"
+		}
+	case orderbook.MarkPricePacket:
+		m := orderbook.UnpackMarkPrice(data)
+		if m == nil {
+			return nil
+		}
+		ev.Mark = &MarkPrice{Mark: m.Mark, Index: m.Index, FundingRate: m.FundingRate, NextFunding: m.NextFunding}
+	default:
+		return nil
+	}
+	return ev
+}
+
+func levelsOf(states []orderbook.OrderState) []Level {
+	levels := make([]Level, len(states))
+	for i, s := range states {
+		levels[i] = Level{Price: s.Price, Size: s.Size}
+	}
+	return levels
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["annotations"] = command{
+		Usage: "print the saved annotations of a product, or all kept revisions",
+		Run:   runAnnotations,
+	}
+}
+
+type AnnotationsReport struct {
+	Product     string              `json:"product"`
+	Bookmarks   []*util.Bookmark    `json:"bookmarks"`
+	Annotations []*util.Annotations `json:"annotations"`
+}
+
+func runAnnotations(args []string) error {
+	var dbPath, product string
+	var revision uint64
+	var all bool
+
+	flags := flag.NewFlagSet("annotations", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&product, "product", "", "product database key, e.g. GDAX-BTC-USD")
+	flags.Uint64Var(&revision, "revision", 0, "revision to print (0 for the latest)")
+	flags.BoolVar(&all, "all", false, "print every kept revision, oldest first")
+	flags.Parse(args)
+
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+
+	db, err := openDB(dbPath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report := &AnnotationsReport{
+		Product:     product,
+		Bookmarks:   util.ListBookmarks(db, product),
+		Annotations: []*util.Annotations{},
+	}
+	if all {
+		report.Annotations = util.ListAnnotationRevisions(db, product)
+	} else {
+		a, err := util.AnnotationsRevision(db, product, revision)
+		if err != nil {
+			return err
+		}
+		if a == nil && revision != 0 {
+			return fmt.Errorf("revision %d of %s is not kept", revision, product)
+		}
+		if a != nil {
+			report.Annotations = append(report.Annotations, a)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
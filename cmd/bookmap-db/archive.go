@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["archive"] = command{
+		Usage: "write one product (or a time range of it) into an archive file",
+		Run:   runArchive,
+	}
+	commands["book"] = command{
+		Usage: "print the book of an archive file at a point in time",
+		Run:   runBook,
+	}
+}
+
+func runArchive(args []string) error {
+	var dbPath, outPath, product, fromValue, toValue string
+
+	flags := flag.NewFlagSet("archive", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&outPath, "out", "", "new archive file")
+	flags.StringVar(&product, "product", "", "product database key, e.g. GDAX-BTC-USD")
+	flags.StringVar(&fromValue, "from", "", "start time (RFC3339), moved back to the previous sync")
+	flags.StringVar(&toValue, "to", "", "end time (RFC3339)")
+	flags.Parse(args)
+
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+	if outPath == "" {
+		return fmt.Errorf("missing -out")
+	}
+	if _, err := os.Stat(outPath); err == nil {
+		return fmt.Errorf("%s already exists", outPath)
+	}
+	from, err := parseTimeFlag(fromValue)
+	if err != nil {
+		return err
+	}
+	to, err := parseTimeFlag(toValue)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(dbPath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := util.ExportArchive(db, outPath, product, from, to)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+type BookLevelResult struct {
+	Price float64 `json:"price"`
+	Size  float64 `json:"size"`
+}
+
+type BookResult struct {
+	Product string    `json:"product"`
+	Time    time.Time `json:"time"`
+	Sync    time.Time `json:"sync"`
+	// the REST snapshot behind the sync, if it came from one
+	Origin  *orderbook.SyncProvenance `json:"origin,omitempty"`
+	Packets int                       `json:"packets"`
+	Bids    []*BookLevelResult        `json:"bids"`
+	Asks    []*BookLevelResult        `json:"asks"`
+}
+
+func runBook(args []string) error {
+	var archivePath, atValue string
+	var depth int
+
+	flags := flag.NewFlagSet("book", flag.ExitOnError)
+	flags.StringVar(&archivePath, "archive", "", "archive file")
+	flags.StringVar(&atValue, "at", "", "time (RFC3339), default the end of the archive")
+	flags.IntVar(&depth, "depth", 10, "levels per side")
+	flags.Parse(args)
+
+	if archivePath == "" {
+		return fmt.Errorf("missing -archive")
+	}
+	at, err := parseTimeFlag(atValue)
+	if err != nil {
+		return err
+	}
+
+	a, err := util.OpenArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	if a.Header.Key != nil {
+		passphrase := os.Getenv("BOOKMAP_PASSPHRASE")
+		if passphrase == "" {
+			return fmt.Errorf("archive is encrypted, set BOOKMAP_PASSPHRASE")
+		}
+		if err := a.Unlock(passphrase); err != nil {
+			return err
+		}
+	}
+
+	c := a.Cursor()
+	if at.IsZero() {
+		key, _ := c.Last()
+		if key == nil {
+			return fmt.Errorf("archive is empty")
+		}
+		at = orderbook.UnpackTimeKey(key)
+	}
+
+	result := &BookResult{Product: a.Header.Product, Time: at, Bids: []*BookLevelResult{}, Asks: []*BookLevelResult{}}
+	key, value := c.SeekSync(at)
+	if key == nil {
+		return fmt.Errorf("no sync before %s", at)
+	}
+	result.Sync = orderbook.UnpackTimeKey(key)
+	result.Origin = orderbook.UnpackSyncProvenance(value)
+
+	book := orderbook.New(a.Header.Product)
+	for ; key != nil; key, value = c.Next() {
+		t := orderbook.UnpackTimeKey(key)
+		if t.After(at) {
+			break
+		}
+		book.Process(t, value)
+		result.Packets += 1
+	}
+
+	for i := len(book.Bid) - 1; i >= 0 && len(result.Bids) < depth; i -= 1 {
+		result.Bids = append(result.Bids, &BookLevelResult{Price: book.Bid[i].Price, Size: book.Bid[i].Quantity})
+	}
+	for i := 0; i < len(book.Ask) && len(result.Asks) < depth; i += 1 {
+		result.Asks = append(result.Asks, &BookLevelResult{Price: book.Ask[i].Price, Size: book.Ask[i].Quantity})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
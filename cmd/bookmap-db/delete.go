@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["delete"] = command{
+		Usage: "delete a time range of a product and leave a gap marker",
+		Run:   runDelete,
+	}
+}
+
+// keys deleted per transaction
+const deleteBatchSize = 10000
+
+type DeleteResult struct {
+	Product string    `json:"product"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Deleted int       `json:"deleted"`
+	DryRun  bool      `json:"dry_run"`
+}
+
+// DeleteRange removes all packets from `from` up to the first sync at or
+// after `to`, so the data following the gap starts with a full book. A gap
+// packet is stored in place of the first removed packet.
+func DeleteRange(db *bolt.DB, product string, from, to time.Time, dryRun bool) (*DeleteResult, error) {
+	result := &DeleteResult{Product: product, DryRun: dryRun}
+	keys := [][]byte{}
+	rewrite := []extractPacket{}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := util.NewCursor(db, b)
+		end := orderbook.PackTimeKey(to)
+
+		for key, buf := c.Seek(orderbook.PackTimeKey(from)); key != nil; key, buf = c.Next() {
+			if bytes.Compare(key, end) >= 0 && orderbook.IsSyncPacket(buf) {
+				result.To = orderbook.UnpackTimeKey(key)
+				break
+			}
+			keys = append(keys, []byte(string(key)))
+		}
+		if len(keys) == 0 || result.To.IsZero() {
+			return nil
+		}
+
+		// delta syncs up to the next keyframe may reference one being removed
+		first, last := orderbook.UnpackTimeKey(keys[0]).UnixNano(), orderbook.UnpackTimeKey(keys[len(keys)-1]).UnixNano()
+		rc := b.Cursor()
+		for key, value := rc.Seek(orderbook.PackTimeKey(result.To)); key != nil; key, value = rc.Next() {
+			plain, err := util.Unseal(db, value)
+			if err != nil || (len(plain) > 0 && plain[0] == orderbook.SyncPacket) {
+				break
+			}
+			if !orderbook.IsSyncDeltaPacket(plain) {
+				continue
+			}
+			if ref := orderbook.SyncDeltaReference(plain); ref < first || ref > last {
+				continue
+			}
+			full, err := util.ExpandSync(db, b, plain)
+			if err != nil {
+				return err
+			}
+			rewrite = append(rewrite, extractPacket{Key: []byte(string(key)), Value: util.Seal(db, full)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Deleted = len(keys)
+	if len(keys) == 0 {
+		return result, nil
+	}
+	result.From = orderbook.UnpackTimeKey(keys[0])
+	if result.To.IsZero() {
+		// no sync after the range, everything until the end was removed
+		result.To = orderbook.UnpackTimeKey(keys[len(keys)-1])
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	if len(rewrite) > 0 {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(product))
+			for _, pkt := range rewrite {
+				if err := b.Put(pkt.Key, pkt.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < len(keys); i += deleteBatchSize {
+		batch := keys[i:]
+		if len(batch) > deleteBatchSize {
+			batch = batch[:deleteBatchSize]
+		}
+		err := db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(product))
+			for _, key := range batch {
+				if err := b.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		return b.Put(keys[0], util.Seal(db, orderbook.PackGap(result.From, result.To)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func runDelete(args []string) error {
+	var dbPath, product, fromValue, toValue string
+	var dryRun bool
+
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&product, "product", "", "product database key, e.g. GDAX-BTC-USD")
+	flags.StringVar(&fromValue, "from", "", "start time (RFC3339)")
+	flags.StringVar(&toValue, "to", "", "end time (RFC3339), extended to the next sync")
+	flags.BoolVar(&dryRun, "dry-run", false, "only report what would be deleted")
+	flags.Parse(args)
+
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+	if fromValue == "" || toValue == "" {
+		return fmt.Errorf("missing -from or -to")
+	}
+	from, err := parseTimeFlag(fromValue)
+	if err != nil {
+		return err
+	}
+	to, err := parseTimeFlag(toValue)
+	if err != nil {
+		return err
+	}
+	if !to.After(from) {
+		return fmt.Errorf("-to has to be after -from")
+	}
+
+	db, err := openDB(dbPath, dryRun)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := DeleteRange(db, product, from, to, dryRun)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lian/gdax-bookmap/episodes"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["episodes"] = command{
+		Usage: "scan a product for high volatility episodes and list them",
+		Run:   runEpisodes,
+	}
+}
+
+type EpisodesReport struct {
+	Product  string          `json:"product"`
+	Episodes []*util.Episode `json:"episodes"`
+}
+
+func runEpisodes(args []string) error {
+	var dbPath, product, fromValue, toValue string
+	var scan bool
+	var minScore float64
+
+	flags := flag.NewFlagSet("episodes", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&product, "product", "", "product database key, e.g. GDAX-BTC-USD")
+	flags.StringVar(&fromValue, "from", "", "start time (RFC3339), default the first recorded day")
+	flags.StringVar(&toValue, "to", "", "end time (RFC3339), default now")
+	flags.BoolVar(&scan, "scan", false, "scan the range again and store and bookmark the episodes found, instead of listing the stored ones")
+	flags.Float64Var(&minScore, "min-score", 0, "only list episodes whose strongest step ran at least this many times its baseline")
+	scanner := episodes.NewScanner(nil)
+	flags.DurationVar(&scanner.Step, "step", scanner.Step, "time the trades, pulls and price moves are counted over")
+	flags.DurationVar(&scanner.Baseline, "baseline", scanner.Baseline, "time before a step it is compared with")
+	flags.Float64Var(&scanner.Factor, "factor", scanner.Factor, "times the baseline trades or pulls of a step have to run to be tagged")
+	flags.Float64Var(&scanner.MinVelocity, "min-velocity", scanner.MinVelocity, "price change in percent per minute tagged as velocity")
+	flags.Parse(args)
+
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+	from, err := parseTimeFlag(fromValue)
+	if err != nil {
+		return err
+	}
+	to, err := parseTimeFlag(toValue)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(dbPath, !scan)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	scanner.DB = db
+
+	found := util.ListEpisodes(db, product, from, to)
+	if scan {
+		if from.IsZero() {
+			if from, err = firstDay(db, product); err != nil {
+				return err
+			}
+		}
+		if to.IsZero() {
+			to = time.Now()
+		}
+		if found, err = scanner.Run(product, from, to); err != nil {
+			return err
+		}
+	}
+
+	report := &EpisodesReport{Product: product, Episodes: []*util.Episode{}}
+	for _, e := range found {
+		if e.Score >= minScore {
+			report.Episodes = append(report.Episodes, e)
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
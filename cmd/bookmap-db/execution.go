@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["execution"] = command{
+		Usage: "measure slippage and adverse selection of fills against the recorded book",
+		Run:   runExecution,
+	}
+}
+
+// ExecutionFill is one line of the fills file. Arrival is when the order
+// was sent, fills without it are measured against the mid at fill time.
+type ExecutionFill struct {
+	Product string    `json:"product"`
+	Side    string    `json:"side"`
+	Price   float64   `json:"price"`
+	Size    float64   `json:"size"`
+	Time    time.Time `json:"time"`
+	Arrival time.Time `json:"arrival,omitempty"`
+}
+
+func (f *ExecutionFill) sign() float64 {
+	if strings.ToLower(f.Side) == "sell" {
+		return -1
+	}
+	return 1
+}
+
+// all values in basis points. positive slippage, spread and adverse
+// selection are costs for the fill owner.
+type ExecutionResult struct {
+	ExecutionFill
+	ArrivalMid       float64 `json:"arrival_mid"`
+	FillMid          float64 `json:"fill_mid"`
+	HorizonMid       float64 `json:"horizon_mid"`
+	Slippage         float64 `json:"slippage_bps"`
+	EffectiveSpread  float64 `json:"effective_spread_bps"`
+	AdverseSelection float64 `json:"adverse_selection_bps"`
+	Missing          string  `json:"missing,omitempty"`
+}
+
+type ExecutionSummary struct {
+	Fills            int     `json:"fills"`
+	Measured         int     `json:"measured"`
+	Size             float64 `json:"size"`
+	Notional         float64 `json:"notional"`
+	Slippage         float64 `json:"slippage_bps"`
+	EffectiveSpread  float64 `json:"effective_spread_bps"`
+	AdverseSelection float64 `json:"adverse_selection_bps"`
+}
+
+type ExecutionReport struct {
+	Horizon  string                       `json:"horizon"`
+	Fills    []*ExecutionResult           `json:"fills"`
+	Products map[string]*ExecutionSummary `json:"products"`
+	Total    *ExecutionSummary            `json:"total"`
+}
+
+type midCheckpoint struct {
+	Time time.Time
+	Mid  *float64
+}
+
+func bookMid(book *orderbook.Book) float64 {
+	bid, ask := book.BestBid(), book.BestAsk()
+	if bid == nil || ask == nil {
+		return 0
+	}
+	return (bid.Price + ask.Price) / 2
+}
+
+// replayMids replays a product from the last sync before the first
+// checkpoint and stores the mid price at every checkpoint.
+func replayMids(db *bolt.DB, product string, checkpoints []midCheckpoint) error {
+	if len(checkpoints) == 0 {
+		return nil
+	}
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i].Time.Before(checkpoints[j].Time) })
+	book := orderbook.New(product)
+	last := checkpoints[len(checkpoints)-1].Time
+
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := util.NewCursor(db, b)
+
+		key, buf := c.Seek(orderbook.PackTimeKey(checkpoints[0].Time))
+		if key == nil {
+			key, buf = c.Last()
+		}
+		for key != nil && !orderbook.IsSyncPacket(buf) {
+			key, buf = c.Prev()
+		}
+		if key == nil {
+			key, buf = c.First()
+		}
+
+		var synced bool
+		i := 0
+		for ; key != nil; key, buf = c.Next() {
+			t := orderbook.UnpackTimeKey(key)
+			for ; i < len(checkpoints) && checkpoints[i].Time.Before(t); i++ {
+				if synced {
+					*checkpoints[i].Mid = bookMid(book)
+				}
+			}
+			if t.After(last) {
+				break
+			}
+			if orderbook.IsSyncPacket(buf) {
+				synced = true
+			}
+			book.Process(t, buf)
+		}
+		return nil
+	})
+}
+
+func (s *ExecutionSummary) add(r *ExecutionResult) {
+	s.Fills += 1
+	if r.Missing != "" {
+		return
+	}
+	notional := r.Price * r.Size
+	s.Measured += 1
+	s.Size += r.Size
+	s.Slippage += r.Slippage * notional
+	s.EffectiveSpread += r.EffectiveSpread * notional
+	s.AdverseSelection += r.AdverseSelection * notional
+	s.Notional += notional
+}
+
+// notional weighted averages
+func (s *ExecutionSummary) finish() {
+	if s.Notional == 0 {
+		return
+	}
+	s.Slippage /= s.Notional
+	s.EffectiveSpread /= s.Notional
+	s.AdverseSelection /= s.Notional
+}
+
+func AnalyzeExecution(db *bolt.DB, fills []*ExecutionFill, horizon time.Duration) (*ExecutionReport, error) {
+	report := &ExecutionReport{
+		Horizon:  horizon.String(),
+		Fills:    []*ExecutionResult{},
+		Products: map[string]*ExecutionSummary{},
+		Total:    &ExecutionSummary{},
+	}
+
+	checkpoints := map[string][]midCheckpoint{}
+	for _, fill := range fills {
+		r := &ExecutionResult{ExecutionFill: *fill}
+		arrival := fill.Arrival
+		if arrival.IsZero() {
+			arrival = fill.Time
+		}
+		checkpoints[fill.Product] = append(checkpoints[fill.Product],
+			midCheckpoint{Time: arrival, Mid: &r.ArrivalMid},
+			midCheckpoint{Time: fill.Time, Mid: &r.FillMid},
+			midCheckpoint{Time: fill.Time.Add(horizon), Mid: &r.HorizonMid},
+		)
+		report.Fills = append(report.Fills, r)
+	}
+
+	for product, list := range checkpoints {
+		if err := replayMids(db, product, list); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, r := range report.Fills {
+		if r.ArrivalMid == 0 || r.FillMid == 0 {
+			r.Missing = "no book at fill time"
+		} else if r.HorizonMid == 0 {
+			r.Missing = "no book after horizon"
+		} else {
+			side := r.sign()
+			r.Slippage = side * ((r.Price - r.ArrivalMid) / r.ArrivalMid) * 10000
+			r.EffectiveSpread = 2 * side * ((r.Price - r.FillMid) / r.FillMid) * 10000
+			r.AdverseSelection = -side * ((r.HorizonMid - r.FillMid) / r.FillMid) * 10000
+		}
+
+		summary, ok := report.Products[r.Product]
+		if !ok {
+			summary = &ExecutionSummary{}
+			report.Products[r.Product] = summary
+		}
+		summary.add(r)
+		report.Total.add(r)
+	}
+
+	for _, summary := range report.Products {
+		summary.finish()
+	}
+	report.Total.finish()
+
+	return report, nil
+}
+
+// ReadExecutionFills reads one JSON encoded fill per line.
+func ReadExecutionFills(path string) ([]*ExecutionFill, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fills := []*ExecutionFill{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		fill := &ExecutionFill{}
+		if err := json.Unmarshal(scanner.Bytes(), fill); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		fills = append(fills, fill)
+	}
+	return fills, scanner.Err()
+}
+
+func runExecution(args []string) error {
+	var dbPath, fillsPath, product string
+	var horizon time.Duration
+
+	flags := flag.NewFlagSet("execution", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&fillsPath, "fills", "", "fills file, one JSON object per line")
+	flags.StringVar(&product, "product", "", "product database key used for fills without product")
+	flags.DurationVar(&horizon, "horizon", 60*time.Second, "adverse selection horizon")
+	flags.Parse(args)
+
+	if fillsPath == "" {
+		return fmt.Errorf("missing -fills")
+	}
+	fills, err := ReadExecutionFills(fillsPath)
+	if err != nil {
+		return err
+	}
+	for _, fill := range fills {
+		if fill.Product == "" {
+			fill.Product = product
+		}
+		if fill.Product == "" {
+			return fmt.Errorf("fill at %s without product", fill.Time)
+		}
+	}
+
+	db, err := openDB(dbPath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := AnalyzeExecution(db, fills, horizon)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
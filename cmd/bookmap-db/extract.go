@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["extract"] = command{
+		Usage: "copy one product (or a time range of it) into a new database file",
+		Run:   runExtract,
+	}
+}
+
+// packets copied per transaction
+const extractBatchSize = 10000
+
+type ExtractResult struct {
+	Product   string    `json:"product"`
+	Out       string    `json:"out"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Packets   int       `json:"packets"`
+	Bookmarks int       `json:"bookmarks"`
+	Encrypted bool      `json:"encrypted"`
+}
+
+type extractPacket struct {
+	Key   []byte
+	Value []byte
+}
+
+// copyBucket copies all keys of a bucket as they are.
+func copyBucket(src, dst *bolt.DB, name string) (int, error) {
+	var count int
+	err := src.View(func(stx *bolt.Tx) error {
+		sb := stx.Bucket([]byte(name))
+		if sb == nil {
+			return nil
+		}
+		return dst.Update(func(dtx *bolt.Tx) error {
+			b, err := dtx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			return sb.ForEach(func(key, value []byte) error {
+				count += 1
+				return b.Put(key, value)
+			})
+		})
+	})
+	return count, err
+}
+
+// ExtractProduct copies the packets of a product into dst. The start is
+// moved back to the last sync before `from` so the copy starts with a full
+// book. Packets are copied as stored, encrypted files stay encrypted with
+// the same key.
+func ExtractProduct(src, dst *bolt.DB, product string, from, to time.Time) (*ExtractResult, error) {
+	result := &ExtractResult{Product: product}
+
+	err := src.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := b.Cursor()
+
+		key, value := c.First()
+		if !from.IsZero() {
+			key, value = c.Seek(orderbook.PackTimeKey(from))
+			if key == nil {
+				key, value = c.Last()
+			}
+			for key != nil {
+				if plain, err := util.Unseal(src, value); err == nil && orderbook.IsSyncPacket(plain) {
+					break
+				}
+				key, value = c.Prev()
+			}
+			if key == nil {
+				key, value = c.First()
+			}
+		}
+
+		var end []byte
+		if !to.IsZero() {
+			end = orderbook.PackTimeKey(to)
+		}
+
+		batch := []extractPacket{}
+		flush := func() error {
+			err := dst.Update(func(dtx *bolt.Tx) error {
+				out, err := dtx.CreateBucketIfNotExists([]byte(product))
+				if err != nil {
+					return err
+				}
+				out.FillPercent = 0.9
+				for _, pkt := range batch {
+					if err := out.Put(pkt.Key, pkt.Value); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			batch = batch[:0]
+			return err
+		}
+
+		for ; key != nil; key, value = c.Next() {
+			if end != nil && bytes.Compare(key, end) > 0 {
+				break
+			}
+			if result.Packets == 0 {
+				result.From = orderbook.UnpackTimeKey(key)
+			}
+			result.To = orderbook.UnpackTimeKey(key)
+			result.Packets += 1
+
+			// delta syncs whose keyframe is not part of the copy are stored in full
+			if plain, err := util.Unseal(src, value); err == nil && orderbook.IsSyncDeltaPacket(plain) &&
+				orderbook.SyncDeltaReference(plain) < result.From.UnixNano() {
+				if full, err := util.ExpandSync(src, b, plain); err == nil {
+					value = util.Seal(src, full)
+				}
+			}
+
+			// bolt values are only valid during the transaction
+			batch = append(batch, extractPacket{Key: append([]byte{}, key...), Value: append([]byte{}, value...)})
+			if len(batch) >= extractBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if len(batch) > 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// metadata
+	if result.Bookmarks, err = copyBucket(src, dst, util.BookmarksBucket(product)); err != nil {
+		return nil, err
+	}
+	if _, err = copyBucket(src, dst, util.AnnotationsBucket(product)); err != nil {
+		return nil, err
+	}
+	// entries outside the copied range only cost a lookup
+	if _, err = copyBucket(src, dst, util.TradeIndexBucket(product)); err != nil {
+		return nil, err
+	}
+	encryption, err := copyBucket(src, dst, util.EncryptionBucket)
+	if err != nil {
+		return nil, err
+	}
+	result.Encrypted = encryption > 0
+
+	return result, nil
+}
+
+func runExtract(args []string) error {
+	var dbPath, outPath, product, fromValue, toValue string
+
+	flags := flag.NewFlagSet("extract", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&outPath, "out", "", "new database file")
+	flags.StringVar(&product, "product", "", "product database key, e.g. GDAX-BTC-USD")
+	flags.StringVar(&fromValue, "from", "", "start time (RFC3339), moved back to the previous sync")
+	flags.StringVar(&toValue, "to", "", "end time (RFC3339)")
+	flags.Parse(args)
+
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+	if outPath == "" {
+		return fmt.Errorf("missing -out")
+	}
+	if _, err := os.Stat(outPath); err == nil {
+		return fmt.Errorf("%s already exists", outPath)
+	}
+	from, err := parseTimeFlag(fromValue)
+	if err != nil {
+		return err
+	}
+	to, err := parseTimeFlag(toValue)
+	if err != nil {
+		return err
+	}
+
+	src, err := openDB(dbPath, true)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := util.OpenDB(outPath, []string{}, false)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	result, err := ExtractProduct(src, dst, product, from, to)
+	if err != nil {
+		return err
+	}
+	result.Out = outPath
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["import"] = command{
+		Usage: "import trades and book snapshots/deltas from a csv tape",
+		Run:   runImport,
+	}
+}
+
+// The csv tape has a header line and the columns
+//
+//	time      RFC3339 or unix seconds with fraction, rows sorted by time
+//	type      snapshot, delta or trade
+//	side      bid or ask (book side), trades also take buy or sell (taker side)
+//	price
+//	size      level size for snapshot and delta (0 removes), traded size
+//
+// Consecutive snapshot rows with the same time form one full book.
+var importColumns = []string{"time", "type", "side", "price", "size"}
+
+// packets written per transaction
+const importBatchSize = 10000
+
+// a full book is stored every this many packets, like the recorder does
+const importSyncEvery = 600
+
+type ImportResult struct {
+	Product   string    `json:"product"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Rows      int       `json:"rows"`
+	Syncs     int       `json:"syncs"`
+	Diffs     int       `json:"diffs"`
+	Trades    int       `json:"trades"`
+	Skipped   int       `json:"skipped"`
+	Encrypted bool      `json:"encrypted"`
+}
+
+type importRow struct {
+	Time  time.Time
+	Type  string
+	Side  orderbook.Side
+	Price float64
+	Size  float64
+}
+
+func parseImportTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		sec, frac := math.Modf(seconds)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func parseImportRow(columns map[string]int, record []string) (*importRow, error) {
+	row := &importRow{Type: strings.ToLower(record[columns["type"]])}
+	var err error
+
+	if row.Time, err = parseImportTime(record[columns["time"]]); err != nil {
+		return nil, err
+	}
+	if row.Price, err = strconv.ParseFloat(record[columns["price"]], 64); err != nil {
+		return nil, err
+	}
+	if row.Size, err = strconv.ParseFloat(record[columns["size"]], 64); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(record[columns["side"]]) {
+	case "bid", "b":
+		row.Side = orderbook.BidSide
+	case "ask", "a":
+		row.Side = orderbook.AskSide
+	case "buy":
+		// a buyer takes from the asks
+		if row.Type != "trade" {
+			return nil, fmt.Errorf("side buy only applies to trades")
+		}
+		row.Side = orderbook.AskSide
+	case "sell":
+		if row.Type != "trade" {
+			return nil, fmt.Errorf("side sell only applies to trades")
+		}
+		row.Side = orderbook.BidSide
+	default:
+		return nil, fmt.Errorf("unknown side %q", record[columns["side"]])
+	}
+
+	switch row.Type {
+	case "snapshot", "delta", "trade":
+	default:
+		return nil, fmt.Errorf("unknown type %q", row.Type)
+	}
+	return row, nil
+}
+
+// tapeImporter turns csv rows into the packets the recorder would have
+// stored for the same market.
+type tapeImporter struct {
+	DB           *bolt.DB
+	Product      string
+	DiffInterval time.Duration
+	Result       *ImportResult
+
+	book      *orderbook.Book
+	synced    bool
+	sinceSync int
+	pending   time.Time
+	lastDiff  time.Time
+	diffSeq   uint64
+	batch     []*storage.Chunk
+	lastKey   int64
+}
+
+func (imp *tapeImporter) write(t time.Time, buf []byte) error {
+	imp.sinceSync += 1
+	imp.batch = append(imp.batch, &storage.Chunk{Time: t, Data: buf})
+	if len(imp.batch) >= importBatchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+func (imp *tapeImporter) flush() error {
+	err := imp.DB.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(imp.Product))
+		if err != nil {
+			return err
+		}
+		b.FillPercent = 0.9
+		index := &util.TradeIndexEntry{}
+		for _, chunk := range imp.batch {
+			// rows with the same time still need their own key
+			nano := chunk.Time.UnixNano()
+			if nano <= imp.lastKey {
+				nano = imp.lastKey + 1
+			}
+			imp.lastKey = nano
+			if err := b.Put(db_orderbook.PackUnixNanoKey(nano), util.Seal(imp.DB, chunk.Data)); err != nil {
+				return err
+			}
+			index.Add(nano, chunk.Data)
+		}
+		return util.PutTradeIndex(tx, imp.DB, imp.Product, index)
+	})
+	imp.batch = imp.batch[:0]
+	return err
+}
+
+func (imp *tapeImporter) writeSync(t time.Time) error {
+	imp.book.FixBookLevels()
+	imp.book.Sequence += 1
+	imp.book.ResetDiff()
+	imp.diffSeq = imp.book.Sequence + 1
+	imp.lastDiff = t
+	imp.synced = true
+	imp.sinceSync = 0
+	imp.Result.Syncs += 1
+	if err := imp.write(t, orderbook.PackSync(imp.book)); err != nil {
+		return err
+	}
+	return imp.write(t, orderbook.PackLevelAges(imp.book))
+}
+
+// writeDiff stores the pending level changes, or a full book when it is
+// time for one.
+func (imp *tapeImporter) writeDiff(t time.Time) error {
+	if len(imp.book.Diff.Bid) == 0 && len(imp.book.Diff.Ask) == 0 {
+		return nil
+	}
+	if !imp.synced || imp.sinceSync >= importSyncEvery {
+		return imp.writeSync(t)
+	}
+	imp.book.FixBookLevels()
+	imp.book.Sequence += 1
+	pkt := orderbook.PackDiff(imp.diffSeq, imp.book.Sequence, imp.book.Diff)
+	imp.book.ResetDiff()
+	imp.diffSeq = imp.book.Sequence + 1
+	imp.lastDiff = t
+	imp.Result.Diffs += 1
+	return imp.write(t, pkt)
+}
+
+func (imp *tapeImporter) update(t time.Time, side orderbook.Side, price, size float64) {
+	if side == orderbook.BidSide {
+		imp.book.UpdateBidLevel(t, price, size)
+	} else {
+		imp.book.UpdateAskLevel(t, price, size)
+	}
+}
+
+func (imp *tapeImporter) Import(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range importColumns {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	var last time.Time
+	var snapshot *time.Time
+	line := 1
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line += 1
+		if err != nil {
+			return err
+		}
+		row, err := parseImportRow(columns, record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", line, err)
+			imp.Result.Skipped += 1
+			continue
+		}
+		if row.Time.Before(last) {
+			return fmt.Errorf("line %d: rows are not sorted by time", line)
+		}
+		last = row.Time
+		imp.Result.Rows += 1
+		if imp.Result.From.IsZero() {
+			imp.Result.From = row.Time
+		}
+		imp.Result.To = row.Time
+
+		// a snapshot ends with the first row of another kind or time
+		if snapshot != nil && (row.Type != "snapshot" || !row.Time.Equal(*snapshot)) {
+			if err := imp.writeSync(*snapshot); err != nil {
+				return err
+			}
+			snapshot = nil
+		}
+
+		switch row.Type {
+		case "snapshot":
+			if snapshot == nil {
+				imp.book.Clear()
+				snapshot = &row.Time
+			}
+			imp.update(row.Time, row.Side, row.Price, row.Size)
+
+		case "delta":
+			if row.Time.Sub(imp.lastDiff) >= imp.DiffInterval {
+				if err := imp.writeDiff(imp.pending); err != nil {
+					return err
+				}
+			}
+			imp.update(row.Time, row.Side, row.Price, row.Size)
+			imp.pending = row.Time
+
+		case "trade":
+			// store the book as it was before the trade
+			if err := imp.writeDiff(imp.pending); err != nil {
+				return err
+			}
+			trade := &orderbook.Trade{Time: row.Time, Side: row.Side, Source: db_orderbook.SideFromVenue, Price: row.Price, Size: row.Size}
+			imp.Result.Trades += 1
+			if err := imp.write(row.Time, orderbook.PackTrade(trade)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if snapshot != nil {
+		if err := imp.writeSync(*snapshot); err != nil {
+			return err
+		}
+	}
+	if err := imp.writeDiff(imp.pending); err != nil {
+		return err
+	}
+	if len(imp.batch) > 0 {
+		return imp.flush()
+	}
+	return nil
+}
+
+func runImport(args []string) error {
+	var dbPath, csvPath, platform, base, quote string
+	var tick float64
+	var diffInterval time.Duration
+
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&csvPath, "csv", "", "csv tape with columns time,type,side,price,size")
+	flags.StringVar(&platform, "platform", "", "venue name used in the product key, e.g. Kraken")
+	flags.StringVar(&base, "base", "", "base currency, e.g. BTC")
+	flags.StringVar(&quote, "quote", "", "quote currency, e.g. USD")
+	flags.Float64Var(&tick, "tick", 0.01, "price increment")
+	flags.DurationVar(&diffInterval, "diff-interval", time.Second, "deltas are stored combined per interval")
+	flags.Parse(args)
+
+	if csvPath == "" {
+		return fmt.Errorf("missing -csv")
+	}
+	if platform == "" || base == "" || quote == "" {
+		return fmt.Errorf("missing -platform, -base or -quote")
+	}
+
+	id := fmt.Sprintf("%s-%s", strings.ToUpper(base), strings.ToUpper(quote))
+	info := &product_info.Info{
+		Platform:       platform,
+		DatabaseKey:    fmt.Sprintf("%s-%s", platform, id),
+		ID:             id,
+		DisplayName:    id,
+		BaseCurrency:   strings.ToUpper(base),
+		QuoteCurrency:  strings.ToUpper(quote),
+		QuoteIncrement: product_info.FloatString(tick),
+		FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(tick)),
+	}
+
+	file, err := os.Open(csvPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db, err := openDB(dbPath, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	exists := false
+	db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(info.DatabaseKey)); b != nil && b.Stats().KeyN > 0 {
+			exists = true
+		}
+		return nil
+	})
+	if exists {
+		return fmt.Errorf("product %s already has data, delete it first", info.DatabaseKey)
+	}
+
+	book := orderbook.New(id)
+	book.SetProductInfo(*info)
+	imp := &tapeImporter{
+		DB:           db,
+		Product:      info.DatabaseKey,
+		DiffInterval: diffInterval,
+		Result:       &ImportResult{Product: info.DatabaseKey},
+		book:         book,
+		batch:        []*storage.Chunk{},
+	}
+	if err := imp.Import(file); err != nil {
+		return err
+	}
+	if err := util.SaveProductInfo(db, info); err != nil {
+		return err
+	}
+	imp.Result.Encrypted = os.Getenv("BOOKMAP_PASSPHRASE") != ""
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(imp.Result)
+}
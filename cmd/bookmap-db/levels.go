@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["index-levels"] = command{
+		Usage: "add days of a product to the per level index used by level",
+		Run:   runIndexLevels,
+	}
+	commands["level"] = command{
+		Usage: "print the size over time of one price level",
+		Run:   runLevel,
+	}
+}
+
+type IndexLevelsDay struct {
+	Day     string `json:"day"`
+	Changes int    `json:"changes"`
+}
+
+func runIndexLevels(args []string) error {
+	var dbPath, product, fromValue, toValue string
+
+	flags := flag.NewFlagSet("index-levels", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&product, "product", "", "product database key, e.g. GDAX-BTC-USD")
+	flags.StringVar(&fromValue, "from", "", "first day (RFC3339), default the first recorded day")
+	flags.StringVar(&toValue, "to", "", "last day (RFC3339), default yesterday")
+	flags.Parse(args)
+
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+	from, err := parseTimeFlag(fromValue)
+	if err != nil {
+		return err
+	}
+	to, err := parseTimeFlag(toValue)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(dbPath, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if from.IsZero() {
+		if from, err = firstDay(db, product); err != nil {
+			return err
+		}
+	}
+	// the current day is still recorded
+	today := time.Now().UTC().Truncate(util.QualityDay)
+	if to.IsZero() || !to.Before(today) {
+		to = today.Add(-util.QualityDay)
+	}
+
+	days := []*IndexLevelsDay{}
+	for day := from.UTC().Truncate(util.QualityDay); !from.IsZero() && !day.After(to); day = day.Add(util.QualityDay) {
+		changes, err := util.IndexLevels(db, product, day)
+		if err != nil {
+			return err
+		}
+		days = append(days, &IndexLevelsDay{Day: day.Format("2006-01-02"), Changes: changes})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(days)
+}
+
+type LevelReport struct {
+	Product string             `json:"product"`
+	Price   float64            `json:"price"`
+	Points  []*util.LevelPoint `json:"points"`
+}
+
+func runLevel(args []string) error {
+	var dbPath, product, fromValue, toValue string
+	var price float64
+
+	flags := flag.NewFlagSet("level", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&product, "product", "", "product database key, e.g. GDAX-BTC-USD")
+	flags.Float64Var(&price, "price", 0, "price of the level")
+	flags.StringVar(&fromValue, "from", "", "start time (RFC3339)")
+	flags.StringVar(&toValue, "to", "", "end time (RFC3339), default now")
+	flags.Parse(args)
+
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+	if price <= 0 {
+		return fmt.Errorf("missing -price")
+	}
+	from, err := parseTimeFlag(fromValue)
+	if err != nil {
+		return err
+	}
+	if from.IsZero() {
+		return fmt.Errorf("missing -from")
+	}
+	to, err := parseTimeFlag(toValue)
+	if err != nil {
+		return err
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	db, err := openDB(dbPath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	points, err := util.LevelSeries(db, product, price, from, to)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&LevelReport{Product: product, Price: price, Points: points})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+type command struct {
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = map[string]command{}
+
+// openDB unlocks encrypted databases with BOOKMAP_PASSPHRASE
+func openDB(path string, readOnly bool) (*bolt.DB, error) {
+	db, err := util.OpenDB(path, []string{}, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase := os.Getenv("BOOKMAP_PASSPHRASE"); passphrase != "" {
+		if err := util.EnableEncryption(db, passphrase); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+func usage() {
+	fmt.Println("Usage: bookmap-db <command> [flags]")
+	fmt.Println()
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-10s %s\n", name, commands[name].Usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[strings.ToLower(os.Args[1])]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.Run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, os.Args[1], "error:", err)
+		os.Exit(1)
+	}
+}
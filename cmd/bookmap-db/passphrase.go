@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["passphrase"] = command{
+		Usage: "change the passphrase of an encrypted database",
+		Run:   runPassphrase,
+	}
+}
+
+func runPassphrase(args []string) error {
+	var dbPath string
+
+	flags := flag.NewFlagSet("passphrase", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.Parse(args)
+
+	oldPassphrase := os.Getenv("BOOKMAP_PASSPHRASE")
+	newPassphrase := os.Getenv("BOOKMAP_NEW_PASSPHRASE")
+	if oldPassphrase == "" || newPassphrase == "" {
+		return fmt.Errorf("set BOOKMAP_PASSPHRASE and BOOKMAP_NEW_PASSPHRASE")
+	}
+
+	db, err := util.OpenDB(dbPath, []string{}, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := util.RotatePassphrase(db, oldPassphrase, newPassphrase); err != nil {
+		return err
+	}
+	fmt.Println("passphrase changed")
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["quality"] = command{
+		Usage: "score the recording quality of a product per day (0-100) and list the scores",
+		Run:   runQuality,
+	}
+}
+
+type QualityReport struct {
+	Product string          `json:"product"`
+	Days    []*util.Quality `json:"days"`
+}
+
+func firstDay(db *bolt.DB, product string) (time.Time, error) {
+	var first time.Time
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		if key, _ := b.Cursor().First(); key != nil {
+			first = orderbook.UnpackTimeKey(key).UTC().Truncate(util.QualityDay)
+		}
+		return nil
+	})
+	return first, err
+}
+
+func runQuality(args []string) error {
+	var dbPath, product, fromValue, toValue string
+	var minScore, maxScore float64
+	var maxSilence time.Duration
+	var recompute bool
+
+	flags := flag.NewFlagSet("quality", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&product, "product", "", "product database key, e.g. GDAX-BTC-USD")
+	flags.StringVar(&fromValue, "from", "", "first day (RFC3339), default the first recorded day")
+	flags.StringVar(&toValue, "to", "", "last day (RFC3339), default yesterday")
+	flags.Float64Var(&minScore, "min-score", 0, "only list days scoring at least this")
+	flags.Float64Var(&maxScore, "max-score", 100, "only list days scoring at most this, e.g. 80 to find bad days")
+	flags.DurationVar(&maxSilence, "max-silence", time.Minute, "longer times without packets count as gaps")
+	flags.BoolVar(&recompute, "recompute", false, "score days again that were already scored")
+	flags.Parse(args)
+
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+	from, err := parseTimeFlag(fromValue)
+	if err != nil {
+		return err
+	}
+	to, err := parseTimeFlag(toValue)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(dbPath, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if from.IsZero() {
+		if from, err = firstDay(db, product); err != nil {
+			return err
+		}
+	}
+	// only complete days are scored
+	today := time.Now().UTC().Truncate(util.QualityDay)
+	if to.IsZero() || !to.Before(today) {
+		to = today.Add(-util.QualityDay)
+	}
+
+	stored := map[string]*util.Quality{}
+	for _, q := range util.ListQuality(db, product) {
+		stored[q.Day] = q
+	}
+
+	report := &QualityReport{Product: product, Days: []*util.Quality{}}
+	for start := from.UTC().Truncate(util.QualityDay); !from.IsZero() && !start.After(to); start = start.Add(util.QualityDay) {
+		q, ok := stored[start.Format("2006-01-02")]
+		if !ok || recompute {
+			if q, err = util.RateDay(db, product, start, maxSilence); err != nil {
+				return err
+			}
+			if err := util.PutQuality(db, product, q); err != nil {
+				return err
+			}
+		}
+		if q.Score >= minScore && q.Score <= maxScore {
+			report.Days = append(report.Days, q)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/tiles"
+)
+
+func init() {
+	commands["tiles"] = command{
+		Usage: "render a product into zoomable png heatmap tiles for a web page",
+		Run:   runTiles,
+	}
+}
+
+func runTiles(args []string) error {
+	var dbPath, product, dayValue, fromValue, toValue, out, paletteName string
+
+	flags := flag.NewFlagSet("tiles", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&product, "product", "", "product database key, e.g. GDAX-BTC-USD")
+	flags.StringVar(&dayValue, "day", "", "day to render (2006-01-02, UTC), instead of -from and -to")
+	flags.StringVar(&fromValue, "from", "", "start time (RFC3339)")
+	flags.StringVar(&toValue, "to", "", "end time (RFC3339), default now")
+	flags.StringVar(&out, "out", "tiles", "directory for the tiles and manifest.json")
+	flags.StringVar(&paletteName, "palette", "default", "colors of the heatmap: default, deuteranopia or protanopia")
+	exporter := tiles.NewExporter(nil)
+	flags.IntVar(&exporter.TileSize, "tile-size", exporter.TileSize, "width and height of the tiles in pixels")
+	flags.DurationVar(&exporter.Resolution, "resolution", exporter.Resolution, "time per pixel column at the deepest zoom")
+	flags.IntVar(&exporter.Rows, "rows", exporter.Rows, "price rows at the deepest zoom")
+	flags.Float64Var(&exporter.PriceMin, "price-min", 0, "lowest price shown (0 with -price-max 0 takes the traded range)")
+	flags.Float64Var(&exporter.PriceMax, "price-max", 0, "highest price shown")
+	flags.Float64Var(&exporter.Margin, "margin", exporter.Margin, "percent of the price added above and below the traded range")
+	flags.Float64Var(&exporter.Saturate, "saturate", exporter.Saturate, "percentile of the level sizes of a zoom drawn at full strength")
+	flags.Parse(args)
+
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+	if err := palette.Select(paletteName); err != nil {
+		return err
+	}
+	var from, to time.Time
+	var err error
+	if dayValue != "" {
+		if from, err = time.Parse("2006-01-02", dayValue); err != nil {
+			return err
+		}
+		to = from.Add(24 * time.Hour)
+	} else {
+		if from, err = parseTimeFlag(fromValue); err != nil {
+			return err
+		}
+		if to, err = parseTimeFlag(toValue); err != nil {
+			return err
+		}
+		if from.IsZero() {
+			return fmt.Errorf("missing -day or -from")
+		}
+		if to.IsZero() {
+			to = time.Now()
+		}
+	}
+
+	db, err := openDB(dbPath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	exporter.DB = db
+
+	m, err := exporter.Export(product, from, to, out)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	commands["trades"] = command{
+		Usage: "list the trades of a product filtered by time, size and price",
+		Run:   runTrades,
+	}
+}
+
+type TradeResult struct {
+	Time  time.Time `json:"time"`
+	Side  string    `json:"side"`
+	Price float64   `json:"price"`
+	Size  float64   `json:"size"`
+}
+
+type TradesReport struct {
+	Product string               `json:"product"`
+	Trades  []*TradeResult       `json:"trades"`
+	Scan    *util.TradeScanStats `json:"scan"`
+}
+
+func runTrades(args []string) error {
+	var dbPath, product, fromValue, toValue string
+	var filter util.TradeFilter
+
+	flags := flag.NewFlagSet("trades", flag.ExitOnError)
+	flags.StringVar(&dbPath, "db", "orderbooks.db", "database file")
+	flags.StringVar(&product, "product", "", "product database key, e.g. GDAX-BTC-USD")
+	flags.StringVar(&fromValue, "from", "", "start time (RFC3339)")
+	flags.StringVar(&toValue, "to", "", "end time (RFC3339)")
+	flags.Float64Var(&filter.MinSize, "min-size", 0, "only trades of at least this size")
+	flags.Float64Var(&filter.MinPrice, "min-price", 0, "only trades at or above this price")
+	flags.Float64Var(&filter.MaxPrice, "max-price", 0, "only trades at or below this price (0 for no limit)")
+	flags.Parse(args)
+
+	if product == "" {
+		return fmt.Errorf("missing -product")
+	}
+	var err error
+	if fromValue != "" {
+		if filter.From, err = parseTimeFlag(fromValue); err != nil {
+			return err
+		}
+	}
+	if toValue != "" {
+		if filter.To, err = parseTimeFlag(toValue); err != nil {
+			return err
+		}
+	}
+
+	db, err := openDB(dbPath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report := &TradesReport{Product: product, Trades: []*TradeResult{}}
+	report.Scan, err = util.ScanTrades(db, product, filter, func(t time.Time, side orderbook.Side, price, size float64) {
+		// the book side the trade happened on
+		name := "bid"
+		if side == orderbook.AskSide {
+			name = "ask"
+		}
+		report.Trades = append(report.Trades, &TradeResult{Time: t, Side: name, Price: price, Size: size})
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
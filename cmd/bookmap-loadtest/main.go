@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	binance_futures_websocket "github.com/lian/gdax-bookmap/exchanges/binance/futures/websocket"
+	binance_websocket "github.com/lian/gdax-bookmap/exchanges/binance/websocket"
+	bitfinex_websocket "github.com/lian/gdax-bookmap/exchanges/bitfinex/websocket"
+	bitmex_websocket "github.com/lian/gdax-bookmap/exchanges/bitmex/websocket"
+	bitstamp_websocket "github.com/lian/gdax-bookmap/exchanges/bitstamp/websocket"
+	bybit_websocket "github.com/lian/gdax-bookmap/exchanges/bybit/websocket"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	deribit_websocket "github.com/lian/gdax-bookmap/exchanges/deribit/websocket"
+	gdax_websocket "github.com/lian/gdax-bookmap/exchanges/gdax/websocket"
+	gemini_websocket "github.com/lian/gdax-bookmap/exchanges/gemini/websocket"
+	huobi_websocket "github.com/lian/gdax-bookmap/exchanges/huobi/websocket"
+	kraken_websocket "github.com/lian/gdax-bookmap/exchanges/kraken/websocket"
+	okx_websocket "github.com/lian/gdax-bookmap/exchanges/okx/websocket"
+	"github.com/lian/gdax-bookmap/exchanges/synthetic"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// the products the recorder subscribes to, see main.go
+var recordedProducts = map[string][]string{
+	"gdax":         []string{"BTC-USD", "ETH-USD", "BCH-USD"},
+	"bitstamp":     []string{"BTC-USD", "ETH-USD", "BCH-USD"},
+	"binance":      []string{"BTC-USDT", "ETH-USDT", "BCH-USDT"},
+	"binanceusdm":  []string{"BTC-USDT", "ETH-USDT"},
+	"binancecoinm": []string{"BTC-USD", "ETH-USD"},
+	"bitfinex":     []string{"BTC-USD", "ETH-USD", "BCH-USD"},
+	"kraken":       []string{"BTC-USD", "ETH-USD", "BCH-USD"},
+	"bitmex":       []string{"BTC-USD", "ETH-USD"},
+	"bybitspot":    []string{"BTC-USDT", "ETH-USDT"},
+	"bybitlinear":  []string{"BTC-USDT", "ETH-USDT"},
+	"bybitinverse": []string{"BTC-USD", "ETH-USD"},
+	"deribit":      []string{"BTC-PERPETUAL", "ETH-PERPETUAL"},
+	"okx":          []string{"BTC-USDT", "ETH-USDT", "BTC-USDT-SWAP"},
+	"huobi":        []string{"BTC-USDT", "ETH-USDT", "BCH-USDT"},
+	"gemini":       []string{"BTC-USD", "ETH-USD"},
+}
+
+// rawHandler is the HandleRaw of an exchange client.
+type rawHandler func(message []byte) error
+
+type Report struct {
+	Source string  `json:"source"`
+	Speed  float64 `json:"speed"`
+	// captured messages, or steps of a synthetic market
+	Messages  int     `json:"messages"`
+	Rejected  int     `json:"rejected"`
+	Seconds   float64 `json:"seconds"`
+	PerSecond float64 `json:"per_second"`
+	// time to parse, apply and store one message
+	LatencyP50 float64 `json:"latency_p50_us"`
+	LatencyP90 float64 `json:"latency_p90_us"`
+	LatencyP99 float64 `json:"latency_p99_us"`
+	LatencyMax float64 `json:"latency_max_us"`
+	// furthest behind the schedule of the replay
+	MaxLag          float64 `json:"max_lag_ms"`
+	AllocsPerMsg    float64 `json:"allocs_per_message"`
+	BytesPerMsg     float64 `json:"alloc_bytes_per_message"`
+	GCs             uint32  `json:"gcs"`
+	GCPause         float64 `json:"gc_pause_ms"`
+	DatabaseBytes   int64   `json:"database_bytes"`
+	StoredPerSecond float64 `json:"stored_bytes_per_second"`
+}
+
+type run struct {
+	speed     float64
+	start     time.Time
+	latencies []time.Duration
+	maxLag    time.Duration
+	rejected  int
+}
+
+// wait sleeps until offset of the source, speed times faster, 0 does not
+// wait at all.
+func (r *run) wait(offset time.Duration) {
+	if r.speed <= 0 {
+		return
+	}
+	due := r.start.Add(time.Duration(float64(offset) / r.speed))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	} else if -d > r.maxLag {
+		r.maxLag = -d
+	}
+}
+
+func (r *run) measure(fn func() error) {
+	t := time.Now()
+	if err := fn(); err != nil {
+		r.rejected += 1
+	}
+	r.latencies = append(r.latencies, time.Since(t))
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(sorted)-1))
+	return float64(sorted[i]) / float64(time.Microsecond)
+}
+
+func replayFeed(db *bolt.DB, path string, r *run) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	handlers := map[string]rawHandler{}
+	handler := func(platform string) (rawHandler, error) {
+		name := strings.ToLower(platform)
+		if h, ok := handlers[name]; ok {
+			return h, nil
+		}
+		products := recordedProducts[name]
+		switch name {
+		case "gdax":
+			c := gdax_websocket.New(db, products)
+			c.Replay = true
+			handlers[name] = c.HandleRaw
+		case "bitstamp":
+			c := bitstamp_websocket.New(db, products)
+			c.Replay = true
+			handlers[name] = c.HandleRaw
+		case "binance":
+			c := binance_websocket.New(db, products)
+			c.Replay = true
+			handlers[name] = c.HandleRaw
+		case "binanceusdm":
+			c := binance_futures_websocket.NewUSDM(db, products)
+			c.Replay = true
+			handlers[name] = c.HandleRaw
+		case "binancecoinm":
+			c := binance_futures_websocket.NewCOINM(db, products)
+			c.Replay = true
+			handlers[name] = c.HandleRaw
+		case "bitfinex":
+			c := bitfinex_websocket.New(db, products)
+			handlers[name] = c.HandleRaw
+		case "kraken":
+			c := kraken_websocket.New(db, products)
+			handlers[name] = c.HandleRaw
+		case "bitmex":
+			c := bitmex_websocket.New(db, products)
+			handlers[name] = c.HandleRaw
+		case "bybitspot":
+			c := bybit_websocket.NewSpot(db, products)
+			handlers[name] = c.HandleRaw
+		case "bybitlinear":
+			c := bybit_websocket.NewLinear(db, products)
+			handlers[name] = c.HandleRaw
+		case "bybitinverse":
+			c := bybit_websocket.NewInverse(db, products)
+			handlers[name] = c.HandleRaw
+		case "deribit":
+			c := deribit_websocket.New(db, products)
+			handlers[name] = c.HandleRaw
+		case "okx":
+			c := okx_websocket.New(db, products)
+			handlers[name] = c.HandleRaw
+		case "huobi":
+			c := huobi_websocket.New(db, products)
+			handlers[name] = c.HandleRaw
+		case "gemini":
+			c := gemini_websocket.New(db, products)
+			handlers[name] = c.HandleRaw
+		default:
+			return nil, fmt.Errorf("can not replay platform %q", platform)
+		}
+		return handlers[name], nil
+	}
+
+	var first time.Time
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var msg common.CapturedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		h, err := handler(msg.Platform)
+		if err != nil {
+			return err
+		}
+		if first.IsZero() {
+			first = msg.Time
+			r.start = time.Now()
+		}
+		r.wait(msg.Time.Sub(first))
+		r.measure(func() error { return h(msg.Data) })
+	}
+	return scanner.Err()
+}
+
+func generateBursts(db *bolt.DB, products []string, config synthetic.Config, duration time.Duration, r *run) {
+	c := synthetic.New(db, products, config)
+	r.start = time.Now()
+	for offset := time.Duration(0); offset < duration; offset += c.Interval {
+		r.wait(offset)
+		// the synthetic clock, batches are stored and synced by it
+		now := r.start.Add(offset)
+		for _, name := range c.Products {
+			market := c.Markets[name]
+			r.measure(func() error {
+				c.HandleStep(market, now, c.Interval)
+				return nil
+			})
+		}
+	}
+}
+
+func main() {
+	var feed, dbPath, products string
+	var duration time.Duration
+	var speed float64
+	config := synthetic.DefaultConfig()
+	config.Seed = 1
+	config.UpdateRate = 2000
+	config.TradeRate = 200
+
+	flag.StringVar(&feed, "feed", "", "capture file of a recorder started with -capture, empty generates synthetic bursts")
+	flag.Float64Var(&speed, "speed", 10, "times faster than the feed or the synthetic clock (0 as fast as possible)")
+	flag.StringVar(&dbPath, "db", "", "database file to store into (default a temporary file, removed afterwards)")
+	flag.DurationVar(&duration, "duration", time.Minute, "synthetic time to generate")
+	flag.StringVar(&products, "products", "BTC-USD,ETH-USD,BCH-USD", "synthetic products")
+	flag.Float64Var(&config.UpdateRate, "updates", config.UpdateRate, "level updates per second of every synthetic product")
+	flag.Float64Var(&config.TradeRate, "trades", config.TradeRate, "trades per second of every synthetic product")
+	flag.IntVar(&config.Depth, "depth", config.Depth, "levels per side of the synthetic products")
+	flag.Parse()
+
+	if dbPath == "" {
+		file, err := ioutil.TempFile("", "bookmap-loadtest-*.db")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		file.Close()
+		dbPath = file.Name()
+		defer os.Remove(dbPath)
+	}
+	db, err := util.OpenDB(dbPath, []string{}, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	r := &run{speed: speed, latencies: []time.Duration{}}
+	source := "synthetic"
+	if feed != "" {
+		source = feed
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	started := time.Now()
+	if feed != "" {
+		err = replayFeed(db, feed, r)
+	} else {
+		generateBursts(db, strings.Split(products, ","), config, duration, r)
+	}
+	elapsed := time.Since(started)
+
+	runtime.ReadMemStats(&after)
+	db.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "loadtest error:", err)
+		os.Exit(1)
+	}
+
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	n := float64(len(r.latencies))
+	report := &Report{
+		Source:     source,
+		Speed:      speed,
+		Messages:   len(r.latencies),
+		Rejected:   r.rejected,
+		Seconds:    elapsed.Seconds(),
+		PerSecond:  n / elapsed.Seconds(),
+		LatencyP50: percentile(r.latencies, 0.5),
+		LatencyP90: percentile(r.latencies, 0.9),
+		LatencyP99: percentile(r.latencies, 0.99),
+		LatencyMax: percentile(r.latencies, 1),
+		MaxLag:     float64(r.maxLag) / float64(time.Millisecond),
+		GCs:        after.NumGC - before.NumGC,
+		GCPause:    float64(after.PauseTotalNs-before.PauseTotalNs) / float64(time.Millisecond),
+	}
+	if n > 0 {
+		report.AllocsPerMsg = float64(after.Mallocs-before.Mallocs) / n
+		report.BytesPerMsg = float64(after.TotalAlloc-before.TotalAlloc) / n
+	}
+	if info, err := os.Stat(dbPath); err == nil {
+		report.DatabaseBytes = info.Size()
+		report.StoredPerSecond = float64(info.Size()) / elapsed.Seconds()
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
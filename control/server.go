@@ -0,0 +1,395 @@
+package control
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/trading"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Server answers JSON-RPC 1.0 requests on a unix socket, for scripts on
+// the same machine, one request object per line, e.g.
+//
+//	{"method": "Control.Snapshot", "params": [{"product": "GDAX-BTC-USD", "depth": 5}], "id": 1}
+//
+// The methods are those of Control.
+type Server struct {
+	Path    string
+	Control *Control
+}
+
+func NewServer(path string, db *bolt.DB, infos []*product_info.Info) *Server {
+	return &Server{Path: path, Control: NewControl(db, infos)}
+}
+
+func (s *Server) Run() {
+	// a socket left over by a crashed run
+	os.Remove(s.Path)
+	listener, err := net.Listen("unix", s.Path)
+	if err != nil {
+		fmt.Println("control socket", err)
+		return
+	}
+	defer listener.Close()
+	// scripts of other users on the machine stay out
+	os.Chmod(s.Path, 0600)
+
+	server := rpc.NewServer()
+	if err := server.Register(s.Control); err != nil {
+		fmt.Println("control socket", err)
+		return
+	}
+	util.Go(s.Control.watchAlerts)
+
+	fmt.Println("control socket listening on", s.Path)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Println("control socket", err)
+			return
+		}
+		codec := jsonrpc.NewServerCodec(conn)
+		util.Go(func() { server.ServeCodec(codec) })
+	}
+}
+
+// Control holds the methods served on the control socket.
+type Control struct {
+	DB    *bolt.DB
+	Infos []*product_info.Info
+	// balances of the authenticated platforms, limits Route
+	Tracker *trading.Tracker
+	// order rate limits of the platforms, taken by Submit
+	Budget *trading.OrderBudget
+
+	alertsMu sync.Mutex
+	alerts   []*Alert
+	nextID   int
+}
+
+func NewControl(db *bolt.DB, infos []*product_info.Info) *Control {
+	return &Control{DB: db, Infos: infos, alerts: []*Alert{}}
+}
+
+func (c *Control) product(key string) (*product_info.Info, error) {
+	for _, info := range c.Infos {
+		if info.DatabaseKey == key {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown product %q", key)
+}
+
+type ProductsArgs struct{}
+
+// Products lists the recorded products.
+func (c *Control) Products(args ProductsArgs, reply *[]*product_info.Info) error {
+	*reply = c.Infos
+	return nil
+}
+
+type SnapshotArgs struct {
+	Product string `json:"product"`
+	// zero for the live book
+	At    time.Time `json:"at"`
+	Depth int       `json:"depth"`
+}
+
+type SnapshotLevel struct {
+	Price float64 `json:"price"`
+	Size  float64 `json:"size"`
+}
+
+type Snapshot struct {
+	Product string           `json:"product"`
+	Time    time.Time        `json:"time"`
+	Bids    []*SnapshotLevel `json:"bids"`
+	Asks    []*SnapshotLevel `json:"asks"`
+}
+
+// Snapshot returns the best Depth levels (default 10) per side of the
+// recorded book at a time.
+func (c *Control) Snapshot(args SnapshotArgs, reply *Snapshot) error {
+	if _, err := c.product(args.Product); err != nil {
+		return err
+	}
+	at := args.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	depth := args.Depth
+	if depth <= 0 {
+		depth = 10
+	}
+
+	book, last, err := util.BookAt(c.DB, args.Product, at)
+	if err != nil {
+		return err
+	}
+	book.Sort()
+	reply.Product = args.Product
+	reply.Time = last
+	reply.Bids = []*SnapshotLevel{}
+	reply.Asks = []*SnapshotLevel{}
+	for i := len(book.Bid) - 1; i >= 0 && len(reply.Bids) < depth; i -= 1 {
+		if book.Bid[i].Quantity > 0 {
+			reply.Bids = append(reply.Bids, &SnapshotLevel{Price: book.Bid[i].Price, Size: book.Bid[i].Quantity})
+		}
+	}
+	for i := 0; i < len(book.Ask) && len(reply.Asks) < depth; i += 1 {
+		if book.Ask[i].Quantity > 0 {
+			reply.Asks = append(reply.Asks, &SnapshotLevel{Price: book.Ask[i].Price, Size: book.Ask[i].Quantity})
+		}
+	}
+	return nil
+}
+
+type ExportArgs struct {
+	Product string `json:"product"`
+	// new archive file, see the archive command of bookmap-db
+	Path string    `json:"path"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Export writes a product or a time range of it into an archive file.
+func (c *Control) Export(args ExportArgs, reply *util.ArchiveResult) error {
+	if _, err := c.product(args.Product); err != nil {
+		return err
+	}
+	if args.Path == "" {
+		return fmt.Errorf("missing path")
+	}
+	result, err := util.ExportArchive(c.DB, args.Path, args.Product, args.From, args.To)
+	if err != nil {
+		return err
+	}
+	*reply = *result
+	return nil
+}
+
+type LevelArgs struct {
+	Product string    `json:"product"`
+	Price   float64   `json:"price"`
+	From    time.Time `json:"from"`
+	// zero for now
+	To time.Time `json:"to"`
+}
+
+// Level returns the size over time of one price level.
+func (c *Control) Level(args LevelArgs, reply *[]*util.LevelPoint) error {
+	if _, err := c.product(args.Product); err != nil {
+		return err
+	}
+	to := args.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	points, err := util.LevelSeries(c.DB, args.Product, args.Price, args.From, to)
+	if err != nil {
+		return err
+	}
+	*reply = points
+	return nil
+}
+
+type RouteArgs struct {
+	// e.g. BTC, routed over its USD quoted products
+	Base string  `json:"base"`
+	Side string  `json:"side"`
+	Size float64 `json:"size"`
+	// taker fee per platform, default trading.DefaultTakerFees
+	Fees map[string]float64 `json:"fees"`
+}
+
+// Route previews a market order split over the live books of all
+// platforms by price after fees. Platforms with a user stream only get
+// what their free balance pays for, nothing is sent.
+func (c *Control) Route(args RouteArgs, reply *trading.Route) error {
+	side := trading.Buy
+	switch args.Side {
+	case "buy":
+	case "sell":
+		side = trading.Sell
+	default:
+		return fmt.Errorf("side must be buy or sell")
+	}
+	if args.Size <= 0 {
+		return fmt.Errorf("size must be positive")
+	}
+
+	free := map[string]map[string]float64{}
+	if c.Tracker != nil {
+		for _, balance := range c.Tracker.AllBalances() {
+			if _, ok := free[balance.Exchange]; !ok {
+				free[balance.Exchange] = map[string]float64{}
+			}
+			free[balance.Exchange][balance.Asset] += balance.Free
+		}
+	}
+
+	venues := []*trading.Venue{}
+	now := time.Now()
+	for _, info := range c.Infos {
+		if info.BaseCurrency != args.Base || !trading.USDAssets[info.QuoteCurrency] {
+			continue
+		}
+		fee, ok := args.Fees[info.Platform]
+		if !ok {
+			fee = trading.DefaultTakerFees[info.Platform]
+		}
+		venue := &trading.Venue{Exchange: info.Platform, Product: info.DatabaseKey, Fee: fee, Sandbox: common.Sandboxed(info.Platform)}
+		if assets, ok := free[info.Platform]; ok {
+			venue.Available = assets[info.QuoteCurrency]
+			if side == trading.Sell {
+				venue.Available = assets[info.BaseCurrency]
+			}
+			if venue.Available <= 0 {
+				continue
+			}
+		}
+
+		book, _, err := util.BookAt(c.DB, info.DatabaseKey, now)
+		if err != nil {
+			continue
+		}
+		book.Sort()
+		if side == trading.Buy {
+			for _, level := range book.Ask {
+				if level.Quantity > 0 {
+					venue.Levels = append(venue.Levels, trading.Level{Price: level.Price, Size: level.Quantity})
+				}
+			}
+		} else {
+			for i := len(book.Bid) - 1; i >= 0; i -= 1 {
+				if book.Bid[i].Quantity > 0 {
+					venue.Levels = append(venue.Levels, trading.Level{Price: book.Bid[i].Price, Size: book.Bid[i].Quantity})
+				}
+			}
+		}
+		venues = append(venues, venue)
+	}
+	if len(venues) == 0 {
+		return fmt.Errorf("no USD quoted %s book to route to", args.Base)
+	}
+
+	*reply = *trading.RouteOrder(side, args.Size, venues)
+	return nil
+}
+
+type SubmitArgs struct {
+	Exchange      string `json:"exchange"`
+	ClientOrderID string `json:"client_order_id"`
+	// wait for the next window instead of failing when over budget
+	Queue bool `json:"queue"`
+}
+
+// Submit is called by order entry right before it sends an order, e.g. a
+// child of Route. It takes the order from the rate budget of the exchange
+// and fails with trading.ErrRateLimited when it is exhausted, while Queue
+// only once trading.OrderBudget.MaxQueue orders wait already. The submit
+// to ack latency of ClientOrderID starts once it passed.
+func (c *Control) Submit(args SubmitArgs, reply *bool) error {
+	if args.Exchange == "" {
+		return fmt.Errorf("exchange is required")
+	}
+	if c.Budget != nil {
+		if err := c.Budget.Acquire(args.Exchange, args.Queue); err != nil {
+			return err
+		}
+	}
+	if c.Tracker != nil && args.ClientOrderID != "" {
+		c.Tracker.Latency.Submitted(args.Exchange, args.ClientOrderID, time.Now())
+	}
+	*reply = true
+	return nil
+}
+
+// Alert is a price level, once a trade reaches it the product gets a
+// bookmark, which also goes out as alert (e.g. over MQTT).
+type Alert struct {
+	ID      int     `json:"id"`
+	Product string  `json:"product"`
+	Price   float64 `json:"price"`
+	// trigger at or above Price, else at or below
+	Above bool `json:"above"`
+}
+
+// SetAlert adds a price alert and replies with its id.
+func (c *Control) SetAlert(args Alert, reply *int) error {
+	if _, err := c.product(args.Product); err != nil {
+		return err
+	}
+	if args.Price <= 0 {
+		return fmt.Errorf("price must be positive")
+	}
+	c.alertsMu.Lock()
+	defer c.alertsMu.Unlock()
+	c.nextID += 1
+	args.ID = c.nextID
+	c.alerts = append(c.alerts, &args)
+	*reply = args.ID
+	return nil
+}
+
+// Alerts lists the alerts which did not trigger yet.
+func (c *Control) Alerts(args ProductsArgs, reply *[]*Alert) error {
+	c.alertsMu.Lock()
+	defer c.alertsMu.Unlock()
+	*reply = append([]*Alert{}, c.alerts...)
+	return nil
+}
+
+func (c *Control) watchAlerts() {
+	topics := []string{}
+	for _, info := range c.Infos {
+		topics = append(topics, util.TradeTopic(info.DatabaseKey))
+	}
+	for {
+		sub := util.Events.Subscribe(topics, 4096)
+		for ev := range sub.C {
+			c.trade(ev)
+		}
+		// missed trades can not be checked anymore
+		fmt.Println("control alerts fell behind, resubscribing")
+	}
+}
+
+func (c *Control) trade(ev *util.Event) {
+	if len(ev.Data) == 0 || ev.Data[0] != orderbook.TradePacket {
+		return
+	}
+	_, price, _ := orderbook.UnpackTrade(ev.Data)
+
+	c.alertsMu.Lock()
+	triggered := []*Alert{}
+	pending := c.alerts[:0]
+	for _, alert := range c.alerts {
+		if alert.Product == ev.Bucket && ((alert.Above && price >= alert.Price) || (!alert.Above && price <= alert.Price)) {
+			triggered = append(triggered, alert)
+		} else {
+			pending = append(pending, alert)
+		}
+	}
+	c.alerts = pending
+	c.alertsMu.Unlock()
+
+	for _, alert := range triggered {
+		info, _ := c.product(alert.Product)
+		label := i18n.Sprintf("price alert %s reached %s", info.FormatFloat(alert.Price), info.FormatFloat(price))
+		if err := util.AddBookmark(c.DB, alert.Product, orderbook.UnpackTimeKey(ev.Key), label); err != nil {
+			fmt.Println("control alert", err)
+		}
+	}
+}
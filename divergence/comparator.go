@@ -0,0 +1,266 @@
+package divergence
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/tsdb"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// metric is the series of a window field, stored under A at the end of
+// the window, e.g. divergence.BTC-USDT@1000ms.mid_diff_avg_bps.
+func metric(b, field string) string {
+	return "divergence." + b + "." + field
+}
+
+// Window is how far two books of the same product were apart over a
+// window, sampled every Interval while both were synced.
+type Window struct {
+	A   string    `json:"a"`
+	B   string    `json:"b"`
+	End time.Time `json:"end"`
+	// samples taken, and with both books synced
+	Samples int `json:"samples"`
+	Synced  int `json:"synced"`
+	// share of the synced samples with a different best bid or ask
+	BBOMismatch float64 `json:"bbo_mismatch"`
+	// mid price difference in basis points
+	MidDiffAvg float64 `json:"mid_diff_avg_bps"`
+	MidDiffMax float64 `json:"mid_diff_max_bps"`
+	// size differing within the top levels as a share of both, 0 equal
+	DepthDiff float64 `json:"depth_diff"`
+	// packets stored for each book
+	PacketsA int `json:"packets_a"`
+	PacketsB int `json:"packets_b"`
+}
+
+// Comparator keeps the books of two recordings of the same product, e.g.
+// depth streams of different speeds, and records how far they diverge.
+type Comparator struct {
+	DB *bolt.DB
+	A  string
+	B  string
+	// how often the books are compared
+	Interval time.Duration
+	// windows are stored and logged after this long
+	Window time.Duration
+	// levels per side compared for DepthDiff
+	Depth int
+
+	books  map[string]*orderbook.Book
+	synced map[string]bool
+
+	mu      sync.Mutex
+	current *Window
+	midSum  float64
+	depth   float64
+	last    *Window
+}
+
+func NewComparator(db *bolt.DB, a, b string) *Comparator {
+	return &Comparator{
+		DB:       db,
+		A:        a,
+		B:        b,
+		Interval: time.Second,
+		Window:   time.Minute,
+		Depth:    10,
+		books:    map[string]*orderbook.Book{a: orderbook.New(a), b: orderbook.New(b)},
+		synced:   map[string]bool{},
+	}
+}
+
+func (c *Comparator) Run() {
+	topics := append(util.ProductTopics(c.A), util.ProductTopics(c.B)...)
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	c.reset(time.Now())
+	for {
+		sub := util.Events.Subscribe(topics, 4096)
+		c.watch(sub, ticker.C)
+		// wait for the next sync of both books
+		fmt.Println("divergence", c.A, c.B, "fell behind, resubscribing")
+		c.synced = map[string]bool{}
+	}
+}
+
+func (c *Comparator) watch(sub *util.Subscription, tick <-chan time.Time) {
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			c.handle(ev)
+		case now := <-tick:
+			c.sample(now)
+		}
+	}
+}
+
+func (c *Comparator) handle(ev *util.Event) {
+	book, ok := c.books[ev.Bucket]
+	if !ok || len(ev.Data) == 0 {
+		return
+	}
+	c.mu.Lock()
+	if ev.Bucket == c.A {
+		c.current.PacketsA += 1
+	} else {
+		c.current.PacketsB += 1
+	}
+	c.mu.Unlock()
+
+	if orderbook.IsSyncPacket(ev.Data) {
+		c.synced[ev.Bucket] = true
+	}
+	if !c.synced[ev.Bucket] {
+		return
+	}
+	if !book.Process(orderbook.UnpackTimeKey(ev.Key), ev.Data) {
+		c.synced[ev.Bucket] = false
+	}
+}
+
+func (c *Comparator) reset(now time.Time) {
+	c.current = &Window{A: c.A, B: c.B, End: now.Add(c.Window)}
+	c.midSum = 0
+	c.depth = 0
+}
+
+func (c *Comparator) sample(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := c.current
+	w.Samples += 1
+	a, b := c.books[c.A], c.books[c.B]
+	// removed levels stay in the books with quantity 0 until ResetStats
+	aBid, aAsk := a.BestBid(), a.BestAsk()
+	bBid, bAsk := b.BestBid(), b.BestAsk()
+	if c.synced[c.A] && c.synced[c.B] && aBid != nil && aAsk != nil && bBid != nil && bAsk != nil {
+		w.Synced += 1
+		if aBid.Price != bBid.Price || aAsk.Price != bAsk.Price {
+			w.BBOMismatch += 1
+		}
+		aMid, bMid := (aBid.Price+aAsk.Price)/2, (bBid.Price+bAsk.Price)/2
+		diff := math.Abs(aMid-bMid) / bMid * 10000
+		c.midSum += diff
+		w.MidDiffMax = math.Max(w.MidDiffMax, diff)
+		c.depth += depthDiff(a, b, c.Depth)
+	}
+
+	if now.Before(w.End) {
+		return
+	}
+	if w.Synced > 0 {
+		w.BBOMismatch /= float64(w.Synced)
+		w.MidDiffAvg = c.midSum / float64(w.Synced)
+		w.DepthDiff = c.depth / float64(w.Synced)
+	}
+	if err := c.store(w); err != nil {
+		fmt.Println("divergence", err)
+	}
+	fmt.Printf("divergence %s vs %s: bbo mismatch %.1f%% mid %.2f/%.2fbps depth %.1f%% packets %d/%d\n",
+		c.A, c.B, w.BBOMismatch*100, w.MidDiffAvg, w.MidDiffMax, w.DepthDiff*100, w.PacketsA, w.PacketsB)
+	c.last = w
+	c.reset(now)
+}
+
+// depthDiff compares the sizes of the top levels of both books by price,
+// removed levels are skipped.
+func depthDiff(a, b *orderbook.Book, depth int) float64 {
+	sizes := map[float64][2]float64{}
+	add := func(levels orderbook.BookLevelList, bids bool, i int) {
+		for n, count := 0, 0; count < depth && n < len(levels); n++ {
+			level := levels[n]
+			if bids {
+				level = levels[len(levels)-1-n]
+			}
+			if level.Quantity == 0 {
+				continue
+			}
+			count++
+			s := sizes[level.Price]
+			s[i] += level.Quantity
+			sizes[level.Price] = s
+		}
+	}
+	add(a.Bid, true, 0)
+	add(a.Ask, false, 0)
+	add(b.Bid, true, 1)
+	add(b.Ask, false, 1)
+
+	diff, total := 0.0, 0.0
+	for _, s := range sizes {
+		diff += math.Abs(s[0] - s[1])
+		total += s[0] + s[1]
+	}
+	if total == 0 {
+		return 0
+	}
+	return diff / total
+}
+
+func (c *Comparator) store(w *Window) error {
+	return tsdb.AppendMany(c.DB, c.A, w.End, map[string]float64{
+		metric(c.B, "samples"):          float64(w.Samples),
+		metric(c.B, "synced"):           float64(w.Synced),
+		metric(c.B, "bbo_mismatch"):     w.BBOMismatch,
+		metric(c.B, "mid_diff_avg_bps"): w.MidDiffAvg,
+		metric(c.B, "mid_diff_max_bps"): w.MidDiffMax,
+		metric(c.B, "depth_diff"):       w.DepthDiff,
+		metric(c.B, "packets_a"):        float64(w.PacketsA),
+		metric(c.B, "packets_b"):        float64(w.PacketsB),
+	})
+}
+
+// Last returns the last finished window, nil before the first.
+func (c *Comparator) Last() *Window {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// ListWindows returns the stored windows of a comparison between from and
+// to, zero times are open ends.
+func ListWindows(db *bolt.DB, a, b string, from, to time.Time) ([]*Window, error) {
+	windows := []*Window{}
+	byEnd := map[int64]*Window{}
+	fields := []struct {
+		name string
+		set  func(w *Window, v float64)
+	}{
+		{"samples", func(w *Window, v float64) { w.Samples = int(v) }},
+		{"synced", func(w *Window, v float64) { w.Synced = int(v) }},
+		{"bbo_mismatch", func(w *Window, v float64) { w.BBOMismatch = v }},
+		{"mid_diff_avg_bps", func(w *Window, v float64) { w.MidDiffAvg = v }},
+		{"mid_diff_max_bps", func(w *Window, v float64) { w.MidDiffMax = v }},
+		{"depth_diff", func(w *Window, v float64) { w.DepthDiff = v }},
+		{"packets_a", func(w *Window, v float64) { w.PacketsA = int(v) }},
+		{"packets_b", func(w *Window, v float64) { w.PacketsB = int(v) }},
+	}
+	for _, field := range fields {
+		points, err := tsdb.Range(db, a, metric(b, field.name), from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range points {
+			w, ok := byEnd[p.Time.UnixNano()]
+			if !ok {
+				w = &Window{A: a, B: b, End: p.Time}
+				byEnd[p.Time.UnixNano()] = w
+				windows = append(windows, w)
+			}
+			field.set(w, p.Value)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].End.Before(windows[j].End) })
+	return windows, nil
+}
@@ -0,0 +1,252 @@
+package episodes
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Scanner replays the recording of a product in steps and tags the steps
+// where
+//
+//	velocity  the last trade price moved at least MinVelocity percent per
+//	          minute since the step before
+//	trades    the trades per second ran Factor times the Baseline before
+//	pulls     the size removed from the book without trades ran Factor
+//	          times the Baseline before
+//
+// Tagged steps at most MergeGap apart are one episode. Episodes are stored
+// with the product and bookmarked, so they show up in the bookmark list to
+// jump into the replay.
+type Scanner struct {
+	DB          *bolt.DB
+	Step        time.Duration
+	Baseline    time.Duration
+	Factor      float64
+	MinVelocity float64
+	// trades per second a step needs for the trades tag, a few trades on a
+	// quiet tape are no burst
+	MinTrades float64
+	MergeGap  time.Duration
+}
+
+func NewScanner(db *bolt.DB) *Scanner {
+	return &Scanner{
+		DB:          db,
+		Step:        10 * time.Second,
+		Baseline:    10 * time.Minute,
+		Factor:      4,
+		MinVelocity: 0.5,
+		MinTrades:   1,
+		MergeGap:    30 * time.Second,
+	}
+}
+
+type step struct {
+	trades int
+	pulled float64
+	price  float64
+}
+
+type scan struct {
+	*Scanner
+	from     time.Time
+	history  []step
+	price    float64
+	episode  *util.Episode
+	first    float64
+	last     float64
+	episodes []*util.Episode
+}
+
+// Run scans from to to, replaces the episodes stored for the range and
+// bookmarks the new ones.
+func (s *Scanner) Run(product string, from, to time.Time) ([]*util.Episode, error) {
+	episodes, err := s.Scan(product, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if err := util.PutEpisodes(s.DB, product, from, to, episodes); err != nil {
+		return nil, err
+	}
+	for _, e := range episodes {
+		label := i18n.Sprintf("volatility %+.2f%% %s %.1fx", e.Move, strings.Join(e.Tags, "+"), e.Score)
+		if err := util.AddBookmark(s.DB, product, e.Start, label); err != nil {
+			return nil, err
+		}
+	}
+	return episodes, nil
+}
+
+// Scan returns the episodes starting between from and to. The Baseline
+// before from is replayed too, so episodes right at from are found.
+func (s *Scanner) Scan(product string, from, to time.Time) ([]*util.Episode, error) {
+	sc := &scan{Scanner: s, from: from, episodes: []*util.Episode{}}
+	start := from.Add(-s.Baseline)
+	book := orderbook.New(product)
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := util.NewCursor(s.DB, b)
+
+		// the book needs the last sync before the start
+		key, buf := c.Seek(orderbook.PackTimeKey(start))
+		for key != nil && !orderbook.IsSyncPacket(buf) {
+			key, buf = c.Prev()
+		}
+		if key == nil {
+			key, buf = c.Seek(orderbook.PackTimeKey(start))
+		}
+
+		var stepStart time.Time
+		current := step{}
+		synced := false
+		for ; key != nil; key, buf = c.Next() {
+			t := orderbook.UnpackTimeKey(key)
+			if !t.Before(to) {
+				break
+			}
+			if !t.Before(start) {
+				if stepStart.IsZero() {
+					stepStart = t.Truncate(s.Step)
+					book.Flow.ResetStats()
+				}
+				for !t.Before(stepStart.Add(s.Step)) {
+					current.pulled = book.Flow.Stats.Bid.Pulled + book.Flow.Stats.Ask.Pulled
+					book.Flow.ResetStats()
+					sc.close(stepStart, current)
+					current = step{}
+					stepStart = stepStart.Add(s.Step)
+				}
+			}
+
+			if len(buf) == 0 {
+				continue
+			}
+			if buf[0] == orderbook.TradePacket {
+				_, price, _ := orderbook.UnpackTrade(buf)
+				if !stepStart.IsZero() {
+					current.trades += 1
+				}
+				sc.price = price
+				book.Process(t, buf)
+				continue
+			}
+			if orderbook.IsSyncPacket(buf) {
+				synced = true
+			}
+			if synced && !book.Process(t, buf) {
+				synced = false
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sc.finish()
+	return sc.episodes, nil
+}
+
+// close rates the step starting at t against the steps before it.
+func (sc *scan) close(t time.Time, current step) {
+	current.price = sc.price
+	steps := int(sc.Baseline / sc.Step)
+	defer func() {
+		sc.history = append(sc.history, current)
+		if len(sc.history) > steps {
+			sc.history = sc.history[1:]
+		}
+	}()
+	if len(sc.history) < steps || t.Before(sc.from) {
+		return
+	}
+
+	var trades, pulled float64
+	for _, h := range sc.history {
+		trades += float64(h.trades)
+		pulled += h.pulled
+	}
+	trades /= float64(len(sc.history))
+	pulled /= float64(len(sc.history))
+	previous := sc.history[len(sc.history)-1].price
+
+	tags := []string{}
+	var score float64
+	var velocity float64
+	if previous > 0 && current.price > 0 {
+		velocity = math.Abs(current.price-previous) / previous * 100 * float64(time.Minute) / float64(sc.Step)
+		if velocity >= sc.MinVelocity {
+			tags = append(tags, "velocity")
+			score = math.Max(score, velocity/sc.MinVelocity)
+		}
+	}
+	rate := float64(current.trades) / sc.Step.Seconds()
+	if rate >= sc.MinTrades && float64(current.trades) >= sc.Factor*trades {
+		tags = append(tags, "trades")
+		if trades > 0 {
+			score = math.Max(score, float64(current.trades)/trades)
+		} else {
+			score = math.Max(score, rate/sc.MinTrades)
+		}
+	}
+	if pulled > 0 && current.pulled >= sc.Factor*pulled {
+		tags = append(tags, "pulls")
+		score = math.Max(score, current.pulled/pulled)
+	}
+	if len(tags) == 0 {
+		return
+	}
+
+	e := sc.episode
+	if e == nil || t.Sub(e.End) > sc.MergeGap {
+		sc.finish()
+		e = &util.Episode{Start: t, Tags: []string{}}
+		sc.episode = e
+		sc.first = previous
+		if sc.first == 0 {
+			sc.first = current.price
+		}
+	}
+	e.End = t.Add(sc.Step)
+	for _, tag := range tags {
+		if !hasTag(e.Tags, tag) {
+			e.Tags = append(e.Tags, tag)
+		}
+	}
+	e.Velocity = math.Max(e.Velocity, velocity)
+	e.Trades = math.Max(e.Trades, rate)
+	e.Pulled += current.pulled
+	e.Score = math.Max(e.Score, score)
+	sc.last = current.price
+}
+
+func (sc *scan) finish() {
+	e := sc.episode
+	if e == nil {
+		return
+	}
+	sc.episode = nil
+	if sc.first > 0 {
+		e.Move = (sc.last - sc.first) / sc.first * 100
+	}
+	sc.episodes = append(sc.episodes, e)
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
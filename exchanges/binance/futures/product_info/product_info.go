@@ -0,0 +1,172 @@
+package product_info
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Market is one of the futures markets of binance, each with its own
+// streams and REST api.
+type Market struct {
+	Platform string
+	// REST api, e.g. https://fapi.binance.com/fapi/v1
+	API string
+	// combined streams, the stream names are appended
+	Stream string
+	// sizes are contracts worth ContractSize of the quote currency
+	Inverse bool
+
+	CachedInfo      map[string]product_info.Info
+	CachedContracts map[string]Contract
+}
+
+// USDM are the USDT and BUSD margined futures, sized in the base currency.
+var USDM = &Market{
+	Platform: "BinanceUSDM",
+	API:      "https://fapi.binance.com/fapi/v1",
+	Stream:   "wss://fstream.binance.com/stream?streams=",
+}
+
+// COINM are the coin margined futures, sized in contracts of usually 10 or
+// 100 USD.
+var COINM = &Market{
+	Platform: "BinanceCOINM",
+	API:      "https://dapi.binance.com/dapi/v1",
+	Stream:   "wss://dstream.binance.com/stream?streams=",
+	Inverse:  true,
+}
+
+// Contract is the symbol of a product and how its sizes are turned into the
+// base currency.
+type Contract struct {
+	Symbol string
+	// quote currency per contract of inverse contracts
+	Size    float64
+	Inverse bool
+}
+
+// BaseSize returns the size of contracts at price in the base currency.
+func (c Contract) BaseSize(size, price float64) float64 {
+	if c.Inverse && price > 0 {
+		return size * c.Size / price
+	}
+	return size
+}
+
+func init() {
+	FetchAllProductInfo()
+}
+
+func FetchAllProductInfo() {
+	for _, m := range []*Market{USDM, COINM} {
+		if err := m.FetchAllProductInfo(); err != nil {
+			fmt.Println("InitProduct error", m.Platform, err)
+		}
+	}
+}
+
+// FetchAllProductInfo reads the symbols of the market from exchangeInfo.
+// Perpetuals are named by their currencies, e.g. BTC-USDT, delivery
+// futures by their symbol, e.g. BTCUSDT_240628.
+func (m *Market) FetchAllProductInfo() error {
+	m.CachedInfo = map[string]product_info.Info{}
+	m.CachedContracts = map[string]Contract{}
+
+	res, err := common.Get(m.Platform, m.API+"/exchangeInfo")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return err
+	}
+
+	var data struct {
+		Symbols []struct {
+			Symbol       string  `json:"symbol"`
+			ContractType string  `json:"contractType"`
+			BaseAsset    string  `json:"baseAsset"`
+			QuoteAsset   string  `json:"quoteAsset"`
+			ContractSize float64 `json:"contractSize"`
+			// status of USD-M, contractStatus of COIN-M
+			Status         string `json:"status"`
+			ContractStatus string `json:"contractStatus"`
+			Filters        []struct {
+				FilterType string `json:"filterType"`
+				TickSize   string `json:"tickSize"`
+				MinQty     string `json:"minQty"`
+			} `json:"filters"`
+		} `json:"symbols"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return common.Protocol("exchangeInfo: %s", err)
+	}
+
+	for _, s := range data.Symbols {
+		if s.Status != "TRADING" && s.ContractStatus != "TRADING" {
+			continue
+		}
+		name := s.Symbol
+		if s.ContractType == "PERPETUAL" {
+			name = fmt.Sprintf("%s-%s", s.BaseAsset, s.QuoteAsset)
+		}
+
+		info := product_info.Info{
+			ID:            s.Symbol,
+			DisplayName:   name,
+			BaseCurrency:  s.BaseAsset,
+			QuoteCurrency: s.QuoteAsset,
+			Platform:      m.Platform,
+			DatabaseKey:   fmt.Sprintf("%s-%s", m.Platform, name),
+		}
+		contract := Contract{Symbol: s.Symbol, Size: s.ContractSize, Inverse: m.Inverse}
+
+		for _, f := range s.Filters {
+			switch f.FilterType {
+			case "PRICE_FILTER":
+				tick, _ := strconv.ParseFloat(f.TickSize, 64)
+				info.QuoteIncrement = product_info.FloatString(tick)
+				info.FloatFormat = fmt.Sprintf("%%.%df", util.NumDecPlaces(tick))
+			case "LOT_SIZE":
+				if !contract.Inverse {
+					// the base size of inverse contracts depends on the price
+					min, _ := strconv.ParseFloat(f.MinQty, 64)
+					info.BaseMinSize = product_info.FloatString(min)
+				}
+			}
+		}
+
+		m.CachedInfo[name] = info
+		m.CachedContracts[name] = contract
+	}
+	return nil
+}
+
+func (m *Market) FetchProductInfo(id string) product_info.Info {
+	if info, ok := m.CachedInfo[id]; ok {
+		return info
+	}
+	return product_info.Info{}
+}
+
+func (m *Market) FetchContract(id string) (Contract, bool) {
+	contract, ok := m.CachedContracts[id]
+	return contract, ok
+}
+
+// StreamSymbol is the symbol as streams name it, e.g. btcusd_perp.
+func (c Contract) StreamSymbol() string {
+	return strings.ToLower(c.Symbol)
+}
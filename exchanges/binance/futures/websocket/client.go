@@ -0,0 +1,517 @@
+package websocket
+
+// https://binance-docs.github.io/apidocs/futures/en/#websocket-market-streams
+// https://binance-docs.github.io/apidocs/delivery/en/#websocket-market-streams
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
+	book_info "github.com/lian/gdax-bookmap/exchanges/binance/futures/product_info"
+	"github.com/lian/gdax-bookmap/exchanges/binance/userdata"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/trading"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	// streams are part of the url like on spot, the REST snapshot has the
+	// top 1000 levels, the user stream reports positions
+	for _, m := range []*book_info.Market{book_info.USDM, book_info.COINM} {
+		common.RegisterCapabilities(&common.Capabilities{
+			Platform:    m.Platform,
+			MaxDepth:    1000,
+			UserStreams: true,
+		})
+		market := m
+		exchanges.Register(m.Platform, func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+			c := New(s.DB, market, s.ProductsOr(defaultProducts(market)...))
+			c.BookmarkTradeSize = s.BookmarkTradeSize
+			c.Shards = s.Shards
+			// no testnet urls, sandboxed futures stay without positions
+			if s.APIKey != "" && !common.Sandboxed(market.Platform) {
+				c.APIKey = s.APIKey
+				c.Tracker = s.Tracker
+			}
+			return c, c.Infos
+		})
+	}
+}
+
+// defaultProducts are recorded when the settings name none.
+func defaultProducts(m *book_info.Market) []string {
+	if m.Inverse {
+		return []string{"BTC-USD", "ETH-USD"}
+	}
+	return []string{"BTC-USDT", "ETH-USDT"}
+}
+
+type Client struct {
+	exchanges.BookIndex
+	Platform          string
+	Market            *book_info.Market
+	Socket            *websocket.Conn
+	Products          []string
+	Books             map[string]*orderbook.Book
+	Contracts         map[string]book_info.Contract
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	Shards            *util.Shards
+	APIKey            string
+	ListenKey         string
+	Tracker           *trading.Tracker
+	stopper           common.Stopper
+	// books start at the first message instead of a REST snapshot, for
+	// replaying captured feeds
+	Replay bool
+}
+
+// NewUSDM records USD-M futures, e.g. BTC-USDT.
+func NewUSDM(db *bolt.DB, products []string) *Client {
+	return New(db, book_info.USDM, products)
+}
+
+// NewCOINM records COIN-M futures, e.g. BTC-USD.
+func NewCOINM(db *bolt.DB, products []string) *Client {
+	return New(db, book_info.COINM, products)
+}
+
+func New(db *bolt.DB, market *book_info.Market, products []string) *Client {
+	c := &Client{
+		Platform:   market.Platform,
+		Market:     market,
+		Products:   []string{},
+		Books:      map[string]*orderbook.Book{},
+		Contracts:  map[string]book_info.Contract{},
+		BatchWrite: map[string]*storage.BookWriter{},
+		DB:         db,
+		Infos:      []*product_info.Info{},
+	}
+	if c.DB != nil {
+		c.dbEnabled = true
+	}
+
+	for _, name := range products {
+		c.AddProduct(name)
+	}
+
+	if c.dbEnabled {
+		buckets := []string{}
+		for _, info := range c.Infos {
+			buckets = append(buckets, info.DatabaseKey)
+		}
+		util.CreateBucketsDB(c.DB, buckets)
+	}
+
+	return c
+}
+
+func (c *Client) AddProduct(name string) {
+	contract, ok := c.Market.FetchContract(name)
+	if !ok {
+		fmt.Println(c.Platform, "unknown product", name)
+		return
+	}
+	c.Products = append(c.Products, name)
+	book := orderbook.New(name)
+	info := c.Market.FetchProductInfo(name)
+	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
+	book.SetProductInfo(info)
+	c.Contracts[name] = contract
+	symbol := contract.StreamSymbol()
+	for _, stream := range []string{symbol + "@depth@100ms", symbol + "@aggTrade", symbol + "@markPrice@1s"} {
+		c.Books[stream] = book
+	}
+	c.AddBook(book.ID, book)
+}
+
+func (c *Client) Connect() error {
+	streams := []string{}
+	for stream, book := range c.Books {
+		streams = append(streams, stream)
+		// updates of the new connection follow a new snapshot
+		book.Synced = false
+		book.Snapshot = nil
+	}
+	if c.APIKey != "" {
+		if c.ListenKey == "" {
+			key, err := userdata.CreateListenKeyAt(c.Market.API+"/listenKey", c.APIKey)
+			if err != nil {
+				fmt.Println("failed to create listenKey", err)
+			}
+			c.ListenKey = key
+		}
+		if c.ListenKey != "" {
+			streams = append(streams, c.ListenKey)
+		}
+	}
+	url := c.Market.Stream + strings.Join(streams, "/")
+
+	fmt.Println("connect to websocket", url)
+	s, _, err := common.Dial(c.Platform, url)
+	if err != nil {
+		return err
+	}
+
+	c.Socket = s
+	c.stopper.Connected(s)
+	c.ConnectedAt = time.Now()
+
+	return nil
+}
+
+type PacketHeader struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+}
+
+type PacketEvent struct {
+	EventType string `json:"e"`
+	EventTime int64  `json:"E"`
+}
+
+type PacketDepthUpdate struct {
+	FirstUpdateID uint64 `json:"U"`
+	FinalUpdateID uint64 `json:"u"`
+	// final update id of the update before
+	PrevUpdateID uint64        `json:"pu"`
+	Bids         []interface{} `json:"b"`
+	Asks         []interface{} `json:"a"`
+}
+
+type PacketAggTrade struct {
+	BuyerMaker *bool       `json:"m"`
+	Price      interface{} `json:"p"`
+	Quantity   interface{} `json:"q"`
+}
+
+type PacketMarkPrice struct {
+	MarkPrice       interface{} `json:"p"`
+	IndexPrice      interface{} `json:"i"`
+	FundingRate     interface{} `json:"r"`
+	NextFundingTime int64       `json:"T"`
+}
+
+// UpdateSync checks a depth update against the book, false skips it. The
+// first update after a snapshot spans its lastUpdateId, every later one
+// continues where the one before ended.
+func (c *Client) UpdateSync(book *orderbook.Book, update *PacketDepthUpdate) (bool, error) {
+	first, last := update.FirstUpdateID, update.FinalUpdateID
+
+	if !book.Synced {
+		if c.Replay {
+			book.Synced = true
+			book.Sequence = last
+			return true, nil
+		}
+		if book.Snapshot == nil {
+			// the snapshot failed, the next message fetches it again
+			return false, nil
+		}
+		if last < book.Sequence {
+			// older than the snapshot
+			return false, nil
+		}
+		if first > book.Sequence {
+			book.Snapshot = nil
+			return false, common.SequenceGap("%s snapshot %d older than update %d, resync", book.ID, book.Sequence, first)
+		}
+		book.Synced = true
+		book.Sequence = last
+		return true, nil
+	}
+
+	if update.PrevUpdateID != book.Sequence {
+		prev := book.Sequence
+		if c.Replay {
+			// nothing to resync from, the replay goes on after the gap
+			book.Sequence = last
+			return false, common.SequenceGap("%s expected pu %d got %d", book.ID, prev, update.PrevUpdateID)
+		}
+		book.Synced = false
+		book.Snapshot = nil
+		return false, common.SequenceGap("%s expected pu %d got %d, resync", book.ID, prev, update.PrevUpdateID)
+	}
+	book.Sequence = last
+	return true, nil
+}
+
+func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage) error {
+	var event PacketEvent
+	if err := json.Unmarshal(raw, &event); err != nil {
+		return common.Protocol("PacketEventType-parse: %s", err)
+	}
+	eventTime := time.Unix(0, event.EventTime*int64(time.Millisecond))
+	common.Clocks.Event(c.Platform, eventTime, time.Now())
+	contract := c.Contracts[book.ID]
+
+	var trade *orderbook.Trade
+	var mark *db_orderbook.MarkPrice
+
+	switch event.EventType {
+	case "depthUpdate":
+		var depthUpdate PacketDepthUpdate
+		if err := json.Unmarshal(raw, &depthUpdate); err != nil {
+			return common.Protocol("PacketDepthUpdate-parse: %s", err)
+		}
+
+		if apply, err := c.UpdateSync(book, &depthUpdate); !apply {
+			return err
+		}
+
+		for _, d := range depthUpdate.Bids {
+			price, size, ok := common.QuotedLevel(c.Platform, "depthUpdate.b", d)
+			if !ok {
+				continue
+			}
+			book.UpdateBidLevel(eventTime, price, contract.BaseSize(size, price))
+		}
+
+		for _, d := range depthUpdate.Asks {
+			price, size, ok := common.QuotedLevel(c.Platform, "depthUpdate.a", d)
+			if !ok {
+				continue
+			}
+			book.UpdateAskLevel(eventTime, price, contract.BaseSize(size, price))
+		}
+
+	case "aggTrade":
+		var data PacketAggTrade
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return common.Protocol("PacketAggTrade-parse: %s", err)
+		}
+
+		price, ok := common.QuotedNumber(c.Platform, "aggTrade.p", data.Price)
+		if !ok {
+			return nil
+		}
+		size, ok := common.QuotedNumber(c.Platform, "aggTrade.q", data.Quantity)
+		if !ok {
+			return nil
+		}
+
+		side, source := book.AggressorSide(price)
+		if data.BuyerMaker != nil {
+			side, source = uint8(orderbook.AskSide), db_orderbook.SideFromVenue
+			if *data.BuyerMaker {
+				// the buyer was resting, a sell hit the bid
+				side = uint8(orderbook.BidSide)
+			}
+		}
+		book.AddClassifiedTrade(eventTime, side, source, price, contract.BaseSize(size, price))
+		trade = book.Trades[len(book.Trades)-1]
+
+	case "markPriceUpdate":
+		var data PacketMarkPrice
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return common.Protocol("PacketMarkPrice-parse: %s", err)
+		}
+
+		mark = &db_orderbook.MarkPrice{}
+		if data.NextFundingTime != 0 {
+			mark.NextFunding = time.Unix(0, data.NextFundingTime*int64(time.Millisecond))
+		}
+		var ok bool
+		if mark.Mark, ok = common.QuotedNumber(c.Platform, "markPriceUpdate.p", data.MarkPrice); !ok {
+			return nil
+		}
+		if mark.Index, ok = common.QuotedNumber(c.Platform, "markPriceUpdate.i", data.IndexPrice); !ok {
+			return nil
+		}
+		if rate, ok := data.FundingRate.(string); ok && rate != "" {
+			// delivery contracts have no funding
+			mark.FundingRate, _ = common.QuotedNumber(c.Platform, "markPriceUpdate.r", data.FundingRate)
+		}
+
+	default:
+		return common.Protocol("unkown event %s %s %s", book.ID, event.EventType, string(raw))
+	}
+
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
+		if trade != nil {
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
+		}
+		if mark != nil {
+			batch.Write(now, db_orderbook.PackMarkPrice(mark))
+		}
+
+		if batch.NextSync(now) {
+			fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
+			c.WriteSync(batch, book, now)
+		} else {
+			if batch.NextDiff(now) {
+				c.WriteDiff(batch, book, now)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	diff := book.Diff
+	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
+		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		batch.Write(now, pkt)
+		book.ResetDiff()
+		batch.LastDiffSeq = book.Sequence + 1
+	}
+}
+
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+func (c *Client) HandleUserData(raw json.RawMessage) error {
+	if c.Tracker == nil {
+		return nil
+	}
+	products := map[string]userdata.FuturesProduct{}
+	for _, info := range c.Infos {
+		margin := info.QuoteCurrency
+		if c.Market.Inverse {
+			margin = info.BaseCurrency
+		}
+		products[info.ID] = userdata.FuturesProduct{Name: info.DisplayName, MarginAsset: margin}
+	}
+	return userdata.HandleFutures(c.Tracker, c.Platform, products, raw)
+}
+
+// keepAliveListenKey extends key until done, it is passed in since the read
+// loop clears ListenKey once it expired.
+func (c *Client) keepAliveListenKey(done chan bool, key string) {
+	ticker := time.NewTicker(30 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := userdata.KeepAliveAt(c.Market.API+"/listenKey", c.APIKey, key); err != nil {
+				fmt.Println("listenKey keepalive", err)
+			}
+		}
+	}
+}
+
+// wait for queued messages before the books get resynced
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
+func (c *Client) Run() {
+	for !c.stopper.Stopped() {
+		c.run()
+	}
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
+func (c *Client) run() {
+	if err := c.Connect(); err != nil {
+		if common.Schedule.Wait(c.Platform) {
+			return
+		}
+		fmt.Println("failed to connect", err)
+		time.Sleep(1000 * time.Millisecond)
+		return
+	}
+
+	defer c.Socket.Close()
+	defer c.flushShards()
+
+	if c.ListenKey != "" {
+		done := make(chan bool)
+		defer close(done)
+		go c.keepAliveListenKey(done, c.ListenKey)
+	}
+
+	for {
+		msgType, message, err := c.Socket.ReadMessage()
+		if err != nil {
+			log.Println("read:", err)
+			return
+		}
+
+		if msgType != websocket.TextMessage {
+			continue
+		}
+
+		common.Capture(c.Platform, message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (c *Client) HandleRaw(message []byte) error {
+	var pkt PacketHeader
+	if err := json.Unmarshal(message, &pkt); err != nil {
+		return fmt.Errorf("PacketHeader-parse: %s", err)
+	}
+
+	if c.ListenKey != "" && pkt.Stream == c.ListenKey {
+		if err := c.HandleUserData(pkt.Data); err != nil {
+			log.Println("user data:", err)
+			if err == userdata.ErrListenKeyExpired {
+				c.ListenKey = ""
+				return common.ErrReconnect
+			}
+		}
+		return nil
+	}
+
+	book, ok := c.Books[pkt.Stream]
+	if !ok {
+		return fmt.Errorf("book not found %s", pkt.Stream)
+	}
+
+	c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+		if book.Snapshot == nil && !c.Replay {
+			// this and the following updates are checked against its
+			// lastUpdateId
+			if err := c.SyncBook(book); err != nil {
+				fmt.Println("sync", book.ID, err)
+			}
+		}
+		if err := c.HandleMessage(book, pkt.Data); err != nil {
+			fmt.Println(err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+)
+
+func (c *Client) FetchSnapshot(book *orderbook.Book) (uint64, []*orderbook.BookLevel, []*orderbook.BookLevel, error) {
+	contract := c.Contracts[book.ID]
+	url := fmt.Sprintf("%s/depth?symbol=%s&limit=1000", c.Market.API, contract.Symbol)
+	res, err := common.Get(c.Platform, url)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return 0, nil, nil, err
+	}
+
+	var data struct {
+		LastUpdateID *uint64       `json:"lastUpdateId"`
+		Bids         []interface{} `json:"bids"`
+		Asks         []interface{} `json:"asks"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return 0, nil, nil, common.Protocol("snapshot: %s", err)
+	}
+	if data.LastUpdateID == nil {
+		return 0, nil, nil, common.Protocol("snapshot without lastUpdateId: %s", string(body))
+	}
+
+	bids := []*orderbook.BookLevel{}
+	for _, d := range data.Bids {
+		price, size, ok := common.QuotedLevel(c.Platform, "depth.bids", d)
+		if !ok {
+			continue
+		}
+		bids = append(bids, &orderbook.BookLevel{Price: price, Size: contract.BaseSize(size, price)})
+	}
+
+	asks := []*orderbook.BookLevel{}
+	for _, d := range data.Asks {
+		price, size, ok := common.QuotedLevel(c.Platform, "depth.asks", d)
+		if !ok {
+			continue
+		}
+		asks = append(asks, &orderbook.BookLevel{Price: price, Size: contract.BaseSize(size, price)})
+	}
+
+	return *data.LastUpdateID, bids, asks, nil
+}
+
+// SyncBook fetches a snapshot the next depth updates continue from.
+func (c *Client) SyncBook(book *orderbook.Book) error {
+	fmt.Println("sync", book.ID)
+
+	seq, bids, asks, err := c.FetchSnapshot(book)
+	if err != nil {
+		return err
+	}
+	t := time.Now()
+	snapshot := &db_orderbook.SyncProvenance{Sequence: seq, Fetched: t}
+
+	if book.Empty() {
+		book.Clear()
+		book.Sequence = seq
+		book.Snapshot = snapshot
+		for _, level := range bids {
+			book.UpdateBidLevel(t, level.Price, level.Size)
+		}
+		for _, level := range asks {
+			book.UpdateAskLevel(t, level.Price, level.Size)
+		}
+
+		if c.dbEnabled {
+			batch := c.BatchWrite[book.ID]
+			fmt.Println("STORE INIT SYNC", book.ID, book.Sequence, batch.Count)
+			c.WriteSync(batch, book, t)
+		}
+		return nil
+	}
+
+	// resync, only record what changed since the book went out of sync
+	book.ApplySnapshot(t, bids, asks)
+	book.Sequence = seq
+	book.Snapshot = snapshot
+	book.Synced = false
+
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		batch.ResetWarmUp()
+		fmt.Println("STORE RESYNC DIFF", book.ID, book.Sequence, len(book.Diff.Bid)+len(book.Diff.Ask))
+		c.WriteDiff(batch, book, t)
+	}
+	return nil
+}
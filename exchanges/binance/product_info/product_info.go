@@ -4,14 +4,34 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
+	"sort"
 	"strconv"
 
+	"github.com/lian/gdax-bookmap/exchanges/common"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 	"github.com/lian/gdax-bookmap/util"
 )
 
+const exchangeInfoURL = "https://api.binance.com/api/v3/exchangeInfo"
+
+// Filters are the trading rules of a symbol from exchangeInfo, 0 where
+// binance sets none.
+type Filters struct {
+	// PRICE_FILTER
+	TickSize float64
+	// LOT_SIZE
+	StepSize float64
+	MinQty   float64
+	MaxQty   float64
+	// MIN_NOTIONAL, NOTIONAL on newer symbols, in the quote currency
+	MinNotional float64
+}
+
 var CachedInfo map[string]product_info.Info
+var CachedFilters map[string]Filters
+
+// products of symbols with status TRADING, sorted
+var tradable []string
 
 func init() {
 	FetchAllProductInfo()
@@ -19,57 +39,100 @@ func init() {
 
 func FetchAllProductInfo() {
 	CachedInfo = map[string]product_info.Info{}
+	CachedFilters = map[string]Filters{}
+	tradable = []string{}
 
-	res, err := http.Get("https://api.binance.com/api/v1/exchangeInfo")
-	if err != nil {
+	if err := fetchExchangeInfo(); err != nil {
 		fmt.Println("InitProduct error", err)
 	}
+	sort.Strings(tradable)
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+type exchangeInfo struct {
+	Symbols []struct {
+		Symbol     string `json:"symbol"`
+		Status     string `json:"status"`
+		BaseAsset  string `json:"baseAsset"`
+		QuoteAsset string `json:"quoteAsset"`
+		Filters    []struct {
+			FilterType  string `json:"filterType"`
+			TickSize    string `json:"tickSize"`
+			StepSize    string `json:"stepSize"`
+			MinQty      string `json:"minQty"`
+			MaxQty      string `json:"maxQty"`
+			MinNotional string `json:"minNotional"`
+		} `json:"filters"`
+	} `json:"symbols"`
+}
 
+func fetchExchangeInfo() error {
+	res, err := common.Get("Binance", exchangeInfoURL)
 	if err != nil {
-		fmt.Println("InitProduct error", err)
+		return err
 	}
+	defer res.Body.Close()
 
-	var data map[string]interface{}
-	json.Unmarshal(body, &data)
-
-	if symbols, ok := data["symbols"].([]interface{}); ok {
-		for _, p := range symbols {
-			i := p.(map[string]interface{})
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return err
+	}
 
-			baseAsset := i["baseAsset"].(string)
-			if baseAsset == "BCC" {
-				baseAsset = "BCH"
-			}
+	var data exchangeInfo
+	if err := json.Unmarshal(body, &data); err != nil {
+		return common.Protocol("exchangeInfo: %s", err)
+	}
 
-			info := product_info.Info{
-				ID:            i["symbol"].(string),
-				DisplayName:   fmt.Sprintf("%s-%s", baseAsset, i["quoteAsset"].(string)),
-				BaseCurrency:  baseAsset,
-				QuoteCurrency: i["quoteAsset"].(string),
-				Platform:      "Binance",
-				DatabaseKey:   fmt.Sprintf("Binance-%s-%s", baseAsset, i["quoteAsset"].(string)),
-			}
+	for _, i := range data.Symbols {
+		baseAsset := i.BaseAsset
+		if baseAsset == "BCC" {
+			baseAsset = "BCH"
+		}
 
-			if filters, ok := i["filters"].([]interface{}); ok {
-				for _, f := range filters {
-					fi := f.(map[string]interface{})
-					if fi["filterType"].(string) == "PRICE_FILTER" {
-						t, _ := strconv.ParseFloat(fi["minPrice"].(string), 64)
-						info.BaseMinSize = product_info.FloatString(t)
-						t, _ = strconv.ParseFloat(fi["maxPrice"].(string), 64)
-						info.BaseMaxSize = product_info.FloatString(t)
-						info.QuoteIncrement = info.BaseMinSize
-						info.FloatFormat = fmt.Sprintf("%%.%df", util.NumDecPlaces(float64(info.QuoteIncrement)))
-						CachedInfo[info.DisplayName] = info
-						break
-					}
-				}
+		var filters Filters
+		for _, f := range i.Filters {
+			switch f.FilterType {
+			case "PRICE_FILTER":
+				filters.TickSize = parseFilter(f.TickSize)
+			case "LOT_SIZE":
+				filters.StepSize = parseFilter(f.StepSize)
+				filters.MinQty = parseFilter(f.MinQty)
+				filters.MaxQty = parseFilter(f.MaxQty)
+			case "MIN_NOTIONAL", "NOTIONAL":
+				filters.MinNotional = parseFilter(f.MinNotional)
 			}
+		}
+		if filters.TickSize == 0 {
+			// the books can not be drawn without a price step
+			continue
+		}
 
+		info := product_info.Info{
+			ID:             i.Symbol,
+			DisplayName:    fmt.Sprintf("%s-%s", baseAsset, i.QuoteAsset),
+			BaseCurrency:   baseAsset,
+			QuoteCurrency:  i.QuoteAsset,
+			Platform:       "Binance",
+			DatabaseKey:    fmt.Sprintf("Binance-%s-%s", baseAsset, i.QuoteAsset),
+			BaseMinSize:    product_info.FloatString(filters.MinQty),
+			BaseMaxSize:    product_info.FloatString(filters.MaxQty),
+			QuoteIncrement: product_info.FloatString(filters.TickSize),
+			FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(filters.TickSize)),
+		}
+		CachedInfo[info.DisplayName] = info
+		CachedFilters[info.DisplayName] = filters
+		if i.Status == "TRADING" {
+			tradable = append(tradable, info.DisplayName)
 		}
 	}
+	return nil
+}
+
+func parseFilter(value string) float64 {
+	f, _ := strconv.ParseFloat(value, 64)
+	return f
 }
 
 func FetchProductInfo(id string) product_info.Info {
@@ -78,3 +141,57 @@ func FetchProductInfo(id string) product_info.Info {
 	}
 	return product_info.Info{}
 }
+
+// FetchFilters returns the trading rules of a product, e.g. BTC-USDT.
+func FetchFilters(id string) (Filters, bool) {
+	filters, ok := CachedFilters[id]
+	return filters, ok
+}
+
+// Products returns the products which trade right now, e.g. BTC-USDT,
+// sorted. Symbols on a break are left out, FetchProductInfo still knows
+// them.
+func Products() []string {
+	return tradable
+}
+
+// Symbol is the listing of a symbol in exchangeInfo.
+type Symbol struct {
+	Symbol     string `json:"symbol"`
+	Status     string `json:"status"`
+	BaseAsset  string `json:"baseAsset"`
+	QuoteAsset string `json:"quoteAsset"`
+}
+
+// FetchSymbols returns the current listing by symbol, including symbols
+// which do not trade, e.g. with status BREAK before they are delisted.
+func FetchSymbols() (map[string]*Symbol, error) {
+	res, err := common.Get("Binance", exchangeInfoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Symbols []*Symbol `json:"symbols"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, common.Protocol("exchangeInfo: %s", err)
+	}
+	if len(data.Symbols) == 0 {
+		// an empty listing ends every recording, rather trust the next one
+		return nil, common.Protocol("exchangeInfo without symbols")
+	}
+	symbols := map[string]*Symbol{}
+	for _, s := range data.Symbols {
+		symbols[s.Symbol] = s
+	}
+	return symbols, nil
+}
@@ -0,0 +1,152 @@
+package userdata
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/trading"
+)
+
+// PacketExecutionReport names the keys differing in case only from the
+// ones used, since encoding/json would fill a field from either of them.
+type PacketExecutionReport struct {
+	EventType       string `json:"e"`
+	EventTime       int64  `json:"E"`
+	Symbol          string `json:"s"`
+	Side            string `json:"S"`
+	OrderType       string `json:"o"`
+	Quantity        string `json:"q"`
+	Price           string `json:"p"`
+	ExecutionType   string `json:"x"`
+	OrderStatus     string `json:"X"`
+	OrderID         int64  `json:"i"`
+	LastQuantity    string `json:"l"`
+	FilledQuantity  string `json:"z"`
+	LastPrice       string `json:"L"`
+	Commission      string `json:"n"`
+	CommissionAsset string `json:"N"`
+	TransactionTime int64  `json:"T"`
+	TradeID         int64  `json:"t"`
+	Maker           bool   `json:"m"`
+	CreationTime    int64  `json:"O"`
+	ClientOrderID   string `json:"c"`
+	OrigClientID    string `json:"C"`
+	StopPrice       string `json:"P"`
+	QuoteQuantity   string `json:"Q"`
+	CumulativeQuote string `json:"Z"`
+	IgnoreI         int64  `json:"I"`
+	IgnoreM         bool   `json:"M"`
+}
+
+type PacketAccountPosition struct {
+	EventType string `json:"e"`
+	EventTime int64  `json:"E"`
+	Balances  []struct {
+		Asset  string `json:"a"`
+		Free   string `json:"f"`
+		Locked string `json:"l"`
+	} `json:"B"`
+}
+
+var ErrListenKeyExpired = errors.New("listenKey expired")
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func unixMilli(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Handle feeds a user data stream event into the tracker. products maps
+// binance symbols (BTCUSDT) to product names (BTC-USDT).
+func Handle(tracker *trading.Tracker, products map[string]string, raw json.RawMessage) error {
+	var header struct {
+		EventType string `json:"e"`
+		EventTime int64  `json:"E"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return err
+	}
+
+	switch header.EventType {
+	case "executionReport":
+		var data PacketExecutionReport
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return err
+		}
+
+		product, ok := products[data.Symbol]
+		if !ok {
+			product = data.Symbol
+		}
+		side := trading.Buy
+		if data.Side == "SELL" {
+			side = trading.Sell
+		}
+
+		tracker.UpdateOrder(&trading.Order{
+			ID:        strconv.FormatInt(data.OrderID, 10),
+			Exchange:  "Binance",
+			Product:   product,
+			Side:      side,
+			Type:      data.OrderType,
+			Price:     parseFloat(data.Price),
+			Size:      parseFloat(data.Quantity),
+			Filled:    parseFloat(data.FilledQuantity),
+			Status:    data.OrderStatus,
+			CreatedAt: unixMilli(data.CreationTime),
+			UpdatedAt: unixMilli(data.EventTime),
+		})
+
+		if data.ExecutionType == "NEW" {
+			tracker.Latency.Acked("Binance", data.ClientOrderID, product, side,
+				parseFloat(data.Price), parseFloat(data.Quantity), unixMilli(data.CreationTime), time.Now())
+		}
+
+		if data.ExecutionType == "TRADE" {
+			tracker.AddFill(&trading.Fill{
+				TradeID:  strconv.FormatInt(data.TradeID, 10),
+				OrderID:  strconv.FormatInt(data.OrderID, 10),
+				Exchange: "Binance",
+				Product:  product,
+				Side:     side,
+				Price:    parseFloat(data.LastPrice),
+				Size:     parseFloat(data.LastQuantity),
+				Fee:      parseFloat(data.Commission),
+				FeeAsset: data.CommissionAsset,
+				Maker:    data.Maker,
+				Time:     unixMilli(data.TransactionTime),
+			})
+		}
+
+	case "outboundAccountPosition":
+		var data PacketAccountPosition
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return err
+		}
+		for _, b := range data.Balances {
+			tracker.SetBalance(trading.Balance{
+				Exchange: "Binance",
+				Asset:    b.Asset,
+				Free:     parseFloat(b.Free),
+				Locked:   parseFloat(b.Locked),
+			})
+		}
+
+	case "balanceUpdate", "listStatus":
+		// covered by outboundAccountPosition / executionReport
+
+	case "listenKeyExpired":
+		return ErrListenKeyExpired
+
+	default:
+		return common.Protocol("unkown user data event %s", header.EventType)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package userdata
+
+// https://binance-docs.github.io/apidocs/futures/en/#user-data-streams
+// https://binance-docs.github.io/apidocs/delivery/en/#user-data-streams
+
+import (
+	"encoding/json"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/trading"
+)
+
+// FuturesProduct is a symbol of a futures user data stream.
+type FuturesProduct struct {
+	Name string
+	// USDT of USD-M, the base currency of COIN-M
+	MarginAsset string
+}
+
+// PacketAccountUpdate lists the balances and positions changed by an event,
+// the others stay as they were.
+type PacketAccountUpdate struct {
+	// both named, keys differing in case only would match either field
+	EventType string `json:"e"`
+	EventTime int64  `json:"E"`
+	Account   struct {
+		Balances []struct {
+			Asset         string `json:"a"`
+			WalletBalance string `json:"wb"`
+			CrossWallet   string `json:"cw"`
+		} `json:"B"`
+		Positions []struct {
+			Symbol        string `json:"s"`
+			Amount        string `json:"pa"`
+			EntryPrice    string `json:"ep"`
+			UnrealizedPnL string `json:"up"`
+			// BOTH, or LONG and SHORT in hedge mode
+			PositionSide string `json:"ps"`
+		} `json:"P"`
+	} `json:"a"`
+}
+
+// HandleFutures feeds a futures user data stream event into the tracker.
+// exchange is the platform, e.g. BinanceUSDM, products maps its symbols
+// (BTCUSDT) to the products.
+func HandleFutures(tracker *trading.Tracker, exchange string, products map[string]FuturesProduct, raw json.RawMessage) error {
+	var header struct {
+		EventType string `json:"e"`
+		EventTime int64  `json:"E"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return err
+	}
+
+	switch header.EventType {
+	case "ACCOUNT_UPDATE":
+		var data PacketAccountUpdate
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return err
+		}
+		for _, b := range data.Account.Balances {
+			// isolated margin is locked to its position
+			wallet := parseFloat(b.WalletBalance)
+			cross := parseFloat(b.CrossWallet)
+			tracker.SetBalance(trading.Balance{
+				Exchange: exchange,
+				Asset:    b.Asset,
+				Free:     cross,
+				Locked:   wallet - cross,
+			})
+		}
+		for _, p := range data.Account.Positions {
+			product, ok := products[p.Symbol]
+			if !ok {
+				product = FuturesProduct{Name: p.Symbol}
+			}
+			name := product.Name
+			if p.PositionSide != "" && p.PositionSide != "BOTH" {
+				name += " " + p.PositionSide
+			}
+			tracker.SetPosition(trading.Position{
+				Exchange:      exchange,
+				Product:       name,
+				MarginAsset:   product.MarginAsset,
+				Size:          parseFloat(p.Amount),
+				EntryPrice:    parseFloat(p.EntryPrice),
+				UnrealizedPnL: parseFloat(p.UnrealizedPnL),
+			})
+		}
+
+	case "ORDER_TRADE_UPDATE", "ACCOUNT_CONFIG_UPDATE", "MARGIN_CALL", "TRADE_LITE":
+		// positions and balances follow as ACCOUNT_UPDATE
+
+	case "listenKeyExpired":
+		return ErrListenKeyExpired
+
+	default:
+		return common.Protocol("unkown user data event %s", header.EventType)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package userdata
+
+// https://github.com/binance-exchange/binance-official-api-docs/blob/master/user-data-stream.md
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+)
+
+var Endpoint = "https://api.binance.com/api/v3/userDataStream"
+
+func request(endpoint, method, apiKey, listenKey string) ([]byte, error) {
+	u := endpoint
+	if listenKey != "" {
+		u += "?" + url.Values{"listenKey": []string{listenKey}}.Encode()
+	}
+
+	req, err := common.NewRequest("Binance", method, u)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-MBX-APIKEY", apiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return nil, fmt.Errorf("userDataStream %s: %w", method, err)
+	}
+	return body, nil
+}
+
+// CreateListenKey starts a new user data stream. The key expires after 60
+// minutes unless KeepAlive is called.
+func CreateListenKey(apiKey string) (string, error) {
+	return CreateListenKeyAt(Endpoint, apiKey)
+}
+
+// CreateListenKeyAt starts a user data stream of another market, e.g. the
+// listenKey endpoint of the futures api.
+func CreateListenKeyAt(endpoint, apiKey string) (string, error) {
+	body, err := request(endpoint, "POST", apiKey, "")
+	if err != nil {
+		return "", err
+	}
+
+	var data struct {
+		ListenKey string `json:"listenKey"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", err
+	}
+	return data.ListenKey, nil
+}
+
+func KeepAlive(apiKey, listenKey string) error {
+	return KeepAliveAt(Endpoint, apiKey, listenKey)
+}
+
+func KeepAliveAt(endpoint, apiKey, listenKey string) error {
+	_, err := request(endpoint, "PUT", apiKey, listenKey)
+	return err
+}
+
+func CloseListenKey(apiKey, listenKey string) error {
+	_, err := request(Endpoint, "DELETE", apiKey, listenKey)
+	return err
+}
@@ -9,43 +9,110 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
 	book_info "github.com/lian/gdax-bookmap/exchanges/binance/product_info"
+	"github.com/lian/gdax-bookmap/exchanges/binance/userdata"
+	"github.com/lian/gdax-bookmap/exchanges/common"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/trading"
 	"github.com/lian/gdax-bookmap/util"
 )
 
+func init() {
+	// streams are part of the url, the REST snapshot has the top 1000 levels
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:    "Binance",
+		MaxDepth:    1000,
+		UserStreams: true,
+	})
+	exchanges.Register("Binance", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		c := New(s.DB, s.ProductsOr("BTC-USDT", "ETH-USDT", "BCH-USDT"))
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		c.PollInterval = s.PollInterval
+		if s.APIKey != "" {
+			c.APIKey = s.APIKey
+			c.Tracker = s.Tracker
+		}
+		return c, c.Infos
+	})
+}
+
 type Client struct {
-	Socket      *websocket.Conn
-	Products    []string
-	Books       map[string]*orderbook.Book
-	ConnectedAt time.Time
-	DB          *bolt.DB
-	dbEnabled   bool
-	BatchWrite  map[string]*util.BookBatchWrite
-	Infos       []*product_info.Info
+	exchanges.BookIndex
+	Socket            *websocket.Conn
+	Products          []string
+	Books             map[string]*orderbook.Book
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	FailedConnects    int
+	PollInterval      time.Duration
+	APIKey            string
+	ListenKey         string
+	Tracker           *trading.Tracker
+	Shards            *util.Shards
+	// books start empty at the first message instead of a REST snapshot,
+	// for replaying captured feeds
+	Replay bool
+	// update speed of the depth streams, e.g. 100ms, empty for the default
+	// 1000ms. Set by NewVariant, the products are recorded as
+	// <DatabaseKey>@<DepthInterval>.
+	DepthInterval string
+
+	// products found delisted, see CheckListing, or removed
+	endMu   sync.Mutex
+	ended   map[string]bool
+	pending []*pendingEnd
+	listed  map[string]*book_info.Symbol
+	strikes *common.Strikes
+	// products added while Run, see AddProduct
+	running bool
+	adds    []string
+
+	stopper common.Stopper
 }
 
 func New(db *bolt.DB, products []string) *Client {
+	return newClient(db, products, "")
+}
+
+// NewVariant records the products a second time from depth streams of
+// another update speed, to compare them with the default feed.
+func NewVariant(db *bolt.DB, products []string, depthInterval string) *Client {
+	return newClient(db, products, depthInterval)
+}
+
+func newClient(db *bolt.DB, products []string, depthInterval string) *Client {
 	c := &Client{
-		Products:   []string{},
-		Books:      map[string]*orderbook.Book{},
-		BatchWrite: map[string]*util.BookBatchWrite{},
-		DB:         db,
-		Infos:      []*product_info.Info{},
+		DepthInterval: depthInterval,
+		Products:      []string{},
+		Books:         map[string]*orderbook.Book{},
+		BatchWrite:    map[string]*storage.BookWriter{},
+		DB:            db,
+		Infos:         []*product_info.Info{},
+		PollInterval:  10 * time.Second,
+		ended:         map[string]bool{},
+		strikes:       common.NewStrikes(common.DelistStrikes),
 	}
 	if c.DB != nil {
 		c.dbEnabled = true
 	}
 
-	// https://api.binance.com/api/v1/exchangeInfo
-
 	for _, name := range products {
-		c.AddProduct(name)
+		c.addProduct(name)
 	}
 
 	if c.dbEnabled {
@@ -59,22 +126,43 @@ func New(db *bolt.DB, products []string) *Client {
 	return c
 }
 
-func streamNames(name string) (string, string) {
+func streamNames(name, depthInterval string) (string, string) {
 	id := strings.ToLower(name)
 	//return id + "@depth.b10", id + "@aggTrade.b10" // ?! new
+	if depthInterval != "" {
+		return id + "@depth@" + depthInterval, id + "@aggTrade"
+	}
 	return id + "@depth", id + "@aggTrade"
 }
 
-func (c *Client) AddProduct(name string) {
-	c.Products = append(c.Products, name)
-	c.BatchWrite[name] = &util.BookBatchWrite{Count: 0, Batch: []*util.BatchChunk{}}
-	book := orderbook.New(name)
+func (c *Client) addProduct(name string) *product_info.Info {
 	info := book_info.FetchProductInfo(name)
-	c.Infos = append(c.Infos, &info)
+	if info.ID == "" && len(book_info.CachedInfo) > 0 {
+		// its streams would stay silent and its snapshots fail forever
+		fmt.Println(name, "is not listed on Binance, not recorded")
+		return nil
+	}
+	book := orderbook.New(name)
+	if c.DepthInterval != "" {
+		info.DatabaseKey += "@" + c.DepthInterval
+		info.DisplayName += " " + c.DepthInterval
+	}
 	book.SetProductInfo(info)
-	diff_channel, trades_channel := streamNames(info.ID)
+	diff_channel, trades_channel := streamNames(info.ID, c.DepthInterval)
+
+	// RemoveProduct reads them from other goroutines
+	c.endMu.Lock()
+	defer c.endMu.Unlock()
+	if _, ok := c.Books[diff_channel]; ok {
+		return nil
+	}
+	c.Products = append(c.Products, name)
+	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
 	c.Books[diff_channel] = book
 	c.Books[trades_channel] = book
+	c.AddBook(book.ID, book)
+	return &info
 }
 
 func (c *Client) Connect() error {
@@ -82,17 +170,30 @@ func (c *Client) Connect() error {
 	for channel, _ := range c.Books {
 		streams = append(streams, channel)
 	}
+	if c.APIKey != "" {
+		if c.ListenKey == "" {
+			key, err := userdata.CreateListenKey(c.APIKey)
+			if err != nil {
+				fmt.Println("failed to create listenKey", err)
+			}
+			c.ListenKey = key
+		}
+		if c.ListenKey != "" {
+			streams = append(streams, c.ListenKey)
+		}
+	}
 	//url := "wss://stream.binance.com:9443/stream?streams=" + strings.Join(streams, "/")
 	url := "wss://stream2.binance.com:9443/stream?streams=" + strings.Join(streams, "/")
 
 	fmt.Println("connect to websocket", url)
-	s, _, err := websocket.DefaultDialer.Dial(url, nil)
+	s, _, err := common.Dial("Binance", url)
 
 	if err != nil {
 		return err
 	}
 
 	c.Socket = s
+	c.stopper.Connected(s)
 	c.ConnectedAt = time.Now()
 
 	return nil
@@ -119,7 +220,7 @@ type PacketAggTrade struct {
 	//Symbol           string `json:"s"`
 	//AggregateTradeID int    `json:"a"`
 	//TradeTime        int    `json:"T"`
-	//BuyMaker      bool   `json:"m"`
+	BuyerMaker *bool `json:"m"`
 	//Ignore        bool   `json:"M"`
 	Price         string `json:"p"`
 	Quantity      string `json:"q"`
@@ -136,9 +237,16 @@ func (c *Client) UpdateSync(book *orderbook.Book, first, last uint64) error {
 	}
 
 	if book.Synced {
+		if first != next && c.Replay {
+			// nothing to resync from, the replay goes on after the gap
+			book.Sequence = last
+			return common.SequenceGap("%s expected %d got %d", book.ID, next, first)
+		}
 		if first != next {
-			c.SyncBook(book)
-			return fmt.Errorf("Message lost, resync")
+			if err := c.SyncBook(book); err != nil {
+				return common.SequenceGap("%s expected %d got %d, resync: %v", book.ID, next, first, err)
+			}
+			return common.SequenceGap("%s expected %d got %d, resynced", book.ID, next, first)
 		}
 	} else {
 		if (first <= next) && (last >= next) {
@@ -150,11 +258,25 @@ func (c *Client) UpdateSync(book *orderbook.Book, first, last uint64) error {
 	return nil
 }
 
-func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage) {
+// levelIncreased reports added size to the latency estimator, our own
+// orders show up in the public book this way.
+func (c *Client) levelIncreased(book *orderbook.Book, side trading.Side, price, size float64, eventTime time.Time) {
+	if c.Tracker == nil {
+		return
+	}
+	bookSide := orderbook.BidSide
+	if side == trading.Sell {
+		bookSide = orderbook.AskSide
+	}
+	if prev := book.LevelSize(bookSide, price); size > prev {
+		c.Tracker.Latency.LevelIncreased("Binance", book.ID, side, price, size-prev, eventTime, time.Now())
+	}
+}
+
+func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage) error {
 	var tmp map[string]interface{}
 	if err := json.Unmarshal(raw, &tmp); err != nil {
-		log.Println("PacketEventType-parse:", err)
-		return
+		return common.Protocol("PacketEventType-parse: %s", err)
 	}
 
 	var eventType string
@@ -162,15 +284,14 @@ func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage) {
 	var ok bool
 
 	if eventType, ok = tmp["e"].(string); !ok {
-		log.Println("PacketEventType-parse: failed to decode eventType")
-		return
+		return common.Protocol("PacketEventType-parse: failed to decode eventType")
 	}
 
 	if eventTimeValue, ok = tmp["E"].(float64); !ok {
-		log.Println("PacketEventType-parse: failed to decode eventTime")
-		return
+		return common.Protocol("PacketEventType-parse: failed to decode eventTime")
 	}
 	eventTime := time.Unix(0, int64(eventTimeValue)*int64(time.Millisecond))
+	common.Clocks.Event(book.ProductInfo.Platform, eventTime, time.Now())
 
 	var trade *orderbook.Trade
 
@@ -178,53 +299,68 @@ func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage) {
 	case "depthUpdate":
 		var depthUpdate PacketDepthUpdate
 		if err := json.Unmarshal(raw, &depthUpdate); err != nil {
-			log.Println("PacketDepthUpdate-parse:", err)
-			return
+			return common.Protocol("PacketDepthUpdate-parse: %s", err)
 		}
 
 		if err := c.UpdateSync(book, uint64(depthUpdate.FirstUpdateID), uint64(depthUpdate.FinalUpdateID)); err != nil {
-			fmt.Println(err)
-			return
+			return err
 		}
 
 		for _, d := range depthUpdate.Bids {
-			data := d.([]interface{})
-			price, _ := strconv.ParseFloat(data[0].(string), 64)
-			size, _ := strconv.ParseFloat(data[1].(string), 64)
+			price, size, ok := common.QuotedLevel("Binance", "depthUpdate.b", d)
+			if !ok {
+				continue
+			}
+			c.levelIncreased(book, trading.Buy, price, size, eventTime)
 			book.UpdateBidLevel(eventTime, price, size)
 		}
 
 		for _, d := range depthUpdate.Asks {
-			data := d.([]interface{})
-			price, _ := strconv.ParseFloat(data[0].(string), 64)
-			size, _ := strconv.ParseFloat(data[1].(string), 64)
+			price, size, ok := common.QuotedLevel("Binance", "depthUpdate.a", d)
+			if !ok {
+				continue
+			}
+			c.levelIncreased(book, trading.Sell, price, size, eventTime)
 			book.UpdateAskLevel(eventTime, price, size)
 		}
 
 	case "aggTrade":
 		var data PacketAggTrade
 		if err := json.Unmarshal(raw, &data); err != nil {
-			log.Println("PacketDepthUpdate-parse:", err)
-			return
+			return common.Protocol("PacketAggTrade-parse: %s", err)
 		}
 
 		price, _ := strconv.ParseFloat(data.Price, 64)
 		size, _ := strconv.ParseFloat(data.Quantity, 64)
 
-		side := book.GetSide(price)
-		book.AddTrade(eventTime, side, price, size)
+		side, source := book.AggressorSide(price)
+		if data.BuyerMaker != nil {
+			side, source = uint8(orderbook.AskSide), db_orderbook.SideFromVenue
+			if *data.BuyerMaker {
+				// the buyer was resting, a sell hit the bid
+				side = uint8(orderbook.BidSide)
+			}
+		}
+		book.AddClassifiedTrade(eventTime, side, source, price, size)
 		trade = book.Trades[len(book.Trades)-1]
 
 	default:
-		fmt.Println("unkown event", book.ID, eventType, string(raw))
-		return
+		return common.Protocol("unkown event %s %s %s", book.ID, eventType, string(raw))
 	}
 
 	if c.dbEnabled {
 		batch := c.BatchWrite[book.ID]
 		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
 		if trade != nil {
-			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(trade))
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
 		}
 
 		if batch.NextSync(now) {
@@ -237,45 +373,140 @@ func (c *Client) HandleMessage(book *orderbook.Book, raw json.RawMessage) {
 			}
 		}
 	}
+	return nil
 }
 
-func (c *Client) WriteDiff(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
 	book.FixBookLevels() // TODO fix/remove
 	diff := book.Diff
 	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
 		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
-		batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, pkt)
+		batch.Write(now, pkt)
 		book.ResetDiff()
 		batch.LastDiffSeq = book.Sequence + 1
 	}
 }
 
-func (c *Client) WriteSync(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
 	book.FixBookLevels() // TODO fix/remove
-	batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
 	book.ResetDiff()
 	batch.LastDiffSeq = book.Sequence + 1
 }
 
-func (c *Client) Run() {
+func (c *Client) WriteDegradedSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	book.FixBookLevels() // TODO fix/remove
+	batch.Write(now, orderbook.PackDegradedSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+func (c *Client) HandleUserData(raw json.RawMessage) error {
+	if c.Tracker == nil {
+		return nil
+	}
+	products := map[string]string{}
+	for _, info := range c.Infos {
+		products[info.ID] = info.DisplayName
+	}
+	return userdata.Handle(c.Tracker, products, raw)
+}
+
+// keepAliveListenKey extends key until done, it is passed in since the read
+// loop clears ListenKey once it expired.
+func (c *Client) keepAliveListenKey(done chan bool, key string) {
+	ticker := time.NewTicker(30 * time.Minute)
+	defer ticker.Stop()
 	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := userdata.KeepAlive(c.APIKey, key); err != nil {
+				fmt.Println("listenKey keepalive", err)
+			}
+		}
+	}
+}
+
+// wait for queued messages before the books get resynced or polled
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
+// Run records until every product ended or was removed, or Stop.
+func (c *Client) Run() {
+	c.endMu.Lock()
+	c.running = true
+	c.endMu.Unlock()
+	if !c.Replay {
+		go c.watchListing()
+	}
+	for len(c.Books) > 0 && !c.stopper.Stopped() {
 		c.run()
 	}
+	if len(c.Books) == 0 {
+		fmt.Println("Binance: every product ended, feed stopped")
+	}
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
 }
 
 func (c *Client) run() {
+	c.endPending()
+	c.addPending()
+	if len(c.Books) == 0 {
+		return
+	}
 	if err := c.Connect(); err != nil {
+		c.FailedConnects += 1
+		if common.Schedule.Wait("Binance") {
+			return
+		}
 		fmt.Println("failed to connect", err)
-		time.Sleep(1000 * time.Millisecond)
+		if c.FailedConnects == 3 {
+			if err := c.CheckSystemStatus(); err != nil {
+				fmt.Println("system status", err)
+			}
+		}
+		if c.FailedConnects >= 3 && c.PollInterval > 0 {
+			// websocket is down, keep recording coarse snapshots until it is back
+			c.PollBooks()
+			time.Sleep(c.PollInterval)
+		} else {
+			time.Sleep(1000 * time.Millisecond)
+		}
 		return
 	}
 
 	defer c.Socket.Close()
+	defer c.flushShards()
+
+	// connecting works again after an announced maintenance
+	common.Schedule.Finish("Binance", time.Now())
+
+	if c.ListenKey != "" {
+		done := make(chan bool)
+		defer close(done)
+		go c.keepAliveListenKey(done, c.ListenKey)
+	}
 
 	for {
 		msgType, message, err := c.Socket.ReadMessage()
 		if err != nil {
 			log.Println("read:", err)
+			if time.Since(c.ConnectedAt).Seconds() < 30.0 {
+				c.FailedConnects += 1
+			} else {
+				c.FailedConnects = 0
+			}
 			return
 		}
 
@@ -283,24 +514,53 @@ func (c *Client) run() {
 			continue
 		}
 
-		var pkt PacketHeader
-		if err := json.Unmarshal(message, &pkt); err != nil {
-			log.Println("PacketHeader-parse:", err)
-			continue
+		common.Capture("Binance", message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
 		}
-
-		var book *orderbook.Book
-		var ok bool
-		if book, ok = c.Books[pkt.Stream]; !ok {
-			log.Println("book not found", pkt.Stream)
-			continue
+		// reconnect with the streams of the products ended or added
+		if c.endPending() || c.addsPending() {
+			return
 		}
+	}
+}
 
-		if book.Sequence == 0 {
-			c.SyncBook(book)
-			continue
+func (c *Client) HandleRaw(message []byte) error {
+	var pkt PacketHeader
+	if err := json.Unmarshal(message, &pkt); err != nil {
+		return fmt.Errorf("PacketHeader-parse: %s", err)
+	}
+
+	if c.ListenKey != "" && pkt.Stream == c.ListenKey {
+		if err := c.HandleUserData(pkt.Data); err != nil {
+			log.Println("user data:", err)
+			if err == userdata.ErrListenKeyExpired {
+				c.ListenKey = ""
+				return common.ErrReconnect
+			}
 		}
+		return nil
+	}
 
-		c.HandleMessage(book, pkt.Data)
+	var book *orderbook.Book
+	var ok bool
+	if book, ok = c.Books[pkt.Stream]; !ok {
+		return fmt.Errorf("book not found %s", pkt.Stream)
 	}
+
+	c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+		if book.Sequence == 0 && !c.Replay {
+			if err := c.SyncBook(book); err != nil {
+				fmt.Println("sync", book.ID, err)
+				c.syncFailed(book, err)
+			}
+			return
+		}
+		if err := c.HandleMessage(book, pkt.Data); err != nil {
+			fmt.Println(err)
+		}
+	})
+	return nil
 }
@@ -0,0 +1,168 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	book_info "github.com/lian/gdax-bookmap/exchanges/binance/product_info"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+)
+
+// how often exchangeInfo is checked for delisted and renamed products
+const listingInterval = time.Hour
+
+type pendingEnd struct {
+	info      *product_info.Info
+	reason    string
+	successor string
+	// by RemoveProduct, the recording is not marked as ended
+	removed bool
+}
+
+// watchListing checks the listing every listingInterval, the read loop
+// stops the feeds of the products it finds delisted.
+func (c *Client) watchListing() {
+	ticker := time.NewTicker(listingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopper.Done():
+			return
+		case <-ticker.C:
+			if err := c.CheckListing(); err != nil {
+				fmt.Println("listing", err)
+			}
+		}
+	}
+}
+
+// CheckListing compares the recorded products with exchangeInfo. Products
+// missing from it end right away, products which do not trade end after
+// common.DelistStrikes checks, halts are usually over by then.
+func (c *Client) CheckListing() error {
+	symbols, err := book_info.FetchSymbols()
+	if err != nil {
+		return err
+	}
+	c.endMu.Lock()
+	previous := c.listed
+	c.listed = symbols
+	c.endMu.Unlock()
+
+	for _, info := range c.recorded() {
+		if c.isEnded(info) {
+			continue
+		}
+		s, ok := symbols[info.ID]
+		switch {
+		case !ok:
+			c.requestEnd(info, fmt.Sprintf("%s is no longer listed", info.ID), successor(info, symbols, previous))
+		case s.Status != "TRADING":
+			if c.strikes.Fail(info.DatabaseKey) {
+				c.requestEnd(info, fmt.Sprintf("%s has status %s", info.ID, s.Status), "")
+			}
+		default:
+			c.strikes.Clear(info.DatabaseKey)
+		}
+	}
+	return nil
+}
+
+// successor looks for a symbol listed since the previous check with the
+// same quote asset and a base asset containing the old one or contained in
+// it, e.g. BCHABCUSDT for BCCUSDT.
+func successor(info *product_info.Info, symbols, previous map[string]*book_info.Symbol) string {
+	if previous == nil {
+		return ""
+	}
+	base := info.BaseCurrency
+	for id, s := range symbols {
+		if _, ok := previous[id]; ok || s.Status != "TRADING" || s.QuoteAsset != info.QuoteCurrency {
+			continue
+		}
+		if strings.Contains(s.BaseAsset, base) || strings.Contains(base, s.BaseAsset) {
+			return id
+		}
+	}
+	return ""
+}
+
+// syncFailed counts snapshots refused for an unknown symbol, the product
+// ends after common.DelistStrikes of them.
+func (c *Client) syncFailed(book *orderbook.Book, err error) {
+	if !errors.Is(err, common.ErrDelisted) {
+		return
+	}
+	info := c.infoOf(book)
+	if info != nil && c.strikes.Fail(info.DatabaseKey) {
+		c.requestEnd(info, err.Error(), "")
+	}
+}
+
+func (c *Client) infoOf(book *orderbook.Book) *product_info.Info {
+	for _, info := range c.recorded() {
+		if info.DatabaseKey == book.ProductInfo.DatabaseKey {
+			return info
+		}
+	}
+	return nil
+}
+
+func (c *Client) isEnded(info *product_info.Info) bool {
+	c.endMu.Lock()
+	defer c.endMu.Unlock()
+	return c.ended[info.DatabaseKey]
+}
+
+func (c *Client) requestEnd(info *product_info.Info, reason, successor string) {
+	c.endMu.Lock()
+	defer c.endMu.Unlock()
+	if c.ended[info.DatabaseKey] {
+		return
+	}
+	c.ended[info.DatabaseKey] = true
+	c.pending = append(c.pending, &pendingEnd{info: info, reason: reason, successor: successor})
+}
+
+// endPending stops the feeds of products found delisted or removed, called
+// by the read loop which owns the books. It tells if any ended, the
+// connection has to be made again without their streams.
+func (c *Client) endPending() bool {
+	c.endMu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.endMu.Unlock()
+
+	for _, end := range pending {
+		diff_channel, trades_channel := streamNames(end.info.ID, c.DepthInterval)
+		book, ok := c.Books[diff_channel]
+		if !ok {
+			continue
+		}
+		// the last queued messages are stored before the feed stops
+		c.Shards.Flush(end.info.DatabaseKey)
+		if c.dbEnabled && book.Synced {
+			c.WriteDiff(c.BatchWrite[book.ID], book, time.Now())
+		}
+		if err := c.BatchWrite[book.ID].Flush(); err != nil {
+			fmt.Println("HandleMessage DB Error", err)
+		}
+		// RemoveProduct reads them from other goroutines
+		c.endMu.Lock()
+		delete(c.Books, diff_channel)
+		delete(c.Books, trades_channel)
+		c.endMu.Unlock()
+		c.RemoveBook(book.ID)
+		if end.removed {
+			fmt.Println(end.info.DatabaseKey, "recording removed")
+			c.forget(end.info)
+			continue
+		}
+		common.EndRecording(c.DB, end.info, end.reason, end.successor)
+	}
+	return len(pending) > 0
+}
@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// AddProduct records another product, e.g. SOL-USDT. While Run the read
+// loop opens the combined stream again with its streams at the next
+// message, the other books carry on and only sync again when updates were
+// missed in between.
+func (c *Client) AddProduct(name string) {
+	c.endMu.Lock()
+	if c.running {
+		c.adds = append(c.adds, name)
+		c.endMu.Unlock()
+		return
+	}
+	c.endMu.Unlock()
+	c.addProducts([]string{name})
+}
+
+// RemoveProduct stops recording a product after storing its last changes,
+// the connection is made again without its streams like for a delisting.
+// Unlike a delisting the recording is not marked as ended, so the product
+// can be added again later.
+func (c *Client) RemoveProduct(name string) {
+	var book *orderbook.Book
+	c.endMu.Lock()
+	for _, b := range c.Books {
+		if b.ID == name {
+			book = b
+			break
+		}
+	}
+	c.endMu.Unlock()
+	if book == nil {
+		return
+	}
+	info := c.infoOf(book)
+	if info == nil {
+		return
+	}
+
+	c.endMu.Lock()
+	if c.ended[info.DatabaseKey] {
+		c.endMu.Unlock()
+		return
+	}
+	c.ended[info.DatabaseKey] = true
+	c.pending = append(c.pending, &pendingEnd{info: info, reason: "removed", removed: true})
+	running := c.running
+	c.endMu.Unlock()
+
+	if !running {
+		c.endPending()
+	}
+}
+
+func (c *Client) addProducts(names []string) {
+	buckets := []string{}
+	for _, name := range names {
+		if info := c.addProduct(name); info != nil {
+			buckets = append(buckets, info.DatabaseKey)
+		}
+	}
+	if c.dbEnabled && len(buckets) > 0 {
+		util.CreateBucketsDB(c.DB, buckets)
+	}
+}
+
+// addPending adds the products of AddProduct, called by the read loop
+// before it connects, when no messages are queued on the shards.
+func (c *Client) addPending() {
+	c.endMu.Lock()
+	adds := c.adds
+	c.adds = nil
+	c.endMu.Unlock()
+	c.addProducts(adds)
+}
+
+func (c *Client) addsPending() bool {
+	c.endMu.Lock()
+	defer c.endMu.Unlock()
+	return len(c.adds) > 0
+}
+
+// recorded returns the products, safe to call from any goroutine.
+func (c *Client) recorded() []*product_info.Info {
+	c.endMu.Lock()
+	defer c.endMu.Unlock()
+	return append([]*product_info.Info{}, c.Infos...)
+}
+
+// forget drops a removed product, so it can be added again. Infos and
+// Products get new arrays, the ones handed out before stay as they were.
+func (c *Client) forget(info *product_info.Info) {
+	// messages of other products still queued may look it up
+	for _, other := range c.recorded() {
+		c.Shards.Flush(other.DatabaseKey)
+	}
+
+	c.endMu.Lock()
+	defer c.endMu.Unlock()
+	for i, other := range c.Infos {
+		if other != info {
+			continue
+		}
+		delete(c.BatchWrite, c.Products[i])
+		c.Infos = append(c.Infos[:i:i], c.Infos[i+1:]...)
+		c.Products = append(c.Products[:i:i], c.Products[i+1:]...)
+		break
+	}
+	delete(c.ended, info.DatabaseKey)
+	c.strikes.Clear(info.DatabaseKey)
+}
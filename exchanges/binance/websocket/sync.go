@@ -5,64 +5,191 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/lian/gdax-bookmap/exchanges/common"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
 )
 
-func (c *Client) SyncBook(book *orderbook.Book) error {
-	fmt.Println("sync", book.ID)
-
+func (c *Client) FetchSnapshot(book *orderbook.Book) (uint64, []*orderbook.BookLevel, []*orderbook.BookLevel, error) {
 	//url := fmt.Sprintf("https://www.binance.com/api/v1/depth?symbol=%s&limit=1000", strings.ToUpper(book.ProductInfo.ID))
 	url := fmt.Sprintf("https://api.binance.com/api/v1/depth?symbol=%s&limit=1000", strings.ToUpper(book.ProductInfo.ID))
-	res, err := http.Get(url)
+	res, err := common.Get("Binance", url)
 	if err != nil {
-		return err
+		return 0, nil, nil, err
 	}
+	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return err
+		return 0, nil, nil, err
+	}
+	if res.StatusCode == http.StatusBadRequest {
+		var apiErr struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		}
+		// -1121 invalid symbol
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Code == -1121 {
+			return 0, nil, nil, common.Delisted("%s: %s", book.ProductInfo.ID, apiErr.Msg)
+		}
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return 0, nil, nil, err
 	}
 
 	var data map[string]interface{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return err
+		return 0, nil, nil, common.Protocol("snapshot: %s", err)
 	}
 
-	if seq, ok := data["lastUpdateId"]; ok {
-		book.Clear()
-		book.Sequence = uint64(seq.(float64))
+	seq, ok := data["lastUpdateId"].(float64)
+	if !ok {
+		return 0, nil, nil, common.Protocol("snapshot without lastUpdateId: %s", string(body))
+	}
 
-		t := time.Now()
+	bids := []*orderbook.BookLevel{}
+	asks := []*orderbook.BookLevel{}
 
-		if bids, ok := data["bids"].([]interface{}); ok {
-			for i := len(bids) - 1; i >= 0; i-- {
-				data := bids[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				quantity, _ := strconv.ParseFloat(data[1].(string), 64)
-				book.UpdateBidLevel(t, price, quantity)
+	if list, ok := data["bids"].([]interface{}); ok {
+		for i := len(list) - 1; i >= 0; i-- {
+			price, quantity, ok := common.QuotedLevel("Binance", "depth.bids", list[i])
+			if !ok {
+				continue
 			}
+			bids = append(bids, &orderbook.BookLevel{Price: price, Size: quantity})
 		}
+	}
 
-		if asks, ok := data["asks"].([]interface{}); ok {
-			for i := len(asks) - 1; i >= 0; i-- {
-				data := asks[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				quantity, _ := strconv.ParseFloat(data[1].(string), 64)
-				book.UpdateAskLevel(t, price, quantity)
+	if list, ok := data["asks"].([]interface{}); ok {
+		for i := len(list) - 1; i >= 0; i-- {
+			price, quantity, ok := common.QuotedLevel("Binance", "depth.asks", list[i])
+			if !ok {
+				continue
 			}
+			asks = append(asks, &orderbook.BookLevel{Price: price, Size: quantity})
+		}
+	}
+
+	return uint64(seq), bids, asks, nil
+}
+
+func (c *Client) SyncBook(book *orderbook.Book) error {
+	fmt.Println("sync", book.ID)
+
+	seq, bids, asks, err := c.FetchSnapshot(book)
+	if err != nil {
+		return err
+	}
+	t := time.Now()
+	snapshot := &db_orderbook.SyncProvenance{Sequence: seq, Fetched: t}
+
+	if book.Empty() {
+		book.Clear()
+		book.Sequence = seq
+		book.Snapshot = snapshot
+		for _, level := range bids {
+			book.UpdateBidLevel(t, level.Price, level.Size)
+		}
+		for _, level := range asks {
+			book.UpdateAskLevel(t, level.Price, level.Size)
 		}
 
 		if c.dbEnabled {
 			batch := c.BatchWrite[book.ID]
-			now := time.Now()
 			fmt.Println("STORE INIT SYNC", book.ID, book.Sequence, batch.Count)
-			c.WriteSync(batch, book, now)
+			c.WriteSync(batch, book, t)
+		}
+	} else {
+		if seq < book.Sequence {
+			// the gap is still there, next message retries
+			return common.SnapshotStale("%s snapshot %d behind update %d", book.ID, seq, book.Sequence)
 		}
+
+		// resync, only record what changed since the book went out of sync
+		book.ApplySnapshot(t, bids, asks)
+		book.Sequence = seq
+		book.Snapshot = snapshot
+		book.Synced = false
+
+		if c.dbEnabled {
+			batch := c.BatchWrite[book.ID]
+			batch.ResetWarmUp()
+			fmt.Println("STORE RESYNC DIFF", book.ID, book.Sequence, len(book.Diff.Bid)+len(book.Diff.Ask))
+			c.WriteDiff(batch, book, t)
+		}
+	}
+
+	return nil
+}
+
+// PollBooks records a snapshot of every book as degraded sync packet, used
+// while the websocket depth feed is unavailable.
+func (c *Client) PollBooks() {
+	polled := map[*orderbook.Book]bool{}
+	for _, book := range c.Books {
+		if polled[book] {
+			continue
+		}
+		polled[book] = true
+
+		seq, bids, asks, err := c.FetchSnapshot(book)
+		if err != nil {
+			fmt.Println("poll", book.ID, err)
+			c.syncFailed(book, err)
+			continue
+		}
+		t := time.Now()
+
+		book.Clear()
+		book.Sequence = seq
+		book.Synced = false
+		book.Snapshot = &db_orderbook.SyncProvenance{Sequence: seq, Fetched: t}
+		for _, level := range bids {
+			book.UpdateBidLevel(t, level.Price, level.Size)
+		}
+		for _, level := range asks {
+			book.UpdateAskLevel(t, level.Price, level.Size)
+		}
+
+		if c.dbEnabled {
+			batch := c.BatchWrite[book.ID]
+			batch.ResetWarmUp()
+			fmt.Println("STORE DEGRADED SYNC", book.ID, book.Sequence)
+			c.WriteDegradedSync(batch, book, t)
+		}
+	}
+}
+
+// CheckSystemStatus asks binance whether it is in maintenance, which
+// explains failing connects.
+func (c *Client) CheckSystemStatus() error {
+	res, err := common.Get("Binance", "https://api.binance.com/sapi/v1/system/status")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return err
 	}
 
+	var data struct {
+		Status int    `json:"status"`
+		Msg    string `json:"msg"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return common.Protocol("system status: %s", err)
+	}
+	if data.Status == 1 {
+		common.Schedule.Begin("Binance", data.Msg, time.Now())
+	} else {
+		common.Schedule.Finish("Binance", time.Now())
+	}
 	return nil
 }
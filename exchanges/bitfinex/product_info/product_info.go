@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/lian/gdax-bookmap/exchanges/common"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 )
 
@@ -20,15 +20,18 @@ func init() {
 func FetchAllProductInfo() {
 	CachedInfo = map[string]product_info.Info{}
 
-	res, err := http.Get("https://api.bitfinex.com/v1/symbols_details")
+	res, err := common.Get("Bitfinex", "https://api.bitfinex.com/v1/symbols_details")
 	if err != nil {
 		fmt.Println("InitProduct error", err)
+		return
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 
 	if err != nil {
 		fmt.Println("InitProduct error", err)
+		return
 	}
 
 	var data []interface{}
@@ -12,23 +12,58 @@ import (
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
 	book_info "github.com/lian/gdax-bookmap/exchanges/bitfinex/product_info"
+	"github.com/lian/gdax-bookmap/exchanges/common"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
 	"github.com/lian/gdax-bookmap/util"
 )
 
+func init() {
+	// books are subscribed with len 100, checksums are not requested
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:         "Bitfinex",
+		MaxDepth:         100,
+		DynamicSubscribe: true,
+		Compression:      true,
+	})
+	exchanges.Register("Bitfinex", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		c := New(s.DB, s.ProductsOr("BTC-USD", "ETH-USD", "BCH-USD"))
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		c.RawBooks = s.RawBooks
+		c.PollInterval = s.PollInterval
+		return c, c.Infos
+	})
+}
+
 type Client struct {
-	Platform      string
-	Socket        *websocket.Conn
-	Products      []string
-	Books         map[string]*orderbook.Book
-	ConnectedAt   time.Time
-	DB            *bolt.DB
-	dbEnabled     bool
-	BatchWrite    map[string]*util.BookBatchWrite
-	Infos         []*product_info.Info
-	Subscriptions map[int]SubscriptionInfo
+	exchanges.BookIndex
+	Platform          string
+	Socket            *websocket.Conn
+	Products          []string
+	Books             map[string]*orderbook.Book
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	Subscriptions     map[int]SubscriptionInfo
+	Shards            *util.Shards
+	FailedConnects    int
+	// REST snapshots are stored this often while the websocket is down,
+	// 0 disables
+	PollInterval time.Duration
+	stopper      common.Stopper
+	// subscribe the raw books (R0), every order instead of the price
+	// levels, set before Run
+	RawBooks  bool
+	RawOrders map[string]*rawBook
 }
 
 func New(db *bolt.DB, products []string) *Client {
@@ -36,10 +71,12 @@ func New(db *bolt.DB, products []string) *Client {
 		Platform:      "Bitfinex",
 		Products:      []string{},
 		Books:         map[string]*orderbook.Book{},
-		BatchWrite:    map[string]*util.BookBatchWrite{},
+		BatchWrite:    map[string]*storage.BookWriter{},
 		DB:            db,
 		Infos:         []*product_info.Info{},
 		Subscriptions: map[int]SubscriptionInfo{},
+		RawOrders:     map[string]*rawBook{},
+		PollInterval:  10 * time.Second,
 	}
 	if c.DB != nil {
 		c.dbEnabled = true
@@ -62,13 +99,15 @@ func New(db *bolt.DB, products []string) *Client {
 
 func (c *Client) AddProduct(name string) {
 	c.Products = append(c.Products, name)
-	c.BatchWrite[name] = &util.BookBatchWrite{Count: 0, Batch: []*util.BatchChunk{}}
 	book := orderbook.New(name)
 	info := book_info.FetchProductInfo(name)
 	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
 	book.SetProductInfo(info)
 	id := fmt.Sprintf("t%s%s", info.BaseCurrency, info.QuoteCurrency)
 	c.Books[id] = book
+	c.AddBook(book.ID, book)
+	c.RawOrders[id] = newRawBook()
 }
 
 type WebsocketHandshake struct {
@@ -95,7 +134,7 @@ func (c *Client) Connect() error {
 	//url := "wss://api.bitfinex.com/ws"
 	url := "wss://api.bitfinex.com/ws/2"
 	fmt.Println("connect to websocket", url)
-	s, _, err := websocket.DefaultDialer.Dial(url, nil)
+	s, _, err := common.Dial(c.Platform, url)
 
 	if err != nil {
 		return err
@@ -107,7 +146,7 @@ func (c *Client) Connect() error {
 	}
 
 	if msgType != websocket.TextMessage {
-		return fmt.Errorf("invalid websocket message")
+		return common.Protocol("invalid websocket message")
 	}
 
 	var hs WebsocketHandshake
@@ -118,10 +157,11 @@ func (c *Client) Connect() error {
 	if hs.Event == "info" {
 		log.Println(c.Platform, "Connected")
 	} else {
-		return fmt.Errorf("no handshake")
+		return common.Protocol("no handshake")
 	}
 
 	c.Socket = s
+	c.stopper.Connected(s)
 	c.ConnectedAt = time.Now()
 
 	for _, channel := range []string{"book", "trades"} {
@@ -129,6 +169,9 @@ func (c *Client) Connect() error {
 			params := make(map[string]string)
 			if channel == "book" {
 				params["prec"] = "P0"
+				if c.RawBooks {
+					params["prec"] = "R0"
+				}
 				params["freq"] = "F0"
 				params["len"] = "100"
 			}
@@ -140,30 +183,51 @@ func (c *Client) Connect() error {
 	return nil
 }
 
-func (c *Client) WriteDiff(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
 	book.FixBookLevels() // TODO fix/remove
 	diff := book.Diff
 	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
 		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
-		batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, pkt)
+		batch.Write(now, pkt)
 		book.ResetDiff()
 		batch.LastDiffSeq = book.Sequence + 1
 	}
 }
 
-func (c *Client) WriteSync(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
 	book.FixBookLevels() // TODO fix/remove
-	batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
 	book.ResetDiff()
 	batch.LastDiffSeq = book.Sequence + 1
 }
 
+func (c *Client) WriteDegradedSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	book.FixBookLevels() // TODO fix/remove
+	batch.Write(now, orderbook.PackDegradedSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+// wait for queued messages before the books get resubscribed
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
 func (c *Client) Run() {
-	for {
+	for !c.stopper.Stopped() {
 		c.run()
 	}
 }
 
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
 type SubscriptionInfo struct {
 	Channel string
 	Symbol  string
@@ -176,17 +240,33 @@ func (c *Client) AddSubscriptionChannel(chanID int, channel, symbol string) {
 
 func (c *Client) run() {
 	if err := c.Connect(); err != nil {
+		c.FailedConnects += 1
+		if common.Schedule.Wait(c.Platform) {
+			return
+		}
 		fmt.Println("failed to connect", err)
-		time.Sleep(1000 * time.Millisecond)
+		if c.FailedConnects >= 3 && c.PollInterval > 0 {
+			// websocket is down, keep recording coarse snapshots until it is back
+			c.PollBooks()
+			time.Sleep(c.PollInterval)
+		} else {
+			time.Sleep(1000 * time.Millisecond)
+		}
 		return
 	}
 
 	defer c.Socket.Close()
+	defer c.flushShards()
 
 	for {
 		msgType, message, err := c.Socket.ReadMessage()
 		if err != nil {
 			log.Println("read:", err)
+			if time.Since(c.ConnectedAt).Seconds() < 30.0 {
+				c.FailedConnects += 1
+			} else {
+				c.FailedConnects = 0
+			}
 			return
 		}
 
@@ -194,148 +274,234 @@ func (c *Client) run() {
 			continue
 		}
 
-		var pkt interface{}
-		if err := json.Unmarshal(message, &pkt); err != nil {
-			log.Println("PacketHeader-parse:", err)
-			continue
+		common.Capture(c.Platform, message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
 		}
+	}
+}
+
+func (c *Client) HandleRaw(message []byte) error {
+	var pkt interface{}
+	if err := json.Unmarshal(message, &pkt); err != nil {
+		return fmt.Errorf("PacketHeader-parse: %s", err)
+	}
 
-		if eventData, ok := pkt.(map[string]interface{}); ok {
-			if event, ok := eventData["event"].(string); ok {
-				switch event {
-				case "subscribed":
-					c.AddSubscriptionChannel(int(eventData["chanId"].(float64)), eventData["channel"].(string), eventData["symbol"].(string))
-				default:
-					fmt.Println("unkown event", eventData)
+	if eventData, ok := pkt.(map[string]interface{}); ok {
+		if event, ok := eventData["event"].(string); ok {
+			switch event {
+			case "subscribed":
+				c.AddSubscriptionChannel(int(eventData["chanId"].(float64)), eventData["channel"].(string), eventData["symbol"].(string))
+			case "info":
+				if code, ok := eventData["code"].(float64); ok {
+					switch int(code) {
+					case 20060:
+						// entering maintenance, the connection stays up without data
+						msg, _ := eventData["msg"].(string)
+						common.Schedule.Begin(c.Platform, msg, time.Now())
+					case 20061:
+						// maintenance ended, the channels have to be subscribed again
+						common.Schedule.Finish(c.Platform, time.Now())
+						return common.ErrReconnect
+					case 20051:
+						log.Println(c.Platform, "server restart requested, reconnecting")
+						return common.ErrReconnect
+					}
 				}
+			default:
+				fmt.Println("unkown event", eventData)
 			}
-			continue
 		}
+		return nil
+	}
 
-		if data, ok := pkt.([]interface{}); ok {
-			if len(data) == 0 {
-				continue
-			}
+	if data, ok := pkt.([]interface{}); ok {
+		if len(data) == 0 {
+			return nil
+		}
 
-			chanInfo, ok := c.Subscriptions[int(data[0].(float64))]
+		chanInfo, ok := c.Subscriptions[int(data[0].(float64))]
 
-			if !ok {
-				log.Println("Unable to locate chanID", int(data[0].(float64)))
-				continue
+		if !ok {
+			return fmt.Errorf("Unable to locate chanID %d", int(data[0].(float64)))
+		}
+
+		if len(data) == 2 {
+			if text, ok := data[1].(string); ok && text == "hb" {
+				// received heartbeat
+				return nil
 			}
+		}
 
-			if len(data) == 2 {
-				if text, ok := data[1].(string); ok && text == "hb" {
-					// received heartbeat
-					continue
-				}
+		book := c.Books[chanInfo.Symbol]
+		c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+			if err := c.HandleMessage(book, chanInfo, data); err != nil {
+				fmt.Println(err)
 			}
+		})
+	}
+	return nil
+}
 
-			book := c.Books[chanInfo.Symbol]
-			//fmt.Println(book.ProductInfo.DatabaseKey, chanInfo.Channel, data)
-			now := time.Now()
+func (c *Client) HandleMessage(book *orderbook.Book, chanInfo SubscriptionInfo, data []interface{}) error {
+	//fmt.Println(book.ProductInfo.DatabaseKey, chanInfo.Channel, data)
+	now := time.Now()
 
-			var trade *orderbook.Trade
+	var trade *orderbook.Trade
 
-			//fmt.Println(chanInfo.Channel, data)
+	//fmt.Println(chanInfo.Channel, data)
 
-			switch chanInfo.Channel {
-			case "book":
-				if len(data) != 2 {
-					fmt.Println("wrong book packet length", chanInfo)
+	switch chanInfo.Channel {
+	case "book":
+		if len(data) != 2 {
+			return common.Protocol("wrong book packet length %v", chanInfo)
+		}
+
+		list, ok := common.List("Bitfinex", "book", data[1])
+		if !ok || len(list) == 0 {
+			return nil
+		}
+
+		if c.RawBooks {
+			c.handleRawBook(book, chanInfo.Symbol, list, now)
+		} else if _, snapshot := list[0].([]interface{}); !snapshot {
+			// update
+
+			price, count, amount, ok := bookLevel("book", list)
+			if !ok {
+				return nil
+			}
+			if amount < 0 {
+				// ask
+				amount = math.Abs(amount)
+				if count == 0 {
+					amount = 0
+				}
+				book.UpdateAskLevel(now, price, amount)
+			} else {
+				// bid
+				if count == 0 {
+					amount = 0
 				}
+				book.UpdateBidLevel(now, price, amount)
+			}
+		} else {
+			// snapshot
 
-				list := data[1].([]interface{})
-
-				if _, ok := list[0].(float64); ok {
-					// update
-
-					price, count, amount := list[0].(float64), list[1].(float64), list[2].(float64)
-					if amount < 0 {
-						// ask
-						amount = math.Abs(amount)
-						if count == 0 {
-							amount = 0
-						}
-						book.UpdateAskLevel(now, price, amount)
-					} else {
-						// bid
-						if count == 0 {
-							amount = 0
-						}
-						book.UpdateBidLevel(now, price, amount)
+			bids := []*orderbook.BookLevel{}
+			asks := []*orderbook.BookLevel{}
+
+			for _, item := range list {
+				price, count, amount, ok := bookLevel("book.snapshot", item)
+				if !ok {
+					continue
+				}
+
+				if amount < 0 {
+					// ask
+					amount = math.Abs(amount)
+					if count == 0 {
+						amount = 0
 					}
+					asks = append(asks, &orderbook.BookLevel{Price: price, Size: amount})
 				} else {
-					// snapshot
-
-					book.Clear()
-					//book.Sequence = uint64(now.Unix())
-					book.Sequence = uint64(0)
-
-					for _, item := range list {
-						values := item.([]interface{})
-						price, count, amount := values[0].(float64), values[1].(float64), values[2].(float64)
-
-						if amount < 0 {
-							// ask
-							amount = math.Abs(amount)
-							if count == 0 {
-								amount = 0
-							}
-							book.UpdateAskLevel(now, price, amount)
-						} else {
-							// bid
-							if count == 0 {
-								amount = 0
-							}
-							book.UpdateBidLevel(now, price, amount)
-						}
+					// bid
+					if count == 0 {
+						amount = 0
 					}
+					bids = append(bids, &orderbook.BookLevel{Price: price, Size: amount})
 				}
-			case "trades":
-				if len(data) != 3 {
-					// skip snapshot
-					//fmt.Println("wrong trades packet length", chanInfo, data)
-				}
+			}
 
-				if pktType, ok := data[1].(string); ok && pktType == "te" {
-					values := data[2].([]interface{})
-					amount, price := values[2].(float64), values[3].(float64)
-					if amount < 0 {
-						// sell
-						amount = math.Abs(amount)
-						book.AddTrade(now, uint8(orderbook.BidSide), price, amount)
-					} else {
-						// buy
-						book.AddTrade(now, uint8(orderbook.AskSide), price, amount)
-					}
-					trade = book.Trades[len(book.Trades)-1]
-				}
+			if book.Empty() {
+				book.Clear()
+				//book.Sequence = uint64(now.Unix())
+				book.Sequence = uint64(0)
+			} else if c.dbEnabled {
+				// resubscribed after a gap
+				c.BatchWrite[book.ID].ResetWarmUp()
+			}
+			// on resubscribe only the levels which changed end up in the diff
+			book.ApplySnapshot(now, bids, asks)
+		}
+	case "trades":
+		if len(data) != 3 {
+			// skip snapshot
+			//fmt.Println("wrong trades packet length", chanInfo, data)
+		}
 
-			default:
-				fmt.Println("unkown channel", chanInfo)
+		if pktType, ok := data[1].(string); ok && pktType == "te" {
+			values, ok := common.List("Bitfinex", "trades.te", data[2])
+			if !ok || len(values) < 4 {
+				return nil
 			}
+			amount, ok := common.Number("Bitfinex", "trades.te.amount", values[2])
+			if !ok {
+				return nil
+			}
+			price, ok := common.Number("Bitfinex", "trades.te.price", values[3])
+			if !ok {
+				return nil
+			}
+			if amount < 0 {
+				// sell
+				amount = math.Abs(amount)
+				book.AddClassifiedTrade(now, uint8(orderbook.BidSide), db_orderbook.SideFromVenue, price, amount)
+			} else {
+				// buy
+				book.AddClassifiedTrade(now, uint8(orderbook.AskSide), db_orderbook.SideFromVenue, price, amount)
+			}
+			trade = book.Trades[len(book.Trades)-1]
+		}
 
-			book.Sequence += 1
+	default:
+		return common.Protocol("unkown channel %v", chanInfo)
+	}
 
-			if c.dbEnabled {
-				batch := c.BatchWrite[book.ID]
-				now := time.Now()
-				if trade != nil {
-					batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(trade))
-				}
+	book.Sequence += 1
 
-				if batch.NextSync(now) {
-					fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
-					c.WriteSync(batch, book, now)
-				} else {
-					if batch.NextDiff(now) {
-						//fmt.Println("STORE DIFF", book.ProductInfo.DatabaseKey, batch.Count)
-						c.WriteDiff(batch, book, now)
-					}
-				}
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
+		if trade != nil {
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
 			}
+		}
 
+		if batch.NextSync(now) {
+			fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
+			c.WriteSync(batch, book, now)
+		} else {
+			if batch.NextDiff(now) {
+				//fmt.Println("STORE DIFF", book.ProductInfo.DatabaseKey, batch.Count)
+				c.WriteDiff(batch, book, now)
+			}
 		}
 	}
+	return nil
+}
+
+// bookLevel reads a level of the book channel, [price, count, amount].
+func bookLevel(field string, v interface{}) (price, count, amount float64, ok bool) {
+	values, ok := common.List("Bitfinex", field, v)
+	if !ok || len(values) < 3 {
+		return 0, 0, 0, false
+	}
+	if price, ok = common.Number("Bitfinex", field+".price", values[0]); !ok {
+		return
+	}
+	if count, ok = common.Number("Bitfinex", field+".count", values[1]); !ok {
+		return
+	}
+	amount, ok = common.Number("Bitfinex", field+".amount", values[2])
+	return
 }
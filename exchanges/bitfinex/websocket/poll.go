@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+)
+
+// FetchSnapshot returns the 100 best levels per side of the REST book of
+// a symbol, e.g. tBTCUSD.
+func (c *Client) FetchSnapshot(symbol string) ([]*orderbook.BookLevel, []*orderbook.BookLevel, error) {
+	url := fmt.Sprintf("https://api-pub.bitfinex.com/v2/book/%s/P0?len=100", symbol)
+	res, err := common.Get(c.Platform, url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return nil, nil, err
+	}
+
+	var list []interface{}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, nil, common.Protocol("book: %s", err)
+	}
+
+	// [price, count, amount], negative amounts are asks
+	bids := []*orderbook.BookLevel{}
+	asks := []*orderbook.BookLevel{}
+	for _, item := range list {
+		price, count, amount, ok := bookLevel("rest.book", item)
+		if !ok || count == 0 {
+			continue
+		}
+		if amount < 0 {
+			asks = append(asks, &orderbook.BookLevel{Price: price, Size: math.Abs(amount)})
+		} else {
+			bids = append(bids, &orderbook.BookLevel{Price: price, Size: amount})
+		}
+	}
+	return bids, asks, nil
+}
+
+// PollBooks records a snapshot of every book as degraded sync packet, used
+// while the websocket is unavailable.
+func (c *Client) PollBooks() {
+	for symbol, book := range c.Books {
+		bids, asks, err := c.FetchSnapshot(symbol)
+		if err != nil {
+			fmt.Println("poll", book.ID, err)
+			continue
+		}
+		t := time.Now()
+
+		book.Clear()
+		book.Sequence += 1
+		book.Snapshot = &db_orderbook.SyncProvenance{Sequence: book.Sequence, Fetched: t}
+		for _, level := range bids {
+			book.UpdateBidLevel(t, level.Price, level.Size)
+		}
+		for _, level := range asks {
+			book.UpdateAskLevel(t, level.Price, level.Size)
+		}
+
+		if c.dbEnabled {
+			batch := c.BatchWrite[book.ID]
+			batch.ResetWarmUp()
+			fmt.Println("STORE DEGRADED SYNC", book.ID, book.Sequence)
+			c.WriteDegradedSync(batch, book, t)
+		}
+	}
+}
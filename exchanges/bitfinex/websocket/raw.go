@@ -0,0 +1,156 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+)
+
+type rawOrder struct {
+	Price  float64
+	Amount float64
+}
+
+// rawBook keeps the orders of a raw book (R0) by id and sums them into the
+// price levels of the common book, positive amounts are bids.
+type rawBook struct {
+	orders map[float64]rawOrder
+	bids   map[float64]float64
+	asks   map[float64]float64
+}
+
+func newRawBook() *rawBook {
+	r := &rawBook{}
+	r.reset()
+	return r
+}
+
+func (r *rawBook) reset() {
+	r.orders = map[float64]rawOrder{}
+	r.bids = map[float64]float64{}
+	r.asks = map[float64]float64{}
+}
+
+func (r *rawBook) level(amount float64) map[float64]float64 {
+	if amount < 0 {
+		return r.asks
+	}
+	return r.bids
+}
+
+// add changes the level of an order by amount and returns its new size.
+func (r *rawBook) add(price, amount float64) float64 {
+	levels := r.level(amount)
+	size := levels[price] + abs(amount)
+	if size < 1e-12 {
+		size = 0
+		delete(levels, price)
+	} else {
+		levels[price] = size
+	}
+	return size
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// levels returns the summed levels of a snapshot.
+func (r *rawBook) levels() (bids, asks []*orderbook.BookLevel) {
+	bids, asks = []*orderbook.BookLevel{}, []*orderbook.BookLevel{}
+	for price, size := range r.bids {
+		bids = append(bids, &orderbook.BookLevel{Price: price, Size: size})
+	}
+	for price, size := range r.asks {
+		asks = append(asks, &orderbook.BookLevel{Price: price, Size: size})
+	}
+	return bids, asks
+}
+
+// update applies one [order id, price, amount] of the raw book, a price of
+// 0 removes the order.
+func (r *rawBook) update(book *orderbook.Book, now time.Time, id, price, amount float64) {
+	if old, ok := r.orders[id]; ok {
+		delete(r.orders, id)
+		size := r.levelAfterRemove(old)
+		if old.Amount < 0 {
+			book.UpdateAskLevel(now, old.Price, size)
+		} else {
+			book.UpdateBidLevel(now, old.Price, size)
+		}
+	}
+	if price == 0 {
+		return
+	}
+	r.orders[id] = rawOrder{Price: price, Amount: amount}
+	size := r.add(price, amount)
+	if amount < 0 {
+		book.UpdateAskLevel(now, price, size)
+	} else {
+		book.UpdateBidLevel(now, price, size)
+	}
+}
+
+func (r *rawBook) levelAfterRemove(order rawOrder) float64 {
+	levels := r.level(order.Amount)
+	size := levels[order.Price] - abs(order.Amount)
+	if size < 1e-12 {
+		delete(levels, order.Price)
+		return 0
+	}
+	levels[order.Price] = size
+	return size
+}
+
+// handleRawBook applies a snapshot or update of the raw book channel.
+func (c *Client) handleRawBook(book *orderbook.Book, symbol string, list []interface{}, now time.Time) {
+	if _, snapshot := list[0].([]interface{}); !snapshot {
+		id, price, amount, ok := rawBookOrder("book.raw", list)
+		if !ok {
+			return
+		}
+		c.RawOrders[symbol].update(book, now, id, price, amount)
+		return
+	}
+
+	raw := c.RawOrders[symbol]
+	raw.reset()
+	for _, item := range list {
+		id, price, amount, ok := rawBookOrder("book.raw.snapshot", item)
+		if !ok || price == 0 {
+			continue
+		}
+		raw.orders[id] = rawOrder{Price: price, Amount: amount}
+		raw.add(price, amount)
+	}
+
+	bids, asks := raw.levels()
+	if book.Empty() {
+		book.Clear()
+		book.Sequence = uint64(0)
+	} else if c.dbEnabled {
+		// resubscribed after a gap
+		c.BatchWrite[book.ID].ResetWarmUp()
+	}
+	book.ApplySnapshot(now, bids, asks)
+}
+
+// rawBookOrder reads an order of the raw book channel, [id, price, amount].
+func rawBookOrder(field string, v interface{}) (id, price, amount float64, ok bool) {
+	values, ok := common.List("Bitfinex", field, v)
+	if !ok || len(values) < 3 {
+		return 0, 0, 0, false
+	}
+	if id, ok = common.Number("Bitfinex", field+".id", values[0]); !ok {
+		return
+	}
+	if price, ok = common.Number("Bitfinex", field+".price", values[1]); !ok {
+		return
+	}
+	amount, ok = common.Number("Bitfinex", field+".amount", values[2])
+	return
+}
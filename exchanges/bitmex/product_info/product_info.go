@@ -0,0 +1,138 @@
+package product_info
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Contract turns the contracts bitmex quotes sizes in into the base
+// currency, so the books compare to the spot venues.
+type Contract struct {
+	Symbol string
+	// a contract is worth one unit of the quote currency, e.g. XBTUSD
+	Inverse bool
+	// contracts per unit of the base currency of linear contracts, 0 for
+	// quanto contracts whose sizes stay in contracts
+	PerBase float64
+}
+
+// BaseSize returns the size of contracts at price in the base currency.
+func (c Contract) BaseSize(contracts, price float64) float64 {
+	switch {
+	case c.Inverse && price > 0:
+		return contracts / price
+	case c.PerBase > 0:
+		return contracts / c.PerBase
+	}
+	return contracts
+}
+
+var CachedInfo map[string]product_info.Info
+var CachedContracts map[string]Contract
+
+// bitmex names some currencies differently
+var currencies = map[string]string{
+	"XBT": "BTC",
+}
+
+func init() {
+	FetchAllProductInfo()
+}
+
+func FetchAllProductInfo() {
+	CachedInfo = map[string]product_info.Info{}
+	CachedContracts = map[string]Contract{}
+
+	res, err := common.Get("BitMEX", "https://www.bitmex.com/api/v1/instrument/active")
+	if err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+
+	var instruments []struct {
+		Symbol                         string  `json:"symbol"`
+		Typ                            string  `json:"typ"`
+		Underlying                     string  `json:"underlying"`
+		QuoteCurrency                  string  `json:"quoteCurrency"`
+		TickSize                       float64 `json:"tickSize"`
+		LotSize                        float64 `json:"lotSize"`
+		IsInverse                      bool    `json:"isInverse"`
+		IsQuanto                       bool    `json:"isQuanto"`
+		UnderlyingToPositionMultiplier float64 `json:"underlyingToPositionMultiplier"`
+	}
+	if err := json.Unmarshal(body, &instruments); err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+
+	for _, i := range instruments {
+		base, quote := currency(i.Underlying), currency(i.QuoteCurrency)
+		name := i.Symbol
+		if i.Typ == "FFWCSX" {
+			// perpetual swaps go by their currencies, futures by symbol
+			name = fmt.Sprintf("%s-%s", base, quote)
+		}
+		if _, ok := CachedInfo[name]; ok {
+			continue
+		}
+
+		info := product_info.Info{
+			ID:             i.Symbol,
+			DisplayName:    name,
+			BaseCurrency:   base,
+			QuoteCurrency:  quote,
+			Platform:       "BitMEX",
+			DatabaseKey:    fmt.Sprintf("BitMEX-%s", name),
+			QuoteIncrement: product_info.FloatString(i.TickSize),
+			FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(i.TickSize)),
+		}
+		contract := Contract{Symbol: i.Symbol, Inverse: i.IsInverse}
+		if !i.IsInverse && !i.IsQuanto {
+			contract.PerBase = i.UnderlyingToPositionMultiplier
+		}
+		if !contract.Inverse {
+			// the base size of inverse contracts depends on the price
+			info.BaseMinSize = product_info.FloatString(contract.BaseSize(i.LotSize, 0))
+		}
+
+		CachedInfo[name] = info
+		CachedContracts[name] = contract
+	}
+}
+
+func currency(name string) string {
+	name = strings.ToUpper(name)
+	if c, ok := currencies[name]; ok {
+		return c
+	}
+	return name
+}
+
+func FetchProductInfo(id string) product_info.Info {
+	if info, ok := CachedInfo[id]; ok {
+		return info
+	}
+	return product_info.Info{}
+}
+
+func FetchContract(id string) (Contract, bool) {
+	contract, ok := CachedContracts[id]
+	return contract, ok
+}
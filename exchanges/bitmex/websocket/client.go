@@ -0,0 +1,423 @@
+package websocket
+
+// api: https://www.bitmex.com/app/wsAPI
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
+	book_info "github.com/lian/gdax-bookmap/exchanges/bitmex/product_info"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	// orderBookL2 is the full book, levels are sent with an id
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:         "BitMEX",
+		DynamicSubscribe: true,
+	})
+	exchanges.Register("BitMEX", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		c := New(s.DB, s.ProductsOr("BTC-USD", "ETH-USD"))
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		return c, c.Infos
+	})
+}
+
+// level of orderBookL2 by its id, updates and deletes may leave out the
+// price
+type level struct {
+	Price float64
+	Side  orderbook.Side
+}
+
+type Client struct {
+	exchanges.BookIndex
+	Platform string
+	Socket   *websocket.Conn
+	// every write to Socket goes through it
+	Writer            *common.Writer
+	Products          []string
+	Books             map[string]*orderbook.Book
+	Contracts         map[string]book_info.Contract
+	Levels            map[string]map[float64]level
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	Shards            *util.Shards
+	stopper           common.Stopper
+}
+
+func New(db *bolt.DB, products []string) *Client {
+	c := &Client{
+		Platform:   "BitMEX",
+		Products:   []string{},
+		Books:      map[string]*orderbook.Book{},
+		Contracts:  map[string]book_info.Contract{},
+		Levels:     map[string]map[float64]level{},
+		BatchWrite: map[string]*storage.BookWriter{},
+		DB:         db,
+		Infos:      []*product_info.Info{},
+	}
+	if c.DB != nil {
+		c.dbEnabled = true
+	}
+
+	for _, name := range products {
+		c.AddProduct(name)
+	}
+
+	if c.dbEnabled {
+		buckets := []string{}
+		for _, info := range c.Infos {
+			buckets = append(buckets, info.DatabaseKey)
+		}
+		util.CreateBucketsDB(c.DB, buckets)
+	}
+
+	return c
+}
+
+func (c *Client) AddProduct(name string) {
+	contract, ok := book_info.FetchContract(name)
+	if !ok {
+		fmt.Println(c.Platform, "unknown product", name)
+		return
+	}
+	c.Products = append(c.Products, name)
+	book := orderbook.New(name)
+	info := book_info.FetchProductInfo(name)
+	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
+	book.SetProductInfo(info)
+	// messages name the symbol, e.g. XBTUSD for BTC-USD
+	c.Books[contract.Symbol] = book
+	c.AddBook(book.ID, book)
+	c.Contracts[contract.Symbol] = contract
+	c.Levels[contract.Symbol] = map[float64]level{}
+}
+
+func (c *Client) Connect() error {
+	url := "wss://ws.bitmex.com/realtime"
+	fmt.Println("connect to websocket", url)
+	s, _, err := common.Dial(c.Platform, url)
+	if err != nil {
+		return err
+	}
+
+	c.Socket = s
+	c.stopper.Connected(s)
+	c.Writer = common.NewWriter(c.Platform, s, 64)
+	c.ConnectedAt = time.Now()
+
+	args := []string{}
+	for symbol, book := range c.Books {
+		// the partial of the new subscription syncs it again
+		book.Synced = false
+		args = append(args, "orderBookL2:"+symbol, "trade:"+symbol)
+	}
+	if err := c.Writer.WriteJSON(map[string]interface{}{"op": "subscribe", "args": args}); err != nil {
+		s.Close()
+		c.Writer.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	diff := book.Diff
+	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
+		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		batch.Write(now, pkt)
+		book.ResetDiff()
+		batch.LastDiffSeq = book.Sequence + 1
+	}
+}
+
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+// wait for queued messages before the books get resubscribed
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
+func (c *Client) Run() {
+	for !c.stopper.Stopped() {
+		c.run()
+	}
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
+func (c *Client) run() {
+	if err := c.Connect(); err != nil {
+		if common.Schedule.Wait(c.Platform) {
+			return
+		}
+		fmt.Println("failed to connect", err)
+		time.Sleep(1000 * time.Millisecond)
+		return
+	}
+
+	defer c.Socket.Close()
+	defer c.Writer.Close()
+	defer c.flushShards()
+
+	for {
+		msgType, message, err := c.Socket.ReadMessage()
+		if err != nil {
+			log.Println("read:", err)
+			return
+		}
+
+		if msgType != websocket.TextMessage {
+			continue
+		}
+
+		common.Capture(c.Platform, message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+type tableMessage struct {
+	Table  string     `json:"table"`
+	Action string     `json:"action"`
+	Data   []tableRow `json:"data"`
+	Info   string     `json:"info"`
+	Error  string     `json:"error"`
+	Status int        `json:"status"`
+	Sub    string     `json:"subscribe"`
+	OK     bool       `json:"success"`
+}
+
+type tableRow struct {
+	Symbol string      `json:"symbol"`
+	ID     float64     `json:"id"`
+	Side   string      `json:"side"`
+	Size   interface{} `json:"size"`
+	Price  interface{} `json:"price"`
+}
+
+func (c *Client) HandleRaw(message []byte) error {
+	if string(message) == "pong" {
+		return nil
+	}
+	var msg tableMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("PacketHeader-parse: %s", err)
+	}
+
+	switch {
+	case msg.Error != "":
+		if msg.Status == 503 {
+			// overloaded or in maintenance
+			common.Schedule.Begin(c.Platform, msg.Error, time.Now())
+			return common.ErrReconnect
+		}
+		return common.Protocol("%s error %s", c.Platform, msg.Error)
+	case msg.Sub != "":
+		log.Printf("%s Subscribed to %s: %v\n", c.Platform, msg.Sub, msg.OK)
+		return nil
+	case msg.Info != "":
+		if common.Schedule.Active(c.Platform, time.Now()) != nil {
+			common.Schedule.Finish(c.Platform, time.Now())
+		}
+		return nil
+	case msg.Table == "":
+		return nil
+	}
+
+	// rows of one message may belong to several symbols
+	rows := map[string][]tableRow{}
+	for _, row := range msg.Data {
+		rows[row.Symbol] = append(rows[row.Symbol], row)
+	}
+	for symbol, data := range rows {
+		book, ok := c.Books[symbol]
+		if !ok {
+			continue
+		}
+		symbol, data := symbol, data
+		c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+			if err := c.HandleMessage(book, symbol, msg.Table, msg.Action, data); err != nil {
+				fmt.Println(err)
+			}
+		})
+	}
+	return nil
+}
+
+func (c *Client) HandleMessage(book *orderbook.Book, symbol, table, action string, data []tableRow) error {
+	now := time.Now()
+	contract := c.Contracts[symbol]
+	levels := c.Levels[symbol]
+
+	trades := []*orderbook.Trade{}
+
+	switch table {
+	case "orderBookL2", "orderBookL2_25":
+		if action == "partial" {
+			for id := range levels {
+				delete(levels, id)
+			}
+			bids, asks := []*orderbook.BookLevel{}, []*orderbook.BookLevel{}
+			for _, row := range data {
+				price, contracts, ok := c.row(row, true)
+				if !ok {
+					continue
+				}
+				l := &orderbook.BookLevel{Price: price, Size: contract.BaseSize(contracts, price)}
+				if row.Side == "Sell" {
+					levels[row.ID] = level{Price: price, Side: orderbook.AskSide}
+					asks = append(asks, l)
+				} else {
+					levels[row.ID] = level{Price: price, Side: orderbook.BidSide}
+					bids = append(bids, l)
+				}
+			}
+			if book.Empty() {
+				book.Clear()
+				book.Sequence = uint64(0)
+			} else if c.dbEnabled {
+				// resubscribed after a gap
+				c.BatchWrite[book.ID].ResetWarmUp()
+			}
+			// on resubscribe only the levels which changed end up in the diff
+			book.ApplySnapshot(now, bids, asks)
+			book.Synced = true
+			break
+		}
+		if !book.Synced {
+			// updates before the partial
+			return nil
+		}
+		for _, row := range data {
+			var price, size float64
+			side := orderbook.BidSide
+			if row.Side == "Sell" {
+				side = orderbook.AskSide
+			}
+			switch action {
+			case "insert":
+				p, contracts, ok := c.row(row, true)
+				if !ok {
+					continue
+				}
+				levels[row.ID] = level{Price: p, Side: side}
+				price, size = p, contract.BaseSize(contracts, p)
+			case "update":
+				known, ok := levels[row.ID]
+				_, contracts, valid := c.row(row, false)
+				if !ok || !valid {
+					continue
+				}
+				price, size = known.Price, contract.BaseSize(contracts, known.Price)
+			case "delete":
+				known, ok := levels[row.ID]
+				if !ok {
+					continue
+				}
+				delete(levels, row.ID)
+				price = known.Price
+			default:
+				continue
+			}
+			if side == orderbook.AskSide {
+				book.UpdateAskLevel(now, price, size)
+			} else {
+				book.UpdateBidLevel(now, price, size)
+			}
+		}
+
+	case "trade":
+		if action == "partial" {
+			// the last trade before subscribing
+			return nil
+		}
+		for _, row := range data {
+			price, contracts, ok := c.row(row, true)
+			if !ok {
+				continue
+			}
+			size := contract.BaseSize(contracts, price)
+			if row.Side == "Sell" {
+				book.AddClassifiedTrade(now, uint8(orderbook.BidSide), db_orderbook.SideFromVenue, price, size)
+			} else {
+				book.AddClassifiedTrade(now, uint8(orderbook.AskSide), db_orderbook.SideFromVenue, price, size)
+			}
+			trades = append(trades, book.Trades[len(book.Trades)-1])
+		}
+
+	default:
+		return common.Protocol("unkown table %s", table)
+	}
+
+	book.Sequence += 1
+
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
+		for _, trade := range trades {
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
+		}
+
+		if batch.NextSync(now) {
+			fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
+			c.WriteSync(batch, book, now)
+		} else {
+			if batch.NextDiff(now) {
+				c.WriteDiff(batch, book, now)
+			}
+		}
+	}
+	return nil
+}
+
+// row reads the price and size in contracts of a row, updates carry no
+// price.
+func (c *Client) row(row tableRow, withPrice bool) (price, contracts float64, ok bool) {
+	if withPrice {
+		if price, ok = common.Number(c.Platform, "price", row.Price); !ok {
+			return
+		}
+	}
+	contracts, ok = common.Number(c.Platform, "size", row.Size)
+	return
+}
@@ -11,32 +11,64 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
 
+	"github.com/lian/gdax-bookmap/exchanges"
 	book_info "github.com/lian/gdax-bookmap/exchanges/bitstamp/product_info"
+	"github.com/lian/gdax-bookmap/exchanges/common"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
 	"github.com/lian/gdax-bookmap/util"
 )
 
+func init() {
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:         "Bitstamp",
+		DynamicSubscribe: true,
+	})
+	exchanges.Register("Bitstamp", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		c := New(s.DB, s.ProductsOr("BTC-USD", "ETH-USD", "BCH-USD"))
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		c.PollInterval = s.PollInterval
+		return c, c.Infos
+	})
+}
+
 type Client struct {
-	Products    []string
-	Books       map[string]*orderbook.Book
-	Socket      *websocket.Conn
-	DB          *bolt.DB
-	dbEnabled   bool
-	LastSync    time.Time
-	LastDiff    time.Time
-	LastDiffSeq uint64
-	BatchWrite  map[string]*util.BookBatchWrite
-	Infos       []*product_info.Info
+	exchanges.BookIndex
+	Products []string
+	Books    map[string]*orderbook.Book
+	// channels of the old pusher feed, for replaying its captures
+	legacy map[string]*orderbook.Book
+	Socket *websocket.Conn
+	// every write to Socket goes through it
+	Writer            *common.Writer
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	FailedConnects    int
+	PollInterval      time.Duration
+	Shards            *util.Shards
+	stopper           common.Stopper
+	// books start empty at the first message instead of a REST snapshot,
+	// for replaying captured feeds
+	Replay bool
 }
 
 func New(db *bolt.DB, products []string) *Client {
 	c := &Client{
-		Products:   []string{},
-		Books:      map[string]*orderbook.Book{},
-		BatchWrite: map[string]*util.BookBatchWrite{},
-		DB:         db,
-		Infos:      []*product_info.Info{},
+		Products:     []string{},
+		Books:        map[string]*orderbook.Book{},
+		legacy:       map[string]*orderbook.Book{},
+		BatchWrite:   map[string]*storage.BookWriter{},
+		DB:           db,
+		Infos:        []*product_info.Info{},
+		PollInterval: 10 * time.Second,
 	}
 
 	if c.DB != nil {
@@ -60,58 +92,86 @@ func New(db *bolt.DB, products []string) *Client {
 
 func (c *Client) AddProduct(name string) {
 	c.Products = append(c.Products, name)
-	c.BatchWrite[name] = &util.BookBatchWrite{Count: 0, Batch: []*util.BatchChunk{}}
 	book := orderbook.New(name)
 	info := book_info.FetchProductInfo(name)
 	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
 	book.SetProductInfo(info)
 	diff_channel, trades_channel := c.GetChannelNames(book)
 	c.Books[diff_channel] = book
 	c.Books[trades_channel] = book
+	c.AddBook(book.ID, book)
+	if book.ID == "BTC-USD" {
+		c.legacy["diff_order_book"] = book
+		c.legacy["live_trades"] = book
+	}
 }
 
 func (c *Client) Connect() error {
-	url := "wss://ws.pusherapp.com/app/de504dc5763aeef9ff52?protocol=7&client=js&version=2.1.6&flash=false"
+	url := "wss://ws.bitstamp.net"
 	fmt.Println("connect to websocket", url)
-	s, _, err := websocket.DefaultDialer.Dial(url, nil)
+	s, _, err := common.Dial("Bitstamp", url)
 
 	if err != nil {
 		return err
 	}
 
 	c.Socket = s
-
-	for channel, _ := range c.Books {
-		c.Subscribe(channel)
+	c.stopper.Connected(s)
+	c.Writer = common.NewWriter("Bitstamp", s, 64)
+	c.ConnectedAt = time.Now()
+
+	for channel, book := range c.Books {
+		// diffs missed while disconnected need a new snapshot, reset on
+		// the shard before the messages of the new connection
+		book := book
+		c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+			book.Synced = false
+		})
+		if err := c.Subscribe(channel); err != nil {
+			fmt.Println("subscribe", channel, err)
+		}
 	}
 
 	return nil
 }
 
-func (c *Client) Subscribe(channel string) {
-	a := map[string]interface{}{"event": "pusher:subscribe", "data": map[string]interface{}{"channel": channel}}
-	c.Socket.WriteJSON(a)
+// Subscribe asks for a channel, safe to call from any goroutine while
+// connected.
+func (c *Client) Subscribe(channel string) error {
+	a := map[string]interface{}{"event": "bts:subscribe", "data": map[string]interface{}{"channel": channel}}
+	return c.Writer.WriteJSON(a)
 }
 
+// GetChannelNames returns the diff and trades channels of a book. The old
+// pusher feed named the ones of BTC-USD without suffix, see legacy.
 func (c *Client) GetChannelNames(book *orderbook.Book) (string, string) {
-	if book.ID == "BTC-USD" {
-		return "diff_order_book", "live_trades"
-	} else {
-		id := strings.ToLower(strings.Replace(book.ProductInfo.ID, "-", "", -1))
-		return fmt.Sprintf("diff_order_book_%s", id), fmt.Sprintf("live_trades_%s", id)
-	}
+	id := strings.ToLower(strings.Replace(book.ProductInfo.ID, "-", "", -1))
+	return fmt.Sprintf("diff_order_book_%s", id), fmt.Sprintf("live_trades_%s", id)
 }
 
 type Packet struct {
-	Event   string `json:"event"`
-	Channel string `json:"channel"`
-	Data    string `json:"data"`
+	Event   string          `json:"event"`
+	Channel string          `json:"channel"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// payload returns the data of the packet, captures of the old pusher feed
+// carry it as a JSON encoded string.
+func (pkt Packet) payload() []byte {
+	var s string
+	if err := json.Unmarshal(pkt.Data, &s); err == nil {
+		return []byte(s)
+	}
+	return pkt.Data
 }
 
+// UpdateSync orders the diffs by their microtimestamp, the ones up to the
+// snapshot are already in the book.
 func (c *Client) UpdateSync(book *orderbook.Book, last uint64) error {
 	seq := book.Sequence
 
-	if last < seq {
+	if last <= seq {
 		return fmt.Errorf("Ignore old messages %d %d", last, seq)
 	}
 
@@ -119,7 +179,7 @@ func (c *Client) UpdateSync(book *orderbook.Book, last uint64) error {
 	return nil
 }
 
-func (c *Client) HandleMessage(book *orderbook.Book, pkt Packet) {
+func (c *Client) HandleMessage(book *orderbook.Book, pkt Packet) error {
 	eventTime := time.Now()
 	var trade *orderbook.Trade
 
@@ -128,55 +188,80 @@ func (c *Client) HandleMessage(book *orderbook.Book, pkt Packet) {
 		//fmt.Println("diff", book.ID, string(pkt.Data))
 
 		var data map[string]interface{}
-		if err := json.Unmarshal([]byte(pkt.Data), &data); err != nil {
-			log.Println(err)
-			return
+		if err := json.Unmarshal(pkt.payload(), &data); err != nil {
+			return common.Protocol("diff-parse: %s", err)
+		}
+		timestamp, ok := data["microtimestamp"].(string)
+		if !ok {
+			return common.Protocol("diff without microtimestamp: %s", string(pkt.payload()))
 		}
-		seq, _ := strconv.ParseInt(data["timestamp"].(string), 10, 64)
+		seq, _ := strconv.ParseInt(timestamp, 10, 64)
 
 		if err := c.UpdateSync(book, uint64(seq)); err != nil {
-			fmt.Println(err)
-			return
+			return err
 		}
 
-		for _, d := range data["bids"].([]interface{}) {
-			data := d.([]interface{})
-			price, _ := strconv.ParseFloat(data[0].(string), 64)
-			size, _ := strconv.ParseFloat(data[1].(string), 64)
+		bids, _ := common.List("Bitstamp", "diff_order_book.bids", data["bids"])
+		for _, d := range bids {
+			price, size, ok := common.QuotedLevel("Bitstamp", "diff_order_book.bids", d)
+			if !ok {
+				continue
+			}
 			book.UpdateBidLevel(eventTime, price, size)
 		}
 
-		for _, d := range data["asks"].([]interface{}) {
-			data := d.([]interface{})
-			price, _ := strconv.ParseFloat(data[0].(string), 64)
-			size, _ := strconv.ParseFloat(data[1].(string), 64)
+		asks, _ := common.List("Bitstamp", "diff_order_book.asks", data["asks"])
+		for _, d := range asks {
+			price, size, ok := common.QuotedLevel("Bitstamp", "diff_order_book.asks", d)
+			if !ok {
+				continue
+			}
 			book.UpdateAskLevel(eventTime, price, size)
 		}
 
 	case "trade":
 		var data map[string]interface{}
-		if err := json.Unmarshal([]byte(pkt.Data), &data); err != nil {
-			log.Println(err)
-			return
+		if err := json.Unmarshal(pkt.payload(), &data); err != nil {
+			return common.Protocol("trade-parse: %s", err)
 		}
 
-		price, _ := strconv.ParseFloat(data["price_str"].(string), 64)
-		size, _ := strconv.ParseFloat(data["amount_str"].(string), 64)
-		side := book.GetSide(price)
+		price, ok := common.QuotedNumber("Bitstamp", "trade.price_str", data["price_str"])
+		if !ok {
+			return nil
+		}
+		size, ok := common.QuotedNumber("Bitstamp", "trade.amount_str", data["amount_str"])
+		if !ok {
+			return nil
+		}
+		side, source := book.AggressorSide(price)
+		// 0 buy, 1 sell, the side of the taker
+		if kind, ok := data["type"].(float64); ok {
+			side, source = uint8(orderbook.AskSide), db_orderbook.SideFromVenue
+			if kind == 1 {
+				side = uint8(orderbook.BidSide)
+			}
+		}
 
-		book.AddTrade(eventTime, side, price, size)
+		book.AddClassifiedTrade(eventTime, side, source, price, size)
 		trade = book.Trades[len(book.Trades)-1]
 
 	default:
-		fmt.Println("unkown event", book.ID, pkt.Event, string(pkt.Data))
-		return
+		return common.Protocol("unkown event %s %s %s", book.ID, pkt.Event, string(pkt.payload()))
 	}
 
 	if c.dbEnabled {
 		batch := c.BatchWrite[book.ID]
 		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
 		if trade != nil {
-			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackTrade(trade))
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
 		}
 
 		if batch.NextSync(now) {
@@ -189,44 +274,83 @@ func (c *Client) HandleMessage(book *orderbook.Book, pkt Packet) {
 			}
 		}
 	}
+	return nil
 }
 
-func (c *Client) WriteDiff(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
 	book.FixBookLevels() // TODO fix/remove
 	diff := book.Diff
 	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
 		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
-		batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, pkt)
+		batch.Write(now, pkt)
 		book.ResetDiff()
 		batch.LastDiffSeq = book.Sequence + 1
 	}
 }
 
-func (c *Client) WriteSync(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	book.FixBookLevels() // TODO fix/remove
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+func (c *Client) WriteDegradedSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
 	book.FixBookLevels() // TODO fix/remove
-	batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackDegradedSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
 	book.ResetDiff()
 	batch.LastDiffSeq = book.Sequence + 1
 }
 
+// wait for queued messages before the books get resynced or polled
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
 func (c *Client) Run() {
-	for {
+	for !c.stopper.Stopped() {
 		c.run()
 	}
 }
 
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
 func (c *Client) run() {
 	if err := c.Connect(); err != nil {
+		c.FailedConnects += 1
+		if common.Schedule.Wait("Bitstamp") {
+			return
+		}
 		fmt.Println("failed to connect", err)
-		time.Sleep(1000 * time.Millisecond)
+		if c.FailedConnects >= 3 && c.PollInterval > 0 {
+			// websocket is down, keep recording coarse snapshots until it is back
+			c.PollBooks()
+			time.Sleep(c.PollInterval)
+		} else {
+			time.Sleep(1000 * time.Millisecond)
+		}
 		return
 	}
 	defer c.Socket.Close()
+	defer c.Writer.Close()
+	defer c.flushShards()
 
 	for {
 		msgType, message, err := c.Socket.ReadMessage()
 		if err != nil {
 			log.Println("read:", err)
+			if time.Since(c.ConnectedAt).Seconds() < 30.0 {
+				c.FailedConnects += 1
+			} else {
+				c.FailedConnects = 0
+			}
 			return
 		}
 
@@ -234,41 +358,55 @@ func (c *Client) run() {
 			continue
 		}
 
-		var pkt Packet
-		if err := json.Unmarshal(message, &pkt); err != nil {
-			log.Println("header-parse:", err)
-			continue
-		}
-
-		switch pkt.Event {
-		// pusher stuff
-		case "pusher:connection_established":
-			log.Println("Connected")
-			continue
-		case "pusher_internal:subscription_succeeded":
-			log.Println("Subscribed")
-			continue
-		case "pusher:pong":
-			// ignore
-			continue
-		case "pusher:ping":
-			c.Socket.WriteJSON(map[string]interface{}{"event": "pusher:pong"})
-			continue
+		common.Capture("Bitstamp", message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
 		}
+	}
+}
 
-		var ok bool
-		var book *orderbook.Book
+func (c *Client) HandleRaw(message []byte) error {
+	var pkt Packet
+	if err := json.Unmarshal(message, &pkt); err != nil {
+		return fmt.Errorf("header-parse: %s", err)
+	}
 
-		if book, ok = c.Books[pkt.Channel]; !ok {
-			log.Println("book not found", pkt.Channel)
-			continue
-		}
+	switch pkt.Event {
+	case "bts:subscription_succeeded":
+		log.Println("Subscribed", pkt.Channel)
+		return nil
+	case "bts:unsubscription_succeeded", "bts:heartbeat":
+		return nil
+	case "bts:request_reconnect":
+		// bitstamp is about to close the connection, e.g. for maintenance
+		log.Println("Bitstamp asked to reconnect")
+		return common.ErrReconnect
+	case "bts:error":
+		return common.Protocol("Bitstamp error %s", string(pkt.payload()))
+	}
 
-		if book.Sequence == 0 {
-			c.SyncBook(book)
-			continue
-		}
+	var ok bool
+	var book *orderbook.Book
 
-		c.HandleMessage(book, pkt)
+	if book, ok = c.Books[pkt.Channel]; !ok {
+		book, ok = c.legacy[pkt.Channel]
 	}
+	if !ok {
+		return fmt.Errorf("book not found %s", pkt.Channel)
+	}
+
+	c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+		if !book.Synced && !c.Replay {
+			if err := c.SyncBook(book); err != nil {
+				fmt.Println("sync", book.ID, err)
+			}
+			return
+		}
+		if err := c.HandleMessage(book, pkt); err != nil {
+			fmt.Println(err)
+		}
+	})
+	return nil
 }
@@ -4,66 +4,151 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/lian/gdax-bookmap/exchanges/common"
 	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
 )
 
-func (c *Client) SyncBook(book *orderbook.Book) error {
-	fmt.Println("sync", book.ID)
-
+func (c *Client) FetchSnapshot(book *orderbook.Book) (uint64, []*orderbook.BookLevel, []*orderbook.BookLevel, error) {
 	id := strings.ToLower(strings.Replace(book.ProductInfo.ID, "-", "", -1))
 	url := fmt.Sprintf("https://www.bitstamp.net/api/v2/order_book/%s", id)
-	res, err := http.Get(url)
+	res, err := common.Get("Bitstamp", url)
 	if err != nil {
-		return err
+		return 0, nil, nil, err
 	}
+	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return err
+		return 0, nil, nil, err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return 0, nil, nil, err
 	}
 
 	var data map[string]interface{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return err
+		return 0, nil, nil, common.Protocol("snapshot: %s", err)
 	}
 
-	if _, ok := data["timestamp"]; ok {
-		book.Clear()
-		seq, _ := strconv.ParseInt(data["timestamp"].(string), 10, 64)
-		book.Sequence = uint64(seq)
+	// the diffs are sequenced by their microtimestamp as well
+	timestamp, ok := data["microtimestamp"].(string)
+	if !ok {
+		return 0, nil, nil, common.Protocol("snapshot without microtimestamp: %s", string(body))
+	}
+	seq, _ := strconv.ParseInt(timestamp, 10, 64)
 
-		t := time.Now()
+	bids := []*orderbook.BookLevel{}
+	asks := []*orderbook.BookLevel{}
 
-		if bids, ok := data["bids"].([]interface{}); ok {
-			for i := len(bids) - 1; i >= 0; i-- {
-				data := bids[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				size, _ := strconv.ParseFloat(data[1].(string), 64)
-				book.UpdateBidLevel(t, price, size)
+	if list, ok := data["bids"].([]interface{}); ok {
+		for i := len(list) - 1; i >= 0; i-- {
+			price, size, ok := common.QuotedLevel("Bitstamp", "order_book.bids", list[i])
+			if !ok {
+				continue
 			}
+			bids = append(bids, &orderbook.BookLevel{Price: price, Size: size})
 		}
+	}
 
-		if asks, ok := data["asks"].([]interface{}); ok {
-			for i := len(asks) - 1; i >= 0; i-- {
-				data := asks[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				size, _ := strconv.ParseFloat(data[1].(string), 64)
-				book.UpdateAskLevel(t, price, size)
+	if list, ok := data["asks"].([]interface{}); ok {
+		for i := len(list) - 1; i >= 0; i-- {
+			price, size, ok := common.QuotedLevel("Bitstamp", "order_book.asks", list[i])
+			if !ok {
+				continue
 			}
+			asks = append(asks, &orderbook.BookLevel{Price: price, Size: size})
+		}
+	}
+
+	return uint64(seq), bids, asks, nil
+}
+
+func (c *Client) SyncBook(book *orderbook.Book) error {
+	fmt.Println("sync", book.ID)
+
+	seq, bids, asks, err := c.FetchSnapshot(book)
+	if err != nil {
+		return err
+	}
+	t := time.Now()
+	snapshot := &db_orderbook.SyncProvenance{Sequence: seq, Fetched: t}
+
+	if book.Empty() {
+		book.Clear()
+		book.Sequence = seq
+		book.Snapshot = snapshot
+		book.Synced = true
+		for _, level := range bids {
+			book.UpdateBidLevel(t, level.Price, level.Size)
+		}
+		for _, level := range asks {
+			book.UpdateAskLevel(t, level.Price, level.Size)
 		}
 
 		if c.dbEnabled {
 			batch := c.BatchWrite[book.ID]
-			now := time.Now()
 			fmt.Println("STORE INIT SYNC", book.ID, book.Sequence, batch.Count)
-			c.WriteSync(batch, book, now)
+			c.WriteSync(batch, book, t)
+		}
+	} else {
+		if seq < book.Sequence {
+			return common.SnapshotStale("%s snapshot %d behind update %d", book.ID, seq, book.Sequence)
+		}
+
+		// resync, only record what changed since the book went out of sync
+		book.ApplySnapshot(t, bids, asks)
+		book.Sequence = seq
+		book.Snapshot = snapshot
+		book.Synced = true
+
+		if c.dbEnabled {
+			batch := c.BatchWrite[book.ID]
+			batch.ResetWarmUp()
+			fmt.Println("STORE RESYNC DIFF", book.ID, book.Sequence, len(book.Diff.Bid)+len(book.Diff.Ask))
+			c.WriteDiff(batch, book, t)
 		}
 	}
 
 	return nil
 }
+
+// PollBooks records a snapshot of every book as degraded sync packet, used
+// while the websocket depth feed is unavailable.
+func (c *Client) PollBooks() {
+	polled := map[*orderbook.Book]bool{}
+	for _, book := range c.Books {
+		if polled[book] {
+			continue
+		}
+		polled[book] = true
+
+		seq, bids, asks, err := c.FetchSnapshot(book)
+		if err != nil {
+			fmt.Println("poll", book.ID, err)
+			continue
+		}
+		t := time.Now()
+
+		book.Clear()
+		book.Sequence = seq
+		book.Snapshot = &db_orderbook.SyncProvenance{Sequence: seq, Fetched: t}
+		for _, level := range bids {
+			book.UpdateBidLevel(t, level.Price, level.Size)
+		}
+		for _, level := range asks {
+			book.UpdateAskLevel(t, level.Price, level.Size)
+		}
+
+		if c.dbEnabled {
+			batch := c.BatchWrite[book.ID]
+			batch.ResetWarmUp()
+			fmt.Println("STORE DEGRADED SYNC", book.ID, book.Sequence)
+			c.WriteDegradedSync(batch, book, t)
+		}
+	}
+}
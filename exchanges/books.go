@@ -0,0 +1,35 @@
+package exchanges
+
+import "sync"
+
+// BookIndex finds the books of a client by product name for GetBook. The
+// clients embed it and keep their own maps by channel for the messages,
+// products added or removed while running update both.
+type BookIndex struct {
+	mu    sync.Mutex
+	books map[string]Book
+}
+
+func (i *BookIndex) AddBook(name string, book Book) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.books == nil {
+		i.books = map[string]Book{}
+	}
+	i.books[name] = book
+}
+
+func (i *BookIndex) RemoveBook(name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.books, name)
+}
+
+func (i *BookIndex) GetBook(name string) Book {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if book, ok := i.books[name]; ok {
+		return book
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+// Package builtin registers the exchange clients of this repository with
+// exchanges.Register, import it for its side effects.
+package builtin
+
+import (
+	_ "github.com/lian/gdax-bookmap/exchanges/binance/futures/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/binance/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitfinex/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitmex/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bitstamp/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/bybit/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/deribit/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/gdax/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/gemini/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/huobi/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/kraken/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/okx/websocket"
+	_ "github.com/lian/gdax-bookmap/exchanges/synthetic"
+)
@@ -0,0 +1,170 @@
+package product_info
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Market is one category of the bybit v5 api, each with its own public
+// websocket.
+type Market struct {
+	Platform string
+	Category string
+	Stream   string
+	// levels of the orderbook topic
+	Depth int
+	// sizes are contracts worth one USD
+	Inverse bool
+
+	CachedInfo    map[string]product_info.Info
+	CachedSymbols map[string]string
+}
+
+var Spot = &Market{
+	Platform: "BybitSpot",
+	Category: "spot",
+	Stream:   "wss://stream.bybit.com/v5/public/spot",
+	Depth:    50,
+}
+
+// Linear are the USDT and USDC margined contracts, sized in the base
+// currency.
+var Linear = &Market{
+	Platform: "BybitLinear",
+	Category: "linear",
+	Stream:   "wss://stream.bybit.com/v5/public/linear",
+	Depth:    500,
+}
+
+// Inverse are the coin margined contracts.
+var Inverse = &Market{
+	Platform: "BybitInverse",
+	Category: "inverse",
+	Stream:   "wss://stream.bybit.com/v5/public/inverse",
+	Depth:    500,
+	Inverse:  true,
+}
+
+var Markets = []*Market{Spot, Linear, Inverse}
+
+// BaseSize returns the size of a level or trade at price in the base
+// currency.
+func (m *Market) BaseSize(size, price float64) float64 {
+	if m.Inverse && price > 0 {
+		return size / price
+	}
+	return size
+}
+
+func init() {
+	FetchAllProductInfo()
+}
+
+func FetchAllProductInfo() {
+	for _, m := range Markets {
+		if err := m.FetchAllProductInfo(); err != nil {
+			fmt.Println("InitProduct error", m.Platform, err)
+		}
+	}
+}
+
+// FetchAllProductInfo reads the instruments of the category. Spot pairs
+// and perpetuals are named by their currencies, e.g. BTC-USDT, dated
+// futures by their symbol.
+func (m *Market) FetchAllProductInfo() error {
+	m.CachedInfo = map[string]product_info.Info{}
+	m.CachedSymbols = map[string]string{}
+
+	res, err := common.Get(m.Platform, "https://api.bybit.com/v5/market/instruments-info?limit=1000&category="+m.Category)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return err
+	}
+
+	var data struct {
+		RetCode int    `json:"retCode"`
+		RetMsg  string `json:"retMsg"`
+		Result  struct {
+			List []struct {
+				Symbol       string `json:"symbol"`
+				BaseCoin     string `json:"baseCoin"`
+				QuoteCoin    string `json:"quoteCoin"`
+				Status       string `json:"status"`
+				ContractType string `json:"contractType"`
+				PriceFilter  struct {
+					TickSize string `json:"tickSize"`
+				} `json:"priceFilter"`
+				LotSizeFilter struct {
+					MinOrderQty string `json:"minOrderQty"`
+				} `json:"lotSizeFilter"`
+			} `json:"list"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return common.Protocol("instruments-info: %s", err)
+	}
+	if data.RetCode != 0 {
+		return common.Protocol("instruments-info: %d %s", data.RetCode, data.RetMsg)
+	}
+
+	for _, i := range data.Result.List {
+		if i.Status != "Trading" {
+			continue
+		}
+		name := i.Symbol
+		if i.ContractType == "" || i.ContractType == "LinearPerpetual" || i.ContractType == "InversePerpetual" {
+			name = fmt.Sprintf("%s-%s", i.BaseCoin, i.QuoteCoin)
+		}
+		if _, ok := m.CachedInfo[name]; ok {
+			continue
+		}
+
+		tick, _ := strconv.ParseFloat(i.PriceFilter.TickSize, 64)
+		info := product_info.Info{
+			ID:             i.Symbol,
+			DisplayName:    name,
+			BaseCurrency:   i.BaseCoin,
+			QuoteCurrency:  i.QuoteCoin,
+			Platform:       m.Platform,
+			DatabaseKey:    fmt.Sprintf("%s-%s", m.Platform, name),
+			QuoteIncrement: product_info.FloatString(tick),
+			FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(tick)),
+		}
+		if !m.Inverse {
+			// the base size of inverse contracts depends on the price
+			min, _ := strconv.ParseFloat(i.LotSizeFilter.MinOrderQty, 64)
+			info.BaseMinSize = product_info.FloatString(min)
+		}
+
+		m.CachedInfo[name] = info
+		m.CachedSymbols[name] = i.Symbol
+	}
+	return nil
+}
+
+func (m *Market) FetchProductInfo(id string) product_info.Info {
+	if info, ok := m.CachedInfo[id]; ok {
+		return info
+	}
+	return product_info.Info{}
+}
+
+// FetchSymbol returns the symbol of a product, e.g. BTCUSDT for BTC-USDT.
+func (m *Market) FetchSymbol(id string) (string, bool) {
+	symbol, ok := m.CachedSymbols[id]
+	return symbol, ok
+}
@@ -0,0 +1,419 @@
+package websocket
+
+// api: https://bybit-exchange.github.io/docs/v5/ws/connect
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
+	book_info "github.com/lian/gdax-bookmap/exchanges/bybit/product_info"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// bybit drops connections without a ping for a while
+const pingInterval = 20 * time.Second
+
+func init() {
+	for _, m := range book_info.Markets {
+		common.RegisterCapabilities(&common.Capabilities{
+			Platform:         m.Platform,
+			MaxDepth:         m.Depth,
+			DynamicSubscribe: true,
+		})
+		market := m
+		exchanges.Register(m.Platform, func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+			c := New(s.DB, market, s.ProductsOr(defaultProducts(market)...))
+			c.BookmarkTradeSize = s.BookmarkTradeSize
+			c.Shards = s.Shards
+			return c, c.Infos
+		})
+	}
+}
+
+// defaultProducts are recorded when the settings name none.
+func defaultProducts(m *book_info.Market) []string {
+	if m.Inverse {
+		return []string{"BTC-USD", "ETH-USD"}
+	}
+	return []string{"BTC-USDT", "ETH-USDT"}
+}
+
+type Client struct {
+	exchanges.BookIndex
+	Platform string
+	Market   *book_info.Market
+	Socket   *websocket.Conn
+	// every write to Socket goes through it
+	Writer   *common.Writer
+	Products []string
+	// by topic, e.g. orderbook.50.BTCUSDT and publicTrade.BTCUSDT
+	Books             map[string]*orderbook.Book
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	Shards            *util.Shards
+	stopper           common.Stopper
+}
+
+// NewSpot records spot pairs, e.g. BTC-USDT.
+func NewSpot(db *bolt.DB, products []string) *Client {
+	return New(db, book_info.Spot, products)
+}
+
+// NewLinear records USDT and USDC contracts, e.g. BTC-USDT.
+func NewLinear(db *bolt.DB, products []string) *Client {
+	return New(db, book_info.Linear, products)
+}
+
+// NewInverse records coin margined contracts, e.g. BTC-USD.
+func NewInverse(db *bolt.DB, products []string) *Client {
+	return New(db, book_info.Inverse, products)
+}
+
+func New(db *bolt.DB, market *book_info.Market, products []string) *Client {
+	c := &Client{
+		Platform:   market.Platform,
+		Market:     market,
+		Products:   []string{},
+		Books:      map[string]*orderbook.Book{},
+		BatchWrite: map[string]*storage.BookWriter{},
+		DB:         db,
+		Infos:      []*product_info.Info{},
+	}
+	if c.DB != nil {
+		c.dbEnabled = true
+	}
+
+	for _, name := range products {
+		c.AddProduct(name)
+	}
+
+	if c.dbEnabled {
+		buckets := []string{}
+		for _, info := range c.Infos {
+			buckets = append(buckets, info.DatabaseKey)
+		}
+		util.CreateBucketsDB(c.DB, buckets)
+	}
+
+	return c
+}
+
+func (c *Client) AddProduct(name string) {
+	symbol, ok := c.Market.FetchSymbol(name)
+	if !ok {
+		fmt.Println(c.Platform, "unknown product", name)
+		return
+	}
+	c.Products = append(c.Products, name)
+	book := orderbook.New(name)
+	info := c.Market.FetchProductInfo(name)
+	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
+	book.SetProductInfo(info)
+	for _, topic := range c.topics(symbol) {
+		c.Books[topic] = book
+	}
+	c.AddBook(book.ID, book)
+}
+
+func (c *Client) topics(symbol string) []string {
+	return []string{fmt.Sprintf("orderbook.%d.%s", c.Market.Depth, symbol), "publicTrade." + symbol}
+}
+
+func (c *Client) Connect() error {
+	url := c.Market.Stream
+	fmt.Println("connect to websocket", url)
+	s, _, err := common.Dial(c.Platform, url)
+	if err != nil {
+		return err
+	}
+
+	c.Socket = s
+	c.stopper.Connected(s)
+	c.Writer = common.NewWriter(c.Platform, s, 64)
+	c.ConnectedAt = time.Now()
+
+	// spot takes at most 10 topics per subscribe
+	for topic, book := range c.Books {
+		// the snapshot of the new subscription syncs it again
+		book.Synced = false
+		if err := c.Writer.WriteJSON(map[string]interface{}{"op": "subscribe", "args": []string{topic}}); err != nil {
+			s.Close()
+			c.Writer.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	diff := book.Diff
+	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
+		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		batch.Write(now, pkt)
+		book.ResetDiff()
+		batch.LastDiffSeq = book.Sequence + 1
+	}
+}
+
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+// wait for queued messages before the books get resubscribed
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
+func (c *Client) Run() {
+	for !c.stopper.Stopped() {
+		c.run()
+	}
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
+func (c *Client) ping(done chan bool) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.Writer.Send(map[string]string{"op": "ping"}); err != nil {
+				fmt.Println(c.Platform, "ping", err)
+			}
+		}
+	}
+}
+
+func (c *Client) run() {
+	if err := c.Connect(); err != nil {
+		if common.Schedule.Wait(c.Platform) {
+			return
+		}
+		fmt.Println("failed to connect", err)
+		time.Sleep(1000 * time.Millisecond)
+		return
+	}
+
+	defer c.Socket.Close()
+	defer c.Writer.Close()
+	defer c.flushShards()
+
+	done := make(chan bool)
+	defer close(done)
+	go c.ping(done)
+
+	for {
+		msgType, message, err := c.Socket.ReadMessage()
+		if err != nil {
+			log.Println("read:", err)
+			return
+		}
+
+		if msgType != websocket.TextMessage {
+			continue
+		}
+
+		common.Capture(c.Platform, message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+type pushMessage struct {
+	Op      string          `json:"op"`
+	Success *bool           `json:"success"`
+	RetMsg  string          `json:"ret_msg"`
+	Topic   string          `json:"topic"`
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type bookData struct {
+	Bids [][]interface{} `json:"b"`
+	Asks [][]interface{} `json:"a"`
+	// update id, 1 after a restart of the service
+	UpdateID uint64 `json:"u"`
+}
+
+type tradeData struct {
+	Side  string      `json:"S"`
+	Size  interface{} `json:"v"`
+	Price interface{} `json:"p"`
+}
+
+func (c *Client) HandleRaw(message []byte) error {
+	var msg pushMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("PacketHeader-parse: %s", err)
+	}
+
+	switch {
+	case msg.Op == "ping" || msg.Op == "pong":
+		return nil
+	case msg.Op != "":
+		if msg.Success != nil && !*msg.Success {
+			return common.Protocol("%s %s failed: %s", c.Platform, msg.Op, msg.RetMsg)
+		}
+		return nil
+	case msg.Topic == "":
+		return nil
+	}
+
+	book, ok := c.Books[msg.Topic]
+	if !ok {
+		return fmt.Errorf("%s message of unknown topic %q", c.Platform, msg.Topic)
+	}
+	c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+		if err := c.HandleMessage(book, &msg); err != nil {
+			fmt.Println(err)
+		}
+	})
+	return nil
+}
+
+func (c *Client) HandleMessage(book *orderbook.Book, msg *pushMessage) error {
+	now := time.Now()
+
+	trades := []*orderbook.Trade{}
+
+	switch {
+	case strings.HasPrefix(msg.Topic, "orderbook."):
+		var data bookData
+		if err := json.Unmarshal(msg.Data, &data); err != nil {
+			return common.Protocol("%s orderbook %s", c.Platform, err)
+		}
+		bids := c.levels("orderbook.b", data.Bids)
+		asks := c.levels("orderbook.a", data.Asks)
+
+		if msg.Type == "snapshot" || data.UpdateID == 1 {
+			if book.Empty() {
+				book.Clear()
+				book.Sequence = uint64(0)
+			} else if c.dbEnabled {
+				// resubscribed or the service restarted
+				c.BatchWrite[book.ID].ResetWarmUp()
+			}
+			// on resubscribe only the levels which changed end up in the diff
+			book.ApplySnapshot(now, bids, asks)
+			book.Synced = true
+			break
+		}
+		if !book.Synced {
+			// deltas before the snapshot
+			return nil
+		}
+		for _, level := range bids {
+			book.UpdateBidLevel(now, level.Price, level.Size)
+		}
+		for _, level := range asks {
+			book.UpdateAskLevel(now, level.Price, level.Size)
+		}
+
+	case strings.HasPrefix(msg.Topic, "publicTrade."):
+		var data []tradeData
+		if err := json.Unmarshal(msg.Data, &data); err != nil {
+			return common.Protocol("%s publicTrade %s", c.Platform, err)
+		}
+		for _, t := range data {
+			price, ok := common.QuotedNumber(c.Platform, "publicTrade.p", t.Price)
+			if !ok {
+				continue
+			}
+			size, ok := common.QuotedNumber(c.Platform, "publicTrade.v", t.Size)
+			if !ok {
+				continue
+			}
+			size = c.Market.BaseSize(size, price)
+			if t.Side == "Sell" {
+				book.AddClassifiedTrade(now, uint8(orderbook.BidSide), db_orderbook.SideFromVenue, price, size)
+			} else {
+				book.AddClassifiedTrade(now, uint8(orderbook.AskSide), db_orderbook.SideFromVenue, price, size)
+			}
+			trades = append(trades, book.Trades[len(book.Trades)-1])
+		}
+
+	default:
+		return common.Protocol("unkown topic %s", msg.Topic)
+	}
+
+	book.Sequence += 1
+
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
+		for _, trade := range trades {
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
+		}
+
+		if batch.NextSync(now) {
+			fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
+			c.WriteSync(batch, book, now)
+		} else {
+			if batch.NextDiff(now) {
+				c.WriteDiff(batch, book, now)
+			}
+		}
+	}
+	return nil
+}
+
+// levels reads [price, size] levels in the base currency, size 0 removes
+// the level.
+func (c *Client) levels(field string, list [][]interface{}) []*orderbook.BookLevel {
+	levels := []*orderbook.BookLevel{}
+	for _, values := range list {
+		if len(values) < 2 {
+			continue
+		}
+		price, ok := common.QuotedNumber(c.Platform, field+".price", values[0])
+		if !ok {
+			continue
+		}
+		size, ok := common.QuotedNumber(c.Platform, field+".size", values[1])
+		if !ok {
+			continue
+		}
+		levels = append(levels, &orderbook.BookLevel{Price: price, Size: c.Market.BaseSize(size, price)})
+	}
+	return levels
+}
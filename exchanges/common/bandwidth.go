@@ -0,0 +1,144 @@
+package common
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Compression asks for permessage-deflate on platforms supporting it.
+var Compression = true
+
+// Meter counts the bytes the websocket connections of the platforms moved
+// on the wire, including TLS, so a metered link can be budgeted.
+var Meter = NewBandwidthMeter()
+
+type BandwidthMeter struct {
+	mu        sync.Mutex
+	platforms map[string]*bandwidth
+}
+
+type bandwidth struct {
+	in          uint64
+	out         uint64
+	connects    int
+	compressed  bool
+	connectedAt time.Time
+	// counters when the current connection was made
+	connectedIn  uint64
+	connectedOut uint64
+}
+
+type BandwidthStats struct {
+	Platform    string    `json:"platform"`
+	Connects    int       `json:"connects"`
+	Compressed  bool      `json:"compressed"`
+	BytesIn     uint64    `json:"bytes_in"`
+	BytesOut    uint64    `json:"bytes_out"`
+	ConnectedAt time.Time `json:"connected_at"`
+	// bytes per second of the current connection
+	RateIn  float64 `json:"rate_in"`
+	RateOut float64 `json:"rate_out"`
+}
+
+func NewBandwidthMeter() *BandwidthMeter {
+	return &BandwidthMeter{platforms: map[string]*bandwidth{}}
+}
+
+func (m *BandwidthMeter) get(platform string) *bandwidth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.platforms[platform]
+	if !ok {
+		b = &bandwidth{}
+		m.platforms[platform] = b
+	}
+	return b
+}
+
+func (m *BandwidthMeter) connected(platform string, compressed bool) {
+	b := m.get(platform)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b.connects += 1
+	b.compressed = compressed
+	b.connectedAt = time.Now()
+	b.connectedIn = atomic.LoadUint64(&b.in)
+	b.connectedOut = atomic.LoadUint64(&b.out)
+}
+
+// Stats returns the counters of every platform sorted by name.
+func (m *BandwidthMeter) Stats() []*BandwidthStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	stats := []*BandwidthStats{}
+	for platform, b := range m.platforms {
+		in, out := atomic.LoadUint64(&b.in), atomic.LoadUint64(&b.out)
+		s := &BandwidthStats{
+			Platform:    platform,
+			Connects:    b.connects,
+			Compressed:  b.compressed,
+			BytesIn:     in,
+			BytesOut:    out,
+			ConnectedAt: b.connectedAt,
+		}
+		if seconds := now.Sub(b.connectedAt).Seconds(); !b.connectedAt.IsZero() && seconds > 0 {
+			s.RateIn = float64(in-b.connectedIn) / seconds
+			s.RateOut = float64(out-b.connectedOut) / seconds
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Platform < stats[j].Platform })
+	return stats
+}
+
+// countingConn counts the bytes of a connection below TLS.
+type countingConn struct {
+	net.Conn
+	b *bandwidth
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(&c.b.in, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddUint64(&c.b.out, uint64(n))
+	return n, err
+}
+
+// Dial connects the websocket of a platform through the Meter and asks for
+// permessage-deflate when the platform supports it.
+func Dial(platform, url string) (*websocket.Conn, *http.Response, error) {
+	b := Meter.get(platform)
+	dialer := &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  websocket.DefaultDialer.HandshakeTimeout,
+		EnableCompression: Compression && CapabilitiesOf(platform).Compression,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &countingConn{Conn: conn, b: b}, nil
+		},
+	}
+
+	conn, res, err := dialer.Dial(WebsocketURL(platform, url), Header(platform))
+	if err != nil {
+		return nil, res, err
+	}
+	// the server decides, compression is only used when it answered with it
+	Meter.connected(platform, strings.Contains(res.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate"))
+	return conn, res, nil
+}
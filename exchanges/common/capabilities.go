@@ -0,0 +1,59 @@
+package common
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Capabilities describe what the feed of a venue offers, so the UI and the
+// flag checks can leave out what a venue lacks instead of failing at
+// runtime.
+type Capabilities struct {
+	Platform string
+	// individual orders (L3) instead of price levels
+	L3 bool
+	// the feed sends checksums to verify the book against
+	Checksums bool
+	// levels per side of the book, 0 for the full book
+	MaxDepth int
+	// products can be subscribed on a running connection
+	DynamicSubscribe bool
+	// authenticated streams of own orders and fills
+	UserStreams bool
+	// the server accepts permessage-deflate
+	Compression bool
+}
+
+var capabilities = map[string]*Capabilities{}
+var capabilitiesMu sync.Mutex
+
+// RegisterCapabilities is called by the clients in init.
+func RegisterCapabilities(c *Capabilities) {
+	capabilitiesMu.Lock()
+	defer capabilitiesMu.Unlock()
+	capabilities[strings.ToLower(c.Platform)] = c
+}
+
+// CapabilitiesOf returns the capabilities of a platform, unknown platforms
+// (e.g. imported products) have none.
+func CapabilitiesOf(platform string) *Capabilities {
+	capabilitiesMu.Lock()
+	defer capabilitiesMu.Unlock()
+	if c, ok := capabilities[strings.ToLower(platform)]; ok {
+		return c
+	}
+	return &Capabilities{Platform: platform}
+}
+
+// Platforms returns the registered platforms sorted by name.
+func Platforms() []*Capabilities {
+	capabilitiesMu.Lock()
+	defer capabilitiesMu.Unlock()
+	list := []*Capabilities{}
+	for _, c := range capabilities {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Platform < list[j].Platform })
+	return list
+}
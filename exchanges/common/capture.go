@@ -0,0 +1,77 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// CapturedMessage is one line of a capture file.
+type CapturedMessage struct {
+	Time     time.Time       `json:"time"`
+	Platform string          `json:"platform"`
+	Data     json.RawMessage `json:"data"`
+}
+
+var captureMu sync.Mutex
+var captureEnc *json.Encoder
+
+// StartCapture appends every text message the clients receive to a file,
+// one CapturedMessage per line, so feeds can be replayed later, e.g. by
+// bookmap-loadtest.
+func StartCapture(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	captureMu.Lock()
+	captureEnc = json.NewEncoder(file)
+	captureMu.Unlock()
+	return nil
+}
+
+// recent messages kept per platform, 0 keeps none
+var recentLimit int
+var recent = map[string][]*CapturedMessage{}
+
+// KeepRecent keeps the last n messages of every platform in memory, for
+// support bundles.
+func KeepRecent(n int) {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+	recentLimit = n
+}
+
+// RecentMessages returns the kept messages by platform, oldest first.
+func RecentMessages() map[string][]*CapturedMessage {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+	messages := map[string][]*CapturedMessage{}
+	for platform, list := range recent {
+		messages[platform] = append([]*CapturedMessage{}, list...)
+	}
+	return messages
+}
+
+// Capture is called by the clients with every received text message.
+func Capture(platform string, message []byte) {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+	if captureEnc == nil && recentLimit == 0 {
+		return
+	}
+	msg := &CapturedMessage{Time: time.Now(), Platform: platform, Data: message}
+	if recentLimit > 0 {
+		msg.Data = append(json.RawMessage{}, message...)
+		list := append(recent[platform], msg)
+		if len(list) > recentLimit {
+			list = list[len(list)-recentLimit:]
+		}
+		recent[platform] = list
+	}
+	if captureEnc != nil {
+		// invalid json is not replayable anyway
+		captureEnc.Encode(msg)
+	}
+}
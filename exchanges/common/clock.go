@@ -0,0 +1,84 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// delays kept per platform, about a minute of a busy feed
+const clockWindow = 2000
+
+// Clocks estimates from the event times of the messages how far the clock
+// of every platform is off from ours and how late its messages arrive.
+var Clocks = NewClockEstimator()
+
+// ClockEstimator takes the fastest messages, the low percentile of the
+// delays between event and receive time, for the skew. A constant network
+// delay can't be told apart from skew this way without round trips, so
+// Latency is how much later than those the messages arrive.
+type ClockEstimator struct {
+	mu        sync.Mutex
+	platforms map[string]*clock
+}
+
+type clock struct {
+	delays    *orderbook.Percentiles
+	lastEvent time.Time
+	received  time.Time
+}
+
+type ClockStats struct {
+	// local time minus venue time of the fastest messages
+	Skew time.Duration
+	// typical delay of a message beyond the skew
+	Latency time.Duration
+	// venue time of the last message and when it was received
+	LastEvent time.Time
+	Received  time.Time
+}
+
+func NewClockEstimator() *ClockEstimator {
+	return &ClockEstimator{platforms: map[string]*clock{}}
+}
+
+// Event is called with the venue time of every message carrying one.
+func (e *ClockEstimator) Event(platform string, event, received time.Time) {
+	if event.IsZero() {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c, ok := e.platforms[platform]
+	if !ok {
+		c = &clock{delays: orderbook.NewPercentiles(clockWindow)}
+		e.platforms[platform] = c
+	}
+	c.delays.Add(float64(received.Sub(event)) / float64(time.Millisecond))
+	c.lastEvent = event
+	c.received = received
+}
+
+// Stats returns the estimate of a platform, nil before its first message.
+func (e *ClockEstimator) Stats(platform string) *ClockStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c, ok := e.platforms[platform]
+	if !ok {
+		return nil
+	}
+	skew := c.delays.Percentile(1)
+	return &ClockStats{
+		Skew:      time.Duration(skew * float64(time.Millisecond)),
+		Latency:   time.Duration((c.delays.Percentile(50) - skew) * float64(time.Millisecond)),
+		LastEvent: c.lastEvent,
+		Received:  c.received,
+	}
+}
+
+// VenueTime converts a local receive time into the estimated event time at
+// the venue.
+func (s *ClockStats) VenueTime(local time.Time) time.Time {
+	return local.Add(-s.Skew - s.Latency)
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Endpoints replaces the scheme, host and a path prefix of the URLs a
+// platform client uses, for gateways, testnets or regional endpoints.
+// The paths and queries of the clients are kept. Empty fields keep the
+// defaults, Preset fills them from EndpointPresets. Sandbox is the
+// testnet preset and marks the platform as one to test trading against.
+type Endpoints struct {
+	Preset    string            `json:"preset"`
+	Sandbox   bool              `json:"sandbox"`
+	Websocket string            `json:"websocket"`
+	REST      string            `json:"rest"`
+	Headers   map[string]string `json:"headers"`
+}
+
+// EndpointPresets are known alternative environments by name and
+// platform.
+var EndpointPresets = map[string]map[string]Endpoints{
+	"testnet": {
+		"Binance": {Websocket: "wss://testnet.binance.vision", REST: "https://testnet.binance.vision"},
+		"GDAX":    {Websocket: "wss://ws-feed-public.sandbox.pro.coinbase.com", REST: "https://api-public.sandbox.pro.coinbase.com"},
+	},
+	"us": {
+		"Binance": {Websocket: "wss://stream.binance.us:9443", REST: "https://api.binance.us"},
+	},
+}
+
+var endpoints = map[string]*Endpoints{}
+var endpointsMu sync.Mutex
+
+// SetEndpoints overrides the endpoints of a platform.
+func SetEndpoints(platform string, e *Endpoints) error {
+	if e.Sandbox && e.Preset == "" {
+		e.Preset = "testnet"
+	}
+	if e.Preset != "" {
+		preset, ok := EndpointPresets[e.Preset][platform]
+		if !ok {
+			return fmt.Errorf("no %s preset for %s", e.Preset, platform)
+		}
+		if e.Websocket == "" {
+			e.Websocket = preset.Websocket
+		}
+		if e.REST == "" {
+			e.REST = preset.REST
+		}
+	}
+	for _, base := range []string{e.Websocket, e.REST} {
+		if base == "" {
+			continue
+		}
+		if u, err := url.Parse(base); err != nil || u.Host == "" {
+			return fmt.Errorf("%s endpoint %q is no absolute url", platform, base)
+		}
+	}
+	endpointsMu.Lock()
+	defer endpointsMu.Unlock()
+	endpoints[platform] = e
+	return nil
+}
+
+// LoadEndpoints reads the overrides of a json file keyed by platform, e.g.
+//
+//	{"Binance": {"preset": "testnet"}, "GDAX": {"rest": "https://gateway/gdax", "headers": {"X-Token": "..."}}}
+func LoadEndpoints(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	overrides := map[string]*Endpoints{}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	for platform, e := range overrides {
+		if err := SetEndpoints(platform, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func endpointsOf(platform string) *Endpoints {
+	endpointsMu.Lock()
+	defer endpointsMu.Unlock()
+	if e, ok := endpoints[platform]; ok {
+		return e
+	}
+	return &Endpoints{}
+}
+
+// Overridden tells whether a platform does not use its default endpoints.
+func Overridden(platform string) bool {
+	e := endpointsOf(platform)
+	return e.Websocket != "" || e.REST != ""
+}
+
+// Sandboxed tells whether a platform runs against its testnet, where
+// orders and balances are not real.
+func Sandboxed(platform string) bool {
+	e := endpointsOf(platform)
+	return e.Sandbox || e.Preset == "testnet"
+}
+
+func rebase(base, rawurl string) string {
+	if base == "" {
+		return rawurl
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	u.Scheme = b.Scheme
+	u.Host = b.Host
+	u.Path = strings.TrimSuffix(b.Path, "/") + u.Path
+	if u.RawPath != "" {
+		u.RawPath = strings.TrimSuffix(b.Path, "/") + u.RawPath
+	}
+	return u.String()
+}
+
+// WebsocketURL returns rawurl on the websocket endpoint of platform.
+func WebsocketURL(platform, rawurl string) string {
+	return rebase(endpointsOf(platform).Websocket, rawurl)
+}
+
+// RESTURL returns rawurl on the REST endpoint of platform.
+func RESTURL(platform, rawurl string) string {
+	return rebase(endpointsOf(platform).REST, rawurl)
+}
+
+// Header returns the extra headers of platform.
+func Header(platform string) http.Header {
+	header := http.Header{}
+	for name, value := range endpointsOf(platform).Headers {
+		header.Set(name, value)
+	}
+	return header
+}
+
+// NewRequest is http.NewRequest on the REST endpoint of platform, with its
+// extra headers.
+func NewRequest(platform, method, rawurl string) (*http.Request, error) {
+	req, err := http.NewRequest(method, RESTURL(platform, rawurl), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range Header(platform) {
+		req.Header[name] = values
+	}
+	return req, nil
+}
+
+// Get is http.Get on the REST endpoint of platform, with its extra headers.
+func Get(platform, rawurl string) (*http.Response, error) {
+	req, err := NewRequest(platform, "GET", rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// failure kinds returned by the exchange clients, check with errors.Is
+var (
+	// a message was lost, the book has to be resynced
+	ErrSequenceGap = errors.New("sequence gap")
+	// a REST snapshot is older than the updates already applied
+	ErrSnapshotStale = errors.New("snapshot stale")
+	// the exchange refused a request because of its rate limit
+	ErrRateLimited = errors.New("rate limited")
+	// a message or response did not look like documented
+	ErrProtocol = errors.New("protocol error")
+	// the venue asked to connect again, e.g. after maintenance
+	ErrReconnect = errors.New("reconnect")
+	// the venue does not know the product (anymore), e.g. a delisted or
+	// renamed symbol
+	ErrDelisted = errors.New("delisted")
+)
+
+func SequenceGap(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrSequenceGap, fmt.Sprintf(format, a...))
+}
+
+func SnapshotStale(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrSnapshotStale, fmt.Sprintf(format, a...))
+}
+
+func Protocol(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrProtocol, fmt.Sprintf(format, a...))
+}
+
+func Delisted(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrDelisted, fmt.Sprintf(format, a...))
+}
+
+// CheckResponse turns non 200 REST responses into errors, 429 and 418 (ip
+// banned after ignoring 429s) are reported as ErrRateLimited.
+func CheckResponse(res *http.Response, body []byte) error {
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusTeapot:
+		return fmt.Errorf("%w: %s %s", ErrRateLimited, res.Request.URL, res.Status)
+	default:
+		return Protocol("%s %s %s", res.Request.URL, res.Status, string(body))
+	}
+}
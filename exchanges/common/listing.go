@@ -0,0 +1,66 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// DelistStrikes is how often in a row a product has to fail like a
+// delisted one before its recording is ended.
+const DelistStrikes = 3
+
+// Strikes counts failures per product that point to a delisting, e.g.
+// subscribe errors or a symbol missing from the listing, so a single bad
+// response of the venue does not end a recording.
+type Strikes struct {
+	Limit  int
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func NewStrikes(limit int) *Strikes {
+	return &Strikes{Limit: limit, counts: map[string]int{}}
+}
+
+// Fail counts a failure and tells if the product reached the limit.
+func (s *Strikes) Fail(product string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[product] += 1
+	return s.counts[product] >= s.Limit
+}
+
+// Clear forgets the failures of a product once it works again.
+func (s *Strikes) Clear(product string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counts, product)
+}
+
+// EndRecording marks the recording of a delisted or renamed product as
+// ended and bookmarks it, which alerts its subscribers, e.g. over mqtt.
+// The client stops its feed of the product.
+func EndRecording(db *bolt.DB, info *product_info.Info, reason, successor string) {
+	now := time.Now()
+	fmt.Println(info.DatabaseKey, "recording ended:", reason, successor)
+	if db == nil {
+		return
+	}
+	end := &util.RecordingEnd{Product: info.DatabaseKey, Time: now, Reason: reason, Successor: successor}
+	if err := util.EndRecording(db, end); err != nil {
+		fmt.Println("recording end", info.DatabaseKey, err)
+	}
+	label := i18n.Sprintf("recording ended: %s", reason)
+	if successor != "" {
+		label += " " + i18n.Sprintf("(new symbol %s?)", successor)
+	}
+	if err := util.AddBookmark(db, info.DatabaseKey, now, label); err != nil {
+		fmt.Println("recording end", info.DatabaseKey, err)
+	}
+}
@@ -0,0 +1,145 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// while a maintenance without a known end is going on, connects are only
+// tried this often
+const maintenanceRetry = 30 * time.Second
+
+// scheduled windows are slept through in steps, so an earlier end can
+// still be picked up
+const maintenanceStep = time.Minute
+
+// Maintenance knows the scheduled and announced maintenance windows of the
+// venues, so the clients wait them out instead of reconnecting every second.
+// Windows are stored in DB for the graphs to show them.
+type Maintenance struct {
+	DB      *bolt.DB
+	windows []*util.MaintenanceWindow
+	logged  map[*util.MaintenanceWindow]bool
+	mu      sync.Mutex
+}
+
+var Schedule = NewMaintenance()
+
+func NewMaintenance() *Maintenance {
+	return &Maintenance{
+		windows: []*util.MaintenanceWindow{},
+		logged:  map[*util.MaintenanceWindow]bool{},
+	}
+}
+
+func (m *Maintenance) save(w *util.MaintenanceWindow) {
+	if m.DB == nil {
+		return
+	}
+	if err := util.SaveMaintenance(m.DB, w); err != nil {
+		fmt.Println("maintenance", err)
+	}
+}
+
+func (m *Maintenance) Add(w *util.MaintenanceWindow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.windows = append(m.windows, w)
+	m.save(w)
+}
+
+// LoadFile adds the windows of a json file with a list of
+// {"platform":"Binance","start":"...","end":"...","reason":"..."}
+func (m *Maintenance) LoadFile(path string) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	windows := []*util.MaintenanceWindow{}
+	if err := json.Unmarshal(buf, &windows); err != nil {
+		return err
+	}
+	for _, w := range windows {
+		if w.Platform == "" || w.Start.IsZero() || w.End.IsZero() {
+			return fmt.Errorf("maintenance window needs platform, start and end: %+v", w)
+		}
+		m.Add(w)
+	}
+	return nil
+}
+
+// Begin records a maintenance announced by the venue.
+func (m *Maintenance) Begin(platform, reason string, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range m.windows {
+		if w.Platform == platform && w.End.IsZero() {
+			return
+		}
+	}
+	w := &util.MaintenanceWindow{Platform: platform, Start: t, Reason: reason}
+	m.windows = append(m.windows, w)
+	m.save(w)
+	fmt.Println(platform, "maintenance started:", reason)
+}
+
+// Finish ends an announced maintenance, if there is one.
+func (m *Maintenance) Finish(platform string, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range m.windows {
+		if w.Platform == platform && w.End.IsZero() {
+			w.End = t
+			m.save(w)
+			fmt.Println(platform, "maintenance ended")
+		}
+	}
+}
+
+func (m *Maintenance) Active(platform string, t time.Time) *util.MaintenanceWindow {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range m.windows {
+		if w.Platform == platform && w.Contains(t) {
+			return w
+		}
+	}
+	return nil
+}
+
+// Wait sleeps a while when platform is in maintenance and tells if it did.
+// Clients call it after a failed connect in place of their usual retry.
+func (m *Maintenance) Wait(platform string) bool {
+	now := time.Now()
+	w := m.Active(platform, now)
+	if w == nil {
+		return false
+	}
+
+	m.mu.Lock()
+	if !m.logged[w] {
+		m.logged[w] = true
+		if w.End.IsZero() {
+			fmt.Println(platform, "in maintenance, retrying every", maintenanceRetry, w.Reason)
+		} else {
+			fmt.Println(platform, "in maintenance until", w.End.Format(time.RFC3339), w.Reason)
+		}
+	}
+	m.mu.Unlock()
+
+	wait := maintenanceRetry
+	if !w.End.IsZero() {
+		wait = w.End.Sub(now)
+		if wait > maintenanceStep {
+			wait = maintenanceStep
+		}
+	}
+	time.Sleep(wait)
+	return true
+}
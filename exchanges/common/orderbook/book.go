@@ -2,8 +2,10 @@ package orderbook
 
 import (
 	"math"
+	"sort"
 	"time"
 
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 )
 
@@ -23,8 +25,9 @@ const BidSide Side = 0
 const AskSide Side = 1
 
 type BookLevel struct {
-	Price float64
-	Size  float64
+	Price     float64
+	Size      float64
+	FirstSeen time.Time
 }
 
 type Trade struct {
@@ -32,6 +35,8 @@ type Trade struct {
 	Size  float64
 	Time  time.Time
 	Side  Side
+	// how Side was known, stored with the trade
+	Source db_orderbook.SideSource
 }
 
 type Book struct {
@@ -43,6 +48,8 @@ type Book struct {
 	Sequence    uint64
 	Synced      bool
 	Diff        *BookLevelDiff
+	// REST snapshot the book was last built from, stored with syncs
+	Snapshot *db_orderbook.SyncProvenance
 }
 
 func New(id string) *Book {
@@ -74,6 +81,48 @@ func (b *Book) GetSide(price float64) uint8 {
 	return uint8(BidSide)
 }
 
+// AggressorSide classifies a trade at price by the best bid and ask: at or
+// above the ask a buy lifted it, at or below the bid a sell hit it. The
+// level a trade emptied is often removed before the trade arrives, which
+// leaves its price inside the spread, those go to the closer side of the
+// touch, or by the tick rule when it is right in the middle.
+func (b *Book) AggressorSide(price float64) (uint8, db_orderbook.SideSource) {
+	bid, ask := b.BestPrices()
+	if ask != 0 && price >= ask {
+		return uint8(AskSide), db_orderbook.SideFromBBO
+	}
+	if bid != 0 && price <= bid {
+		return uint8(BidSide), db_orderbook.SideFromBBO
+	}
+	if bid != 0 && ask != 0 && price-bid != ask-price {
+		if price-bid < ask-price {
+			return uint8(BidSide), db_orderbook.SideFromBBO
+		}
+		return uint8(AskSide), db_orderbook.SideFromBBO
+	}
+	if n := len(b.Trades); n > 0 && b.Trades[n-1].Price != price {
+		if price > b.Trades[n-1].Price {
+			return uint8(AskSide), db_orderbook.SideFromTick
+		}
+		return uint8(BidSide), db_orderbook.SideFromTick
+	}
+	return b.GetSide(price), db_orderbook.SideFromLevel
+}
+
+// LevelSize returns the current size at price, 0 when there is no level.
+func (b *Book) LevelSize(side Side, price float64) float64 {
+	levels := b.Bid
+	if side == AskSide {
+		levels = b.Ask
+	}
+	for _, level := range levels {
+		if level.Price == price {
+			return level.Size
+		}
+	}
+	return 0
+}
+
 func (b *Book) UpdateBidLevel(t time.Time, price, size float64) {
 	var found bool
 
@@ -95,7 +144,7 @@ func (b *Book) UpdateBidLevel(t time.Time, price, size float64) {
 
 	if !found && size != 0 {
 		// add
-		b.Bid = append(b.Bid, &BookLevel{Price: price, Size: size})
+		b.Bid = append(b.Bid, &BookLevel{Price: price, Size: size, FirstSeen: t})
 	}
 
 	// update diff stats
@@ -133,7 +182,7 @@ func (b *Book) UpdateAskLevel(t time.Time, price, size float64) {
 
 	if !found && size != 0 {
 		// add
-		b.Ask = append(b.Ask, &BookLevel{Price: price, Size: size})
+		b.Ask = append(b.Ask, &BookLevel{Price: price, Size: size, FirstSeen: t})
 	}
 
 	// update diff stats
@@ -194,13 +243,91 @@ func (b *Book) FixBookLevels() {
 }
 
 func (b *Book) AddTrade(t time.Time, side uint8, price, size float64) {
+	b.AddClassifiedTrade(t, side, db_orderbook.SideUnknown, price, size)
+}
+
+// AddClassifiedTrade adds a trade along with how its side was known.
+func (b *Book) AddClassifiedTrade(t time.Time, side uint8, source db_orderbook.SideSource, price, size float64) {
 	if len(b.Trades) >= 50 {
 		// remove and free first item
 		copy(b.Trades[0:], b.Trades[1:])
 		b.Trades[len(b.Trades)-1] = nil
 		b.Trades = b.Trades[:len(b.Trades)-1]
 	}
-	b.Trades = append(b.Trades, &Trade{Side: Side(side), Price: price, Size: size, Time: t})
+	b.Trades = append(b.Trades, &Trade{Side: Side(side), Source: source, Price: price, Size: size, Time: t})
+}
+
+// ApplySnapshot brings the book in line with a fresh snapshot by only
+// touching levels that changed, so the pending diff stays small.
+func (b *Book) ApplySnapshot(t time.Time, bids, asks []*BookLevel) {
+	applySnapshotSide(t, b.Bid, bids, b.UpdateBidLevel)
+	applySnapshotSide(t, b.Ask, asks, b.UpdateAskLevel)
+}
+
+func applySnapshotSide(t time.Time, current, snapshot []*BookLevel, update func(time.Time, float64, float64)) {
+	changed := make(map[float64]float64, len(snapshot))
+	for _, level := range snapshot {
+		changed[level.Price] = level.Size
+	}
+
+	removed := []float64{}
+	for _, level := range current {
+		size, ok := changed[level.Price]
+		if !ok {
+			removed = append(removed, level.Price)
+		} else if size == level.Size {
+			delete(changed, level.Price)
+		}
+	}
+
+	for _, price := range removed {
+		update(t, price, 0)
+	}
+	for _, level := range snapshot {
+		if _, ok := changed[level.Price]; ok {
+			update(t, level.Price, level.Size)
+		}
+	}
+}
+
+// Truncate removes the levels behind the best depth of each side, for
+// venues which stop updating levels once they fall out of the subscribed
+// depth instead of removing them.
+func (b *Book) Truncate(t time.Time, depth int) {
+	if len(b.Bid) > depth {
+		bids := append([]*BookLevel{}, b.Bid...)
+		sort.Slice(bids, func(i, j int) bool { return bids[i].Price > bids[j].Price })
+		for _, level := range bids[depth:] {
+			b.UpdateBidLevel(t, level.Price, 0)
+		}
+	}
+	if len(b.Ask) > depth {
+		asks := append([]*BookLevel{}, b.Ask...)
+		sort.Slice(asks, func(i, j int) bool { return asks[i].Price < asks[j].Price })
+		for _, level := range asks[depth:] {
+			b.UpdateAskLevel(t, level.Price, 0)
+		}
+	}
+}
+
+// BestPrices returns the best bid and ask, 0 for an empty side.
+func (b *Book) BestPrices() (float64, float64) {
+	var bid, ask float64
+	for _, level := range b.Bid {
+		if level.Size > 0 && level.Price > bid {
+			bid = level.Price
+		}
+	}
+	for _, level := range b.Ask {
+		if level.Size > 0 && (ask == 0 || level.Price < ask) {
+			ask = level.Price
+		}
+	}
+	return bid, ask
+}
+
+func (b *Book) Empty() bool {
+	return len(b.Bid) == 0 && len(b.Ask) == 0
 }
 
 func (b *Book) Clear() {
@@ -8,8 +8,16 @@ import (
 )
 
 func PackSync(book *Book) []byte {
+	return packSync(db_orderbook.SyncPacket, book)
+}
+
+func PackDegradedSync(book *Book) []byte {
+	return packSync(db_orderbook.DegradedSyncPacket, book)
+}
+
+func packSync(packetType uint8, book *Book) []byte {
 	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, db_orderbook.SyncPacket)
+	binary.Write(buf, binary.LittleEndian, packetType)
 	binary.Write(buf, binary.LittleEndian, uint64(book.Sequence))
 
 	binary.Write(buf, binary.LittleEndian, uint64(len(book.Bid)))
@@ -24,9 +32,25 @@ func PackSync(book *Book) []byte {
 		binary.Write(buf, binary.LittleEndian, level.Size)  // size
 	}
 
+	if book.Snapshot != nil {
+		buf.Write(db_orderbook.PackSyncProvenance(book.Snapshot))
+	}
+
 	return buf.Bytes()
 }
 
+func PackLevelAges(book *Book) []byte {
+	return db_orderbook.PackLevelAges(levelAges(book.Bid), levelAges(book.Ask))
+}
+
+func levelAges(levels []*BookLevel) []db_orderbook.LevelAge {
+	ages := make([]db_orderbook.LevelAge, 0, len(levels))
+	for _, level := range levels {
+		ages = append(ages, db_orderbook.LevelAge{Price: level.Price, FirstSeen: level.FirstSeen})
+	}
+	return ages
+}
+
 func PackDiff(first, last uint64, diff *BookLevelDiff) []byte {
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, db_orderbook.DiffPacket)
@@ -56,5 +80,6 @@ func PackTrade(trade *Trade) []byte {
 	binary.Write(buf, binary.LittleEndian, uint8(trade.Side)) // side
 	binary.Write(buf, binary.LittleEndian, trade.Price)       // price
 	binary.Write(buf, binary.LittleEndian, trade.Size)        // size
+	binary.Write(buf, binary.LittleEndian, uint8(trade.Source))
 	return buf.Bytes()
 }
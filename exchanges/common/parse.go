@@ -0,0 +1,153 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/lian/gdax-bookmap/features"
+)
+
+// Lenient accepts numbers in another encoding than documented, e.g. 7000.5
+// where "7000.5" is expected, instead of dropping them. Either way a
+// malformed field never stops a feed, it is counted in Malformed.
+var Lenient = features.Register("parse.lenient", "read venue numbers sent in another encoding than documented instead of dropping them", false)
+
+// Malformed counts the fields of the messages which did not look like
+// documented, by platform and field.
+var Malformed = NewMalformedCounter()
+
+// malformed fields of one kind are logged the first time and then every
+// this many times
+const malformedLogEvery = 1000
+
+type MalformedCounter struct {
+	mu     sync.Mutex
+	fields map[string]*MalformedStats
+}
+
+type MalformedStats struct {
+	Platform string `json:"platform"`
+	Field    string `json:"field"`
+	// dropped, with the message or level they were part of
+	Dropped uint64 `json:"dropped"`
+	// read anyway in lenient mode
+	Recovered uint64 `json:"recovered"`
+	// the last value seen
+	Last string `json:"last"`
+}
+
+func NewMalformedCounter() *MalformedCounter {
+	return &MalformedCounter{fields: map[string]*MalformedStats{}}
+}
+
+func (m *MalformedCounter) add(platform, field string, value interface{}, recovered bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := platform + " " + field
+	s, ok := m.fields[key]
+	if !ok {
+		s = &MalformedStats{Platform: platform, Field: field}
+		m.fields[key] = s
+	}
+	if recovered {
+		s.Recovered += 1
+	} else {
+		s.Dropped += 1
+	}
+	s.Last = fmt.Sprintf("%#v", value)
+	if n := s.Dropped + s.Recovered; n%malformedLogEvery == 1 {
+		action := "dropped"
+		if recovered {
+			action = "recovered"
+		}
+		fmt.Printf("%s: malformed %s %s, %s (%d so far)\n", platform, field, s.Last, action, n)
+	}
+}
+
+// Stats returns the counters sorted by platform and field.
+func (m *MalformedCounter) Stats() []*MalformedStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := []*MalformedStats{}
+	for _, s := range m.fields {
+		c := *s
+		stats = append(stats, &c)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Platform != stats[j].Platform {
+			return stats[i].Platform < stats[j].Platform
+		}
+		return stats[i].Field < stats[j].Field
+	})
+	return stats
+}
+
+// QuotedNumber reads a number documented as a string, like the prices of
+// most venues. ok is false when the field has to be dropped.
+func QuotedNumber(platform, field string, v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case string:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f, true
+		}
+	case float64:
+		if Lenient.On() {
+			Malformed.add(platform, field, v, true)
+			return value, true
+		}
+	}
+	Malformed.add(platform, field, v, false)
+	return 0, false
+}
+
+// Number reads a number documented as a JSON number.
+func Number(platform, field string, v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case string:
+		if f, err := strconv.ParseFloat(value, 64); err == nil && Lenient.On() {
+			Malformed.add(platform, field, v, true)
+			return f, true
+		}
+	}
+	Malformed.add(platform, field, v, false)
+	return 0, false
+}
+
+// QuotedLevel reads a price level documented as ["price", "size", ...].
+func QuotedLevel(platform, field string, v interface{}) (price, size float64, ok bool) {
+	values, isList := v.([]interface{})
+	if !isList || len(values) < 2 {
+		Malformed.add(platform, field, v, false)
+		return 0, 0, false
+	}
+	if price, ok = QuotedNumber(platform, field+".price", values[0]); !ok {
+		return 0, 0, false
+	}
+	if size, ok = QuotedNumber(platform, field+".size", values[1]); !ok {
+		return 0, 0, false
+	}
+	return price, size, true
+}
+
+// List reads a field documented as a JSON array, nil and false when it is
+// something else.
+func List(platform, field string, v interface{}) ([]interface{}, bool) {
+	list, ok := v.([]interface{})
+	if !ok {
+		Malformed.add(platform, field, v, false)
+	}
+	return list, ok
+}
+
+// String reads a field documented as a string, e.g. an order id.
+func String(platform, field string, v interface{}) (string, bool) {
+	s, ok := v.(string)
+	if !ok {
+		Malformed.add(platform, field, v, false)
+	}
+	return s, ok
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"io"
+	"sync"
+)
+
+// Stopper ends the Run loop of a client from another goroutine: Stop
+// closes the socket of the current connection, so the read fails, and Run
+// returns instead of reconnecting. The zero value is running.
+type Stopper struct {
+	mu     sync.Mutex
+	done   chan struct{}
+	socket io.Closer
+}
+
+func (s *Stopper) init() {
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+}
+
+// Done is closed by Stop, for loops waiting on tickers instead of sockets.
+func (s *Stopper) Done() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	return s.done
+}
+
+func (s *Stopper) Stopped() bool {
+	select {
+	case <-s.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// Connected remembers the socket of a new connection, it is closed right
+// away when the client was stopped while connecting.
+func (s *Stopper) Connected(socket io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	select {
+	case <-s.done:
+		socket.Close()
+	default:
+		s.socket = socket
+	}
+}
+
+// Stop is safe to call more than once.
+func (s *Stopper) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	close(s.done)
+	if s.socket != nil {
+		s.socket.Close()
+		s.socket = nil
+	}
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WriteTimeout bounds every write of a Writer, a stalled connection fails
+// the write instead of blocking everything queued behind it.
+const WriteTimeout = 10 * time.Second
+
+var ErrWriterClosed = errors.New("writer closed")
+
+type queuedWrite struct {
+	v      interface{}
+	result chan error
+}
+
+// Writer serializes the writes of one websocket connection, which allows
+// one concurrent writer only. Subscribes from other goroutines and pongs
+// from the read loop go through its queue instead of interleaving frames.
+type Writer struct {
+	Platform string
+	conn     *websocket.Conn
+	queue    chan *queuedWrite
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewWriter(platform string, conn *websocket.Conn, size int) *Writer {
+	w := &Writer{
+		Platform: platform,
+		conn:     conn,
+		queue:    make(chan *queuedWrite, size),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *Writer) run() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case q := <-w.queue:
+			w.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+			err := w.conn.WriteJSON(q.v)
+			if q.result != nil {
+				q.result <- err
+			} else if err != nil {
+				fmt.Println(w.Platform, "write:", err)
+			}
+		}
+	}
+}
+
+func (w *Writer) enqueue(q *queuedWrite) error {
+	select {
+	case w.queue <- q:
+		return nil
+	case <-w.done:
+		return ErrWriterClosed
+	case <-time.After(WriteTimeout):
+		return fmt.Errorf("%s write queue full", w.Platform)
+	}
+}
+
+// WriteJSON queues a message and waits until it is written.
+func (w *Writer) WriteJSON(v interface{}) error {
+	q := &queuedWrite{v: v, result: make(chan error, 1)}
+	if err := w.enqueue(q); err != nil {
+		return err
+	}
+	select {
+	case err := <-q.result:
+		return err
+	case <-w.done:
+		return ErrWriterClosed
+	}
+}
+
+// Send queues a message without waiting for it, e.g. a pong from the read
+// loop, failed writes are logged.
+func (w *Writer) Send(v interface{}) error {
+	return w.enqueue(&queuedWrite{v: v})
+}
+
+// Close stops the queue, messages still queued are dropped. The connection
+// is closed by its owner.
+func (w *Writer) Close() {
+	w.once.Do(func() { close(w.done) })
+}
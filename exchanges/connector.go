@@ -0,0 +1,115 @@
+// Package exchanges describes what every exchange client offers, so the
+// application starts and stops them alike and other venues can be plugged
+// in without changing it.
+package exchanges
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/trading"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Book is the live order book of a product. It is maintained on the shard
+// of the product, reads from other goroutines are only approximate.
+type Book interface {
+	BestPrices() (float64, float64)
+}
+
+// Connector is an exchange client recording its products into the
+// database.
+type Connector interface {
+	// Connect opens the websocket and subscribes the products, Run calls
+	// it again after every disconnect.
+	Connect() error
+	AddProduct(name string)
+	// Run records until Stop.
+	Run()
+	// GetBook returns the book of a product by its name, nil when it is
+	// not recorded. Safe to call from any goroutine, see BookIndex.
+	GetBook(name string) Book
+	// Stop closes the connection and ends Run, the books keep their last
+	// state. It is safe to call more than once.
+	Stop()
+}
+
+// RawHandler is a websocket client whose captured messages can be fed in
+// again, e.g. by replays and load tests.
+type RawHandler interface {
+	// HandleRaw handles one text message of the websocket,
+	// common.ErrReconnect asks for a new connection.
+	HandleRaw(message []byte) error
+}
+
+// Settings are handed to the factories of registered connectors, which
+// take what applies to them.
+type Settings struct {
+	// products to record, empty for the defaults of the connector
+	Products          []string
+	DB                *bolt.DB
+	Shards            *util.Shards
+	BookmarkTradeSize float64
+	// between REST snapshots while the websocket is down, 0 disables
+	PollInterval time.Duration
+	// order level books where the venue has them, e.g. bitfinex R0
+	RawBooks bool
+	// channel interval of venues offering several, e.g. deribit 100ms
+	Interval string
+	// credentials of the user streams, e.g. a deribit client id and secret,
+	// which feed Tracker
+	APIKey    string
+	APISecret string
+	Tracker   *trading.Tracker
+	// connector specific, e.g. a synthetic.Config
+	Options interface{}
+}
+
+// ProductsOr returns Products, or defaults when none are given.
+func (s Settings) ProductsOr(defaults ...string) []string {
+	if len(s.Products) == 0 {
+		return defaults
+	}
+	return s.Products
+}
+
+// Factory creates a connector and returns the details of the products it
+// records.
+type Factory func(settings Settings) (Connector, []*product_info.Info)
+
+var factories = map[string]Factory{}
+var factoriesMu sync.Mutex
+
+// Register adds a connector, started when -platforms names the platform.
+// It is called in init, next to common.RegisterCapabilities which makes
+// the platform known to the flag checks. The built-in clients register
+// themselves alike.
+func Register(platform string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[strings.ToLower(platform)] = factory
+}
+
+// Registered returns the names of the registered platforms, sorted.
+func Registered() []string {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	names := []string{}
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FactoryOf returns the factory of a registered platform.
+func FactoryOf(platform string) (Factory, bool) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	f, ok := factories[strings.ToLower(platform)]
+	return f, ok
+}
@@ -0,0 +1,127 @@
+package product_info
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Currencies whose futures and options are fetched.
+var Currencies = []string{"BTC", "ETH"}
+
+// Instrument tells how the amounts of an instrument are turned into the
+// base currency.
+type Instrument struct {
+	Kind string
+	// amounts of inverse futures are USD
+	Inverse bool
+}
+
+// BaseSize returns an amount at price in the base currency.
+func (i Instrument) BaseSize(amount, price float64) float64 {
+	if i.Inverse && price > 0 {
+		return amount / price
+	}
+	return amount
+}
+
+var CachedInfo map[string]product_info.Info
+var CachedInstruments map[string]Instrument
+
+func init() {
+	FetchAllProductInfo()
+}
+
+func FetchAllProductInfo() {
+	CachedInfo = map[string]product_info.Info{}
+	CachedInstruments = map[string]Instrument{}
+
+	for _, currency := range Currencies {
+		for _, kind := range []string{"future", "option"} {
+			if err := fetchInstruments(currency, kind); err != nil {
+				fmt.Println("InitProduct error", currency, kind, err)
+			}
+		}
+	}
+}
+
+func fetchInstruments(currency, kind string) error {
+	url := fmt.Sprintf("https://www.deribit.com/api/v2/public/get_instruments?currency=%s&kind=%s", currency, kind)
+	res, err := common.Get("Deribit", url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return err
+	}
+
+	var data struct {
+		Result []struct {
+			InstrumentName string  `json:"instrument_name"`
+			Kind           string  `json:"kind"`
+			BaseCurrency   string  `json:"base_currency"`
+			QuoteCurrency  string  `json:"quote_currency"`
+			TickSize       float64 `json:"tick_size"`
+			MinTradeAmount float64 `json:"min_trade_amount"`
+			InstrumentType string  `json:"instrument_type"`
+			IsActive       bool    `json:"is_active"`
+		} `json:"result"`
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return common.Protocol("get_instruments: %s", err)
+	}
+	if data.Error != nil {
+		return common.Protocol("get_instruments: %d %s", data.Error.Code, data.Error.Message)
+	}
+
+	for _, i := range data.Result {
+		if !i.IsActive {
+			continue
+		}
+		info := product_info.Info{
+			ID:             i.InstrumentName,
+			DisplayName:    i.InstrumentName,
+			BaseCurrency:   i.BaseCurrency,
+			QuoteCurrency:  i.QuoteCurrency,
+			Platform:       "Deribit",
+			DatabaseKey:    fmt.Sprintf("Deribit-%s", i.InstrumentName),
+			QuoteIncrement: product_info.FloatString(i.TickSize),
+			FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(i.TickSize)),
+		}
+		instrument := Instrument{Kind: i.Kind, Inverse: i.InstrumentType == "reversed"}
+		if !instrument.Inverse {
+			// the base size of inverse futures depends on the price
+			info.BaseMinSize = product_info.FloatString(i.MinTradeAmount)
+		}
+
+		CachedInfo[info.DisplayName] = info
+		CachedInstruments[info.DisplayName] = instrument
+	}
+	return nil
+}
+
+func FetchProductInfo(id string) product_info.Info {
+	if info, ok := CachedInfo[id]; ok {
+		return info
+	}
+	return product_info.Info{}
+}
+
+func FetchInstrument(id string) (Instrument, bool) {
+	instrument, ok := CachedInstruments[id]
+	return instrument, ok
+}
@@ -0,0 +1,457 @@
+package websocket
+
+// api: https://docs.deribit.com/#subscriptions
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	book_info "github.com/lian/gdax-bookmap/exchanges/deribit/product_info"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// seconds between heartbeats, deribit closes the connection when its test
+// requests go unanswered
+const heartbeatInterval = 30
+
+func init() {
+	// book changes carry the change_id of the one before
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:         "Deribit",
+		DynamicSubscribe: true,
+	})
+	exchanges.Register("Deribit", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		c := New(s.DB, s.ProductsOr("BTC-PERPETUAL", "ETH-PERPETUAL"))
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		if s.Interval != "" {
+			c.Interval = s.Interval
+		}
+		if c.Interval == "raw" {
+			c.ClientID = s.APIKey
+			c.ClientSecret = s.APISecret
+		}
+		return c, c.Infos
+	})
+}
+
+type Client struct {
+	exchanges.BookIndex
+	Platform string
+	Socket   *websocket.Conn
+	// every write to Socket goes through it
+	Writer      *common.Writer
+	Products    []string
+	Books       map[string]*orderbook.Book
+	Instruments map[string]book_info.Instrument
+	// change_id of the last book message by instrument
+	ChangeIDs map[string]*int64
+	// interval of the book and trades channels, 100ms or raw, which is
+	// only served to connections authorized with the client credentials
+	Interval          string
+	ClientID          string
+	ClientSecret      string
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	Shards            *util.Shards
+	stopper           common.Stopper
+}
+
+func New(db *bolt.DB, products []string) *Client {
+	c := &Client{
+		Platform:    "Deribit",
+		Products:    []string{},
+		Books:       map[string]*orderbook.Book{},
+		Instruments: map[string]book_info.Instrument{},
+		ChangeIDs:   map[string]*int64{},
+		Interval:    "100ms",
+		BatchWrite:  map[string]*storage.BookWriter{},
+		DB:          db,
+		Infos:       []*product_info.Info{},
+	}
+	if c.DB != nil {
+		c.dbEnabled = true
+	}
+
+	for _, name := range products {
+		c.AddProduct(name)
+	}
+
+	if c.dbEnabled {
+		buckets := []string{}
+		for _, info := range c.Infos {
+			buckets = append(buckets, info.DatabaseKey)
+		}
+		util.CreateBucketsDB(c.DB, buckets)
+	}
+
+	return c
+}
+
+// AddProduct adds an instrument by its name, e.g. BTC-PERPETUAL, BTC-27DEC24
+// or BTC-27DEC24-100000-C.
+func (c *Client) AddProduct(name string) {
+	instrument, ok := book_info.FetchInstrument(name)
+	if !ok {
+		fmt.Println(c.Platform, "unknown product", name)
+		return
+	}
+	c.Products = append(c.Products, name)
+	book := orderbook.New(name)
+	info := book_info.FetchProductInfo(name)
+	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
+	book.SetProductInfo(info)
+	c.Books[name] = book
+	c.AddBook(book.ID, book)
+	c.Instruments[name] = instrument
+	c.ChangeIDs[name] = new(int64)
+}
+
+func (c *Client) request(id int, method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+// resubscribe asks for a new snapshot of a book after a gap in the
+// change_ids, the changes in between are skipped.
+func (c *Client) resubscribe(book *orderbook.Book) {
+	book.Synced = false
+	if c.Writer == nil {
+		// replaying a capture
+		return
+	}
+	channels := []string{fmt.Sprintf("book.%s.%s", book.ID, c.Interval)}
+	c.Writer.Send(c.request(3, "public/unsubscribe", map[string]interface{}{"channels": channels}))
+	c.Writer.Send(c.request(4, "public/subscribe", map[string]interface{}{"channels": channels}))
+}
+
+func (c *Client) Connect() error {
+	url := "wss://www.deribit.com/ws/api/v2"
+	fmt.Println("connect to websocket", url)
+	s, _, err := common.Dial(c.Platform, url)
+	if err != nil {
+		return err
+	}
+
+	c.Socket = s
+	c.stopper.Connected(s)
+	c.Writer = common.NewWriter(c.Platform, s, 64)
+	c.ConnectedAt = time.Now()
+
+	channels := []string{}
+	for name, book := range c.Books {
+		// the snapshot of the new subscription syncs it again
+		book.Synced = false
+		channels = append(channels, fmt.Sprintf("book.%s.%s", name, c.Interval), fmt.Sprintf("trades.%s.%s", name, c.Interval))
+	}
+	requests := []map[string]interface{}{
+		c.request(1, "public/set_heartbeat", map[string]interface{}{"interval": heartbeatInterval}),
+	}
+	if c.ClientID != "" {
+		// requests of a connection are handled in order, the subscription
+		// follows the authorization
+		requests = append(requests, c.request(6, "public/auth", map[string]interface{}{
+			"grant_type":    "client_credentials",
+			"client_id":     c.ClientID,
+			"client_secret": c.ClientSecret,
+		}))
+	}
+	requests = append(requests, c.request(2, "public/subscribe", map[string]interface{}{"channels": channels}))
+	for _, req := range requests {
+		if err := c.Writer.WriteJSON(req); err != nil {
+			s.Close()
+			c.Writer.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	diff := book.Diff
+	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
+		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		batch.Write(now, pkt)
+		book.ResetDiff()
+		batch.LastDiffSeq = book.Sequence + 1
+	}
+}
+
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+// wait for queued messages before the books get resubscribed
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
+func (c *Client) Run() {
+	for !c.stopper.Stopped() {
+		c.run()
+	}
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
+func (c *Client) run() {
+	if err := c.Connect(); err != nil {
+		if common.Schedule.Wait(c.Platform) {
+			return
+		}
+		fmt.Println("failed to connect", err)
+		time.Sleep(1000 * time.Millisecond)
+		return
+	}
+
+	defer c.Socket.Close()
+	defer c.Writer.Close()
+	defer c.flushShards()
+
+	for {
+		msgType, message, err := c.Socket.ReadMessage()
+		if err != nil {
+			log.Println("read:", err)
+			return
+		}
+
+		if msgType != websocket.TextMessage {
+			continue
+		}
+
+		common.Capture(c.Platform, message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+type rpcMessage struct {
+	ID     *int   `json:"id"`
+	Method string `json:"method"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Params struct {
+		Type    string          `json:"type"`
+		Channel string          `json:"channel"`
+		Data    json.RawMessage `json:"data"`
+	} `json:"params"`
+}
+
+type bookData struct {
+	Type         string          `json:"type"`
+	ChangeID     int64           `json:"change_id"`
+	PrevChangeID int64           `json:"prev_change_id"`
+	Bids         [][]interface{} `json:"bids"`
+	Asks         [][]interface{} `json:"asks"`
+}
+
+type tradeData struct {
+	Price     interface{} `json:"price"`
+	Amount    interface{} `json:"amount"`
+	Direction string      `json:"direction"`
+}
+
+func (c *Client) HandleRaw(message []byte) error {
+	var msg rpcMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("PacketHeader-parse: %s", err)
+	}
+
+	switch {
+	case msg.Error != nil:
+		if msg.Error.Code == 13028 || msg.Error.Code == 10028 {
+			// too many requests or the matching engine is unavailable
+			common.Schedule.Begin(c.Platform, msg.Error.Message, time.Now())
+			return common.ErrReconnect
+		}
+		return common.Protocol("%s error %d %s", c.Platform, msg.Error.Code, msg.Error.Message)
+	case msg.Method == "heartbeat":
+		if msg.Params.Type == "test_request" && c.Writer != nil {
+			if err := c.Writer.Send(c.request(5, "public/test", map[string]interface{}{})); err != nil {
+				return err
+			}
+		}
+		return nil
+	case msg.ID != nil:
+		// results of our requests
+		return nil
+	case msg.Method != "subscription":
+		return nil
+	}
+
+	if common.Schedule.Active(c.Platform, time.Now()) != nil {
+		common.Schedule.Finish(c.Platform, time.Now())
+	}
+
+	// book.<instrument>.<interval>, trades.<instrument>.<interval>
+	parts := strings.Split(msg.Params.Channel, ".")
+	if len(parts) != 3 {
+		return common.Protocol("%s unkown channel %s", c.Platform, msg.Params.Channel)
+	}
+	book, ok := c.Books[parts[1]]
+	if !ok {
+		return fmt.Errorf("%s message of unknown instrument %q", c.Platform, parts[1])
+	}
+	c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+		if err := c.HandleMessage(book, parts[0], msg.Params.Data); err != nil {
+			fmt.Println(err)
+		}
+	})
+	return nil
+}
+
+func (c *Client) HandleMessage(book *orderbook.Book, channel string, raw json.RawMessage) error {
+	now := time.Now()
+	instrument := c.Instruments[book.ID]
+	changeID := c.ChangeIDs[book.ID]
+
+	trades := []*orderbook.Trade{}
+
+	switch channel {
+	case "book":
+		var data bookData
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return common.Protocol("%s book %s", c.Platform, err)
+		}
+		bids := c.levels("book.bids", instrument, data.Bids)
+		asks := c.levels("book.asks", instrument, data.Asks)
+
+		if data.Type == "snapshot" {
+			if book.Empty() {
+				book.Clear()
+				book.Sequence = uint64(0)
+			} else if c.dbEnabled {
+				// resubscribed after a gap
+				c.BatchWrite[book.ID].ResetWarmUp()
+			}
+			// on resubscribe only the levels which changed end up in the diff
+			book.ApplySnapshot(now, bids, asks)
+			book.Synced = true
+			*changeID = data.ChangeID
+			break
+		}
+		if !book.Synced {
+			// waiting for the snapshot of a resubscribe
+			return nil
+		}
+		if data.PrevChangeID != *changeID {
+			last := *changeID
+			c.resubscribe(book)
+			return common.SequenceGap("%s %s prev_change_id %d, expected %d, resubscribing", c.Platform, book.ID, data.PrevChangeID, last)
+		}
+		*changeID = data.ChangeID
+		for _, level := range bids {
+			book.UpdateBidLevel(now, level.Price, level.Size)
+		}
+		for _, level := range asks {
+			book.UpdateAskLevel(now, level.Price, level.Size)
+		}
+
+	case "trades":
+		var data []tradeData
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return common.Protocol("%s trades %s", c.Platform, err)
+		}
+		for _, t := range data {
+			price, ok := common.Number(c.Platform, "trades.price", t.Price)
+			if !ok {
+				continue
+			}
+			amount, ok := common.Number(c.Platform, "trades.amount", t.Amount)
+			if !ok {
+				continue
+			}
+			size := instrument.BaseSize(amount, price)
+			if t.Direction == "sell" {
+				book.AddClassifiedTrade(now, uint8(orderbook.BidSide), db_orderbook.SideFromVenue, price, size)
+			} else {
+				book.AddClassifiedTrade(now, uint8(orderbook.AskSide), db_orderbook.SideFromVenue, price, size)
+			}
+			trades = append(trades, book.Trades[len(book.Trades)-1])
+		}
+
+	default:
+		return common.Protocol("unkown channel %s", channel)
+	}
+
+	book.Sequence += 1
+
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
+		for _, trade := range trades {
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
+		}
+
+		if batch.NextSync(now) {
+			fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
+			c.WriteSync(batch, book, now)
+		} else {
+			if batch.NextDiff(now) {
+				c.WriteDiff(batch, book, now)
+			}
+		}
+	}
+	return nil
+}
+
+// levels reads [action, price, amount] levels, deletes get size 0.
+func (c *Client) levels(field string, instrument book_info.Instrument, list [][]interface{}) []*orderbook.BookLevel {
+	levels := []*orderbook.BookLevel{}
+	for _, values := range list {
+		if len(values) < 3 {
+			continue
+		}
+		price, ok := common.Number(c.Platform, field+".price", values[1])
+		if !ok {
+			continue
+		}
+		amount, ok := common.Number(c.Platform, field+".amount", values[2])
+		if !ok {
+			continue
+		}
+		if action, _ := values[0].(string); action == "delete" {
+			amount = 0
+		}
+		levels = append(levels, &orderbook.BookLevel{Price: price, Size: instrument.BaseSize(amount, price)})
+	}
+	return levels
+}
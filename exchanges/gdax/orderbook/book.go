@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 )
 
@@ -21,8 +22,9 @@ type Order struct {
 }
 
 type BookLevel struct {
-	Price  float64
-	Orders []*Order
+	Price     float64
+	Orders    []*Order
+	FirstSeen time.Time
 }
 
 type LevelDiff struct {
@@ -75,6 +77,10 @@ type BookLevelDiff struct {
 	Ask []*LevelDiff
 }
 
+// Book is the level 3 book of the full channel, every resting order by
+// its id. The websocket client applies open, match, change and done to it
+// and stores the price levels they change as diffs, with the orders left
+// at those levels, see db_orderbook.OrderBook3.
 type Book struct {
 	ID          string
 	ProductInfo product_info.Info
@@ -84,6 +90,8 @@ type Book struct {
 	Sequence    uint64
 	Trades      []*Order
 	Diff        *BookLevelDiff
+	// REST snapshot the book was last built from, stored with syncs
+	Snapshot *db_orderbook.SyncProvenance
 }
 
 func New(id string) *Book {
@@ -114,6 +122,22 @@ func (b *Book) Clear() {
 	b.ResetDiff()
 }
 
+// BestPrices returns the best bid and ask, 0 for an empty side.
+func (b *Book) BestPrices() (float64, float64) {
+	var bid, ask float64
+	for price, level := range b.Bid {
+		if !level.Empty() && price > bid {
+			bid = price
+		}
+	}
+	for price, level := range b.Ask {
+		if !level.Empty() && (ask == 0 || price < ask) {
+			ask = price
+		}
+	}
+	return bid, ask
+}
+
 func (b *Book) Add(data map[string]interface{}) {
 	order := &Order{
 		ID:    data["id"].(string),
@@ -214,7 +238,7 @@ func (b *Book) Remove(order_id string) {
 }
 
 func (b *Book) AddLevel(order *Order) *BookLevel {
-	level := &BookLevel{Price: order.Price, Orders: []*Order{}}
+	level := &BookLevel{Price: order.Price, Orders: []*Order{}, FirstSeen: time.Now()}
 	if order.Side == BidSide {
 		b.Bid[order.Price] = level
 	} else {
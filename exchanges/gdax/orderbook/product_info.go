@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 
+	"github.com/lian/gdax-bookmap/exchanges/common"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 	"github.com/lian/gdax-bookmap/util"
 )
@@ -19,10 +19,13 @@ func init() {
 func FetchAllProductInfo() {
 	CachedInfo = map[string]product_info.Info{}
 
-	res, err := http.Get("https://api.gdax.com/products")
+	res, err := common.Get("GDAX", "https://api.gdax.com/products")
 	if err != nil {
+		// offline, the synthetic platform and replays still work
 		fmt.Println("InitProduct error", err)
+		return
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 
@@ -4,37 +4,73 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"strconv"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
 
+	"github.com/lian/gdax-bookmap/exchanges"
+	"github.com/lian/gdax-bookmap/exchanges/common"
 	"github.com/lian/gdax-bookmap/exchanges/gdax/orderbook"
+	"github.com/lian/gdax-bookmap/i18n"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
 	"github.com/lian/gdax-bookmap/util"
 )
 
+func init() {
+	// the full channel sends every order, subscribe messages are accepted on
+	// an open connection
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:         "GDAX",
+		L3:               true,
+		DynamicSubscribe: true,
+		Compression:      true,
+	})
+	exchanges.Register("GDAX", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		c := New(s.DB, s.ProductsOr("BTC-USD", "ETH-USD", "BCH-USD"))
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		c.PollInterval = s.PollInterval
+		return c, c.Infos
+	})
+}
+
 type Client struct {
-	Products    []string
-	Books       map[string]*orderbook.Book
-	Socket      *websocket.Conn
-	DB          *bolt.DB
-	dbEnabled   bool
-	LastSync    time.Time
-	LastDiff    time.Time
-	LastDiffSeq uint64
-	BatchWrite  map[string]*util.BookBatchWrite
-	Infos       []*product_info.Info
+	exchanges.BookIndex
+	Products          []string
+	Books             map[string]*orderbook.Book
+	Socket            *websocket.Conn
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	Shards            *util.Shards
+	FailedConnects    int
+	// REST snapshots are stored this often while the websocket is down,
+	// 0 disables
+	PollInterval time.Duration
+	// books start empty at the first message instead of a REST snapshot,
+	// for replaying captured feeds
+	Replay bool
+
+	// subscribe errors per product, see subscribeFailed
+	strikes *common.Strikes
+
+	stopper common.Stopper
 }
 
 func New(db *bolt.DB, products []string) *Client {
 	c := &Client{
-		Products:   []string{},
-		Books:      map[string]*orderbook.Book{},
-		BatchWrite: map[string]*util.BookBatchWrite{},
-		DB:         db,
-		Infos:      []*product_info.Info{},
+		Products:     []string{},
+		Books:        map[string]*orderbook.Book{},
+		BatchWrite:   map[string]*storage.BookWriter{},
+		DB:           db,
+		Infos:        []*product_info.Info{},
+		strikes:      common.NewStrikes(common.DelistStrikes),
+		PollInterval: 10 * time.Second,
 	}
 	if c.DB != nil {
 		c.dbEnabled = true
@@ -55,28 +91,28 @@ func New(db *bolt.DB, products []string) *Client {
 	return c
 }
 
-func (c *Client) GetBook(id string) *orderbook.Book {
-	return c.Books[id]
-}
-
 func (c *Client) AddProduct(name string) {
 	c.Products = append(c.Products, name)
-	c.Books[name] = orderbook.New(name)
-	c.BatchWrite[name] = &util.BookBatchWrite{Count: 0, Batch: []*util.BatchChunk{}}
+	book := orderbook.New(name)
+	c.Books[name] = book
+	c.AddBook(book.ID, book)
 	info := orderbook.FetchProductInfo(name)
 	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
 }
 
 func (c *Client) Connect() error {
 	url := "wss://ws-feed.gdax.com"
 	fmt.Println("connect to websocket", url)
-	s, _, err := websocket.DefaultDialer.Dial(url, nil)
+	s, _, err := common.Dial("GDAX", url)
 
 	if err != nil {
 		return err
 	}
 
 	c.Socket = s
+	c.stopper.Connected(s)
+	c.ConnectedAt = time.Now()
 
 	buf, _ := json.Marshal(map[string]interface{}{"type": "subscribe", "product_ids": c.Products})
 	err = c.Socket.WriteMessage(websocket.TextMessage, buf)
@@ -90,59 +126,80 @@ type PacketHeader struct {
 	ProductID string `json:"product_id"`
 }
 
-func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, message []byte) {
+func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, message []byte) error {
 	var data map[string]interface{}
 	if err := json.Unmarshal(message, &data); err != nil {
-		log.Println("HandleMessage:", err)
+		return common.Protocol("HandleMessage: %s", err)
+	}
+	if value, ok := data["time"].(string); ok {
+		eventTime, _ := time.Parse(time.RFC3339Nano, value)
+		common.Clocks.Event(book.ProductInfo.Platform, eventTime, time.Now())
 	}
 
 	var trade *orderbook.Order
+	m := &fields{kind: header.Type, data: data, ok: true}
 
 	switch header.Type {
 	case "received":
 		// skip
 	case "open":
-		price, _ := strconv.ParseFloat(data["price"].(string), 64)
-		size, _ := strconv.ParseFloat(data["remaining_size"].(string), 64)
+		price, size := m.number("price"), m.number("remaining_size")
+		id, side := m.text("order_id"), m.text("side")
+		if !m.ok {
+			return nil
+		}
 
 		book.Add(map[string]interface{}{
-			"id":    data["order_id"].(string),
-			"side":  data["side"].(string),
+			"id":    id,
+			"side":  side,
 			"price": price,
 			"size":  size,
 			//"time":           data["time"].(string),
 		})
 	case "done":
-		book.Remove(data["order_id"].(string))
+		id := m.text("order_id")
+		if !m.ok {
+			return nil
+		}
+		book.Remove(id)
 	case "match":
-		price, _ := strconv.ParseFloat(data["price"].(string), 64)
-		size, _ := strconv.ParseFloat(data["size"].(string), 64)
+		price, size := m.number("price"), m.number("size")
+		side, maker, taker, matched := m.text("side"), m.text("maker_order_id"), m.text("taker_order_id"), m.text("time")
+		if !m.ok {
+			return nil
+		}
 
 		book.Match(map[string]interface{}{
 			"size":           size,
 			"price":          price,
-			"side":           data["side"].(string),
-			"maker_order_id": data["maker_order_id"].(string),
-			"taker_order_id": data["taker_order_id"].(string),
-			"time":           data["time"].(string),
+			"side":           side,
+			"maker_order_id": maker,
+			"taker_order_id": taker,
+			"time":           matched,
 		}, false)
 		trade = book.Trades[len(book.Trades)-1]
 
 	case "change":
-		if _, ok := book.OrderMap[data["order_id"].(string)]; !ok {
+		id := m.text("order_id")
+		if !m.ok {
+			return nil
+		}
+		if _, ok := book.OrderMap[id]; !ok {
 			// if we don't know about the order, it is a change message for a received order
 		} else {
 			// change messages are treated as match messages
-			old_size, _ := strconv.ParseFloat(data["old_size"].(string), 64)
-			new_size, _ := strconv.ParseFloat(data["new_size"].(string), 64)
-			price, _ := strconv.ParseFloat(data["price"].(string), 64)
+			old_size, new_size, price := m.number("old_size"), m.number("new_size"), m.number("price")
+			side := m.text("side")
+			if !m.ok {
+				return nil
+			}
 			size_delta := old_size - new_size
 
 			book.Match(map[string]interface{}{
 				"size":           size_delta,
 				"price":          price,
-				"side":           data["side"].(string),
-				"maker_order_id": data["order_id"].(string),
+				"side":           side,
+				"maker_order_id": id,
 				//"time":           data["time"].(string),
 			}, true)
 		}
@@ -151,8 +208,16 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 	if c.dbEnabled {
 		batch := c.BatchWrite[book.ID]
 		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
 		if trade != nil {
-			batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, PackTrade(trade))
+			batch.Write(now, PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
 		}
 
 		if batch.NextSync(now) {
@@ -164,42 +229,86 @@ func (c *Client) HandleMessage(book *orderbook.Book, header PacketHeader, messag
 			}
 		}
 	}
+	return nil
 }
 
-func (c *Client) WriteDiff(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
 	diff := book.Diff
 	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
 		pkt := PackDiff(batch.LastDiffSeq, book.Sequence, diff)
-		batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, pkt)
+		batch.Write(now, pkt)
+		batch.Write(now, PackOrders(book, diff))
 		book.ResetDiff()
 		batch.LastDiffSeq = book.Sequence + 1
 	}
 }
 
-func (c *Client) WriteSync(batch *util.BookBatchWrite, book *orderbook.Book, now time.Time) {
-	batch.Write(c.DB, now, book.ProductInfo.DatabaseKey, PackSync(book))
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, PackSync(book))
+	batch.Write(now, PackLevelAges(book))
+	batch.Write(now, PackOrders(book, nil))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+func (c *Client) WriteDegradedSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, PackDegradedSync(book))
+	batch.Write(now, PackLevelAges(book))
+	batch.Write(now, PackOrders(book, nil))
 	book.ResetDiff()
 	batch.LastDiffSeq = book.Sequence + 1
 }
 
+// wait for queued messages before the books get resynced or polled
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
+// Run records until every product ended or Stop.
 func (c *Client) Run() {
-	for {
+	for len(c.Products) > 0 && !c.stopper.Stopped() {
 		c.run()
 	}
+	if len(c.Products) == 0 {
+		fmt.Println("GDAX: every product ended, feed stopped")
+	}
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
 }
 
 func (c *Client) run() {
 	if err := c.Connect(); err != nil {
+		c.FailedConnects += 1
+		if common.Schedule.Wait("GDAX") {
+			return
+		}
 		fmt.Println("failed to connect", err)
-		time.Sleep(1000 * time.Millisecond)
+		if c.FailedConnects >= 3 && c.PollInterval > 0 {
+			// websocket is down, keep recording coarse snapshots until it is back
+			c.PollBooks()
+			time.Sleep(c.PollInterval)
+		} else {
+			time.Sleep(1000 * time.Millisecond)
+		}
 		return
 	}
 	defer c.Socket.Close()
+	defer c.flushShards()
 
 	for {
 		msgType, message, err := c.Socket.ReadMessage()
 		if err != nil {
 			log.Println("read:", err)
+			if time.Since(c.ConnectedAt).Seconds() < 30.0 {
+				c.FailedConnects += 1
+			} else {
+				c.FailedConnects = 0
+			}
 			return
 		}
 
@@ -207,37 +316,82 @@ func (c *Client) run() {
 			continue
 		}
 
-		var header PacketHeader
-		if err := json.Unmarshal(message, &header); err != nil {
-			log.Println("header-parse:", err)
-			continue
+		common.Capture("GDAX", message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
 		}
+	}
+}
 
-		var book *orderbook.Book
-		var ok bool
-		if book, ok = c.Books[header.ProductID]; !ok {
-			log.Println("book not found", header.ProductID)
-			continue
-		}
+func (c *Client) HandleRaw(message []byte) error {
+	var header PacketHeader
+	if err := json.Unmarshal(message, &header); err != nil {
+		return fmt.Errorf("header-parse: %s", err)
+	}
+	if header.Type == "error" {
+		return c.subscribeFailed(message)
+	}
+
+	var book *orderbook.Book
+	var ok bool
+	if book, ok = c.Books[header.ProductID]; !ok {
+		return fmt.Errorf("book not found %s", header.ProductID)
+	}
 
+	c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+		if book.Sequence == 0 && c.Replay {
+			book.Sequence = header.Sequence - 1
+		}
 		if book.Sequence == 0 {
-			c.SyncBook(book)
-			continue
+			if err := c.SyncBook(book); err != nil {
+				fmt.Println("sync", book.ID, err)
+			}
+			return
 		}
 
 		if header.Sequence <= book.Sequence {
 			// Ignore old messages
-			continue
+			return
 		}
 
 		if header.Sequence != (book.Sequence + 1) {
 			// Message lost, resync
-			c.SyncBook(book)
-			continue
+			fmt.Println(common.SequenceGap("%s expected %d got %d", book.ID, book.Sequence+1, header.Sequence))
+			if !c.Replay {
+				if err := c.SyncBook(book); err != nil {
+					fmt.Println("sync", book.ID, err)
+				}
+				return
+			}
 		}
 
 		book.Sequence = header.Sequence
 
-		c.HandleMessage(book, header, message)
-	}
+		if err := c.HandleMessage(book, header, message); err != nil {
+			fmt.Println(err)
+		}
+	})
+	return nil
+}
+
+// fields reads the fields of a full channel message, after a malformed
+// one ok is false and the message is dropped.
+type fields struct {
+	kind string
+	data map[string]interface{}
+	ok   bool
+}
+
+func (m *fields) number(field string) float64 {
+	value, ok := common.QuotedNumber("GDAX", m.kind+"."+field, m.data[field])
+	m.ok = m.ok && ok
+	return value
+}
+
+func (m *fields) text(field string) string {
+	value, ok := common.String("GDAX", m.kind+"."+field, m.data[field])
+	m.ok = m.ok && ok
+	return value
 }
@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+)
+
+// subscribeFailed handles the error the feed answers a subscribe with when
+// a product is unknown, e.g.
+// {"type":"error","message":"Failed to subscribe","reason":"BCH-USD is delisted"}.
+// Nothing is subscribed then, the product ends after common.DelistStrikes
+// of them and the others are subscribed again.
+func (c *Client) subscribeFailed(message []byte) error {
+	var data struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.Unmarshal(message, &data); err != nil {
+		return common.Protocol("error-parse: %s", err)
+	}
+	for _, name := range c.Products {
+		if !strings.Contains(data.Reason, name) {
+			continue
+		}
+		if c.strikes.Fail(name) {
+			c.endProduct(name, data.Reason)
+		}
+		return common.ErrReconnect
+	}
+	return common.Protocol("%s: %s", data.Message, data.Reason)
+}
+
+// endProduct stops the feed of a delisted product, called by the read loop.
+func (c *Client) endProduct(name, reason string) {
+	book, ok := c.Books[name]
+	if !ok {
+		return
+	}
+	c.Shards.Flush(book.ProductInfo.DatabaseKey)
+	if c.dbEnabled && book.Sequence != 0 {
+		c.WriteDiff(c.BatchWrite[name], book, time.Now())
+	}
+	if err := c.BatchWrite[name].Flush(); err != nil {
+		fmt.Println("HandleMessage DB Error", err)
+	}
+	delete(c.Books, name)
+	c.RemoveBook(name)
+	products := []string{}
+	for _, product := range c.Products {
+		if product != name {
+			products = append(products, product)
+		}
+	}
+	c.Products = products
+	common.EndRecording(c.DB, &book.ProductInfo, reason, "")
+}
@@ -31,8 +31,16 @@ func PackDiff(first, last uint64, diff *orderbook.BookLevelDiff) []byte {
 }
 
 func PackSync(book *orderbook.Book) []byte {
+	return packSync(db_orderbook.SyncPacket, book)
+}
+
+func PackDegradedSync(book *orderbook.Book) []byte {
+	return packSync(db_orderbook.DegradedSyncPacket, book)
+}
+
+func packSync(packetType uint8, book *orderbook.Book) []byte {
 	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, db_orderbook.SyncPacket)
+	binary.Write(buf, binary.LittleEndian, packetType)
 	binary.Write(buf, binary.LittleEndian, uint64(book.Sequence))
 
 	binary.Write(buf, binary.LittleEndian, uint64(len(book.Bid)))
@@ -55,9 +63,58 @@ func PackSync(book *orderbook.Book) []byte {
 		binary.Write(buf, binary.LittleEndian, size) // size
 	}
 
+	if book.Snapshot != nil {
+		buf.Write(db_orderbook.PackSyncProvenance(book.Snapshot))
+	}
+
 	return buf.Bytes()
 }
 
+func PackLevelAges(book *orderbook.Book) []byte {
+	return db_orderbook.PackLevelAges(levelAges(book.Bid), levelAges(book.Ask))
+}
+
+func levelAges(levels map[float64]*orderbook.BookLevel) []db_orderbook.LevelAge {
+	ages := make([]db_orderbook.LevelAge, 0, len(levels))
+	for _, level := range levels {
+		ages = append(ages, db_orderbook.LevelAge{Price: level.Price, FirstSeen: level.FirstSeen})
+	}
+	return ages
+}
+
+// PackOrders packs the orders of the levels in diff, every level when diff
+// is nil.
+func PackOrders(book *orderbook.Book, diff *orderbook.BookLevelDiff) []byte {
+	if diff == nil {
+		return db_orderbook.PackOrders(orderSizes(book.Bid, nil), orderSizes(book.Ask, nil))
+	}
+	return db_orderbook.PackOrders(orderSizes(book.Bid, diff.Bid), orderSizes(book.Ask, diff.Ask))
+}
+
+// orderSizes returns the order sizes of the changed levels, removed ones
+// without orders.
+func orderSizes(levels map[float64]*orderbook.BookLevel, changed []*orderbook.LevelDiff) map[float64][]float64 {
+	sizes := map[float64][]float64{}
+	add := func(price float64) {
+		orders := []float64{}
+		if level, ok := levels[price]; ok {
+			for _, order := range level.Orders {
+				orders = append(orders, order.Size)
+			}
+		}
+		sizes[price] = orders
+	}
+	if changed == nil {
+		for price := range levels {
+			add(price)
+		}
+	}
+	for _, state := range changed {
+		add(state.Price)
+	}
+	return sizes
+}
+
 func PackTrade(trade *orderbook.Order) []byte {
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, db_orderbook.TradePacket)
@@ -65,5 +122,7 @@ func PackTrade(trade *orderbook.Order) []byte {
 	binary.Write(buf, binary.LittleEndian, uint8(trade.Side)) // side
 	binary.Write(buf, binary.LittleEndian, trade.Price)       // price
 	binary.Write(buf, binary.LittleEndian, trade.Size)        // size
+	// match messages carry the maker side
+	binary.Write(buf, binary.LittleEndian, uint8(db_orderbook.SideFromVenue))
 	return buf.Bytes()
 }
@@ -4,58 +4,91 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
-	"strconv"
 	"time"
 
+	"github.com/lian/gdax-bookmap/exchanges/common"
 	"github.com/lian/gdax-bookmap/exchanges/gdax/orderbook"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
 )
 
 func (c *Client) SyncBook(book *orderbook.Book) error {
 	fmt.Println("sync", book.ID)
 
+	if err := c.loadSnapshot(book); err != nil {
+		return err
+	}
+
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		now := time.Now()
+		batch.ResetWarmUp()
+		fmt.Println("STORE INIT SYNC", book.ID, batch.Count)
+		c.WriteSync(batch, book, now)
+	}
+
+	return nil
+}
+
+// PollBooks records a snapshot of every book as degraded sync packet, used
+// while the websocket is unavailable. The sequence of the snapshot makes
+// the first message after the reconnect sync the book again.
+func (c *Client) PollBooks() {
+	for _, book := range c.Books {
+		if err := c.loadSnapshot(book); err != nil {
+			fmt.Println("poll", book.ID, err)
+			continue
+		}
+		if c.dbEnabled {
+			batch := c.BatchWrite[book.ID]
+			batch.ResetWarmUp()
+			fmt.Println("STORE DEGRADED SYNC", book.ID, book.Sequence)
+			c.WriteDegradedSync(batch, book, time.Now())
+		}
+	}
+}
+
+// loadSnapshot replaces the book with the level 3 REST snapshot.
+func (c *Client) loadSnapshot(book *orderbook.Book) error {
 	full, err := FetchRawBook(3, book.ID)
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
 
-	if seq, ok := full["sequence"]; ok {
-		book.Clear()
-		book.Sequence = uint64(seq.(float64))
-
-		if bids, ok := full["bids"].([]interface{}); ok {
-			for i := len(bids) - 1; i >= 0; i-- {
-				data := bids[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				size, _ := strconv.ParseFloat(data[1].(string), 64)
-				book.Add(map[string]interface{}{
-					"id":    data[2].(string),
-					"side":  "buy",
-					"price": price,
-					"size":  size,
-				})
+	seq, ok := full["sequence"]
+	if !ok {
+		return common.Protocol("%s book without sequence", book.ID)
+	}
+
+	book.Clear()
+	book.Sequence = uint64(seq.(float64))
+	book.Snapshot = &db_orderbook.SyncProvenance{Sequence: book.Sequence, Fetched: time.Now()}
+
+	if bids, ok := full["bids"].([]interface{}); ok {
+		for i := len(bids) - 1; i >= 0; i-- {
+			price, size, id, ok := snapshotOrder("book.bids", bids[i])
+			if !ok {
+				continue
 			}
+			book.Add(map[string]interface{}{
+				"id":    id,
+				"side":  "buy",
+				"price": price,
+				"size":  size,
+			})
 		}
-		if asks, ok := full["asks"].([]interface{}); ok {
-			for i := len(asks) - 1; i >= 0; i-- {
-				data := asks[i].([]interface{})
-				price, _ := strconv.ParseFloat(data[0].(string), 64)
-				size, _ := strconv.ParseFloat(data[1].(string), 64)
-				book.Add(map[string]interface{}{
-					"id":    data[2].(string),
-					"side":  "sell",
-					"price": price,
-					"size":  size,
-				})
+	}
+	if asks, ok := full["asks"].([]interface{}); ok {
+		for i := len(asks) - 1; i >= 0; i-- {
+			price, size, id, ok := snapshotOrder("book.asks", asks[i])
+			if !ok {
+				continue
 			}
-		}
-
-		if c.dbEnabled {
-			batch := c.BatchWrite[book.ID]
-			now := time.Now()
-			fmt.Println("STORE INIT SYNC", book.ID, batch.Count)
-			c.WriteSync(batch, book, now)
+			book.Add(map[string]interface{}{
+				"id":    id,
+				"side":  "sell",
+				"price": price,
+				"size":  size,
+			})
 		}
 	}
 
@@ -64,20 +97,37 @@ func (c *Client) SyncBook(book *orderbook.Book) error {
 
 func FetchRawBook(level int, product string) (map[string]interface{}, error) {
 	url := fmt.Sprintf("https://api.gdax.com/products/%s/book?level=%d", product, level)
-	res, err := http.Get(url)
+	res, err := common.Get("GDAX", url)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return nil, err
+	}
 
 	var data map[string]interface{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return nil, err
+		return nil, common.Protocol("book: %s", err)
 	}
 
 	return data, nil
 }
+
+// snapshotOrder reads an order of the level 3 book, [price, size, order_id].
+func snapshotOrder(field string, v interface{}) (price, size float64, id string, ok bool) {
+	if price, size, ok = common.QuotedLevel("GDAX", field, v); !ok {
+		return
+	}
+	var value interface{}
+	if order := v.([]interface{}); len(order) > 2 {
+		value = order[2]
+	}
+	id, ok = common.String("GDAX", field+".order_id", value)
+	return
+}
@@ -0,0 +1,105 @@
+package product_info
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// gemini has no listing with the details of every symbol, so they are
+// fetched per product when it is first added
+var mu sync.Mutex
+var CachedInfo map[string]product_info.Info
+
+func init() {
+	FetchAllProductInfo()
+}
+
+// FetchAllProductInfo forgets the fetched details, e.g. after the endpoints
+// were overridden.
+func FetchAllProductInfo() {
+	mu.Lock()
+	defer mu.Unlock()
+	CachedInfo = map[string]product_info.Info{}
+}
+
+// Symbol is the symbol of a product on gemini, e.g. BTCUSD for BTC-USD.
+func Symbol(id string) string {
+	return strings.ToUpper(strings.Replace(id, "-", "", -1))
+}
+
+// FetchProductInfo returns the details of a product, e.g. BTC-USD, an
+// empty Info when gemini does not list it.
+func FetchProductInfo(id string) product_info.Info {
+	mu.Lock()
+	defer mu.Unlock()
+	if info, ok := CachedInfo[id]; ok {
+		return info
+	}
+	info, err := fetchDetails(id)
+	if err != nil {
+		fmt.Println("InitProduct error", id, err)
+		return product_info.Info{}
+	}
+	CachedInfo[id] = info
+	return info
+}
+
+func fetchDetails(id string) (product_info.Info, error) {
+	url := "https://api.gemini.com/v1/symbols/details/" + strings.ToLower(Symbol(id))
+	res, err := common.Get("Gemini", url)
+	if err != nil {
+		return product_info.Info{}, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return product_info.Info{}, err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return product_info.Info{}, err
+	}
+
+	var data struct {
+		Symbol         string      `json:"symbol"`
+		BaseCurrency   string      `json:"base_currency"`
+		QuoteCurrency  string      `json:"quote_currency"`
+		QuoteIncrement float64     `json:"quote_increment"`
+		MinOrderSize   interface{} `json:"min_order_size"`
+		Status         string      `json:"status"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return product_info.Info{}, common.Protocol("symbol details: %s", err)
+	}
+	if data.Symbol == "" {
+		return product_info.Info{}, common.Protocol("symbol details without symbol: %s", string(body))
+	}
+
+	base, quote := strings.ToUpper(data.BaseCurrency), strings.ToUpper(data.QuoteCurrency)
+	info := product_info.Info{
+		ID:             data.Symbol,
+		DisplayName:    fmt.Sprintf("%s-%s", base, quote),
+		BaseCurrency:   base,
+		QuoteCurrency:  quote,
+		Platform:       "Gemini",
+		DatabaseKey:    fmt.Sprintf("Gemini-%s-%s", base, quote),
+		QuoteIncrement: product_info.FloatString(data.QuoteIncrement),
+		FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(data.QuoteIncrement)),
+	}
+	// sent as string or number
+	if s, ok := data.MinOrderSize.(string); ok {
+		min, _ := strconv.ParseFloat(s, 64)
+		info.BaseMinSize = product_info.FloatString(min)
+	} else if min, ok := data.MinOrderSize.(float64); ok {
+		info.BaseMinSize = product_info.FloatString(min)
+	}
+	return info, nil
+}
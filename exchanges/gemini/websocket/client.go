@@ -0,0 +1,355 @@
+package websocket
+
+// api: https://docs.gemini.com/websocket-api/#market-data-version-2
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	book_info "github.com/lian/gdax-bookmap/exchanges/gemini/product_info"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	// the l2 updates carry no sequence numbers, a connection either
+	// delivers all of them or drops
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:         "Gemini",
+		DynamicSubscribe: true,
+	})
+	exchanges.Register("Gemini", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		c := New(s.DB, s.ProductsOr("BTC-USD", "ETH-USD"))
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		return c, c.Infos
+	})
+}
+
+type Client struct {
+	exchanges.BookIndex
+	Platform string
+	Socket   *websocket.Conn
+	// every write to Socket goes through it
+	Writer   *common.Writer
+	Products []string
+	// by symbol, e.g. BTCUSD
+	Books             map[string]*orderbook.Book
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	Shards            *util.Shards
+	stopper           common.Stopper
+}
+
+func New(db *bolt.DB, products []string) *Client {
+	c := &Client{
+		Platform:   "Gemini",
+		Products:   []string{},
+		Books:      map[string]*orderbook.Book{},
+		BatchWrite: map[string]*storage.BookWriter{},
+		DB:         db,
+		Infos:      []*product_info.Info{},
+	}
+	if c.DB != nil {
+		c.dbEnabled = true
+	}
+
+	for _, name := range products {
+		c.AddProduct(name)
+	}
+
+	if c.dbEnabled {
+		buckets := []string{}
+		for _, info := range c.Infos {
+			buckets = append(buckets, info.DatabaseKey)
+		}
+		util.CreateBucketsDB(c.DB, buckets)
+	}
+
+	return c
+}
+
+// AddProduct adds a pair by its currencies, e.g. BTC-USD.
+func (c *Client) AddProduct(name string) {
+	info := book_info.FetchProductInfo(name)
+	if info.ID == "" {
+		fmt.Println(c.Platform, "unknown product", name)
+		return
+	}
+	c.Products = append(c.Products, name)
+	book := orderbook.New(name)
+	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
+	book.SetProductInfo(info)
+	c.Books[info.ID] = book
+	c.AddBook(book.ID, book)
+}
+
+func (c *Client) Connect() error {
+	url := "wss://api.gemini.com/v2/marketdata"
+	fmt.Println("connect to websocket", url)
+	s, _, err := common.Dial(c.Platform, url)
+	if err != nil {
+		return err
+	}
+
+	c.Socket = s
+	c.stopper.Connected(s)
+	c.Writer = common.NewWriter(c.Platform, s, 64)
+	c.ConnectedAt = time.Now()
+
+	symbols := []string{}
+	for symbol, book := range c.Books {
+		// the first l2_updates of the new subscription syncs it again
+		book.Synced = false
+		symbols = append(symbols, symbol)
+	}
+	// l2 also streams the trades of the symbols
+	subscribe := map[string]interface{}{
+		"type": "subscribe",
+		"subscriptions": []map[string]interface{}{
+			{"name": "l2", "symbols": symbols},
+		},
+	}
+	if err := c.Writer.WriteJSON(subscribe); err != nil {
+		s.Close()
+		c.Writer.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	diff := book.Diff
+	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
+		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		batch.Write(now, pkt)
+		book.ResetDiff()
+		batch.LastDiffSeq = book.Sequence + 1
+	}
+}
+
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+// wait for queued messages before the books get resubscribed
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
+func (c *Client) Run() {
+	for !c.stopper.Stopped() {
+		c.run()
+	}
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
+func (c *Client) run() {
+	if err := c.Connect(); err != nil {
+		if common.Schedule.Wait(c.Platform) {
+			return
+		}
+		fmt.Println("failed to connect", err)
+		time.Sleep(1000 * time.Millisecond)
+		return
+	}
+
+	defer c.Socket.Close()
+	defer c.Writer.Close()
+	defer c.flushShards()
+
+	for {
+		msgType, message, err := c.Socket.ReadMessage()
+		if err != nil {
+			log.Println("read:", err)
+			return
+		}
+
+		if msgType != websocket.TextMessage {
+			continue
+		}
+
+		common.Capture(c.Platform, message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+type marketMessage struct {
+	Type   string `json:"type"`
+	Symbol string `json:"symbol"`
+	Reason string `json:"reason"`
+	// [side, price, quantity] of l2_updates, quantity 0 removes the level
+	Changes [][]interface{} `json:"changes"`
+	// fields of trade
+	Price    interface{} `json:"price"`
+	Quantity interface{} `json:"quantity"`
+	Side     string      `json:"side"`
+}
+
+func (c *Client) HandleRaw(message []byte) error {
+	var msg marketMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("PacketHeader-parse: %s", err)
+	}
+
+	switch msg.Type {
+	case "l2_updates", "trade":
+	case "error":
+		return common.Protocol("%s error %s", c.Platform, msg.Reason)
+	default:
+		// heartbeat, candles and auction events
+		return nil
+	}
+
+	book, ok := c.Books[msg.Symbol]
+	if !ok {
+		return fmt.Errorf("%s message of unknown symbol %q", c.Platform, msg.Symbol)
+	}
+	c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+		if err := c.HandleMessage(book, &msg); err != nil {
+			fmt.Println(err)
+		}
+	})
+	return nil
+}
+
+func (c *Client) HandleMessage(book *orderbook.Book, msg *marketMessage) error {
+	now := time.Now()
+
+	trades := []*orderbook.Trade{}
+
+	switch msg.Type {
+	case "l2_updates":
+		bids, asks := c.changes(msg.Changes)
+
+		if !book.Synced {
+			// the first l2_updates of a subscription is the whole book, its
+			// trades are the latest ones from before the subscription and
+			// are left out
+			if book.Empty() {
+				book.Clear()
+				book.Sequence = uint64(0)
+			} else if c.dbEnabled {
+				// reconnected
+				c.BatchWrite[book.ID].ResetWarmUp()
+			}
+			// on reconnect only the levels which changed end up in the diff
+			book.ApplySnapshot(now, bids, asks)
+			book.Synced = true
+			break
+		}
+		for _, level := range bids {
+			book.UpdateBidLevel(now, level.Price, level.Size)
+		}
+		for _, level := range asks {
+			book.UpdateAskLevel(now, level.Price, level.Size)
+		}
+
+	case "trade":
+		if !book.Synced {
+			return nil
+		}
+		price, ok := common.QuotedNumber(c.Platform, "trade.price", msg.Price)
+		if !ok {
+			return nil
+		}
+		size, ok := common.QuotedNumber(c.Platform, "trade.quantity", msg.Quantity)
+		if !ok {
+			return nil
+		}
+		// side of the taker
+		if msg.Side == "sell" {
+			book.AddClassifiedTrade(now, uint8(orderbook.BidSide), db_orderbook.SideFromVenue, price, size)
+		} else {
+			book.AddClassifiedTrade(now, uint8(orderbook.AskSide), db_orderbook.SideFromVenue, price, size)
+		}
+		trades = append(trades, book.Trades[len(book.Trades)-1])
+
+	default:
+		return common.Protocol("unkown message type %s", msg.Type)
+	}
+
+	book.Sequence += 1
+
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
+		for _, trade := range trades {
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
+		}
+
+		if batch.NextSync(now) {
+			fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
+			c.WriteSync(batch, book, now)
+		} else {
+			if batch.NextDiff(now) {
+				c.WriteDiff(batch, book, now)
+			}
+		}
+	}
+	return nil
+}
+
+// changes splits [side, price, quantity] changes into bid and ask levels.
+func (c *Client) changes(list [][]interface{}) ([]*orderbook.BookLevel, []*orderbook.BookLevel) {
+	bids := []*orderbook.BookLevel{}
+	asks := []*orderbook.BookLevel{}
+	for _, values := range list {
+		if len(values) < 3 {
+			continue
+		}
+		price, ok := common.QuotedNumber(c.Platform, "changes.price", values[1])
+		if !ok {
+			continue
+		}
+		size, ok := common.QuotedNumber(c.Platform, "changes.quantity", values[2])
+		if !ok {
+			continue
+		}
+		level := &orderbook.BookLevel{Price: price, Size: size}
+		switch side, _ := values[0].(string); side {
+		case "buy":
+			bids = append(bids, level)
+		case "sell":
+			asks = append(asks, level)
+		}
+	}
+	return bids, asks
+}
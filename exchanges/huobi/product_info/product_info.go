@@ -0,0 +1,102 @@
+package product_info
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+)
+
+var CachedInfo map[string]product_info.Info
+
+// CachedSymbols are the lower case symbols of the products on the
+// websocket, e.g. btcusdt for BTC-USDT.
+var CachedSymbols map[string]string
+
+func init() {
+	FetchAllProductInfo()
+}
+
+func FetchAllProductInfo() {
+	CachedInfo = map[string]product_info.Info{}
+	CachedSymbols = map[string]string{}
+
+	res, err := common.Get("Huobi", "https://api.huobi.pro/v1/common/symbols")
+	if err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+
+	var data struct {
+		Status  string `json:"status"`
+		ErrMsg  string `json:"err-msg"`
+		Symbols []struct {
+			Symbol          string  `json:"symbol"`
+			BaseCurrency    string  `json:"base-currency"`
+			QuoteCurrency   string  `json:"quote-currency"`
+			PricePrecision  int     `json:"price-precision"`
+			AmountPrecision int     `json:"amount-precision"`
+			MinOrderAmt     float64 `json:"min-order-amt"`
+			State           string  `json:"state"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+	if data.Status != "ok" {
+		fmt.Println("InitProduct error", data.Status, data.ErrMsg)
+		return
+	}
+
+	for _, s := range data.Symbols {
+		if s.State != "" && s.State != "online" {
+			continue
+		}
+		base, quote := strings.ToUpper(s.BaseCurrency), strings.ToUpper(s.QuoteCurrency)
+
+		info := product_info.Info{
+			ID:             fmt.Sprintf("%s-%s", base, quote),
+			DisplayName:    fmt.Sprintf("%s-%s", base, quote),
+			BaseCurrency:   base,
+			QuoteCurrency:  quote,
+			Platform:       "Huobi",
+			DatabaseKey:    fmt.Sprintf("Huobi-%s-%s", base, quote),
+			BaseMinSize:    product_info.FloatString(s.MinOrderAmt),
+			QuoteIncrement: product_info.FloatString(math.Pow10(-s.PricePrecision)),
+			FloatFormat:    fmt.Sprintf("%%.%df", s.PricePrecision),
+		}
+
+		CachedInfo[info.DisplayName] = info
+		CachedSymbols[info.DisplayName] = s.Symbol
+	}
+}
+
+func FetchProductInfo(id string) product_info.Info {
+	if info, ok := CachedInfo[id]; ok {
+		return info
+	}
+	return product_info.Info{}
+}
+
+// FetchSymbol returns the websocket symbol of a product, e.g. btcusdt for
+// BTC-USDT.
+func FetchSymbol(id string) (string, bool) {
+	symbol, ok := CachedSymbols[id]
+	return symbol, ok
+}
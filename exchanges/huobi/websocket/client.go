@@ -0,0 +1,383 @@
+package websocket
+
+// api: https://huobiapi.github.io/docs/spot/v1/en/#websocket-market-data
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	book_info "github.com/lian/gdax-bookmap/exchanges/huobi/product_info"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// levels per side of the step0 depth channel
+const bookDepth = 150
+
+func init() {
+	// every frame is gzipped by huobi itself, permessage-deflate is not
+	// offered
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:         "Huobi",
+		MaxDepth:         bookDepth,
+		DynamicSubscribe: true,
+	})
+	exchanges.Register("Huobi", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		c := New(s.DB, s.ProductsOr("BTC-USDT", "ETH-USDT", "BCH-USDT"))
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		return c, c.Infos
+	})
+}
+
+type Client struct {
+	exchanges.BookIndex
+	Platform string
+	Socket   *websocket.Conn
+	// every write to Socket goes through it
+	Writer            *common.Writer
+	Products          []string
+	Books             map[string]*orderbook.Book
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	Shards            *util.Shards
+	stopper           common.Stopper
+}
+
+func New(db *bolt.DB, products []string) *Client {
+	c := &Client{
+		Platform:   "Huobi",
+		Products:   []string{},
+		Books:      map[string]*orderbook.Book{},
+		BatchWrite: map[string]*storage.BookWriter{},
+		DB:         db,
+		Infos:      []*product_info.Info{},
+	}
+	if c.DB != nil {
+		c.dbEnabled = true
+	}
+
+	for _, name := range products {
+		c.AddProduct(name)
+	}
+
+	if c.dbEnabled {
+		buckets := []string{}
+		for _, info := range c.Infos {
+			buckets = append(buckets, info.DatabaseKey)
+		}
+		util.CreateBucketsDB(c.DB, buckets)
+	}
+
+	return c
+}
+
+func (c *Client) AddProduct(name string) {
+	symbol, ok := book_info.FetchSymbol(name)
+	if !ok {
+		fmt.Println(c.Platform, "unknown product", name)
+		return
+	}
+	c.Products = append(c.Products, name)
+	book := orderbook.New(name)
+	info := book_info.FetchProductInfo(name)
+	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
+	book.SetProductInfo(info)
+	// channels name the symbol, e.g. market.btcusdt.depth.step0
+	c.Books[symbol] = book
+	c.AddBook(book.ID, book)
+}
+
+func (c *Client) Connect() error {
+	url := "wss://api.huobi.pro/ws"
+	fmt.Println("connect to websocket", url)
+	s, _, err := common.Dial(c.Platform, url)
+	if err != nil {
+		return err
+	}
+
+	c.Socket = s
+	c.stopper.Connected(s)
+	c.Writer = common.NewWriter(c.Platform, s, 64)
+	c.ConnectedAt = time.Now()
+
+	// one subscription per message, the id is echoed in the reply
+	for symbol, book := range c.Books {
+		book.Synced = false
+		for _, topic := range []string{"market.%s.depth.step0", "market.%s.trade.detail"} {
+			sub := fmt.Sprintf(topic, symbol)
+			if err := c.Writer.WriteJSON(map[string]string{"sub": sub, "id": sub}); err != nil {
+				s.Close()
+				c.Writer.Close()
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	diff := book.Diff
+	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
+		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		batch.Write(now, pkt)
+		book.ResetDiff()
+		batch.LastDiffSeq = book.Sequence + 1
+	}
+}
+
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+// wait for queued messages before the books get resubscribed
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
+func (c *Client) Run() {
+	for !c.stopper.Stopped() {
+		c.run()
+	}
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
+func (c *Client) run() {
+	if err := c.Connect(); err != nil {
+		if common.Schedule.Wait(c.Platform) {
+			return
+		}
+		fmt.Println("failed to connect", err)
+		time.Sleep(1000 * time.Millisecond)
+		return
+	}
+
+	defer c.Socket.Close()
+	defer c.Writer.Close()
+	defer c.flushShards()
+
+	for {
+		msgType, message, err := c.Socket.ReadMessage()
+		if err != nil {
+			log.Println("read:", err)
+			return
+		}
+
+		if msgType != websocket.BinaryMessage {
+			continue
+		}
+		// captured unzipped, so replays pass plain json to HandleRaw
+		message, err = gunzip(message)
+		if err != nil {
+			log.Println(c.Platform, "gunzip:", err)
+			return
+		}
+
+		common.Capture(c.Platform, message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func gunzip(frame []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+type pushMessage struct {
+	Ping    int64           `json:"ping"`
+	Status  string          `json:"status"`
+	Subbed  string          `json:"subbed"`
+	ErrCode string          `json:"err-code"`
+	ErrMsg  string          `json:"err-msg"`
+	Ch      string          `json:"ch"`
+	Tick    json.RawMessage `json:"tick"`
+}
+
+type depthTick struct {
+	Bids [][]interface{} `json:"bids"`
+	Asks [][]interface{} `json:"asks"`
+}
+
+type tradeTick struct {
+	Data []struct {
+		Price     interface{} `json:"price"`
+		Amount    interface{} `json:"amount"`
+		Direction string      `json:"direction"`
+	} `json:"data"`
+}
+
+// HandleRaw handles one unzipped message of the websocket, ErrReconnect
+// asks for a new connection.
+func (c *Client) HandleRaw(message []byte) error {
+	var msg pushMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("PacketHeader-parse: %s", err)
+	}
+
+	switch {
+	case msg.Ping != 0:
+		// the connection is closed after two unanswered pings, the pong
+		// echoes the timestamp of the server
+		if c.Writer != nil {
+			if err := c.Writer.Send(map[string]int64{"pong": msg.Ping}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case msg.Status == "error":
+		return common.Protocol("%s error %s %s", c.Platform, msg.ErrCode, msg.ErrMsg)
+	case msg.Subbed != "":
+		log.Printf("%s Subscribed to %s: %s\n", c.Platform, msg.Subbed, msg.Status)
+		return nil
+	case msg.Ch == "":
+		return nil
+	}
+
+	// market.<symbol>.<channel>...
+	parts := strings.SplitN(msg.Ch, ".", 3)
+	if len(parts) != 3 {
+		return common.Protocol("%s unkown channel %s", c.Platform, msg.Ch)
+	}
+	book, ok := c.Books[parts[1]]
+	if !ok {
+		return fmt.Errorf("%s message of unknown symbol %q", c.Platform, parts[1])
+	}
+	c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+		if err := c.HandleMessage(book, parts[2], msg.Tick); err != nil {
+			fmt.Println(err)
+		}
+	})
+	return nil
+}
+
+func (c *Client) HandleMessage(book *orderbook.Book, channel string, tick json.RawMessage) error {
+	now := time.Now()
+
+	trades := []*orderbook.Trade{}
+
+	switch {
+	case strings.HasPrefix(channel, "depth."):
+		var data depthTick
+		if err := json.Unmarshal(tick, &data); err != nil {
+			return common.Protocol("%s depth %s", c.Platform, err)
+		}
+		// every push is the whole top of the book, only the levels which
+		// changed end up in the diff
+		if book.Empty() {
+			book.Clear()
+			book.Sequence = uint64(0)
+		}
+		book.ApplySnapshot(now, c.levels("depth.bids", data.Bids), c.levels("depth.asks", data.Asks))
+		book.Synced = true
+
+	case channel == "trade.detail":
+		var data tradeTick
+		if err := json.Unmarshal(tick, &data); err != nil {
+			return common.Protocol("%s trade %s", c.Platform, err)
+		}
+		for _, t := range data.Data {
+			price, ok := common.Number(c.Platform, "trade.price", t.Price)
+			if !ok {
+				continue
+			}
+			size, ok := common.Number(c.Platform, "trade.amount", t.Amount)
+			if !ok {
+				continue
+			}
+			if t.Direction == "sell" {
+				book.AddClassifiedTrade(now, uint8(orderbook.BidSide), db_orderbook.SideFromVenue, price, size)
+			} else {
+				book.AddClassifiedTrade(now, uint8(orderbook.AskSide), db_orderbook.SideFromVenue, price, size)
+			}
+			trades = append(trades, book.Trades[len(book.Trades)-1])
+		}
+
+	default:
+		return common.Protocol("unkown channel %s", channel)
+	}
+
+	book.Sequence += 1
+
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
+		for _, trade := range trades {
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
+		}
+
+		if batch.NextSync(now) {
+			fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
+			c.WriteSync(batch, book, now)
+		} else {
+			if batch.NextDiff(now) {
+				c.WriteDiff(batch, book, now)
+			}
+		}
+	}
+	return nil
+}
+
+// levels reads [price, amount] levels.
+func (c *Client) levels(field string, list [][]interface{}) []*orderbook.BookLevel {
+	levels := []*orderbook.BookLevel{}
+	for _, values := range list {
+		if len(values) < 2 {
+			continue
+		}
+		price, ok := common.Number(c.Platform, field+".price", values[0])
+		if !ok {
+			continue
+		}
+		size, ok := common.Number(c.Platform, field+".amount", values[1])
+		if !ok {
+			continue
+		}
+		levels = append(levels, &orderbook.BookLevel{Price: price, Size: size})
+	}
+	return levels
+}
@@ -0,0 +1,126 @@
+package product_info
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+)
+
+// Pair is what the websocket client needs beyond the Info of a product,
+// the name of the pair on the websocket and the decimals its prices and
+// volumes are formatted with for the book checksums.
+type Pair struct {
+	WSName        string
+	PriceDecimals int
+	LotDecimals   int
+}
+
+var CachedInfo map[string]product_info.Info
+var CachedPairs map[string]Pair
+
+// kraken names some currencies differently
+var currencies = map[string]string{
+	"XBT": "BTC",
+	"XDG": "DOGE",
+}
+
+func init() {
+	FetchAllProductInfo()
+}
+
+func FetchAllProductInfo() {
+	CachedInfo = map[string]product_info.Info{}
+	CachedPairs = map[string]Pair{}
+
+	res, err := common.Get("Kraken", "https://api.kraken.com/0/public/AssetPairs")
+	if err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+
+	var data struct {
+		Error  []string `json:"error"`
+		Result map[string]struct {
+			WSName       string `json:"wsname"`
+			PairDecimals int    `json:"pair_decimals"`
+			LotDecimals  int    `json:"lot_decimals"`
+			OrderMin     string `json:"ordermin"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		fmt.Println("InitProduct error", err)
+		return
+	}
+	if len(data.Error) > 0 {
+		fmt.Println("InitProduct error", strings.Join(data.Error, ", "))
+		return
+	}
+
+	for _, pair := range data.Result {
+		// dark pool pairs (.d) have no wsname
+		names := strings.Split(pair.WSName, "/")
+		if len(names) != 2 {
+			continue
+		}
+		base, quote := currency(names[0]), currency(names[1])
+
+		info := product_info.Info{
+			ID:            fmt.Sprintf("%s-%s", base, quote),
+			DisplayName:   fmt.Sprintf("%s-%s", base, quote),
+			BaseCurrency:  base,
+			QuoteCurrency: quote,
+			Platform:      "Kraken",
+			DatabaseKey:   fmt.Sprintf("Kraken-%s-%s", base, quote),
+		}
+
+		t, _ := strconv.ParseFloat(pair.OrderMin, 64)
+		info.BaseMinSize = product_info.FloatString(t)
+		info.QuoteIncrement = product_info.FloatString(math.Pow10(-pair.PairDecimals))
+		info.FloatFormat = fmt.Sprintf("%%.%df", pair.PairDecimals)
+
+		CachedInfo[info.DisplayName] = info
+		CachedPairs[info.DisplayName] = Pair{
+			WSName:        pair.WSName,
+			PriceDecimals: pair.PairDecimals,
+			LotDecimals:   pair.LotDecimals,
+		}
+	}
+}
+
+func currency(name string) string {
+	if c, ok := currencies[name]; ok {
+		return c
+	}
+	return name
+}
+
+func FetchProductInfo(id string) product_info.Info {
+	if info, ok := CachedInfo[id]; ok {
+		return info
+	}
+	return product_info.Info{}
+}
+
+// FetchPair returns the websocket name and decimals of a product, e.g.
+// XBT/USD for BTC-USD.
+func FetchPair(id string) (Pair, bool) {
+	pair, ok := CachedPairs[id]
+	return pair, ok
+}
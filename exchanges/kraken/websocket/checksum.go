@@ -0,0 +1,52 @@
+package websocket
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	book_info "github.com/lian/gdax-bookmap/exchanges/kraken/product_info"
+)
+
+// levels per side the checksums are taken over
+const checksumLevels = 10
+
+// sortedLevels returns the levels of a side best first.
+func sortedLevels(book *orderbook.Book, side orderbook.Side) []*orderbook.BookLevel {
+	levels := append([]*orderbook.BookLevel{}, book.Bid...)
+	if side == orderbook.AskSide {
+		levels = append([]*orderbook.BookLevel{}, book.Ask...)
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if side == orderbook.AskSide {
+			return levels[i].Price < levels[j].Price
+		}
+		return levels[i].Price > levels[j].Price
+	})
+	return levels
+}
+
+// Checksum is the CRC32 kraken sends with book updates, over the price and
+// volume of the best 10 asks and then bids, formatted in the decimals of
+// the pair without the point and leading zeros.
+func Checksum(book *orderbook.Book, pair book_info.Pair) string {
+	var b strings.Builder
+	for _, side := range []orderbook.Side{orderbook.AskSide, orderbook.BidSide} {
+		levels := sortedLevels(book, side)
+		if len(levels) > checksumLevels {
+			levels = levels[:checksumLevels]
+		}
+		for _, level := range levels {
+			b.WriteString(checksumNumber(level.Price, pair.PriceDecimals))
+			b.WriteString(checksumNumber(level.Size, pair.LotDecimals))
+		}
+	}
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(b.String()))), 10)
+}
+
+func checksumNumber(v float64, decimals int) string {
+	s := strings.Replace(strconv.FormatFloat(v, 'f', decimals, 64), ".", "", 1)
+	return strings.TrimLeft(s, "0")
+}
@@ -0,0 +1,432 @@
+package websocket
+
+// api: https://docs.kraken.com/websockets/
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	book_info "github.com/lian/gdax-bookmap/exchanges/kraken/product_info"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// levels per side subscribed, the deepest book kraken sends
+const bookDepth = 1000
+
+func init() {
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:         "Kraken",
+		Checksums:        true,
+		MaxDepth:         bookDepth,
+		DynamicSubscribe: true,
+		Compression:      true,
+	})
+	exchanges.Register("Kraken", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		c := New(s.DB, s.ProductsOr("BTC-USD", "ETH-USD", "BCH-USD"))
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		return c, c.Infos
+	})
+}
+
+type Client struct {
+	exchanges.BookIndex
+	Platform string
+	Socket   *websocket.Conn
+	// every write to Socket goes through it
+	Writer            *common.Writer
+	Products          []string
+	Books             map[string]*orderbook.Book
+	Pairs             map[string]book_info.Pair
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	Shards            *util.Shards
+	stopper           common.Stopper
+}
+
+func New(db *bolt.DB, products []string) *Client {
+	c := &Client{
+		Platform:   "Kraken",
+		Products:   []string{},
+		Books:      map[string]*orderbook.Book{},
+		Pairs:      map[string]book_info.Pair{},
+		BatchWrite: map[string]*storage.BookWriter{},
+		DB:         db,
+		Infos:      []*product_info.Info{},
+	}
+	if c.DB != nil {
+		c.dbEnabled = true
+	}
+
+	for _, name := range products {
+		c.AddProduct(name)
+	}
+
+	if c.dbEnabled {
+		buckets := []string{}
+		for _, info := range c.Infos {
+			buckets = append(buckets, info.DatabaseKey)
+		}
+		util.CreateBucketsDB(c.DB, buckets)
+	}
+
+	return c
+}
+
+func (c *Client) AddProduct(name string) {
+	pair, ok := book_info.FetchPair(name)
+	if !ok {
+		fmt.Println(c.Platform, "unknown product", name)
+		return
+	}
+	c.Products = append(c.Products, name)
+	book := orderbook.New(name)
+	info := book_info.FetchProductInfo(name)
+	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
+	book.SetProductInfo(info)
+	// books and trades arrive by the websocket name of the pair
+	c.Books[pair.WSName] = book
+	c.AddBook(book.ID, book)
+	c.Pairs[pair.WSName] = pair
+}
+
+func (c *Client) Subscribe(name string, pairs []string) error {
+	subscription := map[string]interface{}{"name": name}
+	if name == "book" {
+		subscription["depth"] = bookDepth
+	}
+	return c.Writer.WriteJSON(map[string]interface{}{
+		"event":        "subscribe",
+		"pair":         pairs,
+		"subscription": subscription,
+	})
+}
+
+// resubscribe asks for a new snapshot of a book, the updates in between are
+// skipped.
+func (c *Client) resubscribe(book *orderbook.Book, pair string) {
+	book.Synced = false
+	if c.Writer == nil {
+		// replaying a capture
+		return
+	}
+	subscription := map[string]interface{}{"name": "book", "depth": bookDepth}
+	c.Writer.Send(map[string]interface{}{"event": "unsubscribe", "pair": []string{pair}, "subscription": subscription})
+	c.Writer.Send(map[string]interface{}{"event": "subscribe", "pair": []string{pair}, "subscription": subscription})
+}
+
+func (c *Client) Connect() error {
+	url := "wss://ws.kraken.com"
+	fmt.Println("connect to websocket", url)
+	s, _, err := common.Dial(c.Platform, url)
+	if err != nil {
+		return err
+	}
+
+	c.Socket = s
+	c.stopper.Connected(s)
+	c.Writer = common.NewWriter(c.Platform, s, 64)
+	c.ConnectedAt = time.Now()
+
+	pairs := []string{}
+	for pair, book := range c.Books {
+		// the snapshot of the new subscription syncs it again
+		book.Synced = false
+		pairs = append(pairs, pair)
+	}
+	for _, name := range []string{"book", "trade"} {
+		if err := c.Subscribe(name, pairs); err != nil {
+			s.Close()
+			c.Writer.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	diff := book.Diff
+	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
+		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		batch.Write(now, pkt)
+		book.ResetDiff()
+		batch.LastDiffSeq = book.Sequence + 1
+	}
+}
+
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+// wait for queued messages before the books get resubscribed
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
+func (c *Client) Run() {
+	for !c.stopper.Stopped() {
+		c.run()
+	}
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
+func (c *Client) run() {
+	if err := c.Connect(); err != nil {
+		if common.Schedule.Wait(c.Platform) {
+			return
+		}
+		fmt.Println("failed to connect", err)
+		time.Sleep(1000 * time.Millisecond)
+		return
+	}
+
+	defer c.Socket.Close()
+	defer c.Writer.Close()
+	defer c.flushShards()
+
+	for {
+		msgType, message, err := c.Socket.ReadMessage()
+		if err != nil {
+			log.Println("read:", err)
+			return
+		}
+
+		if msgType != websocket.TextMessage {
+			continue
+		}
+
+		common.Capture(c.Platform, message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (c *Client) HandleRaw(message []byte) error {
+	var pkt interface{}
+	if err := json.Unmarshal(message, &pkt); err != nil {
+		return fmt.Errorf("PacketHeader-parse: %s", err)
+	}
+
+	if eventData, ok := pkt.(map[string]interface{}); ok {
+		event, _ := eventData["event"].(string)
+		switch event {
+		case "heartbeat", "pong":
+		case "systemStatus":
+			switch status, _ := eventData["status"].(string); status {
+			case "online":
+				if common.Schedule.Active(c.Platform, time.Now()) != nil {
+					// the channels have to be subscribed again
+					common.Schedule.Finish(c.Platform, time.Now())
+					return common.ErrReconnect
+				}
+			case "maintenance", "cancel_only", "limit_only", "post_only":
+				common.Schedule.Begin(c.Platform, status, time.Now())
+			}
+		case "subscriptionStatus":
+			pair, _ := eventData["pair"].(string)
+			channel, _ := eventData["channelName"].(string)
+			switch status, _ := eventData["status"].(string); status {
+			case "subscribed":
+				log.Printf("%s Subscribed to Channel: %s Pair: %s\n", c.Platform, channel, pair)
+			case "error":
+				return common.Protocol("%s subscription of %s: %v", c.Platform, pair, eventData["errorMessage"])
+			}
+		default:
+			fmt.Println("unkown event", eventData)
+		}
+		return nil
+	}
+
+	// [channelID, payload..., channelName, pair]
+	data, ok := pkt.([]interface{})
+	if !ok || len(data) < 4 {
+		return nil
+	}
+	channel, _ := data[len(data)-2].(string)
+	pair, _ := data[len(data)-1].(string)
+	book, ok := c.Books[pair]
+	if !ok {
+		return fmt.Errorf("%s message of unknown pair %q", c.Platform, pair)
+	}
+
+	c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+		if err := c.HandleMessage(book, pair, channel, data[1:len(data)-2]); err != nil {
+			fmt.Println(err)
+		}
+	})
+	return nil
+}
+
+func (c *Client) HandleMessage(book *orderbook.Book, pair, channel string, payloads []interface{}) error {
+	now := time.Now()
+
+	trades := []*orderbook.Trade{}
+
+	switch {
+	case strings.HasPrefix(channel, "book"):
+		checksum := ""
+		for _, payload := range payloads {
+			levels, ok := payload.(map[string]interface{})
+			if !ok {
+				return common.Protocol("%s book payload %v", c.Platform, payload)
+			}
+			if _, snapshot := levels["as"]; snapshot {
+				bids := bookLevels("book.bs", levels["bs"])
+				asks := bookLevels("book.as", levels["as"])
+				if book.Empty() {
+					book.Clear()
+					book.Sequence = uint64(0)
+				} else if c.dbEnabled {
+					// resubscribed after a gap
+					c.BatchWrite[book.ID].ResetWarmUp()
+				}
+				// on resubscribe only the levels which changed end up in the diff
+				book.ApplySnapshot(now, bids, asks)
+				book.Synced = true
+				continue
+			}
+			if !book.Synced {
+				// waiting for the snapshot of a resubscribe
+				return nil
+			}
+			for _, level := range bookLevels("book.b", levels["b"]) {
+				book.UpdateBidLevel(now, level.Price, level.Size)
+			}
+			for _, level := range bookLevels("book.a", levels["a"]) {
+				book.UpdateAskLevel(now, level.Price, level.Size)
+			}
+			if sum, ok := levels["c"].(string); ok {
+				checksum = sum
+			}
+		}
+		// levels pushed out of the subscribed depth are not removed by kraken
+		book.Truncate(now, bookDepth)
+		if checksum != "" {
+			if sum := Checksum(book, c.Pairs[pair]); sum != checksum {
+				c.resubscribe(book, pair)
+				return common.SequenceGap("%s checksum of %s is %s, expected %s, resubscribing", c.Platform, pair, sum, checksum)
+			}
+		}
+
+	case channel == "trade":
+		if len(payloads) == 0 {
+			return nil
+		}
+		list, ok := common.List(c.Platform, "trade", payloads[0])
+		if !ok {
+			return nil
+		}
+		for _, item := range list {
+			// [price, volume, time, side, orderType, misc]
+			values, ok := common.List(c.Platform, "trade.item", item)
+			if !ok || len(values) < 4 {
+				continue
+			}
+			price, ok := common.QuotedNumber(c.Platform, "trade.price", values[0])
+			if !ok {
+				continue
+			}
+			size, ok := common.QuotedNumber(c.Platform, "trade.volume", values[1])
+			if !ok {
+				continue
+			}
+			if side, _ := values[3].(string); side == "s" {
+				book.AddClassifiedTrade(now, uint8(orderbook.BidSide), db_orderbook.SideFromVenue, price, size)
+			} else {
+				book.AddClassifiedTrade(now, uint8(orderbook.AskSide), db_orderbook.SideFromVenue, price, size)
+			}
+			trades = append(trades, book.Trades[len(book.Trades)-1])
+		}
+
+	default:
+		return common.Protocol("unkown channel %s", channel)
+	}
+
+	book.Sequence += 1
+
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
+		for _, trade := range trades {
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
+		}
+
+		if batch.NextSync(now) {
+			fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
+			c.WriteSync(batch, book, now)
+		} else {
+			if batch.NextDiff(now) {
+				c.WriteDiff(batch, book, now)
+			}
+		}
+	}
+	return nil
+}
+
+// bookLevels reads the levels of a book message, [price, volume, timestamp]
+// with an additional "r" for republished updates. A volume of 0 removes
+// the level.
+func bookLevels(field string, v interface{}) []*orderbook.BookLevel {
+	levels := []*orderbook.BookLevel{}
+	if v == nil {
+		return levels
+	}
+	list, ok := common.List("Kraken", field, v)
+	if !ok {
+		return levels
+	}
+	for _, item := range list {
+		values, ok := common.List("Kraken", field, item)
+		if !ok || len(values) < 2 {
+			continue
+		}
+		price, ok := common.QuotedNumber("Kraken", field+".price", values[0])
+		if !ok {
+			continue
+		}
+		size, ok := common.QuotedNumber("Kraken", field+".volume", values[1])
+		if !ok {
+			continue
+		}
+		levels = append(levels, &orderbook.BookLevel{Price: price, Size: size})
+	}
+	return levels
+}
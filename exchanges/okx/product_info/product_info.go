@@ -0,0 +1,149 @@
+package product_info
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// InstrumentTypes are the kinds of instruments discovered, spot markets and
+// perpetual and dated futures.
+var InstrumentTypes = []string{"SPOT", "SWAP", "FUTURES"}
+
+// Contract turns the contracts swaps and futures are sized in into the
+// base currency, spot sizes are already in it.
+type Contract struct {
+	Type string
+	// base currency per contract of linear contracts, quote currency per
+	// contract of inverse ones
+	Value   float64
+	Inverse bool
+}
+
+// BaseSize returns the size of contracts at price in the base currency.
+func (c Contract) BaseSize(size, price float64) float64 {
+	switch {
+	case c.Type == "SPOT" || c.Value == 0:
+		return size
+	case c.Inverse && price > 0:
+		return size * c.Value / price
+	case c.Inverse:
+		return size
+	}
+	return size * c.Value
+}
+
+var CachedInfo map[string]product_info.Info
+var CachedContracts map[string]Contract
+
+func init() {
+	FetchAllProductInfo()
+}
+
+type instrument struct {
+	InstID   string `json:"instId"`
+	InstType string `json:"instType"`
+	BaseCcy  string `json:"baseCcy"`
+	QuoteCcy string `json:"quoteCcy"`
+	Uly      string `json:"uly"`
+	CtVal    string `json:"ctVal"`
+	CtType   string `json:"ctType"`
+	TickSz   string `json:"tickSz"`
+	MinSz    string `json:"minSz"`
+}
+
+func FetchAllProductInfo() {
+	CachedInfo = map[string]product_info.Info{}
+	CachedContracts = map[string]Contract{}
+
+	for _, instType := range InstrumentTypes {
+		instruments, err := fetchInstruments(instType)
+		if err != nil {
+			fmt.Println("InitProduct error", instType, err)
+			continue
+		}
+		for _, i := range instruments {
+			addInstrument(i)
+		}
+	}
+}
+
+func fetchInstruments(instType string) ([]instrument, error) {
+	res, err := common.Get("OKX", "https://www.okx.com/api/v5/public/instruments?instType="+instType)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := common.CheckResponse(res, body); err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Code string       `json:"code"`
+		Msg  string       `json:"msg"`
+		Data []instrument `json:"data"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	if data.Code != "0" {
+		return nil, common.Protocol("instruments %s: %s %s", instType, data.Code, data.Msg)
+	}
+	return data.Data, nil
+}
+
+func addInstrument(i instrument) {
+	base, quote := i.BaseCcy, i.QuoteCcy
+	if i.InstType != "SPOT" {
+		// derivatives name their underlying, e.g. BTC-USDT
+		currencies := strings.Split(i.Uly, "-")
+		if len(currencies) != 2 {
+			return
+		}
+		base, quote = currencies[0], currencies[1]
+	}
+
+	tick, _ := strconv.ParseFloat(i.TickSz, 64)
+	info := product_info.Info{
+		ID:             i.InstID,
+		DisplayName:    i.InstID,
+		BaseCurrency:   base,
+		QuoteCurrency:  quote,
+		Platform:       "OKX",
+		DatabaseKey:    fmt.Sprintf("OKX-%s", i.InstID),
+		QuoteIncrement: product_info.FloatString(tick),
+		FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(tick)),
+	}
+
+	contract := Contract{Type: i.InstType, Inverse: i.CtType == "inverse"}
+	contract.Value, _ = strconv.ParseFloat(i.CtVal, 64)
+	if min, err := strconv.ParseFloat(i.MinSz, 64); err == nil && !contract.Inverse {
+		info.BaseMinSize = product_info.FloatString(contract.BaseSize(min, 0))
+	}
+
+	CachedInfo[info.DisplayName] = info
+	CachedContracts[info.DisplayName] = contract
+}
+
+func FetchProductInfo(id string) product_info.Info {
+	if info, ok := CachedInfo[id]; ok {
+		return info
+	}
+	return product_info.Info{}
+}
+
+func FetchContract(id string) (Contract, bool) {
+	contract, ok := CachedContracts[id]
+	return contract, ok
+}
@@ -0,0 +1,422 @@
+package websocket
+
+// api: https://www.okx.com/docs-v5/en/#websocket-api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/exchanges"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	book_info "github.com/lian/gdax-bookmap/exchanges/okx/product_info"
+	"github.com/lian/gdax-bookmap/i18n"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// levels per side of the books channel
+const bookDepth = 400
+
+func init() {
+	// the checksums are taken over the strings as sent, the sequence ids
+	// are checked instead
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:         "OKX",
+		MaxDepth:         bookDepth,
+		DynamicSubscribe: true,
+	})
+	exchanges.Register("OKX", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		c := New(s.DB, s.ProductsOr("BTC-USDT", "ETH-USDT", "BTC-USDT-SWAP"))
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		return c, c.Infos
+	})
+}
+
+type Client struct {
+	exchanges.BookIndex
+	Platform string
+	Socket   *websocket.Conn
+	// every write to Socket goes through it
+	Writer            *common.Writer
+	Products          []string
+	Books             map[string]*orderbook.Book
+	Contracts         map[string]book_info.Contract
+	SeqIDs            map[string]*int64
+	ConnectedAt       time.Time
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	Shards            *util.Shards
+	stopper           common.Stopper
+}
+
+func New(db *bolt.DB, products []string) *Client {
+	c := &Client{
+		Platform:   "OKX",
+		Products:   []string{},
+		Books:      map[string]*orderbook.Book{},
+		Contracts:  map[string]book_info.Contract{},
+		SeqIDs:     map[string]*int64{},
+		BatchWrite: map[string]*storage.BookWriter{},
+		DB:         db,
+		Infos:      []*product_info.Info{},
+	}
+	if c.DB != nil {
+		c.dbEnabled = true
+	}
+
+	for _, name := range products {
+		c.AddProduct(name)
+	}
+
+	if c.dbEnabled {
+		buckets := []string{}
+		for _, info := range c.Infos {
+			buckets = append(buckets, info.DatabaseKey)
+		}
+		util.CreateBucketsDB(c.DB, buckets)
+	}
+
+	return c
+}
+
+// AddProduct adds an instrument by its id, e.g. BTC-USDT, BTC-USDT-SWAP or
+// BTC-USD-241227.
+func (c *Client) AddProduct(name string) {
+	contract, ok := book_info.FetchContract(name)
+	if !ok {
+		fmt.Println(c.Platform, "unknown product", name)
+		return
+	}
+	c.Products = append(c.Products, name)
+	book := orderbook.New(name)
+	info := book_info.FetchProductInfo(name)
+	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
+	book.SetProductInfo(info)
+	c.Books[name] = book
+	c.AddBook(book.ID, book)
+	c.Contracts[name] = contract
+	c.SeqIDs[name] = new(int64)
+}
+
+func subscription(op, channel, instID string) map[string]interface{} {
+	return map[string]interface{}{
+		"op":   op,
+		"args": []map[string]string{{"channel": channel, "instId": instID}},
+	}
+}
+
+// resubscribe asks for a new snapshot of a book after a sequence gap, the
+// updates in between are skipped.
+func (c *Client) resubscribe(book *orderbook.Book) {
+	book.Synced = false
+	if c.Writer == nil {
+		// replaying a capture
+		return
+	}
+	c.Writer.Send(subscription("unsubscribe", "books", book.ID))
+	c.Writer.Send(subscription("subscribe", "books", book.ID))
+}
+
+func (c *Client) Connect() error {
+	url := "wss://ws.okx.com:8443/ws/v5/public"
+	fmt.Println("connect to websocket", url)
+	s, _, err := common.Dial(c.Platform, url)
+	if err != nil {
+		return err
+	}
+
+	c.Socket = s
+	c.stopper.Connected(s)
+	c.Writer = common.NewWriter(c.Platform, s, 64)
+	c.ConnectedAt = time.Now()
+
+	args := []map[string]string{}
+	for id, book := range c.Books {
+		// the snapshot of the new subscription syncs it again
+		book.Synced = false
+		args = append(args, map[string]string{"channel": "books", "instId": id})
+		args = append(args, map[string]string{"channel": "trades", "instId": id})
+	}
+	if err := c.Writer.WriteJSON(map[string]interface{}{"op": "subscribe", "args": args}); err != nil {
+		s.Close()
+		c.Writer.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	diff := book.Diff
+	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
+		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		batch.Write(now, pkt)
+		book.ResetDiff()
+		batch.LastDiffSeq = book.Sequence + 1
+	}
+}
+
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+// wait for queued messages before the books get resubscribed
+func (c *Client) flushShards() {
+	for _, info := range c.Infos {
+		c.Shards.Flush(info.DatabaseKey)
+	}
+	storage.FlushAll(c.BatchWrite)
+}
+
+func (c *Client) Run() {
+	for !c.stopper.Stopped() {
+		c.run()
+	}
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
+func (c *Client) run() {
+	if err := c.Connect(); err != nil {
+		if common.Schedule.Wait(c.Platform) {
+			return
+		}
+		fmt.Println("failed to connect", err)
+		time.Sleep(1000 * time.Millisecond)
+		return
+	}
+
+	defer c.Socket.Close()
+	defer c.Writer.Close()
+	defer c.flushShards()
+
+	for {
+		msgType, message, err := c.Socket.ReadMessage()
+		if err != nil {
+			log.Println("read:", err)
+			return
+		}
+
+		if msgType != websocket.TextMessage {
+			continue
+		}
+
+		common.Capture(c.Platform, message)
+		if err := c.HandleRaw(message); err == common.ErrReconnect {
+			return
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+type pushMessage struct {
+	Event string `json:"event"`
+	Code  string `json:"code"`
+	Msg   string `json:"msg"`
+	Arg   struct {
+		Channel string `json:"channel"`
+		InstID  string `json:"instId"`
+	} `json:"arg"`
+	Action string            `json:"action"`
+	Data   []json.RawMessage `json:"data"`
+}
+
+type bookData struct {
+	Asks      [][]interface{} `json:"asks"`
+	Bids      [][]interface{} `json:"bids"`
+	SeqID     int64           `json:"seqId"`
+	PrevSeqID int64           `json:"prevSeqId"`
+}
+
+type tradeData struct {
+	Price interface{} `json:"px"`
+	Size  interface{} `json:"sz"`
+	Side  string      `json:"side"`
+}
+
+func (c *Client) HandleRaw(message []byte) error {
+	if string(message) == "pong" {
+		return nil
+	}
+	var msg pushMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("PacketHeader-parse: %s", err)
+	}
+
+	switch msg.Event {
+	case "":
+	case "subscribe", "unsubscribe":
+		log.Printf("%s %sd Channel: %s Instrument: %s\n", c.Platform, msg.Event, msg.Arg.Channel, msg.Arg.InstID)
+		return nil
+	case "error":
+		return common.Protocol("%s error %s %s", c.Platform, msg.Code, msg.Msg)
+	case "notice":
+		// the service is upgraded, the connection is closed soon
+		log.Println(c.Platform, msg.Msg)
+		return common.ErrReconnect
+	default:
+		fmt.Println("unkown event", string(message))
+		return nil
+	}
+
+	book, ok := c.Books[msg.Arg.InstID]
+	if !ok {
+		return fmt.Errorf("%s message of unknown instrument %q", c.Platform, msg.Arg.InstID)
+	}
+	c.Shards.Do(book.ProductInfo.DatabaseKey, func() {
+		if err := c.HandleMessage(book, &msg); err != nil {
+			fmt.Println(err)
+		}
+	})
+	return nil
+}
+
+func (c *Client) HandleMessage(book *orderbook.Book, msg *pushMessage) error {
+	now := time.Now()
+	contract := c.Contracts[book.ID]
+
+	trades := []*orderbook.Trade{}
+
+	switch msg.Arg.Channel {
+	case "books":
+		for _, raw := range msg.Data {
+			var data bookData
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return common.Protocol("%s books %s", c.Platform, err)
+			}
+			bids := c.levels("books.bids", contract, data.Bids)
+			asks := c.levels("books.asks", contract, data.Asks)
+
+			if msg.Action == "snapshot" {
+				if book.Empty() {
+					book.Clear()
+					book.Sequence = uint64(0)
+				} else if c.dbEnabled {
+					// resubscribed after a gap
+					c.BatchWrite[book.ID].ResetWarmUp()
+				}
+				// on resubscribe only the levels which changed end up in the diff
+				book.ApplySnapshot(now, bids, asks)
+				book.Synced = true
+				c.lastSeq(book, data.SeqID)
+				continue
+			}
+			if !book.Synced {
+				// waiting for the snapshot of a resubscribe
+				return nil
+			}
+			if last := c.lastSeq(book, data.SeqID); data.PrevSeqID != last {
+				c.resubscribe(book)
+				return common.SequenceGap("%s %s prevSeqId %d, expected %d, resubscribing", c.Platform, book.ID, data.PrevSeqID, last)
+			}
+			for _, level := range bids {
+				book.UpdateBidLevel(now, level.Price, level.Size)
+			}
+			for _, level := range asks {
+				book.UpdateAskLevel(now, level.Price, level.Size)
+			}
+		}
+		book.Truncate(now, bookDepth)
+
+	case "trades":
+		for _, raw := range msg.Data {
+			var data tradeData
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return common.Protocol("%s trades %s", c.Platform, err)
+			}
+			price, ok := common.QuotedNumber(c.Platform, "trades.px", data.Price)
+			if !ok {
+				continue
+			}
+			size, ok := common.QuotedNumber(c.Platform, "trades.sz", data.Size)
+			if !ok {
+				continue
+			}
+			size = contract.BaseSize(size, price)
+			if data.Side == "sell" {
+				book.AddClassifiedTrade(now, uint8(orderbook.BidSide), db_orderbook.SideFromVenue, price, size)
+			} else {
+				book.AddClassifiedTrade(now, uint8(orderbook.AskSide), db_orderbook.SideFromVenue, price, size)
+			}
+			trades = append(trades, book.Trades[len(book.Trades)-1])
+		}
+
+	default:
+		return common.Protocol("unkown channel %s", msg.Arg.Channel)
+	}
+
+	book.Sequence += 1
+
+	if c.dbEnabled {
+		batch := c.BatchWrite[book.ID]
+		now := time.Now()
+		if !batch.WarmedUp(now, book) {
+			return nil
+		}
+		for _, trade := range trades {
+			batch.Write(now, orderbook.PackTrade(trade))
+			batch.TrackPrice(trade.Price)
+			if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+				label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+				batch.Bookmark(now, label)
+			}
+		}
+
+		if batch.NextSync(now) {
+			fmt.Println("STORE SYNC", book.ProductInfo.DatabaseKey, batch.Count)
+			c.WriteSync(batch, book, now)
+		} else {
+			if batch.NextDiff(now) {
+				c.WriteDiff(batch, book, now)
+			}
+		}
+	}
+	return nil
+}
+
+// lastSeq returns the seqId of the previous message of a book and keeps
+// seq for the next one.
+func (c *Client) lastSeq(book *orderbook.Book, seq int64) int64 {
+	last := *c.SeqIDs[book.ID]
+	*c.SeqIDs[book.ID] = seq
+	return last
+}
+
+// levels reads [price, size, 0, orders] levels, sizes of contracts are
+// turned into the base currency. A size of 0 removes the level.
+func (c *Client) levels(field string, contract book_info.Contract, list [][]interface{}) []*orderbook.BookLevel {
+	levels := []*orderbook.BookLevel{}
+	for _, values := range list {
+		if len(values) < 2 {
+			continue
+		}
+		price, ok := common.QuotedNumber(c.Platform, field+".price", values[0])
+		if !ok {
+			continue
+		}
+		size, ok := common.QuotedNumber(c.Platform, field+".size", values[1])
+		if !ok {
+			continue
+		}
+		levels = append(levels, &orderbook.BookLevel{Price: price, Size: contract.BaseSize(size, price)})
+	}
+	return levels
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/rebroadcast"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// a stream starting this long after the last stored packet of a product is
+// a gap worth filling from the backfill peer
+const backfillMinGap = 10 * time.Second
+
+// lastKeys returns the last stored key of every product.
+func (c *Client) lastKeys() map[string]int64 {
+	last := map[string]int64{}
+	c.DB.View(func(tx *bolt.Tx) error {
+		for _, product := range c.Products {
+			b := tx.Bucket([]byte(product))
+			if b == nil {
+				continue
+			}
+			if key, _ := b.Cursor().Last(); key != nil {
+				last[product] = orderbook.UnpackTimeKey(key).UnixNano()
+			}
+		}
+		return nil
+	})
+	return last
+}
+
+// checkGap is called with the first message of a product after connecting.
+func (c *Client) checkGap(msg *rebroadcast.Message, last int64) {
+	if c.Backfill == "" || last == 0 {
+		return
+	}
+	first := orderbook.UnpackTimeKey([]byte(msg.Key)).UnixNano()
+	if time.Duration(first-last) < backfillMinGap {
+		return
+	}
+	go func() {
+		if err := c.backfill(msg.Product, last, first); err != nil {
+			fmt.Println("remote backfill", msg.Product, err)
+		}
+	}()
+}
+
+func (c *Client) fetchRange(product string, from, to int64, cursor string) (*rebroadcast.Page, error) {
+	query := url.Values{}
+	query.Set("product", product)
+	query.Set("from", strconv.FormatInt(from, 10))
+	query.Set("to", strconv.FormatInt(to, 10))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	res, err := http.Get(fmt.Sprintf("http://%s/range?%s", c.Backfill, query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, common.Protocol("backfill range: %s", res.Status)
+	}
+
+	page := &rebroadcast.Page{}
+	if err := json.NewDecoder(res.Body).Decode(page); err != nil {
+		return nil, common.Protocol("backfill range: %s", err)
+	}
+	return page, nil
+}
+
+// backfill fills the time between the last packet stored before a gap and
+// the first packet stored after it with what the peer recorded, and notes
+// the filled range in the Backfill bucket of the product. Every page of
+// the range is stored before the next is fetched.
+func (c *Client) backfill(product string, last, first int64) error {
+	fmt.Println("remote backfill", product, time.Unix(0, last), "-", time.Unix(0, first), "from", c.Backfill)
+	f := &util.Backfill{
+		From: time.Unix(0, last+1),
+		To:   time.Unix(0, first),
+		Peer: c.Backfill,
+	}
+
+	cursor := ""
+	for {
+		page, err := c.fetchRange(product, last+1, first, cursor)
+		if err != nil {
+			return err
+		}
+		err = c.DB.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(product))
+			if b == nil {
+				return nil
+			}
+			b.FillPercent = 0.9
+			for _, msg := range page.Messages {
+				nano := orderbook.UnpackTimeKey([]byte(msg.Key)).UnixNano()
+				if msg.Product != product || nano <= last || nano >= first {
+					continue
+				}
+				if err := b.Put([]byte(msg.Key), util.Seal(c.DB, msg.Data)); err != nil {
+					return err
+				}
+				f.Packets += 1
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+
+	if f.Packets == 0 {
+		return nil
+	}
+	f.Filled = time.Now()
+	err := c.DB.Update(func(tx *bolt.Tx) error {
+		return util.PutBackfill(tx, product, f)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("remote backfill", product, "filled", f.Packets, "packets")
+	return nil
+}
@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/rebroadcast"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	// the products of a remote keep the capabilities of their venue
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform:    "Remote",
+		Compression: true,
+	})
+}
+
+// Client subscribes to the rebroadcast server of another bookmap recorder
+// and stores the received packets under their original keys, so the
+// bookmaps read them like locally recorded data.
+type Client struct {
+	Addr        string
+	Products    []string
+	Socket      *websocket.Conn
+	ConnectedAt time.Time
+	DB          *bolt.DB
+	Infos       []*product_info.Info
+	Received    int
+	// rebroadcast server to fill gaps in the local recording from, often
+	// the same as Addr
+	Backfill string
+
+	mu      sync.Mutex
+	pending []*rebroadcast.Message
+}
+
+// New fetches the products of the server at addr, empty products
+// subscribes to everything it records.
+func New(db *bolt.DB, addr string, products []string) (*Client, error) {
+	c := &Client{
+		Addr:     addr,
+		Products: []string{},
+		DB:       db,
+		Infos:    []*product_info.Info{},
+		pending:  []*rebroadcast.Message{},
+	}
+
+	infos, err := c.FetchProductInfos()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if len(products) > 0 && !contains(products, info.DatabaseKey) {
+			continue
+		}
+		c.Products = append(c.Products, info.DatabaseKey)
+		c.Infos = append(c.Infos, info)
+	}
+	if len(c.Infos) == 0 {
+		return nil, fmt.Errorf("remote %s records none of %v", addr, products)
+	}
+
+	util.CreateBucketsDB(db, c.Products)
+	return c, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) FetchProductInfos() ([]*product_info.Info, error) {
+	res, err := http.Get(fmt.Sprintf("http://%s/products", c.Addr))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, common.Protocol("remote products: %s", res.Status)
+	}
+
+	infos := []*product_info.Info{}
+	if err := json.NewDecoder(res.Body).Decode(&infos); err != nil {
+		return nil, common.Protocol("remote products: %s", err)
+	}
+	return infos, nil
+}
+
+func (c *Client) Connect() error {
+	u := url.URL{Scheme: "ws", Host: c.Addr, Path: "/stream", RawQuery: "products=" + url.QueryEscape(strings.Join(c.Products, ","))}
+	fmt.Println("connect to remote", u.String())
+	s, _, err := common.Dial("Remote", u.String())
+	if err != nil {
+		return err
+	}
+
+	c.Socket = s
+	c.ConnectedAt = time.Now()
+	return nil
+}
+
+// Flush stores the received packets. Packets sent again after a reconnect
+// overwrite themselves with the same value.
+func (c *Client) Flush() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = []*rebroadcast.Message{}
+	c.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	c.DB.Update(func(tx *bolt.Tx) error {
+		for _, msg := range pending {
+			b := tx.Bucket([]byte(msg.Product))
+			if b == nil {
+				continue
+			}
+			b.FillPercent = 0.9
+			if err := b.Put([]byte(msg.Key), util.Seal(c.DB, msg.Data)); err != nil {
+				fmt.Println("remote DB Error", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *Client) Run() {
+	go func() {
+		for range time.Tick(500 * time.Millisecond) {
+			c.Flush()
+		}
+	}()
+
+	for {
+		c.run()
+	}
+}
+
+func (c *Client) run() {
+	if err := c.Connect(); err != nil {
+		fmt.Println("failed to connect", err)
+		time.Sleep(1000 * time.Millisecond)
+		return
+	}
+	defer c.Socket.Close()
+
+	// the first message of every product shows if packets were missed
+	// while disconnected
+	c.Flush()
+	last := c.lastKeys()
+	seen := map[string]bool{}
+
+	for {
+		var msg rebroadcast.Message
+		if err := c.Socket.ReadJSON(&msg); err != nil {
+			log.Println("read:", err)
+			time.Sleep(1000 * time.Millisecond)
+			return
+		}
+		if !contains(c.Products, msg.Product) {
+			continue
+		}
+		if !seen[msg.Product] {
+			seen[msg.Product] = true
+			c.checkGap(&msg, last[msg.Product])
+		}
+
+		c.mu.Lock()
+		c.pending = append(c.pending, &msg)
+		c.Received += 1
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,207 @@
+package synthetic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/lian/gdax-bookmap/exchanges"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/storage"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+func init() {
+	common.RegisterCapabilities(&common.Capabilities{
+		Platform: "Synthetic",
+	})
+	exchanges.Register("Synthetic", func(s exchanges.Settings) (exchanges.Connector, []*product_info.Info) {
+		config, ok := s.Options.(Config)
+		if !ok {
+			config = DefaultConfig()
+		}
+		c := New(s.DB, s.ProductsOr("BTC-USD", "ETH-USD", "BCH-USD"), config)
+		c.BookmarkTradeSize = s.BookmarkTradeSize
+		c.Shards = s.Shards
+		return c, c.Infos
+	})
+}
+
+// start prices of the generated products
+var startPrices = map[string]float64{
+	"BTC-USD": 7000,
+	"ETH-USD": 700,
+	"BCH-USD": 1500,
+}
+
+// Client records generated markets like the clients of real venues, for
+// demos, load tests of the storage and working without network.
+type Client struct {
+	exchanges.BookIndex
+	Products          []string
+	Markets           map[string]*Market
+	DB                *bolt.DB
+	dbEnabled         bool
+	BatchWrite        map[string]*storage.BookWriter
+	Infos             []*product_info.Info
+	BookmarkTradeSize float64
+	// time between two steps of the markets
+	Interval time.Duration
+	Shards   *util.Shards
+	// of the markets added next
+	Config Config
+
+	stopper common.Stopper
+}
+
+func New(db *bolt.DB, products []string, config Config) *Client {
+	c := &Client{
+		Products:   []string{},
+		Markets:    map[string]*Market{},
+		BatchWrite: map[string]*storage.BookWriter{},
+		DB:         db,
+		Infos:      []*product_info.Info{},
+		Interval:   50 * time.Millisecond,
+		Config:     config,
+	}
+
+	if c.DB != nil {
+		c.dbEnabled = true
+	}
+
+	for _, name := range products {
+		c.AddProduct(name)
+	}
+
+	if c.dbEnabled {
+		buckets := []string{}
+		for _, info := range c.Infos {
+			buckets = append(buckets, info.DatabaseKey)
+		}
+		util.CreateBucketsDB(db, buckets)
+	}
+
+	return c
+}
+
+func ProductInfo(id string) product_info.Info {
+	currencies := strings.SplitN(id+"-", "-", 3)
+	return product_info.Info{
+		Platform:       "Synthetic",
+		DatabaseKey:    "Synthetic-" + id,
+		ID:             id,
+		DisplayName:    id,
+		BaseCurrency:   currencies[0],
+		QuoteCurrency:  currencies[1],
+		QuoteIncrement: 0.01,
+		FloatFormat:    fmt.Sprintf("%%.%df", util.NumDecPlaces(0.01)),
+	}
+}
+
+func (c *Client) AddProduct(name string) {
+	config := c.Config
+	if config.Seed != 0 {
+		// same seed, same markets, but not the same walk for all
+		config.Seed += int64(len(c.Products))
+	}
+	price, ok := startPrices[name]
+	if !ok {
+		price = 100
+	}
+	info := ProductInfo(name)
+	book := orderbook.New(name)
+	book.SetProductInfo(info)
+	c.Products = append(c.Products, name)
+	c.Infos = append(c.Infos, &info)
+	c.BatchWrite[name] = storage.NewBookWriter(c.DB, info.DatabaseKey)
+	c.Markets[name] = NewMarket(book, price, config)
+	c.AddBook(book.ID, book)
+}
+
+func (c *Client) HandleStep(market *Market, now time.Time, dt time.Duration) {
+	book := market.Book
+	trades := market.Step(now, dt)
+
+	if !c.dbEnabled {
+		book.ResetDiff()
+		return
+	}
+	batch := c.BatchWrite[book.ID]
+	if !batch.WarmedUp(now, book) {
+		return
+	}
+	for _, trade := range trades {
+		batch.Write(now, orderbook.PackTrade(trade))
+		batch.TrackPrice(trade.Price)
+		if c.BookmarkTradeSize > 0 && trade.Size >= c.BookmarkTradeSize {
+			label := i18n.Sprintf("trade %.4f @ %s", trade.Size, book.ProductInfo.FormatFloat(trade.Price))
+			batch.Bookmark(now, label)
+		}
+	}
+
+	if batch.NextSync(now) {
+		fmt.Println("STORE SYNC", book.ID, batch.Count)
+		c.WriteSync(batch, book, now)
+	} else if batch.NextDiff(now) {
+		c.WriteDiff(batch, book, now)
+	}
+}
+
+func (c *Client) WriteDiff(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	diff := book.Diff
+	if len(diff.Bid) != 0 || len(diff.Ask) != 0 {
+		pkt := orderbook.PackDiff(batch.LastDiffSeq, book.Sequence, diff)
+		batch.Write(now, pkt)
+		book.ResetDiff()
+		batch.LastDiffSeq = book.Sequence + 1
+	}
+}
+
+func (c *Client) WriteSync(batch *storage.BookWriter, book *orderbook.Book, now time.Time) {
+	batch.Write(now, orderbook.PackSync(book))
+	batch.Write(now, orderbook.PackLevelAges(book))
+	book.ResetDiff()
+	batch.LastDiffSeq = book.Sequence + 1
+}
+
+// Connect does nothing, the markets are generated locally.
+func (c *Client) Connect() error {
+	return nil
+}
+
+func (c *Client) Stop() {
+	c.stopper.Stop()
+}
+
+// Run generates the markets until Stop.
+func (c *Client) Run() {
+	fmt.Println("generating synthetic markets", c.Products)
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		var now time.Time
+		select {
+		case <-c.stopper.Done():
+			return
+		case now = <-ticker.C:
+		}
+		dt := now.Sub(last)
+		last = now
+		for _, name := range c.Products {
+			market := c.Markets[name]
+			if c.Shards == nil {
+				c.HandleStep(market, now, dt)
+				continue
+			}
+			c.Shards.Do(market.Book.ProductInfo.DatabaseKey, func() {
+				c.HandleStep(market, now, dt)
+			})
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package synthetic
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+)
+
+// Config shapes the generated markets.
+type Config struct {
+	// standard deviation of the relative mid price change per second
+	Volatility float64
+	// levels per side, Spacing apart
+	Depth int
+	// distance between levels as share of the start price, rounded to the
+	// quote increment
+	Spacing float64
+	// mean size of the best levels
+	LevelSize float64
+	// depth profile, every level further out is this much larger
+	DepthSlope float64
+	// share of new levels which are walls of ten times the size
+	WallChance float64
+	// mean level size changes per second
+	UpdateRate float64
+	// mean trades per second and mean trade size
+	TradeRate float64
+	TradeSize float64
+	// 0 seeds from the clock
+	Seed int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Volatility: 0.0003,
+		Depth:      100,
+		Spacing:    0.0001,
+		LevelSize:  2,
+		DepthSlope: 0.05,
+		WallChance: 0.02,
+		UpdateRate: 50,
+		TradeRate:  2,
+		TradeSize:  0.5,
+	}
+}
+
+// Market is a random walk of the mid price with a book of Depth levels per
+// side around it, level updates and trades against the touch arriving at
+// random (poisson). Step moves it on by any duration, so it runs in real
+// time or as fast as it can.
+type Market struct {
+	Config Config
+	Book   *orderbook.Book
+	Mid    float64
+	step   float64
+	rand   *rand.Rand
+	// direction of the last move, trades follow it
+	up bool
+}
+
+func NewMarket(book *orderbook.Book, price float64, config Config) *Market {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	increment := float64(book.ProductInfo.QuoteIncrement)
+	if increment <= 0 {
+		increment = 0.01
+	}
+	step := math.Max(1, math.Round(price*config.Spacing/increment)) * increment
+	if config.Depth < 1 {
+		config.Depth = 1
+	}
+	return &Market{
+		Config: config,
+		Book:   book,
+		Mid:    price,
+		step:   step,
+		rand:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+// levelSize draws the size of the level index steps away from the touch.
+func (m *Market) levelSize(index int) float64 {
+	size := m.Config.LevelSize * (1 + m.Config.DepthSlope*float64(index)) * math.Exp(m.rand.NormFloat64()*0.5)
+	if m.rand.Float64() < m.Config.WallChance {
+		size *= 10
+	}
+	return m.round(size)
+}
+
+func (m *Market) round(size float64) float64 {
+	return math.Round(size*1e8) / 1e8
+}
+
+// poisson draws the number of events of a rate over dt.
+func (m *Market) poisson(rate float64, dt time.Duration) int {
+	lambda := rate * dt.Seconds()
+	if lambda <= 0 {
+		return 0
+	}
+	if lambda > 30 {
+		// normal approximation, exp(-lambda) underflows the loop
+		return int(math.Max(0, math.Round(lambda+math.Sqrt(lambda)*m.rand.NormFloat64())))
+	}
+	l := math.Exp(-lambda)
+	n := 0
+	for p := m.rand.Float64(); p > l; p *= m.rand.Float64() {
+		n += 1
+	}
+	return n
+}
+
+// touch returns the best bid and ask for the current mid.
+func (m *Market) touch() (float64, float64) {
+	bid := math.Floor(m.Mid/m.step) * m.step
+	return m.price(bid), m.price(bid + m.step)
+}
+
+func (m *Market) price(p float64) float64 {
+	increment := float64(m.Book.ProductInfo.QuoteIncrement)
+	if increment <= 0 {
+		return p
+	}
+	if increment < 1 {
+		// dividing keeps e.g. 6966.4 from turning into 6966.400000000001
+		return math.Round(p/increment) / math.Round(1/increment)
+	}
+	return math.Round(p/increment) * increment
+}
+
+// Step moves the market on by dt and returns the trades of it, the changes
+// are left in the diff of the book.
+func (m *Market) Step(t time.Time, dt time.Duration) []*orderbook.Trade {
+	move := m.Config.Volatility * math.Sqrt(dt.Seconds()) * m.rand.NormFloat64()
+	if move != 0 {
+		m.up = move > 0
+	}
+	m.Mid *= math.Exp(move)
+
+	bestBid, bestAsk := m.touch()
+	lowestBid := m.price(bestBid - float64(m.Config.Depth-1)*m.step)
+	highestAsk := m.price(bestAsk + float64(m.Config.Depth-1)*m.step)
+
+	// levels crossed or left behind by the move
+	for _, level := range append([]*orderbook.BookLevel{}, m.Book.Bid...) {
+		if level.Price > bestBid || level.Price < lowestBid {
+			m.Book.UpdateBidLevel(t, level.Price, 0)
+		}
+	}
+	for _, level := range append([]*orderbook.BookLevel{}, m.Book.Ask...) {
+		if level.Price < bestAsk || level.Price > highestAsk {
+			m.Book.UpdateAskLevel(t, level.Price, 0)
+		}
+	}
+
+	// empty levels fill up again within a few hundred milliseconds
+	refill := math.Min(1, 4*dt.Seconds())
+	for i := 0; i < m.Config.Depth; i += 1 {
+		bid := m.price(bestBid - float64(i)*m.step)
+		if m.Book.LevelSize(orderbook.BidSide, bid) == 0 && (i > 0 || m.rand.Float64() < refill) {
+			m.Book.UpdateBidLevel(t, bid, m.levelSize(i))
+		}
+		ask := m.price(bestAsk + float64(i)*m.step)
+		if m.Book.LevelSize(orderbook.AskSide, ask) == 0 && (i > 0 || m.rand.Float64() < refill) {
+			m.Book.UpdateAskLevel(t, ask, m.levelSize(i))
+		}
+	}
+
+	// most updates happen close to the touch
+	for n := m.poisson(m.Config.UpdateRate, dt); n > 0; n -= 1 {
+		i := int(math.Min(float64(m.Config.Depth-1), m.rand.ExpFloat64()*float64(m.Config.Depth)/8))
+		if m.rand.Intn(2) == 0 {
+			m.Book.UpdateBidLevel(t, m.price(bestBid-float64(i)*m.step), m.levelSize(i))
+		} else {
+			m.Book.UpdateAskLevel(t, m.price(bestAsk+float64(i)*m.step), m.levelSize(i))
+		}
+	}
+
+	trades := []*orderbook.Trade{}
+	for n := m.poisson(m.Config.TradeRate, dt); n > 0; n -= 1 {
+		buy := m.rand.Float64() < 0.35
+		if m.up {
+			buy = !buy
+		}
+		size := m.round(math.Max(0.0001, m.rand.ExpFloat64()*m.Config.TradeSize))
+		price, side := bestBid, orderbook.BidSide
+		if buy {
+			price, side = bestAsk, orderbook.AskSide
+		}
+		// the trade takes from the level it hits
+		remaining := m.round(math.Max(0, m.Book.LevelSize(side, price)-size))
+		if side == orderbook.BidSide {
+			m.Book.UpdateBidLevel(t, price, remaining)
+		} else {
+			m.Book.UpdateAskLevel(t, price, remaining)
+		}
+		m.Book.AddClassifiedTrade(t, uint8(side), db_orderbook.SideFromVenue, price, size)
+		trades = append(trades, m.Book.Trades[len(m.Book.Trades)-1])
+	}
+
+	m.Book.Sequence += 1
+	return trades
+}
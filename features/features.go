@@ -0,0 +1,132 @@
+// Package features switches subsystems on and off at runtime, so
+// experimental ones can be tried by opting in per feature instead of
+// running a separate build. A package registers its features once and
+// checks them where it branches:
+//
+//	var lenient = features.Register("parse.lenient", "read numbers in another encoding", false)
+//
+//	if lenient.On() { ... }
+//
+// They are set with -features at startup and through /features of the
+// admin server while running.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Feature struct {
+	Name        string
+	Description string
+	// state before -features or the admin server changed it
+	Default bool
+
+	enabled int32
+	mu      sync.Mutex
+	watches []func(bool)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Feature{}
+)
+
+// Register adds a feature, registering a name twice returns the first.
+func Register(name, description string, enabled bool) *Feature {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if f, ok := registry[name]; ok {
+		return f
+	}
+	f := &Feature{Name: name, Description: description, Default: enabled}
+	if enabled {
+		f.enabled = 1
+	}
+	registry[name] = f
+	return f
+}
+
+// On is cheap enough to be checked per message.
+func (f *Feature) On() bool {
+	return atomic.LoadInt32(&f.enabled) == 1
+}
+
+// Set switches the feature and calls the watches when it changed.
+func (f *Feature) Set(enabled bool) {
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+	if atomic.SwapInt32(&f.enabled, value) == value {
+		return
+	}
+	f.mu.Lock()
+	watches := append([]func(bool){}, f.watches...)
+	f.mu.Unlock()
+	for _, watch := range watches {
+		watch(enabled)
+	}
+}
+
+// Watch calls fn whenever the feature is switched, for subsystems which
+// have to be started or stopped instead of checking On.
+func (f *Feature) Watch(fn func(bool)) {
+	f.mu.Lock()
+	f.watches = append(f.watches, fn)
+	f.mu.Unlock()
+}
+
+func Lookup(name string) (*Feature, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	f, ok := registry[name]
+	return f, ok
+}
+
+func Set(name string, enabled bool) error {
+	f, ok := Lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown feature %q", name)
+	}
+	f.Set(enabled)
+	return nil
+}
+
+// Apply sets the features of a comma separated list, a leading - disables
+// one, e.g. parse.lenient,-render.candle-range
+func Apply(spec string) error {
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		enabled := !strings.HasPrefix(name, "-")
+		if err := Set(strings.TrimPrefix(name, "-"), enabled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type State struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	Default     bool   `json:"default"`
+}
+
+// List returns the state of every feature sorted by name.
+func List() []State {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	states := []State{}
+	for _, f := range registry {
+		states = append(states, State{Name: f.Name, Description: f.Description, Enabled: f.On(), Default: f.Default})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
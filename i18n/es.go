@@ -0,0 +1,60 @@
+package i18n
+
+var spanish = map[string]string{
+	// status line
+	"LIVE":                      "EN VIVO",
+	"HISTORY":                   "HISTORIAL",
+	"AGE":                       "EDAD",
+	"MAINTENANCE":               "MANTENIMIENTO",
+	"TOP %d":                    "MEJORES %d",
+	"SANDBOX":                   "PRUEBAS",
+	"LOCKED":                    "FIJADO",
+	"AUTO RANGE":                "RANGO AUTO",
+	"depth %s":                  "profundidad %s",
+	"waiting for a sync":        "esperando sincronización",
+	"%% OF %s":                  "%% DE %s",
+	"tape %.1f trades/s %.4f/s": "cinta %.1f trades/s %.4f/s",
+	"venue %s":                  "mercado %s",
+	"rx %s skew %s lag %s":      "rx %s desfase %s retraso %s",
+	"REPLAY %gx":                "REPRODUCCIÓN %gx",
+	"mark %s funding %+.4f%%":   "marca %s financiación %+.4f%%",
+	"in %s":                     "en %s",
+	"%s %s %s   PriceSteps %s MaxSizeHisto %.2f ColumnWidth %.0f ViewportStep %d time-diff %s trades p50 %.4f p99 %.4f": "%s %s %s   PasoPrecio %s MaxHisto %.2f AnchoColumna %.0f PasoVista %d retraso %s trades p50 %.4f p99 %.4f",
+
+	// graph
+	"spread":               "spread",
+	"depth":                "profundidad",
+	"bookmarks":            "marcadores",
+	"venue in maintenance": "mercado en mantenimiento",
+
+	// market order estimate
+	"buy":                               "compra",
+	"sell":                              "venta",
+	"%s %.4f avg %s %+.1fbps %d levels": "%s %.4f medio %s %+.1fpb %d niveles",
+	"only %.4f":                         "solo %.4f",
+
+	// portfolio panel
+	"portfolio  $%.2f": "cartera  $%.2f",
+
+	// whale feed
+	"whales  %d": "ballenas  %d",
+	"n/a":        "n/d",
+
+	// bookmark labels, stored in the language they were created in
+	"manual":                              "manual",
+	"trade %.4f @ %s":                     "trade %.4f @ %s",
+	"trade-through %d levels %s-%s":       "barrido %d niveles %s-%s",
+	"stop run %d levels %s-%s through %s": "caza de stops %d niveles %s-%s por %s",
+	"swing high %s":                       "máximo %s",
+	"swing low %s":                        "mínimo %s",
+	"tape %.1f trades/s (%.1fx) %.4f/s":   "cinta %.1f trades/s (%.1fx) %.4f/s",
+	"volatility %+.2f%% %s %.1fx":         "volatilidad %+.2f%% %s %.1fx",
+	"whale %.4f @ %s (%.1fx)":             "ballena %.4f @ %s (%.1fx)",
+	"recording ended: %s":                 "grabación terminada: %s",
+	"(new symbol %s?)":                    "(¿nuevo símbolo %s?)",
+	"price alert %s reached %s":           "alerta de precio %s alcanzada %s",
+	"backup %s score %.1f %d packets":     "copia %s puntuación %.1f %d paquetes",
+	"backup %s failed: %s":                "copia %s falló: %s",
+	"no packets stored for %s":            "sin paquetes guardados durante %s",
+	"recording resumed after %s":          "grabación reanudada tras %s",
+}
@@ -0,0 +1,60 @@
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Language of the UI texts, English texts are used as keys and returned
+// when there is no translation.
+var Language = "en"
+
+var tables = map[string]map[string]string{
+	"es": spanish,
+}
+
+// T returns the translation of an English UI text.
+func T(text string) string {
+	if table, ok := tables[Language]; ok {
+		if translated, ok := table[text]; ok {
+			return translated
+		}
+	}
+	return text
+}
+
+// Sprintf formats with the translated format string.
+func Sprintf(format string, a ...interface{}) string {
+	return fmt.Sprintf(T(format), a...)
+}
+
+func Languages() []string {
+	languages := []string{"en"}
+	for lang := range tables {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// SetLanguage takes a language code or locale like es_ES.UTF-8, an empty
+// value uses the LANG environment variable.
+func SetLanguage(lang string) error {
+	if lang == "" {
+		lang = os.Getenv("LANG")
+		if lang == "" || lang == "C" || lang == "POSIX" {
+			return nil
+		}
+	}
+	code := strings.ToLower(lang)
+	if i := strings.IndexAny(code, "_-."); i >= 0 {
+		code = code[:i]
+	}
+	if _, ok := tables[code]; !ok && code != "en" {
+		return fmt.Errorf("no translation for %s, available: %s", lang, strings.Join(Languages(), " "))
+	}
+	Language = code
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+// MacroAction is one recorded UI action. Keys cover switching products,
+// zooming and screenshots, jumps are stored relative to the time of replay
+// so a macro can be used again on the next day.
+type MacroAction struct {
+	Kind string           `json:"kind"` // key or jump
+	Key  glfw.Key         `json:"key,omitempty"`
+	Mods glfw.ModifierKey `json:"mods,omitempty"`
+	Ago  time.Duration    `json:"ago,omitempty"`
+}
+
+// Macros records sequences of actions into the slots 4-9. shift+slot starts
+// and stops recording, slot replays.
+type Macros struct {
+	Path  string
+	Slots map[string][]*MacroAction
+
+	recording string
+	actions   []*MacroAction
+	replaying bool
+}
+
+var macroSlots = map[glfw.Key]string{
+	glfw.Key4: "4",
+	glfw.Key5: "5",
+	glfw.Key6: "6",
+	glfw.Key7: "7",
+	glfw.Key8: "8",
+	glfw.Key9: "9",
+}
+
+func NewMacros(path string) *Macros {
+	m := &Macros{Path: path, Slots: map[string][]*MacroAction{}}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return m
+	}
+	if err := json.Unmarshal(buf, &m.Slots); err != nil {
+		fmt.Println("macros", path, err)
+	}
+	return m
+}
+
+func (m *Macros) save() {
+	buf, err := json.MarshalIndent(m.Slots, "", "  ")
+	if err != nil {
+		fmt.Println("macros", err)
+		return
+	}
+	if err := ioutil.WriteFile(m.Path, buf, 0644); err != nil {
+		fmt.Println("macros", err)
+	}
+}
+
+// HandleKey starts, stops or replays a macro and tells if key was one of
+// the slots. Other keys are recorded while a recording is running.
+func (m *Macros) HandleKey(window *Window, key glfw.Key, mods glfw.ModifierKey) bool {
+	slot, ok := macroSlots[key]
+	if !ok {
+		if m.recording != "" && !m.replaying && key != glfw.KeyEscape {
+			m.actions = append(m.actions, &MacroAction{Kind: "key", Key: key, Mods: mods})
+		}
+		return false
+	}
+
+	if mods&glfw.ModShift != 0 {
+		if m.recording == "" {
+			m.recording = slot
+			m.actions = []*MacroAction{}
+			fmt.Println("macro", slot, "recording")
+		} else {
+			fmt.Println("macro", m.recording, "saved with", len(m.actions), "actions")
+			m.Slots[m.recording] = m.actions
+			m.recording = ""
+			m.actions = nil
+			m.save()
+		}
+		return true
+	}
+
+	if m.recording != "" || m.replaying {
+		return true
+	}
+	m.Replay(window, slot)
+	return true
+}
+
+// Jumped records a jump to t in the history.
+func (m *Macros) Jumped(t time.Time) {
+	if m.recording != "" && !m.replaying {
+		m.actions = append(m.actions, &MacroAction{Kind: "jump", Ago: time.Since(t)})
+	}
+}
+
+func (m *Macros) Replay(window *Window, slot string) {
+	actions, ok := m.Slots[slot]
+	if !ok {
+		fmt.Println("macro", slot, "is empty")
+		return
+	}
+	m.replaying = true
+	defer func() { m.replaying = false }()
+
+	for _, a := range actions {
+		switch a.Kind {
+		case "key":
+			keyCallback(window, a.Key, glfw.Press, a.Mods)
+		case "jump":
+			jumpTo(time.Now().Add(-a.Ago))
+		}
+	}
+}
+
+// jumpTo moves all visible graphs to t. When the active product is in a
+// watch group only the group jumps, the other graphs stay where they are.
+func jumpTo(t time.Time) {
+	if bm, ok := bookmaps[ActiveProduct]; ok && bm.Watch != nil && bm.Graph != nil {
+		group := bm.Watch
+		at := t.Add(bm.Window())
+		group.Seek(at, time.Now())
+		for _, product := range group.Products {
+			bookmaps[product].ShowUntil(at)
+		}
+		return
+	}
+	for _, info := range infos {
+		if visible(info) {
+			bookmaps[info.DatabaseKey].JumpTo(t)
+		}
+	}
+}
+
+// TakeScreenshot writes the visible graphs as png files into dir.
+func TakeScreenshot(dir string) {
+	now := time.Now().UTC().Format("20060102-150405")
+	for _, info := range infos {
+		if !visible(info) {
+			continue
+		}
+		bm := bookmaps[info.DatabaseKey]
+		bm.Render()
+		path := filepath.Join(dir, fmt.Sprintf("screenshot-%s-%s.png", strings.ToLower(info.DatabaseKey), now))
+		if err := writePNG(path, bm.Image); err != nil {
+			fmt.Println("screenshot", err)
+			continue
+		}
+		fmt.Println("screenshot", path)
+	}
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
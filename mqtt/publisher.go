@@ -0,0 +1,162 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+type BBO struct {
+	Product string    `json:"product"`
+	Time    time.Time `json:"time"`
+	Bid     float64   `json:"bid"`
+	BidSize float64   `json:"bid_size"`
+	Ask     float64   `json:"ask"`
+	AskSize float64   `json:"ask_size"`
+}
+
+type Trade struct {
+	Product string    `json:"product"`
+	Time    time.Time `json:"time"`
+	Side    string    `json:"side"`
+	Price   float64   `json:"price"`
+	Size    float64   `json:"size"`
+}
+
+type Alert struct {
+	Product string    `json:"product"`
+	Time    time.Time `json:"time"`
+	Label   string    `json:"label"`
+}
+
+// Publisher replays the packets stored by the recorder and publishes
+//
+//	<topic>/<product>/bbo    best bid and offer when it changed (retained)
+//	<topic>/<product>/trade  every trade (retained)
+//	<topic>/<product>/alert  bookmarks, e.g. large trades
+type Publisher struct {
+	Client   *Client
+	Topic    string
+	Products []string
+
+	books  map[string]*orderbook.Book
+	synced map[string]bool
+	bbo    map[string]BBO
+}
+
+func NewPublisher(client *Client, topic string, products []string) *Publisher {
+	return &Publisher{
+		Client:   client,
+		Topic:    strings.TrimSuffix(topic, "/"),
+		Products: products,
+		books:    map[string]*orderbook.Book{},
+		synced:   map[string]bool{},
+		bbo:      map[string]BBO{},
+	}
+}
+
+func (p *Publisher) Run() {
+	topics := []string{}
+	for _, product := range p.Products {
+		p.books[product] = orderbook.New(product)
+		topics = append(topics, util.ProductTopics(product)...)
+		topics = append(topics, util.AlertTopic(product))
+	}
+
+	for {
+		sub := util.Events.Subscribe(topics, 4096)
+		for pkt := range sub.C {
+			if !p.Client.Connected() {
+				// drop messages while the broker is away
+				continue
+			}
+			if err := p.handle(pkt); err != nil {
+				fmt.Println("mqtt publish", err)
+			}
+		}
+		// too slow, wait for the next sync of every book
+		fmt.Println("mqtt publisher fell behind, resubscribing")
+		for product := range p.synced {
+			p.synced[product] = false
+		}
+	}
+}
+
+// Connect keeps the broker connection up.
+func (p *Publisher) Connect() {
+	for {
+		if !p.Client.Connected() {
+			if err := p.Client.Connect(); err != nil {
+				fmt.Println("mqtt connect", err)
+			} else {
+				fmt.Println("mqtt connected to", p.Client.Addr)
+			}
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (p *Publisher) publishJSON(product, kind string, v interface{}, retain bool) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return p.Client.Publish(fmt.Sprintf("%s/%s/%s", p.Topic, product, kind), payload, retain)
+}
+
+func (p *Publisher) handle(pkt *util.Event) error {
+	t := orderbook.UnpackTimeKey(pkt.Key)
+
+	if strings.HasPrefix(pkt.Topic, "alert.") {
+		product := strings.TrimPrefix(pkt.Topic, "alert.")
+		return p.publishJSON(product, "alert", &Alert{Product: product, Time: t, Label: string(pkt.Data)}, false)
+	}
+
+	book, ok := p.books[pkt.Bucket]
+	if !ok || len(pkt.Data) == 0 {
+		return nil
+	}
+	if orderbook.IsSyncPacket(pkt.Data) {
+		p.synced[pkt.Bucket] = true
+	}
+	if !p.synced[pkt.Bucket] {
+		return nil
+	}
+
+	if !book.Process(t, pkt.Data) {
+		p.synced[pkt.Bucket] = false
+		return nil
+	}
+
+	if pkt.Data[0] == orderbook.TradePacket && len(book.Trades) > 0 {
+		trade := book.Trades[len(book.Trades)-1]
+		// the book side the trade happened on
+		side := "bid"
+		if trade.Side == orderbook.AskSide {
+			side = "ask"
+		}
+		msg := &Trade{Product: pkt.Bucket, Time: t, Side: side, Price: trade.Price, Size: trade.Quantity}
+		if err := p.publishJSON(pkt.Bucket, "trade", msg, true); err != nil {
+			return err
+		}
+	}
+
+	// the retained bbo stays at the last full quote while a side is empty
+	bid, ask := book.BestBid(), book.BestAsk()
+	if bid == nil || ask == nil {
+		return nil
+	}
+	bbo := BBO{Product: pkt.Bucket, Bid: bid.Price, BidSize: bid.Quantity, Ask: ask.Price, AskSize: ask.Quantity}
+	last := p.bbo[pkt.Bucket]
+	last.Time = time.Time{}
+	if bbo == last {
+		return nil
+	}
+	p.bbo[pkt.Bucket] = bbo
+	bbo.Time = t
+	return p.publishJSON(pkt.Bucket, "bbo", &bbo, true)
+}
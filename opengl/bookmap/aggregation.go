@@ -0,0 +1,112 @@
+package bookmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultAggregations are the price ladder presets of products without
+// their own.
+const DefaultAggregations = "1t,5t,0.1%,0.5%"
+
+// Aggregation is a price ladder preset, rows of a number of ticks or of a
+// percentage of the current price.
+type Aggregation struct {
+	Name    string
+	Ticks   float64
+	Percent float64
+}
+
+// ParseAggregation takes presets like 5t (5 ticks) or 0.1% (of the price).
+func ParseAggregation(value string) (*Aggregation, error) {
+	value = strings.TrimSpace(value)
+	a := &Aggregation{Name: value}
+	var err error
+	switch {
+	case strings.HasSuffix(value, "%"):
+		a.Percent, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	case strings.HasSuffix(value, "t"):
+		a.Ticks, err = strconv.ParseFloat(strings.TrimSuffix(value, "t"), 64)
+	default:
+		err = fmt.Errorf("missing t or %% suffix")
+	}
+	if err != nil || a.Ticks < 0 || a.Percent < 0 || (a.Ticks == 0 && a.Percent == 0) {
+		return nil, fmt.Errorf("bad aggregation %q, expected e.g. 5t or 0.1%%", value)
+	}
+	return a, nil
+}
+
+func ParseAggregations(list string) ([]*Aggregation, error) {
+	aggregations := []*Aggregation{}
+	for _, value := range strings.Split(list, ",") {
+		a, err := ParseAggregation(value)
+		if err != nil {
+			return nil, err
+		}
+		aggregations = append(aggregations, a)
+	}
+	return aggregations, nil
+}
+
+// LoadAggregations reads the presets of products from a json file like
+// {"GDAX-BTC-USD": "1t,10t,0.1%"}.
+func LoadAggregations(path string) (map[string][]*Aggregation, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lists := map[string]string{}
+	if err := json.Unmarshal(buf, &lists); err != nil {
+		return nil, err
+	}
+	presets := map[string][]*Aggregation{}
+	for product, list := range lists {
+		if presets[product], err = ParseAggregations(list); err != nil {
+			return nil, fmt.Errorf("%s: %s", product, err)
+		}
+	}
+	return presets, nil
+}
+
+// Steps returns the row height at price, percentages are rounded to whole
+// ticks.
+func (a *Aggregation) Steps(tick, price float64) float64 {
+	if a.Ticks > 0 {
+		return a.Ticks * tick
+	}
+	steps := price * a.Percent / 100
+	if tick > 0 {
+		steps = math.Max(tick, math.Round(steps/tick)*tick)
+	}
+	return steps
+}
+
+// NextAggregation switches to the next preset of the product and returns
+// it, or nil when the product has no presets or no price yet.
+func (s *Bookmap) NextAggregation() *Aggregation {
+	if len(s.Aggregations) == 0 || s.Graph == nil {
+		return nil
+	}
+	price := s.Graph.Book.CenterPrice()
+	if price == 0 {
+		return nil
+	}
+	s.AggregationIndex = (s.AggregationIndex + 1) % len(s.Aggregations)
+	a := s.Aggregations[s.AggregationIndex]
+	s.PriceSteps = a.Steps(float64(s.ProductInfo.QuoteIncrement), price)
+	s.Graph.ClearSlotRows()
+	s.ForceAutoScroll()
+	return a
+}
+
+// aggregationName is the active preset, empty after zooming by hand.
+func (s *Bookmap) aggregationName() string {
+	if s.AggregationIndex < 0 || s.AggregationIndex >= len(s.Aggregations) {
+		return ""
+	}
+	return s.Aggregations[s.AggregationIndex].Name
+}
@@ -0,0 +1,60 @@
+package bookmap
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// marks are the annotations of the graph, compared to save only changes
+type marks struct {
+	impact     time.Time
+	impactSize float64
+	highlight  util.StoredTrade
+}
+
+func (s *Bookmap) marks() marks {
+	m := marks{}
+	if s.Impact != nil {
+		m.impact = s.Impact.Time
+		m.impactSize = s.ImpactSize
+	}
+	if s.Highlight != nil {
+		m.highlight = *s.Highlight
+	}
+	return m
+}
+
+// RestoreAnnotations shows the marks saved with the data again.
+func (s *Bookmap) RestoreAnnotations(a *util.Annotations) {
+	if a.Highlight != nil {
+		s.Highlight = a.Highlight
+	}
+	if !a.Impact.IsZero() {
+		if a.ImpactSize > 0 {
+			s.ImpactSize = a.ImpactSize
+		}
+		s.estimateImpactAt(a.Impact)
+	}
+	s.savedMarks = s.marks()
+}
+
+// SaveAnnotations stores a new revision of the annotations when the marks
+// changed since the last call.
+func (s *Bookmap) SaveAnnotations() {
+	m := s.marks()
+	if m == s.savedMarks {
+		return
+	}
+	err := util.UpdateAnnotations(s.DB, s.ProductInfo.DatabaseKey, func(a *util.Annotations) {
+		a.Impact = m.impact
+		a.ImpactSize = m.impactSize
+		a.Highlight = s.Highlight
+	})
+	if err != nil {
+		fmt.Println("save annotations", s.ProductInfo.DatabaseKey, err)
+		return
+	}
+	s.savedMarks = m
+}
@@ -0,0 +1,210 @@
+package bookmap
+
+import (
+	"math"
+	"sort"
+
+	"github.com/lian/gdax-bookmap/features"
+)
+
+// off auto center follows the center of the book again
+var candleRange = features.Register("render.candle-range", "follow the recent candles with auto center instead of every move of the book", true)
+
+const (
+	// slots per candle and candles the price axis follows
+	rangeCandleSlots = 10
+	rangeCandles     = 30
+	// candle highs and lows further than this many median deviations from
+	// their median are taken for bad prints
+	rangeOutlierDeviations = 5.0
+	// share of the rows the center of the range may be off the center of
+	// the axis before it recenters, and the share of the distance moved
+	// per progress
+	rangeDeadBand   = 0.2
+	rangeRecenterBy = 0.25
+	// share of the rows the range is kept between with AutoRange
+	rangeMaxFill = 0.8
+	rangeMinFill = 0.2
+)
+
+type candle struct {
+	Low, High float64
+}
+
+// candles returns the recent candles of the graph, oldest first, from the
+// traded prices and the best bid and ask of its slots.
+func (g *Graph) candles() []candle {
+	candles := []candle{}
+	var current candle
+	slots := 0
+	for idx := len(g.Timeslots) - 1; idx >= 0 && len(candles) < rangeCandles; idx -= 1 {
+		stats := g.Timeslots[idx].Stats
+		if stats == nil {
+			continue
+		}
+		low, high := math.Inf(1), math.Inf(-1)
+		bid, ask := math.Inf(-1), math.Inf(1)
+		for _, state := range stats.Bid {
+			if state.TradeSize > 0 {
+				low, high = math.Min(low, state.Price), math.Max(high, state.Price)
+			}
+			if state.Size > 0 {
+				bid = math.Max(bid, state.Price)
+			}
+		}
+		for _, state := range stats.Ask {
+			if state.TradeSize > 0 {
+				low, high = math.Min(low, state.Price), math.Max(high, state.Price)
+			}
+			if state.Size > 0 {
+				ask = math.Min(ask, state.Price)
+			}
+		}
+		if !math.IsInf(bid, -1) && !math.IsInf(ask, 1) {
+			low, high = math.Min(low, bid), math.Max(high, ask)
+		}
+		if math.IsInf(low, 1) {
+			continue
+		}
+		if slots == 0 {
+			current = candle{Low: low, High: high}
+		} else {
+			current.Low, current.High = math.Min(current.Low, low), math.Max(current.High, high)
+		}
+		slots += 1
+		if slots == rangeCandleSlots {
+			candles = append([]candle{current}, candles...)
+			slots = 0
+		}
+	}
+	if slots > 0 && len(candles) < rangeCandles {
+		candles = append([]candle{current}, candles...)
+	}
+	return candles
+}
+
+// PriceRange returns the low and high of the recent candles, ignoring the
+// highs and lows of fat finger prints far outside the others.
+func (g *Graph) PriceRange() (float64, float64, bool) {
+	candles := g.candles()
+	if len(candles) == 0 {
+		return 0, 0, false
+	}
+	lows, highs := make([]float64, len(candles)), make([]float64, len(candles))
+	for i, c := range candles {
+		lows[i], highs[i] = c.Low, c.High
+	}
+	return robustMin(lows), robustMax(highs), true
+}
+
+// median and median absolute deviation
+func medianDeviation(values []float64) (float64, float64) {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	deviations := make([]float64, len(sorted))
+	for i, v := range sorted {
+		deviations[i] = math.Abs(v - median)
+	}
+	sort.Float64s(deviations)
+	return median, deviations[len(deviations)/2]
+}
+
+func robustMin(values []float64) float64 {
+	median, deviation := medianDeviation(values)
+	min := median
+	for _, v := range values {
+		if v >= median-rangeOutlierDeviations*deviation {
+			min = math.Min(min, v)
+		}
+	}
+	return min
+}
+
+func robustMax(values []float64) float64 {
+	median, deviation := medianDeviation(values)
+	max := median
+	for _, v := range values {
+		if v <= median+rangeOutlierDeviations*deviation {
+			max = math.Max(max, v)
+		}
+	}
+	return max
+}
+
+func (s *Bookmap) rowsCount() float64 {
+	return s.graphHeight() / s.RowHeight
+}
+
+// centerPrice is the price in the middle of the axis.
+func (s *Bookmap) centerPrice() float64 {
+	return s.PriceScrollPosition - float64(s.rowsCount()/2)*s.PriceSteps
+}
+
+// centerOn puts price into the middle row.
+func (s *Bookmap) centerOn(price float64) {
+	last := s.PriceScrollPosition
+	s.PriceScrollPosition = (price - math.Mod(price, s.PriceSteps)) + (float64(s.rowsCount()/2) * s.PriceSteps)
+	if last != s.PriceScrollPosition && s.Graph != nil {
+		s.Graph.ClearSlotRows()
+	}
+}
+
+// LockPrice toggles the manual lock of the price axis at its current
+// center, which only the w/s keys move then. Zooming keeps the center.
+func (s *Bookmap) LockPrice() {
+	s.PriceLock = !s.PriceLock
+	s.lockedPrice = s.centerPrice()
+}
+
+// ScrollPrice moves the axis by rows, up for positive rows.
+func (s *Bookmap) ScrollPrice(rows int) {
+	s.PriceScrollPosition += float64(rows) * s.PriceSteps
+	s.lockedPrice += float64(rows) * s.PriceSteps
+	if s.Graph != nil {
+		s.Graph.ClearSlotRows()
+	}
+}
+
+// followRange recenters the axis on the recent candles once they moved out
+// of the dead band around the center, a part of the way per call so the
+// heatmap glides instead of jumping. With AutoRange it also zooms so the
+// candles keep between rangeMinFill and rangeMaxFill of the rows.
+func (s *Bookmap) followRange() {
+	low, high, ok := s.Graph.PriceRange()
+	if !ok {
+		s.ForceAutoScroll()
+		return
+	}
+	// the book may have moved on from the last candle
+	if price := s.Graph.Book.CenterPrice(); price != 0 {
+		low, high = math.Min(low, price), math.Max(high, price)
+	}
+
+	rows := s.rowsCount()
+	if s.AutoRange && !s.PercentAxis {
+		fill := (high - low) / (rows * s.PriceSteps)
+		increment := float64(s.ProductInfo.QuoteIncrement)
+		zoomed := false
+		if fill > rangeMaxFill {
+			s.PriceSteps *= 2
+			zoomed = true
+		} else if fill < rangeMinFill && s.PriceSteps/2 >= increment {
+			s.PriceSteps /= 2
+			zoomed = true
+		}
+		if zoomed {
+			s.AggregationIndex = -1
+			s.centerOn((low + high) / 2)
+			return
+		}
+	}
+
+	target := (low + high) / 2
+	distance := target - s.centerPrice()
+	if math.Abs(distance) < rangeDeadBand*rows*s.PriceSteps {
+		return
+	}
+	step := math.Max(math.Abs(distance)*rangeRecenterBy, s.PriceSteps)
+	s.centerOn(s.centerPrice() + math.Copysign(step, distance) + s.PriceSteps/2)
+}
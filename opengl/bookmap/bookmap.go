@@ -10,7 +10,11 @@ import (
 	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/opengl/palette"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
 	font "github.com/lian/gonky/font/terminus"
 
 	"github.com/lian/gonky/shader"
@@ -19,6 +23,8 @@ import (
 	"github.com/llgcode/draw2d/draw2dkit"
 )
 
+const tapeGaugeWindow = 10 * time.Second
+
 type Bookmap struct {
 	ID                  string
 	ProductInfo         product_info.Info
@@ -35,22 +41,77 @@ type Bookmap struct {
 	StatusImage         *image.RGBA
 	GraphImage          *image.RGBA
 	StatsImage          *image.RGBA
+	MinimapImage        *image.RGBA
+	Minimap             *Minimap
+	MinimapHeight       float64
+	MinimapUpdated      time.Time
+	Live                bool
+	Bookmarks           []*util.Bookmark
+	BookmarkIndex       int
+	BookmarksUpdated    time.Time
+	ShowBookmarks       bool
+	Maintenance         []*util.MaintenanceWindow
+	MaintenanceUpdated  time.Time
+	External            []*util.ExternalEvent
+	ExternalUpdated     time.Time
+	ShowFlow            bool
+	FlowHeight          float64
+	ShowTape            bool
+	TapeHeight          float64
 	IgnoreTexture       bool
 	ShowDebug           bool
 	AutoHistoSize       bool
 	AutoScroll          bool
+	Aggregations        []*Aggregation
+	AggregationIndex    int
+	Capabilities        *common.Capabilities
+	Liquidity           *Liquidity
+	LiquidityUpdated    time.Time
+	LiquidityImage      *image.RGBA
+	Impact              *Impact
+	// size of the market orders estimated with a right click
+	ImpactSize float64
+	// shares the heatmap intensity with other products, nil on its own
+	Norm *NormGroup
+	// replays the history together with other products, nil on its own
+	Watch *WatchGroup
+	// trade selected in the trades panel, see highlight.go
+	Highlight *util.StoredTrade
+	// label rows in percent of RefPrice, see percent.go
+	PercentAxis  bool
+	RefPrice     float64
+	percentSteps float64
+	// zoom the price axis with the recent candles, see autorange.go
+	AutoRange bool
+	// keep the price axis where it was put by hand, see LockPrice
+	PriceLock   bool
+	lockedPrice float64
+	// position while the timeline is dragged, zero otherwise
+	Scrub time.Time
+	// replay position to start the graph at, see RestoreState
+	resume     time.Time
+	saved      util.UIState
+	savedMarks marks
 }
 
 func New(program *shader.Program, width, height float64, x float64, info product_info.Info, db *bolt.DB) *Bookmap {
 	s := &Bookmap{
-		ID:           info.ID,
-		ProductInfo:  info,
-		DB:           db,
-		RowHeight:    14,
-		ColumnWidth:  4,
-		ViewportStep: 1,
-		ShowDebug:    true,
-		AutoScroll:   true,
+		ID:            info.ID,
+		ProductInfo:   info,
+		Capabilities:  common.CapabilitiesOf(info.Platform),
+		DB:            db,
+		RowHeight:     14,
+		ColumnWidth:   4,
+		ViewportStep:  1,
+		ShowDebug:     true,
+		AutoScroll:    true,
+		Live:          true,
+		MinimapHeight: 40,
+		FlowHeight:    60,
+		TapeHeight:    40,
+		ImpactSize:    1,
+		// no preset active until cycled
+		AggregationIndex: -1,
 		Texture: &texture.Texture{
 			X:      x,
 			Y:      height + 10,
@@ -64,12 +125,20 @@ func New(program *shader.Program, width, height float64, x float64, info product
 		s.Texture.Setup(program)
 	}
 	s.Image = image.NewRGBA(image.Rect(0, 0, int(s.Texture.Width), int(s.Texture.Height)))
-	s.GraphImage = image.NewRGBA(image.Rect(0, 0, int(s.Texture.Width-145), int(s.Texture.Height-s.RowHeight)))
-	s.StatsImage = image.NewRGBA(image.Rect(0, 0, int(145), int(s.Texture.Height-s.RowHeight)))
+	s.GraphImage = image.NewRGBA(image.Rect(0, 0, int(s.Texture.Width-145), int(s.graphHeight())))
+	s.StatsImage = image.NewRGBA(image.Rect(0, 0, int(145), int(s.graphHeight())))
 	s.StatusImage = image.NewRGBA(image.Rect(0, 0, int(s.Texture.Width), int(s.RowHeight)))
+	s.MinimapImage = image.NewRGBA(image.Rect(0, 0, int(s.Texture.Width-145), int(s.MinimapHeight)))
+	s.Minimap = NewMinimap(db, info.DatabaseKey, int(s.Texture.Width-145), int(s.MinimapHeight))
+	s.Liquidity = NewLiquidity(db, info.DatabaseKey, 7)
+	s.LiquidityImage = image.NewRGBA(image.Rect(0, 0, int(145), int(s.MinimapHeight)))
 	return s
 }
 
+func (s *Bookmap) graphHeight() float64 {
+	return s.Texture.Height - s.RowHeight - s.MinimapHeight
+}
+
 // ugly af
 func round(k float64, precision int) float64 {
 	format := fmt.Sprintf("%%.%df", precision)
@@ -83,7 +152,12 @@ func (s *Bookmap) ForceAutoScroll() {
 		return
 	}
 
-	rowsCount := s.Texture.Height / s.RowHeight
+	if s.PriceLock {
+		s.centerOn(s.lockedPrice)
+		return
+	}
+
+	rowsCount := s.graphHeight() / s.RowHeight
 
 	last := s.PriceScrollPosition
 
@@ -98,11 +172,15 @@ func (s *Bookmap) ForceAutoScroll() {
 }
 
 func (s *Bookmap) DoAutoScroll() {
-	if !s.AutoScroll {
+	if s.Graph == nil || !s.AutoScroll && !s.PriceLock {
+		return
+	}
+	if s.PriceLock || s.PercentAxis || !candleRange.On() {
+		s.ForceAutoScroll()
 		return
 	}
 
-	s.ForceAutoScroll()
+	s.followRange()
 }
 
 func (s *Bookmap) WriteTexture() {
@@ -120,21 +198,127 @@ func (s *Bookmap) Progress() bool {
 	now := time.Now()
 
 	if s.Graph == nil {
-		graph := NewGraph(s.DB, s.ProductInfo.DatabaseKey, int(s.Texture.Width-145), int(s.Texture.Height-s.RowHeight), int(s.ColumnWidth), int(s.ViewportStep))
-		if graph.SetStart(now) {
+		graph := NewGraph(s.DB, s.ProductInfo.DatabaseKey, int(s.Texture.Width-145), int(s.graphHeight()), int(s.ColumnWidth), int(s.ViewportStep))
+		if s.startGraph(graph, now) {
 			s.Graph = graph
 		}
 		return false
 	}
 
+	s.updateRefPrice()
 	s.DoAutoScroll()
 
+	if !s.Live {
+		end := s.Graph.Start.Add(s.Window())
+		// the graph can't go back, a group behind it waits
+		if s.Watch != nil && s.Watch.Playing() {
+			if at := s.Watch.Position(now); at.After(end) {
+				end = at
+			}
+		}
+		if end.Before(now) {
+			return s.Graph.SetEnd(end)
+		}
+		s.Live = true
+	}
+
 	return s.Graph.SetEnd(now)
 }
 
+// JumpTo moves the viewport to start at t and stops following live data.
+func (s *Bookmap) JumpTo(t time.Time) {
+	if s.Graph == nil {
+		return
+	}
+	if s.Graph.SetStart(t) {
+		s.Live = false
+		s.resetRefPrice()
+		s.ForceAutoScroll()
+	}
+}
+
+// Window is how much time the graph shows.
+func (s *Bookmap) Window() time.Duration {
+	return time.Duration(s.Graph.SlotSteps*s.Graph.SlotCount) * time.Second
+}
+
+// ShowUntil moves the viewport to end at t, for the replay of a watch
+// group.
+func (s *Bookmap) ShowUntil(t time.Time) {
+	if s.Graph == nil {
+		return
+	}
+	s.JumpTo(t.Add(-s.Window()))
+}
+
+func (s *Bookmap) GoLive() {
+	if s.Graph == nil || s.Live {
+		return
+	}
+	start := time.Now().Add(time.Duration((s.Graph.SlotSteps*s.Graph.SlotCount)*-1) * time.Second)
+	if s.Graph.SetStart(start) {
+		s.Live = true
+		s.resetRefPrice()
+	}
+}
+
+// MinimapTimeAt returns the point in history for a click at x, y relative
+// to the top left corner of the texture, if the click hit the minimap.
+func (s *Bookmap) MinimapTimeAt(x, y float64) (time.Time, bool) {
+	if y < s.Texture.Height-s.MinimapHeight || x < 0 || x > float64(s.Minimap.Width) {
+		return time.Time{}, false
+	}
+	return s.Minimap.TimeAt(x), true
+}
+
+// ScrubTo follows a drag of the timeline to t without moving the graph,
+// the sync packets ahead of the drag are prefetched so the release point
+// renders right away.
+func (s *Bookmap) ScrubTo(t time.Time) {
+	if s.Graph == nil {
+		return
+	}
+	from := s.Scrub
+	if from.IsZero() {
+		from = s.Graph.Start
+	}
+	s.Scrub = t
+	s.Graph.Syncs.Prefetch(s.DB, s.ProductInfo.DatabaseKey, t, !t.Before(from))
+}
+
+// EndScrub returns the release point of the drag.
+func (s *Bookmap) EndScrub() time.Time {
+	t := s.Scrub
+	s.Scrub = time.Time{}
+	return t
+}
+
+func (s *Bookmap) UpdateMinimap(now time.Time) {
+	if now.Sub(s.MinimapUpdated).Seconds() < 60.0 {
+		return
+	}
+	s.MinimapUpdated = now
+	go s.Minimap.Update()
+}
+
+func (s *Bookmap) DrawMinimap() {
+	bg1 := palette.Current().Bg
+	fg1 := palette.Current().Fg
+
+	img := s.MinimapImage
+	from, to := s.Graph.Start, s.Graph.End
+	if !s.Scrub.IsZero() {
+		from, to = s.Scrub, s.Scrub.Add(to.Sub(from))
+	}
+	s.Minimap.Draw(img, from, to, bg1, fg1, s.Graph.Red)
+
+	b := image.Rect(0, int(s.Texture.Height-s.MinimapHeight), s.Minimap.Width, int(s.Texture.Height))
+	draw.Draw(s.Image, b, img, img.Bounds().Min, draw.Src)
+}
+
 func (s *Bookmap) DrawGraph() {
-	bg1 := color.RGBA{0x15, 0x23, 0x2c, 0xff}
-	fg1 := color.RGBA{0xdd, 0xdf, 0xe1, 0xff}
+	bg1 := palette.Current().Bg
+	fg1 := palette.Current().Fg
 
 	//img := image.NewRGBA(image.Rect(0, 0, int(s.Graph.Width), int(s.Graph.Height)))
 	img := s.GraphImage
@@ -151,9 +335,24 @@ func (s *Bookmap) DrawGraph() {
 	x := float64(s.Graph.Width)
 	rowCount := ((float64(s.Graph.Height) - s.RowHeight) / s.RowHeight)
 	s.Graph.DrawTimeslots(gc, x, rowCount, s.RowHeight, s.PriceScrollPosition, s.PriceSteps, s.MaxSizeHisto)
+	s.DrawMaintenance(gc, img, x, rowCount*s.RowHeight)
 	s.Graph.DrawTradeDots(gc, x, s.RowHeight, s.PriceScrollPosition, s.PriceSteps, s.MaxSizeHisto)
 	s.Graph.DrawBidAskLines(img, x, s.RowHeight, s.PriceScrollPosition, s.PriceSteps)
+	s.Graph.DrawMarkLine(img, x, s.RowHeight, s.PriceScrollPosition, s.PriceSteps)
+	strip := (rowCount - 1) * s.RowHeight
+	if s.ShowFlow {
+		strip -= s.FlowHeight
+		s.Graph.DrawFlow(gc, x, strip, s.FlowHeight)
+	}
+	if s.ShowTape {
+		strip -= s.TapeHeight
+		s.Graph.DrawTape(gc, x, strip, s.TapeHeight)
+	}
 	s.Graph.DrawTimeline(gc, img, x, rowCount*s.RowHeight)
+	s.DrawExternal(gc, img, x, rowCount*s.RowHeight)
+	s.DrawBookmarks(gc, img, x, rowCount*s.RowHeight)
+	s.DrawImpact(gc, img, x, rowCount*s.RowHeight)
+	s.DrawHighlight(gc, img, x)
 
 	b := image.Rect(0, int(s.RowHeight), int(s.Graph.Width), int(s.Graph.Height)+int(s.RowHeight))
 	draw.Draw(s.Image, b, img, img.Bounds().Min, draw.Src)
@@ -167,10 +366,10 @@ func (s *Bookmap) DrawGraphStats() {
 	stats := s.Graph.Book.StateAsStats()
 	statsSlot.Fill(stats)
 
-	fg1 := color.RGBA{0xdd, 0xdf, 0xe1, 0xff}
-	bg1 := color.RGBA{0x15, 0x23, 0x2c, 0xff}
-	green := color.RGBA{0x4d, 0xa5, 0x3c, 0xff}
-	red := color.RGBA{0xff, 0x69, 0x39, 0xff}
+	fg1 := palette.Current().Fg
+	bg1 := palette.Current().Bg
+	green := palette.Current().BidText
+	red := palette.Current().Ask
 
 	//img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
 	img := s.StatsImage
@@ -195,7 +394,7 @@ func (s *Bookmap) DrawGraphStats() {
 
 	var y float64
 	//xx := x + 1 + 70 // 20 = font width
-	xx := x + 4 + (float64(len(s.ProductInfo.FormatFloat(statsSlot.Rows[0].Heigh))) * font.Width) + (2 * font.Width)
+	xx := x + 4 + (float64(len(s.axisLabel(statsSlot.Rows[0].Heigh))) * font.Width) + (2 * font.Width)
 
 	fontPad := int((s.RowHeight - font.Height) / 2.0)
 	for n, row := range statsSlot.Rows {
@@ -232,8 +431,27 @@ func (s *Bookmap) DrawGraphStats() {
 				size := 2 + (float64(width) * (row.Size / (statsSlot.MaxSize)))
 				draw2dkit.Rectangle(gc, float64(x+1), y, float64(x+1)+size, y+s.RowHeight)
 				gc.Fill()
+
+				// queue composition, recorded or estimated, one tick per
+				// order boundary
+				if len(row.Queue) > 1 {
+					gc.SetStrokeColor(bg1)
+					gc.SetLineWidth(1.0)
+					var queued float64
+					for _, order := range row.Queue[:len(row.Queue)-1] {
+						queued += order
+						xq := float64(x+1) + 2 + (float64(width) * (queued / statsSlot.MaxSize))
+						gc.MoveTo(xq, y+2)
+						gc.LineTo(xq, y+s.RowHeight-2)
+						gc.Stroke()
+					}
+				}
+			}
+			if s.Graph.EstimateQueues && s.Graph.Book.Orders == nil {
+				font.DrawString(img, int(xx), int(y)+fontPad, fmt.Sprintf("%.2f ~%d", row.Size, len(row.Queue)), fg1)
+			} else {
+				font.DrawString(img, int(xx), int(y)+fontPad, fmt.Sprintf("%.2f (%d)", row.Size, row.OrderCount), fg1)
 			}
-			font.DrawString(img, int(xx), int(y)+fontPad, fmt.Sprintf("%.2f (%d)", row.Size, row.OrderCount), fg1)
 		}
 
 		/*
@@ -244,7 +462,7 @@ func (s *Bookmap) DrawGraphStats() {
 		*/
 
 		//if math.Mod(float64(n), 2) == 0 {
-		font.DrawString(img, int(x+4), int(y)+fontPad, s.ProductInfo.FormatFloat(row.Heigh), fg1)
+		font.DrawString(img, int(x+4), int(y)+fontPad, s.axisLabel(row.Heigh), fg1)
 		//}
 	}
 
@@ -259,14 +477,26 @@ func (s *Bookmap) Render() {
 		return
 	}
 
+	now := time.Now()
+	max := s.Graph.MaxHistoSize()
+	if s.Norm != nil {
+		max = s.Norm.Max(s.ProductInfo.DatabaseKey, max, now)
+	}
 	if s.MaxSizeHisto == 0 || s.AutoHistoSize {
-		s.MaxSizeHisto = round(s.Graph.MaxHistoSize()*0.60, 0)
+		s.MaxSizeHisto = round(max*0.60, 0)
 	}
 
+	s.LoadBookmarks(now)
+	s.LoadMaintenance(now)
+	s.LoadExternal(now)
+
 	s.DrawGraph()
 	s.DrawGraphStats()
 
-	now := time.Now()
+	s.UpdateMinimap(now)
+	s.DrawMinimap()
+	s.UpdateLiquidity(now)
+	s.DrawLiquidity()
 	s.DrawStatus(now)
 
 	s.WriteTexture()
@@ -277,25 +507,69 @@ func (s *Bookmap) DrawStatus(now time.Time) {
 	img := s.StatusImage
 	gc := draw2dimg.NewGraphicContext(img)
 
-	bg1 := color.RGBA{0x15, 0x23, 0x2c, 0xff}
-	fg1 := color.RGBA{0xdd, 0xdf, 0xe1, 0xff}
+	bg1 := palette.Current().Bg
+	fg1 := palette.Current().Fg
 
 	gc.SetFillColor(bg1)
 	draw2dkit.Rectangle(gc, 0, 0, s.Texture.Width, s.RowHeight)
 	gc.Fill()
 
-	text := fmt.Sprintf(
-		"%s %s   PriceSteps %s MaxSizeHisto %.2f ColumnWidth %.0f ViewportStep %d time-diff %s",
+	mode := i18n.T("LIVE")
+	if !s.Live {
+		mode = i18n.T("HISTORY")
+	}
+	if s.Graph.ColorByAge {
+		mode += " " + i18n.T("AGE")
+	}
+	if name := s.aggregationName(); name != "" {
+		mode += " " + name
+	}
+	if s.Capabilities.MaxDepth > 0 {
+		// the venue sends no deeper levels, a wide view is not the full book
+		mode += " " + i18n.Sprintf("TOP %d", s.Capabilities.MaxDepth)
+	}
+	if common.Sandboxed(s.ProductInfo.Platform) {
+		mode += " " + i18n.T("SANDBOX")
+	}
+	if s.PercentAxis && s.RefPrice != 0 {
+		mode += " " + i18n.Sprintf("%% OF %s", s.ProductInfo.FormatFloat(s.RefPrice))
+	}
+	if s.PriceLock {
+		mode += " " + i18n.T("LOCKED")
+	} else if s.AutoRange && s.AutoScroll {
+		mode += " " + i18n.T("AUTO RANGE")
+	}
+	if s.Watch != nil && s.Watch.Playing() && !s.Live {
+		mode += " " + i18n.Sprintf("REPLAY %gx", s.Watch.Speed())
+	}
+	if s.InMaintenance(now) {
+		mode += " " + i18n.T("MAINTENANCE")
+	}
+
+	text := i18n.Sprintf(
+		"%s %s %s   PriceSteps %s MaxSizeHisto %.2f ColumnWidth %.0f ViewportStep %d time-diff %s trades p50 %.4f p99 %.4f",
 		s.ProductInfo.DatabaseKey,
+		mode,
 		s.ProductInfo.FormatFloat(s.Graph.Book.LastPrice()),
 		s.ProductInfo.FormatFloat(s.PriceSteps),
 		s.MaxSizeHisto,
 		s.ColumnWidth,
 		s.ViewportStep,
 		now.Sub(s.Graph.CurrentTime),
+		s.Graph.Book.TradeSizes.Percentile(50),
+		s.Graph.Book.TradeSizes.Percentile(99),
 	)
 
+	// speed of the tape over the last seconds of the graph
+	trades, volume := s.Graph.Book.Tape.Rate(s.Graph.CurrentTime, tapeGaugeWindow)
+	text += "   " + i18n.Sprintf("tape %.1f trades/s %.4f/s", trades, volume)
+	// the mark of the replayed book, in step with it
+	if mark := markStatus(s.Graph.Book, s.ProductInfo.FormatFloat, s.Graph.CurrentTime); mark != "" {
+		text += "   " + mark
+	}
+
 	font.DrawString(img, 10, 2, text, fg1)
+	s.DrawClock(img, now)
 	b := image.Rect(0, 0, int(s.Texture.Width), int(s.RowHeight))
 	draw.Draw(s.Image, b, img, img.Bounds().Min, draw.Src)
 }
@@ -0,0 +1,120 @@
+package bookmap
+
+import (
+	"image"
+	"time"
+
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/util"
+	font "github.com/lian/gonky/font/terminus"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dkit"
+)
+
+func (s *Bookmap) LoadBookmarks(now time.Time) {
+	if now.Sub(s.BookmarksUpdated).Seconds() < 10.0 {
+		return
+	}
+	s.BookmarksUpdated = now
+	s.Bookmarks = util.ListBookmarks(s.DB, s.ProductInfo.DatabaseKey)
+	if s.BookmarkIndex >= len(s.Bookmarks) {
+		s.BookmarkIndex = len(s.Bookmarks) - 1
+	}
+}
+
+// ViewportCenter returns the time in the middle of the visible graph, or
+// the current time when following live data.
+func (s *Bookmap) ViewportCenter() time.Time {
+	if s.Graph == nil || s.Live {
+		return time.Now()
+	}
+	return s.Graph.Start.Add(s.Graph.End.Sub(s.Graph.Start) / 2)
+}
+
+func (s *Bookmap) AddBookmark(label string) {
+	t := s.ViewportCenter()
+	if err := util.AddBookmark(s.DB, s.ProductInfo.DatabaseKey, t, label); err != nil {
+		return
+	}
+	s.BookmarksUpdated = time.Time{}
+	s.LoadBookmarks(time.Now())
+	for i, bookmark := range s.Bookmarks {
+		if bookmark.Time.Equal(t) {
+			s.BookmarkIndex = i
+		}
+	}
+}
+
+// SelectBookmark moves the selection by offset and returns the start of a
+// viewport which has the selected bookmark in its center.
+func (s *Bookmap) SelectBookmark(offset int) (time.Time, bool) {
+	if s.Graph == nil || len(s.Bookmarks) == 0 {
+		return time.Time{}, false
+	}
+
+	s.BookmarkIndex += offset
+	if s.BookmarkIndex < 0 {
+		s.BookmarkIndex = 0
+	}
+	if s.BookmarkIndex >= len(s.Bookmarks) {
+		s.BookmarkIndex = len(s.Bookmarks) - 1
+	}
+
+	span := time.Duration(s.Graph.SlotSteps*s.Graph.SlotCount) * time.Second
+	return s.Bookmarks[s.BookmarkIndex].Time.Add(-span / 2), true
+}
+
+func (s *Bookmap) DrawBookmarks(gc *draw2dimg.GraphicContext, img *image.RGBA, x, height float64) {
+	if len(s.Graph.Timeslots) == 0 {
+		return
+	}
+	last := s.Graph.Timeslots[len(s.Graph.Timeslots)-1].To
+	yellow := palette.Current().Mark
+
+	gc.SetLineWidth(1.0)
+	gc.SetStrokeColor(yellow)
+	for _, bookmark := range s.Bookmarks {
+		if bookmark.Time.Before(s.Graph.Start) || bookmark.Time.After(last) {
+			continue
+		}
+		xx := x - ((last.Sub(bookmark.Time).Seconds() / float64(s.Graph.SlotSteps)) * float64(s.Graph.SlotWidth))
+		gc.MoveTo(xx, 0)
+		gc.LineTo(xx, height)
+		gc.Stroke()
+	}
+
+	if !s.ShowBookmarks {
+		return
+	}
+
+	lineHeight := font.Height + 2
+	rows := (int(height) / lineHeight) - 1
+	first := 0
+	if s.BookmarkIndex >= rows {
+		first = s.BookmarkIndex - rows + 1
+	}
+	visible := s.Bookmarks[first:]
+	if len(visible) > rows {
+		visible = visible[:rows]
+	}
+
+	width := float64(font.Width * 50)
+	left := x - width - 10
+
+	panel := palette.Current().Bg
+	panel.A = 0xee
+	gc.SetFillColor(panel)
+	draw2dkit.Rectangle(gc, left, 0, x, float64((len(visible)+1)*lineHeight)+4)
+	gc.Fill()
+
+	font.DrawString(img, int(left)+4, 2, i18n.T("bookmarks"), s.Graph.Fg1)
+	for i, bookmark := range visible {
+		fg := s.Graph.Fg1
+		if first+i == s.BookmarkIndex {
+			fg = yellow
+		}
+		text := bookmark.Time.Format("01-02 15:04:05") + " " + bookmark.Label
+		font.DrawString(img, int(left)+4, 2+((i+1)*lineHeight), text, fg)
+	}
+}
@@ -0,0 +1,36 @@
+package bookmap
+
+import (
+	"image"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	font "github.com/lian/gonky/font/terminus"
+)
+
+// badges turn the ask color when messages arrive later than this
+const slowLatency = time.Second
+
+// DrawClock draws a badge at the right end of the status line with the
+// venue time of the newest column, estimated from the clock of the venue,
+// and while live how late its messages arrive and how far the graph is
+// behind. Platforms without event times in their messages get none.
+func (s *Bookmap) DrawClock(img *image.RGBA, now time.Time) {
+	clock := common.Clocks.Stats(s.ProductInfo.Platform)
+	if clock == nil {
+		return
+	}
+	text := i18n.Sprintf("venue %s", clock.VenueTime(s.Graph.CurrentTime).UTC().Format("15:04:05.000"))
+	color := palette.Current().Fg
+	if s.Live {
+		lag := now.Sub(s.Graph.CurrentTime)
+		text += " " + i18n.Sprintf("rx %s skew %s lag %s",
+			clock.Latency.Round(time.Millisecond), clock.Skew.Round(time.Millisecond), lag.Round(100*time.Millisecond))
+		if clock.Latency > slowLatency || now.Sub(clock.Received) > slowLatency*10 {
+			color = palette.Current().Ask
+		}
+	}
+	font.DrawString(img, int(s.Texture.Width)-10-len(text)*font.Width, 2, text, color)
+}
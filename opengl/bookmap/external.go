@@ -0,0 +1,62 @@
+package bookmap
+
+import (
+	"image"
+	"time"
+
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/util"
+	font "github.com/lian/gonky/font/terminus"
+	"github.com/llgcode/draw2d/draw2dimg"
+)
+
+// characters of an external event title drawn next to its marker
+const externalTitleLength = 32
+
+func (s *Bookmap) LoadExternal(now time.Time) {
+	if now.Sub(s.ExternalUpdated).Seconds() < 10.0 {
+		return
+	}
+	s.ExternalUpdated = now
+	s.External = []*util.ExternalEvent{}
+	for _, e := range util.ListExternalEvents(s.DB, time.Time{}, now.Add(24*time.Hour)) {
+		if e.AppliesTo(s.ProductInfo.DatabaseKey, s.ProductInfo.BaseCurrency) {
+			s.External = append(s.External, e)
+		}
+	}
+}
+
+// DrawExternal marks the events posted to the webhook with a line across
+// the graph and their title, in rows at the bottom so close events stay
+// readable.
+func (s *Bookmap) DrawExternal(gc *draw2dimg.GraphicContext, img *image.RGBA, x, height float64) {
+	if len(s.Graph.Timeslots) == 0 {
+		return
+	}
+	last := s.Graph.Timeslots[len(s.Graph.Timeslots)-1].To
+	fg := palette.Current().Age
+
+	gc.SetLineWidth(1.0)
+	gc.SetStrokeColor(fg)
+	row := 0
+	for _, e := range s.External {
+		if e.Time.Before(s.Graph.Start) || e.Time.After(last) {
+			continue
+		}
+		xx := x - ((last.Sub(e.Time).Seconds() / float64(s.Graph.SlotSteps)) * float64(s.Graph.SlotWidth))
+		gc.MoveTo(xx, 0)
+		gc.LineTo(xx, height)
+		gc.Stroke()
+
+		title := e.Title
+		if len(title) > externalTitleLength {
+			title = title[:externalTitleLength-1] + "~"
+		}
+		if e.Source != "" {
+			title = e.Source + ": " + title
+		}
+		y := int(height) - (row%3+1)*(font.Height+2)
+		font.DrawString(img, int(xx)+3, y, title, fg)
+		row += 1
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/opengl/palette"
 	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
 )
 
 type Graph struct {
@@ -31,26 +33,53 @@ type Graph struct {
 	Fg1         color.RGBA
 	CurrentSlot *TimeSlot
 	NoTimeout   bool
+	// estimate order queues at each level (market-by-order)
+	EstimateQueues bool
+	// color levels by how long liquidity rested there instead of size
+	ColorByAge  bool
+	MaxLevelAge time.Duration
+	Age         color.RGBA
+	// mark price line of futures
+	Dim color.RGBA
+	// sync packets prefetched while the timeline is dragged
+	Syncs *SyncCache
 }
 
 func NewGraph(db *bolt.DB, productID string, width, height, slotWidth, slotSteps int) *Graph {
 	g := &Graph{
-		ProductID: productID,
-		DB:        db,
-		Width:     width,
-		Height:    height,
-		SlotWidth: slotWidth,
-		SlotCount: width / slotWidth,
-		SlotSteps: slotSteps,
-		Red:       color.RGBA{0xff, 0x69, 0x39, 0xff},
-		Green:     color.RGBA{0x84, 0xf7, 0x66, 0xff},
-		Bg1:       color.RGBA{0x15, 0x23, 0x2c, 0xff},
-		Fg1:       color.RGBA{0xdd, 0xdf, 0xe1, 0xff},
-		Book:      orderbook.New(productID),
+		ProductID:   productID,
+		DB:          db,
+		Width:       width,
+		Height:      height,
+		SlotWidth:   slotWidth,
+		SlotCount:   width / slotWidth,
+		SlotSteps:   slotSteps,
+		MaxLevelAge: 10 * time.Minute,
+		Book:        orderbook.New(productID),
+		Syncs:       NewSyncCache(),
 	}
+	g.SetPalette(palette.Current())
 	return g
 }
 
+func (g *Graph) SetPalette(p *palette.Palette) {
+	g.Red = p.Ask
+	g.Green = p.Bid
+	g.Bg1 = p.Bg
+	g.Fg1 = p.Fg
+	g.Age = p.Age
+	g.Dim = p.Dim
+}
+
+func (g *Graph) SetEstimateQueues(enabled bool) {
+	g.EstimateQueues = enabled
+	if enabled {
+		g.Book.Queues = orderbook.NewQueueEstimator()
+	} else {
+		g.Book.Queues = nil
+	}
+}
+
 func (g *Graph) MaxHistoSize() float64 {
 	var max float64
 	for _, slot := range g.Timeslots {
@@ -68,15 +97,16 @@ func (g *Graph) ClearSlotRows() {
 }
 
 func (g *Graph) SetStart(start time.Time) bool {
-	var err error
-	g.Start = RoundTime(start, g.SlotSteps)
+	start = RoundTime(start, g.SlotSteps)
 
-	g.CurrentTime, g.Book, err = g.FetchBook(g.Start)
+	currentTime, book, err := g.FetchBook(start)
 	if err != nil {
-		g.Book = nil
 		fmt.Println("ERROR", "SetStart", err)
 		return false
 	}
+	g.Start = start
+	g.CurrentTime = currentTime
+	g.Book = book
 	g.Timeslots = make([]*TimeSlot, 0, g.SlotCount)
 
 	return true
@@ -155,7 +185,7 @@ func (g *Graph) ProcessTimeslots() {
 	processingStart := time.Now()
 
 	g.DB.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(g.ProductID)).Cursor()
+		c := util.NewCursor(g.DB, tx.Bucket([]byte(g.ProductID)))
 
 		c.Seek(orderbook.PackTimeKey(g.CurrentTime))
 		for {
@@ -236,22 +266,31 @@ func (g *Graph) FetchBook(from time.Time) (time.Time, *orderbook.Book, error) {
 	//fmt.Println("Begin FetchBook")
 	var err error
 	book := orderbook.New(g.ProductID)
+	if g.EstimateQueues {
+		book.Queues = orderbook.NewQueueEstimator()
+	}
 	startKey := orderbook.PackTimeKey(from)
 
 	g.DB.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(g.ProductID)).Cursor()
+		c := util.NewCursor(g.DB, tx.Bucket([]byte(g.ProductID)))
 
 		first := true
 		var key, buf []byte
-		for key, buf = c.Seek(startKey); !bytes.HasPrefix(buf, []byte("\x00")); key, buf = c.Prev() {
-			if first == false && key == nil {
-				err = errors.New(fmt.Sprintf("FetchBook %s no sync key found", g.ProductID))
-				return nil
+		if cached := g.Syncs.nearest(startKey); cached != nil {
+			key, buf = cached.key, cached.data
+			c.Seek(key)
+		} else {
+			for key, buf = c.Seek(startKey); !orderbook.IsSyncPacket(buf); key, buf = c.Prev() {
+				if first == false && key == nil {
+					err = errors.New(fmt.Sprintf("FetchBook %s no sync key found", g.ProductID))
+					return nil
+				}
+				first = false
 			}
-			first = false
 		}
 
-		// apply sync packet
+		// apply sync packet, with the mark price it was taken at
+		book.Mark, book.MarkTime = util.MarkBefore(g.DB, tx.Bucket([]byte(g.ProductID)), key)
 		book.Process(orderbook.UnpackTimeKey(key), buf)
 		LastProcessedKey := []byte(string(key))
 
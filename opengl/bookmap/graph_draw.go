@@ -4,6 +4,7 @@ import (
 	"image"
 	"image/color"
 	"math"
+	"time"
 
 	font "github.com/lian/gonky/font/terminus"
 	"github.com/llgcode/draw2d/draw2dimg"
@@ -34,6 +35,33 @@ func DrawCircle(gc *draw2dimg.GraphicContext, color color.RGBA, x, y, size float
 	gc.Fill()
 }
 
+// tradeDotScale maps a traded size to 0..1, from the median trade size of the
+// product to its 99th percentile. Falls back to the histogram size until
+// enough trades were seen.
+func (g *Graph) tradeDotScale(size, maxSizeHisto float64) float64 {
+	if g.Book.TradeSizes.Count() >= 50 {
+		return g.Book.TradeSizes.Scale(size, 50, 99)
+	}
+	t := (size / (maxSizeHisto * 0.8))
+	if t > 1.0 {
+		t = 1.0
+	}
+	return t
+}
+
+// ageStrength maps a level age to 0..1 on a log scale, so both seconds old
+// and long resting liquidity stay distinguishable.
+func (g *Graph) ageStrength(age time.Duration) float64 {
+	if age <= 0 {
+		return 0
+	}
+	strength := math.Log1p(age.Seconds()) / math.Log1p(g.MaxLevelAge.Seconds())
+	if strength > 1.0 {
+		strength = 1.0
+	}
+	return strength
+}
+
 func (g *Graph) DrawTradeDots(gc *draw2dimg.GraphicContext, x, rowHeight, pricePosition, priceSteps, maxSizeHisto float64) {
 	var xx, y float64
 
@@ -54,21 +82,13 @@ func (g *Graph) DrawTradeDots(gc *draw2dimg.GraphicContext, x, rowHeight, priceP
 
 		if slot.AskTradeSize != 0 {
 			y = ((pricePosition - slot.AskPrice) / priceSteps) * rowHeight
-			t := (slot.AskTradeSize / (maxSizeHisto * 0.8))
-			if t > 1.0 {
-				t = 1.0
-			}
-			size := 4 + float64(t*15)
+			size := 4 + float64(g.tradeDotScale(slot.AskTradeSize, maxSizeHisto)*15)
 			DrawCircle(gc, g.Green, xx, y, size)
 		}
 
 		if slot.BidTradeSize != 0 {
 			y = ((pricePosition - slot.BidPrice) / priceSteps) * rowHeight
-			t := (slot.BidTradeSize / (maxSizeHisto * 0.8))
-			if t > 1.0 {
-				t = 1.0
-			}
-			size := 4 + float64(t*15)
+			size := 4 + float64(g.tradeDotScale(slot.BidTradeSize, maxSizeHisto)*15)
 			DrawCircle(gc, g.Red, xx, y, size)
 		}
 	}
@@ -186,6 +206,16 @@ func (g *Graph) DrawTimeslots(gc *draw2dimg.GraphicContext, x, rowsCount, rowHei
 		x2 = x + float64(g.SlotWidth)
 
 		for i, row := range slot.Rows {
+			if g.ColorByAge {
+				if row.Size > 0 && !row.FirstSeen.IsZero() {
+					y = float64(i) * rowHeight
+					draw2dkit.Rectangle(gc, x, y, x2, y+rowHeight)
+					gc.SetFillColor(colourGradientor(g.ageStrength(slot.To.Sub(row.FirstSeen)), g.Age, g.Bg1))
+					gc.Fill()
+				}
+				continue
+			}
+
 			strength := (row.Size / maxSizeHisto)
 			if strength > 0 {
 				y = float64(i) * rowHeight
@@ -197,6 +227,108 @@ func (g *Graph) DrawTimeslots(gc *draw2dimg.GraphicContext, x, rowsCount, rowHei
 	}
 }
 
+func fillRect(gc *draw2dimg.GraphicContext, c color.RGBA, x1, y1, x2, y2 float64) {
+	draw2dkit.Rectangle(gc, x1, y1, x2, y2)
+	gc.SetFillColor(c)
+	gc.Fill()
+}
+
+// DrawFlow draws the size removed from the book per timeslot into a strip,
+// bids upwards and asks downwards from its middle. Filled liquidity is
+// drawn solid next to the middle, pulled liquidity dimmed on top of it.
+func (g *Graph) DrawFlow(gc *draw2dimg.GraphicContext, x, top, height float64) {
+	var max float64
+	for _, slot := range g.Timeslots {
+		if slot.noStats() {
+			continue
+		}
+		max = math.Max(max, math.Max(slot.Stats.Flow.Bid.Total(), slot.Stats.Flow.Ask.Total()))
+	}
+
+	fillRect(gc, g.Bg1, 0, top, x, top+height)
+	if max == 0 {
+		return
+	}
+
+	middle := top + (height / 2)
+	scale := (height / 2) / max
+	pulledGreen := colourGradientor(0.4, g.Green, g.Bg1)
+	pulledRed := colourGradientor(0.4, g.Red, g.Bg1)
+
+	for idx := len(g.Timeslots) - 1; idx > 0; idx-- {
+		x -= float64(g.SlotWidth)
+		if x < 0 {
+			break
+		}
+
+		slot := g.Timeslots[idx]
+		if slot.noStats() {
+			continue
+		}
+		flow := slot.Stats.Flow
+		x2 := x + float64(g.SlotWidth)
+
+		filled, pulled := flow.Bid.Filled*scale, flow.Bid.Pulled*scale
+		fillRect(gc, g.Green, x, middle-filled, x2, middle)
+		fillRect(gc, pulledGreen, x, middle-filled-pulled, x2, middle-filled)
+
+		filled, pulled = flow.Ask.Filled*scale, flow.Ask.Pulled*scale
+		fillRect(gc, g.Red, x, middle, x2, middle+filled)
+		fillRect(gc, pulledRed, x, middle+filled, x2, middle+filled+pulled)
+	}
+}
+
+// DrawTape draws the volume per second of every timeslot as bars into a
+// strip and the trades per second as a line over them.
+func (g *Graph) DrawTape(gc *draw2dimg.GraphicContext, x, top, height float64) {
+	var maxVolume, maxTrades float64
+	for _, slot := range g.Timeslots {
+		if slot.noStats() {
+			continue
+		}
+		seconds := slot.To.Sub(slot.From).Seconds()
+		maxVolume = math.Max(maxVolume, slot.Stats.Tape.Volume/seconds)
+		maxTrades = math.Max(maxTrades, float64(slot.Stats.Tape.Trades)/seconds)
+	}
+
+	fillRect(gc, g.Bg1, 0, top, x, top+height)
+	if maxVolume == 0 {
+		return
+	}
+
+	bottom := top + height
+	bar := colourGradientor(0.4, g.Fg1, g.Bg1)
+	gc.SetLineWidth(1.0)
+	gc.SetStrokeColor(g.Fg1)
+	var line bool
+
+	for idx := len(g.Timeslots) - 1; idx > 0; idx-- {
+		x -= float64(g.SlotWidth)
+		if x < 0 {
+			break
+		}
+
+		slot := g.Timeslots[idx]
+		if slot.noStats() {
+			continue
+		}
+		seconds := slot.To.Sub(slot.From).Seconds()
+		x2 := x + float64(g.SlotWidth)
+		fillRect(gc, bar, x, bottom-(height*(slot.Stats.Tape.Volume/seconds)/maxVolume), x2, bottom)
+
+		y := bottom - (height * (float64(slot.Stats.Tape.Trades) / seconds) / maxTrades)
+		if line {
+			gc.LineTo(x2, y)
+		} else {
+			gc.MoveTo(x2, y)
+			line = true
+		}
+	}
+	if line {
+		gc.Stroke()
+	}
+}
+
 func (g *Graph) DrawTimeline(gc *draw2dimg.GraphicContext, image *image.RGBA, x, y float64) {
 	for idx := len(g.Timeslots) - 1; idx > 0; idx-- {
 		slot := g.Timeslots[idx]
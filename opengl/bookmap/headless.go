@@ -0,0 +1,77 @@
+package bookmap
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	font "github.com/lian/gonky/font/terminus"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dkit"
+)
+
+// NewHeadless returns a Bookmap which only draws into Image, it needs no
+// window or GL context.
+func NewHeadless(width, height float64, info product_info.Info, db *bolt.DB) *Bookmap {
+	s := New(nil, width, height, 0, info, db)
+	s.IgnoreTexture = true
+	return s
+}
+
+// RenderView draws the recorded history from start to end into Image, with
+// the time zoom chosen so the whole range fits the graph.
+func (s *Bookmap) RenderView(start, end time.Time) bool {
+	slots := int(s.Texture.Width-145) / int(s.ColumnWidth)
+	s.ViewportStep = (int(end.Sub(start).Seconds()) + slots - 1) / slots
+	if s.ViewportStep <= 0 {
+		s.ViewportStep = 1
+	}
+
+	graph := NewGraph(s.DB, s.ProductInfo.DatabaseKey, int(s.Texture.Width-145), int(s.graphHeight()), int(s.ColumnWidth), s.ViewportStep)
+	graph.NoTimeout = true
+	if !graph.SetStart(start) {
+		return false
+	}
+	s.Graph = graph
+	s.Live = false
+	if !graph.SetEnd(end) {
+		return false
+	}
+	s.ForceAutoScroll()
+	s.MaxSizeHisto = round(graph.MaxHistoSize()*0.60, 0)
+
+	s.LoadBookmarks(end)
+	s.LoadMaintenance(end)
+	s.LoadExternal(end)
+	s.Minimap.Update()
+
+	s.DrawGraph()
+	s.DrawGraphStats()
+	s.DrawMinimap()
+	s.DrawStatus(end)
+	return true
+}
+
+// Annotate writes lines of text into the top left corner of the graph.
+func (s *Bookmap) Annotate(lines ...string) {
+	gc := draw2dimg.NewGraphicContext(s.Image)
+	gc.SetFillColor(palette.Current().Bg)
+	draw2dkit.Rectangle(gc, 0, s.RowHeight, 8+float64(maxLen(lines)*6), s.RowHeight*float64(len(lines)+1))
+	gc.Fill()
+
+	fg := palette.Current().Fg
+	for i, line := range lines {
+		font.DrawString(s.Image, 4, int(s.RowHeight)*(i+1)+2, line, fg)
+	}
+}
+
+func maxLen(lines []string) int {
+	n := 0
+	for _, line := range lines {
+		if len(line) > n {
+			n = len(line)
+		}
+	}
+	return n
+}
@@ -0,0 +1,63 @@
+package bookmap
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"time"
+
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/util"
+	font "github.com/lian/gonky/font/terminus"
+	"github.com/llgcode/draw2d/draw2dimg"
+)
+
+// ShowTrade moves the graph so the stored trade at t and price is in its
+// center and marks it, e.g. for a trade clicked in the trades panel.
+func (s *Bookmap) ShowTrade(t time.Time, price float64) bool {
+	if s.Graph == nil {
+		return false
+	}
+	trade, err := util.LocateTrade(s.DB, s.ProductInfo.DatabaseKey, t, price)
+	if err != nil {
+		fmt.Println("show trade", err)
+		return false
+	}
+	if trade == nil {
+		return false
+	}
+	s.Highlight = trade
+
+	span := time.Duration(s.Graph.SlotSteps*s.Graph.SlotCount) * time.Second
+	s.JumpTo(trade.Time.Add(-span / 2))
+
+	// keep the print centered instead of the center of the book
+	rowsCount := s.graphHeight() / s.RowHeight
+	s.AutoScroll = false
+	s.PriceScrollPosition = (trade.Price - math.Mod(trade.Price, s.PriceSteps)) + (float64(rowsCount/2) * s.PriceSteps)
+	s.Graph.ClearSlotRows()
+	return true
+}
+
+// DrawHighlight rings the trade of ShowTrade.
+func (s *Bookmap) DrawHighlight(gc *draw2dimg.GraphicContext, img *image.RGBA, x float64) {
+	if s.Highlight == nil || len(s.Graph.Timeslots) == 0 {
+		return
+	}
+	last := s.Graph.Timeslots[len(s.Graph.Timeslots)-1].To
+	if s.Highlight.Time.Before(s.Graph.Start) || s.Highlight.Time.After(last) {
+		return
+	}
+	xx := x - ((last.Sub(s.Highlight.Time).Seconds() / float64(s.Graph.SlotSteps)) * float64(s.Graph.SlotWidth))
+	y := ((s.PriceScrollPosition - s.Highlight.Price) / s.PriceSteps) * s.RowHeight
+	mark := palette.Current().Mark
+
+	gc.SetLineWidth(2.0)
+	gc.SetStrokeColor(mark)
+	gc.ArcTo(xx, y, 12, 12, 0, 2*math.Pi)
+	gc.Close()
+	gc.Stroke()
+
+	label := fmt.Sprintf("%.4f @ %s", s.Highlight.Size, s.ProductInfo.FormatFloat(s.Highlight.Price))
+	font.DrawString(img, int(xx)+16, int(y)-font.Height/2, label, mark)
+}
@@ -0,0 +1,124 @@
+package bookmap
+
+import (
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+	font "github.com/lian/gonky/font/terminus"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dkit"
+)
+
+// Impact is what a market order of ImpactSize would have cost at a point
+// of the graph.
+type Impact struct {
+	Time time.Time
+	Buy  *orderbook.FillEstimate
+	Sell *orderbook.FillEstimate
+}
+
+// timeAt returns the time of column x of the graph.
+func (s *Bookmap) timeAt(x float64) (time.Time, bool) {
+	if s.Graph == nil || len(s.Graph.Timeslots) == 0 || x < 0 || x > float64(s.Graph.Width) {
+		return time.Time{}, false
+	}
+	last := s.Graph.Timeslots[len(s.Graph.Timeslots)-1].To
+	seconds := ((float64(s.Graph.Width) - x) / float64(s.Graph.SlotWidth)) * float64(s.Graph.SlotSteps)
+	return last.Add(-time.Duration(seconds * float64(time.Second))), true
+}
+
+// EstimateImpact estimates a buy and a sell of ImpactSize against the book
+// at the column clicked, x and y relative to the top left corner of the
+// texture. A second click on the same column removes it.
+func (s *Bookmap) EstimateImpact(x, y float64) bool {
+	if y < s.RowHeight || y > s.RowHeight+float64(s.Graph.Height) {
+		return false
+	}
+	t, ok := s.timeAt(x)
+	if !ok {
+		return false
+	}
+	column := time.Duration(s.Graph.SlotSteps) * time.Second
+	if s.Impact != nil && t.Sub(s.Impact.Time) < column && s.Impact.Time.Sub(t) < column {
+		s.Impact = nil
+		return true
+	}
+
+	return s.estimateImpactAt(t)
+}
+
+func (s *Bookmap) estimateImpactAt(t time.Time) bool {
+	book, _, err := util.BookAt(s.DB, s.ProductInfo.DatabaseKey, t)
+	if err != nil {
+		fmt.Println("impact", err)
+		return false
+	}
+	s.Impact = &Impact{
+		Time: t,
+		Buy:  book.MarketOrder(true, s.ImpactSize),
+		Sell: book.MarketOrder(false, s.ImpactSize),
+	}
+	return true
+}
+
+func (s *Bookmap) impactLine(e *orderbook.FillEstimate) string {
+	if e == nil {
+		return i18n.T("n/a")
+	}
+	text := i18n.Sprintf("%s %.4f avg %s %+.1fbps %d levels", i18n.T(e.Side), e.Size, s.ProductInfo.FormatFloat(e.AvgPrice), e.Slippage, e.Levels)
+	if e.Partial {
+		text += " " + i18n.Sprintf("only %.4f", e.Filled)
+	}
+	return text
+}
+
+// DrawImpact marks the column of the estimate and prints it next to it.
+func (s *Bookmap) DrawImpact(gc *draw2dimg.GraphicContext, img *image.RGBA, x, height float64) {
+	if s.Impact == nil || len(s.Graph.Timeslots) == 0 {
+		return
+	}
+	last := s.Graph.Timeslots[len(s.Graph.Timeslots)-1].To
+	if s.Impact.Time.Before(s.Graph.Start) || s.Impact.Time.After(last) {
+		return
+	}
+	xx := x - ((last.Sub(s.Impact.Time).Seconds() / float64(s.Graph.SlotSteps)) * float64(s.Graph.SlotWidth))
+	fg := s.Graph.Fg1
+
+	gc.SetLineWidth(1.0)
+	gc.SetStrokeColor(fg)
+	gc.MoveTo(xx, 0)
+	gc.LineTo(xx, height)
+	gc.Stroke()
+
+	lines := []string{
+		s.Impact.Time.Format("01-02 15:04:05"),
+		s.impactLine(s.Impact.Buy),
+		s.impactLine(s.Impact.Sell),
+	}
+	lineHeight := font.Height + 2
+	width := 0
+	for _, line := range lines {
+		if w := len(line) * font.Width; w > width {
+			width = w
+		}
+	}
+	// right of the line unless it would leave the graph
+	left := xx + 4
+	if left+float64(width)+8 > x {
+		left = xx - float64(width) - 12
+	}
+
+	panel := palette.Current().Bg
+	panel.A = 0xee
+	gc.SetFillColor(panel)
+	draw2dkit.Rectangle(gc, left, 0, left+float64(width)+8, float64(len(lines)*lineHeight)+4)
+	gc.Fill()
+	for i, line := range lines {
+		font.DrawString(img, int(left)+4, 2+(i*lineHeight), line, fg)
+	}
+}
@@ -0,0 +1,203 @@
+package bookmap
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+	font "github.com/lian/gonky/font/terminus"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dkit"
+)
+
+// depth counts the size of both sides within this fraction of the mid price
+const liquidityDepthRange = 0.001
+
+// Liquidity keeps the percentiles of the spread and of the depth near the
+// mid price over the last Days of syncs, to tell whether the current book
+// is unusually thin.
+type Liquidity struct {
+	DB        *bolt.DB
+	ProductID string
+	Days      int
+	Spread    *orderbook.Percentiles
+	Depth     *orderbook.Percentiles
+	Updating  bool
+	mu        sync.Mutex
+}
+
+func NewLiquidity(db *bolt.DB, productID string, days int) *Liquidity {
+	return &Liquidity{DB: db, ProductID: productID, Days: days}
+}
+
+// nearMidDepth sums the size of the levels within liquidityDepthRange of the
+// mid price, returns the spread and the depth.
+func nearMidDepth(bids, asks []orderbook.OrderState) (float64, float64) {
+	var bestBid, bestAsk float64
+	for _, state := range bids {
+		if state.Size > 0 && state.Price > bestBid {
+			bestBid = state.Price
+		}
+	}
+	for _, state := range asks {
+		if state.Size > 0 && (bestAsk == 0 || state.Price < bestAsk) {
+			bestAsk = state.Price
+		}
+	}
+	if bestBid == 0 || bestAsk == 0 {
+		return 0, 0
+	}
+
+	mid := bestBid + ((bestAsk - bestBid) / 2)
+	low, high := mid*(1-liquidityDepthRange), mid*(1+liquidityDepthRange)
+	var depth float64
+	for _, list := range [][]orderbook.OrderState{bids, asks} {
+		for _, state := range list {
+			if state.Price >= low && state.Price <= high {
+				depth += state.Size
+			}
+		}
+	}
+	return bestAsk - bestBid, depth
+}
+
+// Update scans the syncs of the last Days, meant to be run in the
+// background.
+func (l *Liquidity) Update() {
+	l.mu.Lock()
+	if l.Updating {
+		l.mu.Unlock()
+		return
+	}
+	l.Updating = true
+	l.mu.Unlock()
+
+	spreads, depths := []float64{}, []float64{}
+	from := time.Now().Add(-time.Duration(l.Days) * 24 * time.Hour)
+	l.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(l.ProductID))
+		if b == nil {
+			return nil
+		}
+		c := util.NewCursor(l.DB, b)
+		for key, buf := c.Seek(orderbook.PackTimeKey(from)); key != nil; key, buf = c.Next() {
+			if !orderbook.IsSyncPacket(buf) {
+				continue
+			}
+			_, bids, asks := orderbook.UnpackSync(buf)
+			if spread, depth := nearMidDepth(bids, asks); spread > 0 {
+				spreads = append(spreads, spread)
+				depths = append(depths, depth)
+			}
+		}
+		return nil
+	})
+
+	var spread, depth *orderbook.Percentiles
+	if len(spreads) > 0 {
+		spread = orderbook.NewPercentiles(len(spreads))
+		depth = orderbook.NewPercentiles(len(depths))
+		for i := range spreads {
+			spread.Add(spreads[i])
+			depth.Add(depths[i])
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Updating = false
+	l.Spread = spread
+	l.Depth = depth
+}
+
+// Bands returns the percentiles for the current values, nil before the
+// first update or without recorded syncs.
+func (l *Liquidity) Bands() (*orderbook.Percentiles, *orderbook.Percentiles) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Spread, l.Depth
+}
+
+func (s *Bookmap) UpdateLiquidity(now time.Time) {
+	if s.Liquidity == nil || s.Liquidity.Days <= 0 || now.Sub(s.LiquidityUpdated) < time.Hour {
+		return
+	}
+	s.LiquidityUpdated = now
+	go s.Liquidity.Update()
+}
+
+// DrawLiquidity draws the current spread and near mid depth against their
+// p10-p90 band (with the p50 tick) next to the minimap. Values outside the
+// band on the thin side are drawn in the ask color.
+func (s *Bookmap) DrawLiquidity() {
+	if s.Liquidity == nil || s.Liquidity.Days <= 0 {
+		return
+	}
+	spreadBand, depthBand := s.Liquidity.Bands()
+
+	width, height := 145, int(s.MinimapHeight)
+	img := s.LiquidityImage
+	gc := draw2dimg.NewGraphicContext(img)
+	gc.SetFillColor(palette.Current().Bg)
+	draw2dkit.Rectangle(gc, 0, 0, float64(width), float64(height))
+	gc.Fill()
+
+	book := s.Graph.Book
+	bids := make([]orderbook.OrderState, 0, len(book.Bid))
+	asks := make([]orderbook.OrderState, 0, len(book.Ask))
+	for _, level := range book.Bid {
+		bids = append(bids, orderbook.OrderState{Price: level.Price, Size: level.Quantity})
+	}
+	for _, level := range book.Ask {
+		asks = append(asks, orderbook.OrderState{Price: level.Price, Size: level.Quantity})
+	}
+	spread, depth := nearMidDepth(bids, asks)
+
+	row := height / 2
+	s.drawBand(gc, img, 0, row, width, i18n.T("spread"), spread, spreadBand, spreadBand != nil && spread > spreadBand.Percentile(90))
+	s.drawBand(gc, img, row, row, width, i18n.T("depth"), depth, depthBand, depthBand != nil && depth < depthBand.Percentile(10))
+
+	b := image.Rect(s.Minimap.Width, int(s.Texture.Height-s.MinimapHeight), s.Minimap.Width+width, int(s.Texture.Height))
+	draw.Draw(s.Image, b, img, img.Bounds().Min, draw.Src)
+}
+
+func (s *Bookmap) drawBand(gc *draw2dimg.GraphicContext, img *image.RGBA, y, height, width int, label string, value float64, band *orderbook.Percentiles, thin bool) {
+	fg := palette.Current().Fg
+	font.DrawString(img, 4, y+(height-int(font.Height))/2, label, fg)
+	if band == nil || band.Count() == 0 {
+		return
+	}
+
+	x0, x1 := 4+(6*float64(font.Width)), float64(width-4)
+	max := math.Max(band.Percentile(99), value)
+	if max <= 0 {
+		return
+	}
+	scale := func(v float64) float64 { return x0 + ((x1 - x0) * math.Min(v/max, 1)) }
+	top, bottom := float64(y+3), float64(y+height-3)
+
+	draw2dkit.Rectangle(gc, scale(band.Percentile(10)), top, scale(band.Percentile(90)), bottom)
+	gc.SetFillColor(palette.Current().Dim)
+	gc.Fill()
+
+	gc.SetLineWidth(1.0)
+	gc.SetStrokeColor(fg)
+	gc.MoveTo(scale(band.Percentile(50)), top)
+	gc.LineTo(scale(band.Percentile(50)), bottom)
+	gc.Stroke()
+
+	marker := fg
+	if thin {
+		marker = palette.Current().Ask
+	}
+	draw2dkit.Rectangle(gc, scale(value)-1.5, top-2, scale(value)+1.5, bottom+2)
+	gc.SetFillColor(marker)
+	gc.Fill()
+}
@@ -0,0 +1,64 @@
+package bookmap
+
+import (
+	"image"
+	"image/color"
+	"time"
+
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/util"
+	font "github.com/lian/gonky/font/terminus"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dkit"
+)
+
+func (s *Bookmap) LoadMaintenance(now time.Time) {
+	if now.Sub(s.MaintenanceUpdated).Seconds() < 10.0 {
+		return
+	}
+	s.MaintenanceUpdated = now
+	s.Maintenance = util.ListMaintenance(s.DB, s.ProductInfo.Platform)
+}
+
+func (s *Bookmap) InMaintenance(t time.Time) bool {
+	for _, w := range s.Maintenance {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// DrawMaintenance covers the time the venue was in maintenance with a band,
+// there is no data to show for it.
+func (s *Bookmap) DrawMaintenance(gc *draw2dimg.GraphicContext, img *image.RGBA, x, height float64) {
+	if len(s.Graph.Timeslots) == 0 {
+		return
+	}
+	last := s.Graph.Timeslots[len(s.Graph.Timeslots)-1].To
+	label := i18n.T("venue in maintenance")
+
+	for _, w := range s.Maintenance {
+		end := w.End
+		if end.IsZero() || end.After(last) {
+			end = last
+		}
+		if end.Before(s.Graph.Start) || w.Start.After(last) {
+			continue
+		}
+		start := w.Start
+		if start.Before(s.Graph.Start) {
+			start = s.Graph.Start
+		}
+
+		x1 := x - ((last.Sub(start).Seconds() / float64(s.Graph.SlotSteps)) * float64(s.Graph.SlotWidth))
+		x2 := x - ((last.Sub(end).Seconds() / float64(s.Graph.SlotSteps)) * float64(s.Graph.SlotWidth))
+		gc.SetFillColor(color.RGBA{0x60, 0x60, 0x60, 0x80})
+		draw2dkit.Rectangle(gc, x1, 0, x2, height)
+		gc.Fill()
+
+		if x2-x1 > float64(len(label)*font.Width)+8 {
+			font.DrawString(img, int(x1)+4, 2, label, s.Graph.Fg1)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package bookmap
+
+import (
+	"image"
+	"time"
+
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/llgcode/draw2d/draw2dimg"
+)
+
+// mark prices further apart than this are not interpolated, the feed was
+// down in between
+const markGap = time.Minute
+
+type markPoint struct {
+	Time  time.Time
+	Price float64
+}
+
+// markPoints returns the mark prices published up to the timeslots, in
+// order. Every slot holds the last one seen, so consecutive slots mostly
+// repeat it.
+func (g *Graph) markPoints() []markPoint {
+	points := []markPoint{}
+	for _, slot := range g.Timeslots {
+		if slot.noStats() || slot.Stats.Mark == nil {
+			continue
+		}
+		if n := len(points); n > 0 && !slot.Stats.MarkTime.After(points[n-1].Time) {
+			continue
+		}
+		points = append(points, markPoint{Time: slot.Stats.MarkTime, Price: slot.Stats.Mark.Mark})
+	}
+	return points
+}
+
+// markAt interpolates the mark price at t between the published ones,
+// which arrive every few seconds only. After the last one it is held like
+// the live book does until the next arrives.
+func markAt(points []markPoint, t time.Time) (float64, bool) {
+	for i, p := range points {
+		if p.Time.Before(t) {
+			continue
+		}
+		if p.Time.Equal(t) {
+			return p.Price, true
+		}
+		if i == 0 {
+			return 0, false
+		}
+		prev := points[i-1]
+		span := p.Time.Sub(prev.Time)
+		if span > markGap {
+			return 0, false
+		}
+		w := float64(t.Sub(prev.Time)) / float64(span)
+		return prev.Price + (p.Price-prev.Price)*w, true
+	}
+	if len(points) == 0 || t.Sub(points[len(points)-1].Time) > markGap {
+		return 0, false
+	}
+	return points[len(points)-1].Price, true
+}
+
+// DrawMarkLine draws the mark price of futures as a thin line over the
+// bid and ask lines, interpolated at the end of every timeslot. Spot
+// products have none.
+func (g *Graph) DrawMarkLine(img *image.RGBA, x, rowHeight, pricePosition, priceSteps float64) {
+	points := g.markPoints()
+	if len(points) == 0 {
+		return
+	}
+
+	gc := draw2dimg.NewGraphicContext(img)
+	gc.SetLineWidth(1.0)
+	gc.SetStrokeColor(g.Dim)
+	var line bool
+
+	for idx := len(g.Timeslots) - 1; idx > 0; idx-- {
+		x -= float64(g.SlotWidth)
+		if x < 0 {
+			break
+		}
+
+		slot := g.Timeslots[idx]
+		t := slot.To
+		if t.After(g.CurrentTime) {
+			t = g.CurrentTime
+		}
+		mark, ok := markAt(points, t)
+		if slot.noStats() || !ok {
+			if line {
+				gc.Stroke()
+				line = false
+			}
+			continue
+		}
+
+		y := ((pricePosition - mark) / priceSteps) * rowHeight
+		if line {
+			gc.LineTo(x+float64(g.SlotWidth), y)
+		} else {
+			gc.MoveTo(x+float64(g.SlotWidth), y)
+			line = true
+		}
+	}
+	if line {
+		gc.Stroke()
+	}
+}
+
+// markStatus describes the mark price and funding of a futures book for
+// the status line, empty for spot.
+func markStatus(book *orderbook.Book, format func(float64) string, now time.Time) string {
+	if book.Mark == nil {
+		return ""
+	}
+	text := i18n.Sprintf("mark %s funding %+.4f%%", format(book.Mark.Mark), book.Mark.FundingRate*100)
+	if !book.Mark.NextFunding.IsZero() && book.Mark.NextFunding.After(now) {
+		text += " " + i18n.Sprintf("in %s", book.Mark.NextFunding.Sub(now).Round(time.Minute))
+	}
+	return text
+}
@@ -0,0 +1,262 @@
+package bookmap
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dkit"
+)
+
+// levels further away from the center price are not kept in a sample
+const minimapSampleRange = 0.02
+const minimapSampleBins = 100
+
+type MinimapSample struct {
+	Time   time.Time
+	Center float64
+	Levels []orderbook.OrderState
+}
+
+type Minimap struct {
+	DB        *bolt.DB
+	ProductID string
+	Width     int
+	Height    int
+	Samples   []*MinimapSample
+	From      time.Time
+	To        time.Time
+	LastKey   []byte
+	Updating  bool
+	mu        sync.Mutex
+}
+
+func NewMinimap(db *bolt.DB, productID string, width, height int) *Minimap {
+	return &Minimap{
+		DB:        db,
+		ProductID: productID,
+		Width:     width,
+		Height:    height,
+		Samples:   []*MinimapSample{},
+	}
+}
+
+func newMinimapSample(t time.Time, bids, asks []orderbook.OrderState) *MinimapSample {
+	var bestBid, bestAsk float64
+	for _, state := range bids {
+		if state.Size > 0 && state.Price > bestBid {
+			bestBid = state.Price
+		}
+	}
+	for _, state := range asks {
+		if state.Size > 0 && (bestAsk == 0 || state.Price < bestAsk) {
+			bestAsk = state.Price
+		}
+	}
+
+	center := bestBid
+	if bestAsk != 0 {
+		center = bestAsk
+		if bestBid != 0 {
+			center = bestBid + ((bestAsk - bestBid) / 2)
+		}
+	}
+	if center == 0 {
+		return nil
+	}
+
+	low := center * (1 - minimapSampleRange)
+	step := (center * minimapSampleRange * 2) / minimapSampleBins
+	bins := make([]float64, minimapSampleBins)
+
+	for _, list := range [][]orderbook.OrderState{bids, asks} {
+		for _, state := range list {
+			i := int((state.Price - low) / step)
+			if i >= 0 && i < minimapSampleBins {
+				bins[i] += state.Size
+			}
+		}
+	}
+
+	sample := &MinimapSample{Time: t, Center: center, Levels: []orderbook.OrderState{}}
+	for i, size := range bins {
+		if size > 0 {
+			sample.Levels = append(sample.Levels, orderbook.OrderState{Price: low + (float64(i) * step), Size: size})
+		}
+	}
+	return sample
+}
+
+// Update scans all sync packets stored since the last update. The first
+// call walks the whole bucket, so it is meant to be run in the background.
+func (m *Minimap) Update() {
+	m.mu.Lock()
+	if m.Updating {
+		m.mu.Unlock()
+		return
+	}
+	m.Updating = true
+	lastKey := m.LastKey
+	m.mu.Unlock()
+
+	samples := []*MinimapSample{}
+	var first, last []byte
+
+	m.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(m.ProductID))
+		if b == nil {
+			return nil
+		}
+		c := util.NewCursor(m.DB, b)
+
+		var key, buf []byte
+		if lastKey == nil {
+			key, buf = c.First()
+			first = key
+		} else {
+			key, buf = c.Seek(lastKey)
+			if key != nil && bytes.Equal(key, lastKey) {
+				key, buf = c.Next()
+			}
+		}
+
+		for ; key != nil; key, buf = c.Next() {
+			last = key
+			if !orderbook.IsSyncPacket(buf) {
+				continue
+			}
+			_, bids, asks := orderbook.UnpackSync(buf)
+			if sample := newMinimapSample(orderbook.UnpackTimeKey(key), bids, asks); sample != nil {
+				samples = append(samples, sample)
+			}
+		}
+		return nil
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Updating = false
+	if first != nil {
+		m.From = orderbook.UnpackTimeKey(first)
+	}
+	if last != nil {
+		m.LastKey = []byte(string(last))
+		m.To = orderbook.UnpackTimeKey(last)
+	}
+	m.Samples = append(m.Samples, samples...)
+}
+
+func (m *Minimap) findSample(t time.Time) *MinimapSample {
+	i := sort.Search(len(m.Samples), func(i int) bool { return m.Samples[i].Time.After(t) })
+	if i == 0 {
+		return nil
+	}
+	return m.Samples[i-1]
+}
+
+// TimeAt maps a x position inside the minimap to a point in the history.
+func (m *Minimap) TimeAt(x float64) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if x < 0 {
+		x = 0
+	}
+	if x > float64(m.Width) {
+		x = float64(m.Width)
+	}
+	span := m.To.Sub(m.From)
+	return m.From.Add(time.Duration(float64(span) * (x / float64(m.Width))))
+}
+
+func (m *Minimap) Draw(img *image.RGBA, viewFrom, viewTo time.Time, bg, fg, line color.RGBA) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	gc := draw2dimg.NewGraphicContext(img)
+	gc.SetFillColor(bg)
+	draw2dkit.Rectangle(gc, 0, 0, float64(m.Width), float64(m.Height))
+	gc.Fill()
+
+	if len(m.Samples) == 0 || !m.To.After(m.From) {
+		return
+	}
+
+	minPrice, maxPrice := math.MaxFloat64, 0.0
+	for _, sample := range m.Samples {
+		if sample.Center < minPrice {
+			minPrice = sample.Center
+		}
+		if sample.Center > maxPrice {
+			maxPrice = sample.Center
+		}
+	}
+	minPrice = minPrice * (1 - (minimapSampleRange / 2))
+	maxPrice = maxPrice * (1 + (minimapSampleRange / 2))
+	priceRange := maxPrice - minPrice
+	height := float64(m.Height)
+
+	columnWidth := 2
+	columns := m.Width / columnWidth
+	span := float64(m.To.Sub(m.From))
+	maxSize := 0.0
+	picked := make([]*MinimapSample, columns)
+
+	for i := 0; i < columns; i++ {
+		t := m.From.Add(time.Duration(span * (float64(i+1) / float64(columns))))
+		picked[i] = m.findSample(t)
+		if picked[i] == nil {
+			continue
+		}
+		for _, level := range picked[i].Levels {
+			if level.Size > maxSize {
+				maxSize = level.Size
+			}
+		}
+	}
+
+	rowHeight := 2.0
+	var lastY float64
+	for i, sample := range picked {
+		if sample == nil {
+			continue
+		}
+		x := float64(i * columnWidth)
+
+		for _, level := range sample.Levels {
+			y := height - (((level.Price - minPrice) / priceRange) * height)
+			draw2dkit.Rectangle(gc, x, y-rowHeight, x+float64(columnWidth), y)
+			gc.SetFillColor(colourGradientor((level.Size/maxSize)*2, fg, bg))
+			gc.Fill()
+		}
+
+		y := height - (((sample.Center - minPrice) / priceRange) * height)
+		if lastY != 0 {
+			gc.SetLineWidth(1.0)
+			gc.SetStrokeColor(line)
+			gc.MoveTo(x, lastY)
+			gc.LineTo(x+float64(columnWidth), y)
+			gc.Stroke()
+		}
+		lastY = y
+	}
+
+	// current viewport
+	x1 := (float64(viewFrom.Sub(m.From)) / span) * float64(m.Width)
+	x2 := (float64(viewTo.Sub(m.From)) / span) * float64(m.Width)
+	if x2-x1 < 2 {
+		x1 = x2 - 2
+	}
+	gc.SetLineWidth(1.0)
+	gc.SetStrokeColor(fg)
+	draw2dkit.Rectangle(gc, x1, 0.5, x2, height-0.5)
+	gc.Stroke()
+}
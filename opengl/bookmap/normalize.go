@@ -0,0 +1,99 @@
+package bookmap
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+)
+
+// maxima of members not rendered for this long are left out, e.g. hidden
+// products
+const normStale = time.Minute
+
+// NormGroup shares the heatmap intensity of its products, so the same size
+// is drawn the same way in every one of them. Products without a group are
+// normalized on their own.
+type NormGroup struct {
+	Name string
+
+	mu     sync.Mutex
+	maxima map[string]normMax
+}
+
+type normMax struct {
+	Size    float64
+	Updated time.Time
+}
+
+func NewNormGroup(name string) *NormGroup {
+	return &NormGroup{Name: name, maxima: map[string]normMax{}}
+}
+
+// Max stores the rolling depth maximum of one member and returns the
+// largest of the group.
+func (g *NormGroup) Max(product string, size float64, now time.Time) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.maxima[product] = normMax{Size: size, Updated: now}
+	max := size
+	for _, m := range g.maxima {
+		if now.Sub(m.Updated) < normStale && m.Size > max {
+			max = m.Size
+		}
+	}
+	return max
+}
+
+// ParseNormGroups assigns the products to groups by base (currency),
+// platform or all, or to the listed groups like
+// GDAX-BTC-USD+Binance-BTC-USDT,GDAX-ETH-USD+Binance-ETH-USDT. Empty keeps
+// every product on its own.
+func ParseNormGroups(spec string, infos []*product_info.Info) (map[string]*NormGroup, error) {
+	groups := map[string]*NormGroup{}
+	byName := map[string]*NormGroup{}
+	join := func(product, name string) {
+		g, ok := byName[name]
+		if !ok {
+			g = NewNormGroup(name)
+			byName[name] = g
+		}
+		groups[product] = g
+	}
+
+	switch spec {
+	case "":
+	case "base":
+		for _, info := range infos {
+			join(info.DatabaseKey, info.BaseCurrency)
+		}
+	case "platform":
+		for _, info := range infos {
+			join(info.DatabaseKey, info.Platform)
+		}
+	case "all":
+		for _, info := range infos {
+			join(info.DatabaseKey, "all")
+		}
+	default:
+		known := map[string]bool{}
+		for _, info := range infos {
+			known[info.DatabaseKey] = true
+		}
+		for _, group := range strings.Split(spec, ",") {
+			group = strings.TrimSpace(group)
+			for _, product := range strings.Split(group, "+") {
+				if !known[product] {
+					return nil, fmt.Errorf("unknown product %q in heatmap group %q, expected base, platform, all or groups like GDAX-BTC-USD+Binance-BTC-USDT", product, group)
+				}
+				if _, ok := groups[product]; ok {
+					return nil, fmt.Errorf("product %s is in more than one heatmap group", product)
+				}
+				join(product, group)
+			}
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,74 @@
+package bookmap
+
+import (
+	"fmt"
+	"math"
+)
+
+// SetPercentAxis labels the price axis in percent of RefPrice instead of
+// prices. The reference is the center of the book when the axis is turned
+// on and again after every jump, so products of very different prices
+// can be compared row by row.
+func (s *Bookmap) SetPercentAxis(on bool) {
+	s.PercentAxis = on
+	s.RefPrice = 0
+	s.updateRefPrice()
+}
+
+// updateRefPrice takes up the reference price once the book has one.
+func (s *Bookmap) updateRefPrice() {
+	if !s.PercentAxis || s.RefPrice != 0 || s.Graph == nil {
+		return
+	}
+	price := s.Graph.Book.CenterPrice()
+	if price == 0 {
+		return
+	}
+	s.RefPrice = price
+	if s.percentSteps > 0 {
+		s.ZoomPercent(s.percentSteps)
+	}
+}
+
+// resetRefPrice keeps the zoom in percent while a jump moves the reference.
+func (s *Bookmap) resetRefPrice() {
+	if !s.PercentAxis {
+		return
+	}
+	s.percentSteps = s.StepsPercent()
+	s.RefPrice = 0
+}
+
+// StepsPercent is the price zoom in percent of the reference price per
+// row.
+func (s *Bookmap) StepsPercent() float64 {
+	if s.RefPrice == 0 {
+		return s.percentSteps
+	}
+	return s.PriceSteps / s.RefPrice * 100
+}
+
+// ZoomPercent sets PriceSteps to percent of the reference price, rounded
+// to the quote increment. Without a reference yet it is applied once the
+// graph started.
+func (s *Bookmap) ZoomPercent(percent float64) {
+	s.percentSteps = percent
+	if s.RefPrice == 0 {
+		return
+	}
+	increment := float64(s.ProductInfo.QuoteIncrement)
+	if increment <= 0 {
+		increment = 0.01
+	}
+	s.PriceSteps = math.Max(1, math.Round(s.RefPrice*percent/100/increment)) * increment
+	s.AggregationIndex = -1
+	s.ForceAutoScroll()
+}
+
+// axisLabel is the label of a price row.
+func (s *Bookmap) axisLabel(price float64) string {
+	if !s.PercentAxis || s.RefPrice == 0 {
+		return s.ProductInfo.FormatFloat(price)
+	}
+	return fmt.Sprintf("%+.3f%%", (price/s.RefPrice-1)*100)
+}
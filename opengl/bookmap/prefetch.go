@@ -0,0 +1,169 @@
+package bookmap
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// sync packets prefetched per drag position, and kept per graph
+const (
+	prefetchSyncs = 8
+	cachedSyncs   = 64
+)
+
+type cachedSync struct {
+	key  []byte
+	data []byte
+	// key of the following sync packet, the entry is used for starts
+	// before it only, so no more diffs are replayed than without it
+	next []byte
+}
+
+// SyncCache keeps decrypted and expanded sync packets of a graph, filled
+// in the background while the timeline is dragged so FetchBook at the
+// release point does not have to walk back to the last sync first.
+type SyncCache struct {
+	mu      sync.Mutex
+	entries []*cachedSync
+	// bumped by every Prefetch, older prefetches stop early
+	generation int
+}
+
+func NewSyncCache() *SyncCache {
+	return &SyncCache{entries: []*cachedSync{}}
+}
+
+// nearest returns the sync packet a book at key is built from, nil if it
+// is not cached.
+func (s *SyncCache) nearest(key []byte) *cachedSync {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.entries), func(i int) bool { return bytes.Compare(s.entries[i].key, key) > 0 })
+	if i == 0 {
+		return nil
+	}
+	e := s.entries[i-1]
+	if e.next == nil || bytes.Compare(key, e.next) >= 0 {
+		return nil
+	}
+	return e
+}
+
+func (s *SyncCache) add(e *cachedSync, around []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.entries), func(i int) bool { return bytes.Compare(s.entries[i].key, e.key) >= 0 })
+	if i < len(s.entries) && bytes.Equal(s.entries[i].key, e.key) {
+		if e.next != nil {
+			s.entries[i].next = e.next
+		}
+		return
+	}
+	s.entries = append(s.entries, nil)
+	copy(s.entries[i+1:], s.entries[i:])
+	s.entries[i] = e
+
+	// drop the sync packets furthest from the drag position
+	t := orderbook.UnpackTimeKey(around)
+	distance := func(e *cachedSync) time.Duration {
+		d := orderbook.UnpackTimeKey(e.key).Sub(t)
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	for len(s.entries) > cachedSyncs {
+		if distance(s.entries[0]) > distance(s.entries[len(s.entries)-1]) {
+			s.entries = s.entries[1:]
+		} else {
+			s.entries = s.entries[:len(s.entries)-1]
+		}
+	}
+}
+
+func (s *SyncCache) current(generation int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation == generation
+}
+
+// Prefetch caches the sync packet a book at t starts from and the next
+// ones in the drag direction, in the background. A newer Prefetch stops
+// the running one.
+func (s *SyncCache) Prefetch(db *bolt.DB, product string, t time.Time, forward bool) {
+	s.mu.Lock()
+	s.generation += 1
+	generation := s.generation
+	s.mu.Unlock()
+
+	go func() {
+		around := orderbook.PackTimeKey(t)
+		db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(product))
+			if b == nil {
+				return nil
+			}
+			c := util.NewCursor(db, b)
+
+			// the sync packet the book at t starts from
+			var key, buf []byte
+			for key, buf = c.Seek(around); ; key, buf = c.Prev() {
+				if key == nil {
+					key, buf = c.First()
+					break
+				}
+				if orderbook.IsSyncPacket(buf) {
+					break
+				}
+			}
+			if key == nil {
+				return nil
+			}
+			anchor := &cachedSync{key: copyBytes(key), data: copyBytes(buf)}
+			syncs := []*cachedSync{anchor}
+
+			// the following sync packet bounds the one of t, also when
+			// dragging back
+			for key, buf = c.Next(); key != nil; key, buf = c.Next() {
+				if orderbook.IsSyncPacket(buf) {
+					syncs = append(syncs, &cachedSync{key: copyBytes(key), data: copyBytes(buf)})
+					break
+				}
+			}
+
+			step := c.Next
+			if !forward {
+				c.Seek(anchor.key)
+				step = c.Prev
+			}
+			for len(syncs) <= prefetchSyncs && s.current(generation) {
+				if key, buf = step(); key == nil {
+					break
+				}
+				if !orderbook.IsSyncPacket(buf) {
+					continue
+				}
+				syncs = append(syncs, &cachedSync{key: copyBytes(key), data: copyBytes(buf)})
+			}
+
+			sort.Slice(syncs, func(i, j int) bool { return bytes.Compare(syncs[i].key, syncs[j].key) < 0 })
+			for i, e := range syncs {
+				if i+1 < len(syncs) {
+					e.next = syncs[i+1].key
+				}
+				s.add(e, around)
+			}
+			return nil
+		})
+	}()
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,87 @@
+package bookmap
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// State is the view to resume after a restart.
+func (s *Bookmap) State() *util.UIState {
+	state := &util.UIState{
+		PriceSteps:   s.PriceSteps,
+		ViewportStep: s.ViewportStep,
+		ColumnWidth:  s.ColumnWidth,
+		Aggregation:  s.aggregationName(),
+		AutoScroll:   s.AutoScroll,
+	}
+	if !s.AutoScroll {
+		state.PriceScrollPosition = s.PriceScrollPosition
+	}
+	if s.PriceLock {
+		state.LockedPrice = s.lockedPrice
+	}
+	if s.Graph != nil && !s.Live {
+		state.Replay = s.Graph.Start
+	} else if s.Graph == nil {
+		// not started yet, keep what is still to be resumed
+		state.Replay = s.resume
+	}
+	return state
+}
+
+// RestoreState applies a saved view, the replay position is taken up once
+// the graph starts.
+func (s *Bookmap) RestoreState(state *util.UIState) {
+	if state.PriceSteps > 0 {
+		s.PriceSteps = state.PriceSteps
+	}
+	if state.ViewportStep > 0 {
+		s.ViewportStep = state.ViewportStep
+	}
+	if state.ColumnWidth > 0 {
+		s.ColumnWidth = state.ColumnWidth
+	}
+	s.AggregationIndex = -1
+	for i, a := range s.Aggregations {
+		if a.Name == state.Aggregation {
+			s.AggregationIndex = i
+		}
+	}
+	s.AutoScroll = state.AutoScroll
+	if !s.AutoScroll {
+		s.PriceScrollPosition = state.PriceScrollPosition
+	}
+	s.PriceLock = state.LockedPrice != 0
+	s.lockedPrice = state.LockedPrice
+	s.resume = state.Replay
+	s.saved = *s.State()
+}
+
+// SaveState stores the view when it changed since the last call.
+func (s *Bookmap) SaveState() {
+	state := s.State()
+	if *state == s.saved {
+		return
+	}
+	if err := util.SaveUIState(s.DB, s.ProductInfo.DatabaseKey, state); err != nil {
+		fmt.Println("save ui state", s.ProductInfo.DatabaseKey, err)
+		return
+	}
+	s.saved = *state
+}
+
+// startGraph starts the graph at the resumed replay position, or live.
+func (s *Bookmap) startGraph(graph *Graph, now time.Time) bool {
+	if !s.resume.IsZero() && s.resume.Before(now) {
+		if graph.SetStart(s.resume) {
+			s.resume = time.Time{}
+			s.Live = false
+			return true
+		}
+		// e.g. the data was deleted since
+		s.resume = time.Time{}
+	}
+	return graph.SetStart(now)
+}
@@ -2,6 +2,7 @@ package bookmap
 
 import (
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/lian/gdax-bookmap/orderbook"
@@ -17,6 +18,9 @@ type TimeSlotRow struct {
 	BidSize    float64
 	BidCount   int
 	AskCount   int
+	Queue      []float64
+	// oldest liquidity in the row
+	FirstSeen time.Time
 }
 
 type TimeSlot struct {
@@ -55,10 +59,21 @@ func (s *TimeSlot) isEmpty() bool {
 	return true
 }
 
+// FindRow computes the row of price from the row height instead of
+// searching, refilling all slots after the price steps changed stays fast.
 func (s *TimeSlot) FindRow(price float64) *TimeSlotRow {
-	for _, row := range s.Rows {
-		if (price <= row.Heigh) && (price > row.Low) {
-			return row
+	if len(s.Rows) == 0 {
+		return nil
+	}
+	steps := s.Rows[0].Heigh - s.Rows[0].Low
+	if steps <= 0 {
+		return nil
+	}
+	i := int(math.Floor((s.Rows[0].Heigh - price) / steps))
+	// float rounding can put the price one row off
+	for _, n := range []int{i, i - 1, i + 1} {
+		if n >= 0 && n < len(s.Rows) && price <= s.Rows[n].Heigh && price > s.Rows[n].Low {
+			return s.Rows[n]
 		}
 	}
 	return nil
@@ -99,6 +114,8 @@ func (s *TimeSlot) Refill() {
 		row.AskCount = 0
 		row.OrderCount = 0
 		row.Size = 0
+		row.Queue = nil
+		row.FirstSeen = time.Time{}
 	}
 	if s.Stats != nil {
 		s.Fill(s.Stats)
@@ -138,6 +155,10 @@ func (s *TimeSlot) Fill(stats *orderbook.BookMapStatsCopy) {
 		row.BidSize += state.Size
 		row.BidCount += state.OrderCount
 		row.OrderCount += state.OrderCount
+		row.Queue = append(row.Queue, state.Queue...)
+		if !state.FirstSeen.IsZero() && (row.FirstSeen.IsZero() || state.FirstSeen.Before(row.FirstSeen)) {
+			row.FirstSeen = state.FirstSeen
+		}
 
 		if s.BidPrice == 0 {
 			s.BidPrice = state.Price
@@ -173,6 +194,10 @@ func (s *TimeSlot) Fill(stats *orderbook.BookMapStatsCopy) {
 		row.AskSize += state.Size
 		row.AskCount += state.OrderCount
 		row.OrderCount += state.OrderCount
+		row.Queue = append(row.Queue, state.Queue...)
+		if !state.FirstSeen.IsZero() && (row.FirstSeen.IsZero() || state.FirstSeen.Before(row.FirstSeen)) {
+			row.FirstSeen = state.FirstSeen
+		}
 
 		if s.AskPrice == 0 {
 			s.AskPrice = state.Price
@@ -0,0 +1,123 @@
+package bookmap
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+)
+
+// replay speeds cycled through, times real time
+var watchSpeeds = []float64{1, 2, 5, 10, 30, 60}
+
+// WatchGroup is a set of products whose panes replay the history together,
+// at one position and speed, while the other panes stay live. The position
+// is the end of the graphs.
+type WatchGroup struct {
+	Name     string
+	Products []string
+
+	mu      sync.Mutex
+	at      time.Time
+	wall    time.Time
+	speed   float64
+	playing bool
+}
+
+func NewWatchGroup(name string, products []string) *WatchGroup {
+	return &WatchGroup{Name: name, Products: products, speed: watchSpeeds[0]}
+}
+
+// Position returns where in the history the group is at wall time now.
+func (g *WatchGroup) Position(now time.Time) time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.position(now)
+}
+
+func (g *WatchGroup) position(now time.Time) time.Time {
+	if !g.playing {
+		return g.at
+	}
+	return g.at.Add(time.Duration(float64(now.Sub(g.wall)) * g.speed))
+}
+
+// Seek moves the group to t, it keeps playing if it was.
+func (g *WatchGroup) Seek(t, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.at, g.wall = t, now
+}
+
+func (g *WatchGroup) Play(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.at, g.wall = g.position(now), now
+	g.playing = true
+}
+
+// Pause stops the group and returns where it stopped.
+func (g *WatchGroup) Pause(now time.Time) time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.at, g.wall = g.position(now), now
+	g.playing = false
+	return g.at
+}
+
+func (g *WatchGroup) Playing() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.playing
+}
+
+func (g *WatchGroup) Speed() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.speed
+}
+
+// NextSpeed cycles the replay speed and returns the new one.
+func (g *WatchGroup) NextSpeed(now time.Time) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.at, g.wall = g.position(now), now
+	next := watchSpeeds[0]
+	for i, speed := range watchSpeeds {
+		if speed == g.speed && i+1 < len(watchSpeeds) {
+			next = watchSpeeds[i+1]
+		}
+	}
+	g.speed = next
+	return next
+}
+
+// ParseWatchGroups reads groups like GDAX-BTC-USD+GDAX-ETH-USD, comma
+// separated, and returns the group of every product in one.
+func ParseWatchGroups(spec string, infos []*product_info.Info) (map[string]*WatchGroup, error) {
+	groups := map[string]*WatchGroup{}
+	if spec == "" {
+		return groups, nil
+	}
+	known := map[string]bool{}
+	for _, info := range infos {
+		known[info.DatabaseKey] = true
+	}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		products := strings.Split(name, "+")
+		g := NewWatchGroup(name, products)
+		for _, product := range products {
+			if !known[product] {
+				return nil, fmt.Errorf("unknown product %q in watch group %q, expected groups like GDAX-BTC-USD+GDAX-ETH-USD", product, name)
+			}
+			if _, ok := groups[product]; ok {
+				return nil, fmt.Errorf("product %s is in more than one watch group", product)
+			}
+			groups[product] = g
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,251 @@
+package depth
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+	font "github.com/lian/gonky/font/terminus"
+
+	"github.com/lian/gonky/shader"
+	"github.com/lian/gonky/texture"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dkit"
+)
+
+// Panel is the classic depth chart: the cumulative size of the bids below
+// and the asks above the center price, of the live book. It keeps its own
+// books from the stored packets on the bus, so it neither reads the
+// database nor waits for the graphs, and is only drawn again after a
+// packet changed the shown product.
+type Panel struct {
+	Texture *texture.Texture
+	Image   *image.RGBA
+	// price range shown on each side, in percent of the center price
+	Range float64
+
+	mu      sync.Mutex
+	infos   []*product_info.Info
+	books   map[string]*orderbook.Book
+	synced  map[string]bool
+	changed map[string]bool
+	shown   string
+}
+
+func New(program *shader.Program, infos []*product_info.Info, width, height float64) *Panel {
+	s := &Panel{
+		Texture: &texture.Texture{
+			X:      0,
+			Y:      height,
+			Width:  width,
+			Height: height,
+		},
+		Range:   1,
+		infos:   infos,
+		books:   map[string]*orderbook.Book{},
+		synced:  map[string]bool{},
+		changed: map[string]bool{},
+	}
+	for _, info := range infos {
+		book := orderbook.New(info.DatabaseKey)
+		book.ProductInfo = *info
+		s.books[info.DatabaseKey] = book
+	}
+	if program != nil {
+		s.Texture.Setup(program)
+	}
+	s.Image = image.NewRGBA(image.Rect(0, 0, int(s.Texture.Width), int(s.Texture.Height)))
+	return s
+}
+
+// Run follows the packets of every product.
+func (s *Panel) Run() {
+	topics := []string{}
+	for _, info := range s.infos {
+		topics = append(topics, util.ProductTopics(info.DatabaseKey)...)
+	}
+	for {
+		sub := util.Events.Subscribe(topics, 4096)
+		for pkt := range sub.C {
+			s.handle(pkt)
+		}
+		// too slow, wait for the next sync of every book
+		fmt.Println("depth chart fell behind, resubscribing")
+		s.mu.Lock()
+		for product := range s.synced {
+			s.synced[product] = false
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Panel) handle(pkt *util.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	book, ok := s.books[pkt.Bucket]
+	if !ok || len(pkt.Data) == 0 || pkt.Data[0] == orderbook.TradePacket {
+		return
+	}
+	if orderbook.IsSyncPacket(pkt.Data) {
+		s.synced[pkt.Bucket] = true
+	}
+	if !s.synced[pkt.Bucket] {
+		return
+	}
+	if !book.Process(orderbook.UnpackTimeKey(pkt.Key), pkt.Data) {
+		s.synced[pkt.Bucket] = false
+	}
+	s.changed[pkt.Bucket] = true
+}
+
+// blend mixes share of c into bg.
+func blend(bg, c color.RGBA, share float64) color.RGBA {
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a)*(1-share) + float64(b)*share)
+	}
+	return color.RGBA{mix(bg.R, c.R), mix(bg.G, c.G), mix(bg.B, c.B), 0xff}
+}
+
+// Invalidate draws the next Render even without changes, e.g. after the
+// palette changed.
+func (s *Panel) Invalidate() {
+	s.mu.Lock()
+	s.shown = ""
+	s.mu.Unlock()
+}
+
+type step struct {
+	price float64
+	total float64
+}
+
+// cumulative sums levels from the best price outwards until limit.
+func cumulative(levels orderbook.BookLevelList, bids bool, limit float64) []step {
+	steps := []step{}
+	total := 0.0
+	for i := range levels {
+		level := levels[i]
+		if bids {
+			level = levels[len(levels)-1-i]
+			if level.Price < limit {
+				break
+			}
+		} else if level.Price > limit {
+			break
+		}
+		total += level.Quantity
+		steps = append(steps, step{price: level.Price, total: total})
+	}
+	return steps
+}
+
+// Render draws the depth of product, unless nothing changed since it was
+// last drawn.
+func (s *Panel) Render(product string) {
+	s.mu.Lock()
+	book, ok := s.books[product]
+	if !ok || (product == s.shown && !s.changed[product]) {
+		s.mu.Unlock()
+		return
+	}
+	s.shown = product
+	s.changed[product] = false
+	var bids, asks []step
+	center := 0.0
+	if s.synced[product] && !book.Empty() {
+		center = book.CenterPrice()
+		bids = cumulative(book.Bid, true, center*(1-s.Range/100))
+		asks = cumulative(book.Ask, false, center*(1+s.Range/100))
+	}
+	info := book.ProductInfo
+	s.mu.Unlock()
+
+	s.draw(&info, center, bids, asks)
+}
+
+func (s *Panel) draw(info *product_info.Info, center float64, bids, asks []step) {
+	img := s.Image
+	gc := draw2dimg.NewGraphicContext(img)
+
+	p := palette.Current()
+	gc.SetFillColor(p.Bg)
+	gc.SetStrokeColor(p.Fg)
+	gc.SetLineWidth(1.0)
+	draw2dkit.Rectangle(gc, 0.5, 0.5, s.Texture.Width-0.5, s.Texture.Height-0.5)
+	gc.FillStroke()
+
+	lineHeight := float64(font.Height + 2)
+	title := i18n.Sprintf("depth %s", info.DatabaseKey)
+	if center == 0 {
+		font.DrawString(img, 10, 5, title+"  "+i18n.T("waiting for a sync"), p.Dim)
+		s.Texture.Write(&img.Pix)
+		return
+	}
+	font.DrawString(img, 10, 5, fmt.Sprintf("%s  %s", title, info.FormatFloat(center)), p.Fg)
+
+	maxTotal := 0.0
+	if len(bids) > 0 {
+		maxTotal = bids[len(bids)-1].total
+	}
+	if len(asks) > 0 {
+		maxTotal = math.Max(maxTotal, asks[len(asks)-1].total)
+	}
+	if maxTotal == 0 {
+		s.Texture.Write(&img.Pix)
+		return
+	}
+
+	left, right := 10.0, s.Texture.Width-10
+	top, bottom := lineHeight*2, s.Texture.Height-lineHeight-5
+	low, high := center*(1-s.Range/100), center*(1+s.Range/100)
+	x := func(price float64) float64 {
+		return left + (price-low)/(high-low)*(right-left)
+	}
+	y := func(total float64) float64 {
+		return bottom - total/maxTotal*(bottom-top)
+	}
+
+	// stepped areas from the best prices outwards, each level adds its size
+	// once the price is reached
+	fill := func(steps []step, edge float64, c color.RGBA) {
+		if len(steps) == 0 {
+			return
+		}
+		gc.SetFillColor(blend(p.Bg, c, 0.35))
+		gc.SetStrokeColor(c)
+		gc.MoveTo(x(steps[0].price), bottom)
+		last := 0.0
+		for _, st := range steps {
+			gc.LineTo(x(st.price), y(last))
+			gc.LineTo(x(st.price), y(st.total))
+			last = st.total
+		}
+		gc.LineTo(x(edge), y(last))
+		gc.LineTo(x(edge), bottom)
+		gc.Close()
+		gc.FillStroke()
+	}
+	fill(bids, low, p.Bid)
+	fill(asks, high, p.Ask)
+
+	gc.SetStrokeColor(p.Dim)
+	gc.MoveTo(x(center), top)
+	gc.LineTo(x(center), bottom)
+	gc.Stroke()
+
+	labelY := int(bottom) + 3
+	font.DrawString(img, int(left), labelY, info.FormatFloat(low), p.Dim)
+	highLabel := info.FormatFloat(high)
+	font.DrawString(img, int(right)-len(highLabel)*font.Width, labelY, highLabel, p.Dim)
+	maxLabel := fmt.Sprintf("%.4f", maxTotal)
+	font.DrawString(img, int(right)-len(maxLabel)*font.Width, int(top), maxLabel, p.Dim)
+
+	s.Texture.Write(&img.Pix)
+}
@@ -0,0 +1,115 @@
+package palette
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// Palette holds the colors shared by the graphs and panels.
+type Palette struct {
+	Name string
+	Bg   color.RGBA
+	// text, axes and the liquidity heatmap
+	Fg  color.RGBA
+	Dim color.RGBA
+	// bid lines, buy trade dots and the bid histogram
+	Bid color.RGBA
+	// bids on the trade list and the histogram, darker than Bid
+	BidText color.RGBA
+	Ask     color.RGBA
+	Age     color.RGBA
+	Mark    color.RGBA
+}
+
+var Default = &Palette{
+	Name:    "default",
+	Bg:      color.RGBA{0x15, 0x23, 0x2c, 0xff},
+	Fg:      color.RGBA{0xdd, 0xdf, 0xe1, 0xff},
+	Dim:     color.RGBA{0x80, 0x88, 0x8e, 0xff},
+	Bid:     color.RGBA{0x84, 0xf7, 0x66, 0xff},
+	BidText: color.RGBA{0x4d, 0xa5, 0x3c, 0xff},
+	Ask:     color.RGBA{0xff, 0x69, 0x39, 0xff},
+	Age:     color.RGBA{0xff, 0xc1, 0x07, 0xff},
+	Mark:    color.RGBA{0xff, 0xd7, 0x00, 0xff},
+}
+
+// The color blind palettes use the Okabe-Ito colors, which stay apart for
+// both red-green deficiencies. Bid and ask differ in hue and lightness so
+// they can also be told apart in grayscale.
+
+// Deuteranopia pairs sky blue bids with orange asks.
+var Deuteranopia = &Palette{
+	Name:    "deuteranopia",
+	Bg:      Default.Bg,
+	Fg:      Default.Fg,
+	Dim:     Default.Dim,
+	Bid:     color.RGBA{0x56, 0xb4, 0xe9, 0xff},
+	BidText: color.RGBA{0x56, 0xb4, 0xe9, 0xff},
+	Ask:     color.RGBA{0xe6, 0x9f, 0x00, 0xff},
+	Age:     color.RGBA{0xcc, 0x79, 0xa7, 0xff},
+	Mark:    color.RGBA{0xf0, 0xe4, 0x42, 0xff},
+}
+
+// Protanopia avoids reds, which appear dark, and pairs sky blue bids with
+// yellow asks.
+var Protanopia = &Palette{
+	Name:    "protanopia",
+	Bg:      Default.Bg,
+	Fg:      Default.Fg,
+	Dim:     Default.Dim,
+	Bid:     color.RGBA{0x56, 0xb4, 0xe9, 0xff},
+	BidText: color.RGBA{0x56, 0xb4, 0xe9, 0xff},
+	Ask:     color.RGBA{0xf0, 0xe4, 0x42, 0xff},
+	Age:     color.RGBA{0xe6, 0x9f, 0x00, 0xff},
+	Mark:    color.RGBA{0x00, 0x9e, 0x73, 0xff},
+}
+
+var Palettes = []*Palette{Default, Deuteranopia, Protanopia}
+
+var active = Default
+
+// HighContrast draws text and axes white on black with any palette.
+var HighContrast bool
+
+// Current returns the selected palette, adjusted for high contrast.
+func Current() *Palette {
+	if !HighContrast {
+		return active
+	}
+	p := *active
+	p.Bg = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	p.Fg = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	p.Dim = color.RGBA{0xc0, 0xc0, 0xc0, 0xff}
+	p.BidText = p.Bid
+	return &p
+}
+
+func Names() []string {
+	names := []string{}
+	for _, p := range Palettes {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func Select(name string) error {
+	for _, p := range Palettes {
+		if p.Name == strings.ToLower(name) {
+			active = p
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown palette %s, available: %s", name, strings.Join(Names(), " "))
+}
+
+// Next selects the palette after the current one.
+func Next() *Palette {
+	for i, p := range Palettes {
+		if p == active {
+			active = Palettes[(i+1)%len(Palettes)]
+			break
+		}
+	}
+	return active
+}
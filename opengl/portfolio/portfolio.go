@@ -0,0 +1,88 @@
+package portfolio
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/trading"
+	font "github.com/lian/gonky/font/terminus"
+
+	"github.com/lian/gonky/shader"
+	"github.com/lian/gonky/texture"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dkit"
+)
+
+type Panel struct {
+	Texture *texture.Texture
+	Image   *image.RGBA
+}
+
+func New(program *shader.Program, height float64) *Panel {
+	width := float64(font.Width * 48)
+	s := &Panel{
+		Texture: &texture.Texture{
+			X:      0,
+			Y:      height,
+			Width:  width,
+			Height: height,
+		},
+	}
+	if program != nil {
+		s.Texture.Setup(program)
+	}
+	s.Image = image.NewRGBA(image.Rect(0, 0, int(s.Texture.Width), int(s.Texture.Height)))
+	return s
+}
+
+func (s *Panel) Render(portfolio *trading.Portfolio) {
+	img := s.Image
+	gc := draw2dimg.NewGraphicContext(img)
+
+	bg1 := palette.Current().Bg
+	fg1 := palette.Current().Fg
+	gray := palette.Current().Dim
+
+	gc.SetFillColor(bg1)
+	gc.SetStrokeColor(fg1)
+	gc.SetLineWidth(1.0)
+	draw2dkit.Rectangle(gc, 0.5, 0.5, s.Texture.Width-0.5, s.Texture.Height-0.5)
+	gc.FillStroke()
+
+	lineHeight := font.Height + 2
+	font.DrawString(img, 10, 5, i18n.Sprintf("portfolio  $%.2f", portfolio.Value), fg1)
+
+	y := lineHeight * 2
+	for _, h := range portfolio.Holdings {
+		if y+lineHeight > int(s.Texture.Height) {
+			break
+		}
+		value := i18n.T("n/a")
+		fg := gray
+		if h.Priced {
+			value = fmt.Sprintf("$%.2f", h.Value)
+			fg = fg1
+		}
+		font.DrawString(img, 10, y, fmt.Sprintf("%-6s %16.8f %18s", h.Asset, h.Total(), value), fg)
+		y += lineHeight
+	}
+
+	// positions with their unrealized pnl
+	for _, p := range portfolio.Positions {
+		if y+lineHeight > int(s.Texture.Height) {
+			break
+		}
+		value := i18n.T("n/a")
+		fg := gray
+		if p.Priced {
+			value = fmt.Sprintf("%+.2f", p.Value)
+			fg = fg1
+		}
+		font.DrawString(img, 10, y, fmt.Sprintf("%-12s %+10.4f %18s", p.Product, p.Size, value), fg)
+		y += lineHeight
+	}
+
+	s.Texture.Write(&img.Pix)
+}
@@ -6,6 +6,7 @@ import (
 	"image/color"
 
 	"github.com/lian/gdax-bookmap/opengl/bookmap"
+	"github.com/lian/gdax-bookmap/opengl/palette"
 	"github.com/lian/gdax-bookmap/orderbook"
 	"github.com/lian/gdax-bookmap/orderbook/product_info"
 
@@ -23,6 +24,8 @@ type Trades struct {
 	Texture     *texture.Texture
 	bookmap     *bookmap.Bookmap
 	Image       *image.RGBA
+	// the trades listed by the last Render, newest first
+	rows []*orderbook.Trade
 }
 
 func New(program *shader.Program, bookmap *bookmap.Bookmap, info product_info.Info, height float64, x float64) *Trades {
@@ -51,11 +54,10 @@ func (s *Trades) Render() {
 	data := s.Image
 	gc := draw2dimg.NewGraphicContext(data)
 
-	bg1 := color.RGBA{0x15, 0x23, 0x2c, 0xff}
-	fg1 := color.RGBA{0xdd, 0xdf, 0xe1, 0xff}
-	//green := color.RGBA{0x84, 0xf7, 0x66, 0xff}
-	green := color.RGBA{0x4d, 0xa5, 0x3c, 0xff}
-	red := color.RGBA{0xff, 0x69, 0x39, 0xff}
+	bg1 := palette.Current().Bg
+	fg1 := palette.Current().Fg
+	green := palette.Current().BidText
+	red := palette.Current().Ask
 
 	gc.SetFillColor(bg1)
 	draw2dkit.Rectangle(gc, 0, 0, s.Texture.Width, s.Texture.Height)
@@ -64,7 +66,7 @@ func (s *Trades) Render() {
 	if s.bookmap.Graph == nil {
 		return
 	}
-	book := s.bookmap.Graph.Book.Book
+	book := s.bookmap.Graph.Book
 
 	sizePadding := font.Width * 15
 	pricePadding := sizePadding + (font.Width * 12)
@@ -86,8 +88,15 @@ func (s *Trades) Render() {
 
 	x := 0
 	y := lineHeight * 2
+	s.rows = s.rows[:0]
 	for i := tradesCount - 1; i >= (tradesCount - limit); i-- {
 		trade := trades[i]
+		s.rows = append(s.rows, trade)
+		if h := s.bookmap.Highlight; h != nil && h.Time.Equal(trade.Time) && h.Price == trade.Price {
+			gc.SetFillColor(palette.Current().Dim)
+			draw2dkit.Rectangle(gc, 0, float64(y-1), s.Texture.Width, float64(y+lineHeight-1))
+			gc.Fill()
+		}
 
 		var fg color.Color
 		if trade.Side == orderbook.BidSide {
@@ -113,3 +122,17 @@ func (s *Trades) Render() {
 
 	s.Texture.Write(&data.Pix)
 }
+
+// TradeAt returns the listed trade at x, y relative to the top left corner
+// of the texture.
+func (s *Trades) TradeAt(x, y float64) (*orderbook.Trade, bool) {
+	lineHeight := float64(font.Height + 2)
+	if x < 0 || x > s.Texture.Width || y < lineHeight*2 {
+		return nil, false
+	}
+	row := int((y - lineHeight*2) / lineHeight)
+	if row >= len(s.rows) {
+		return nil, false
+	}
+	return s.rows[row], true
+}
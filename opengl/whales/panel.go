@@ -0,0 +1,87 @@
+package whales
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/whales"
+	font "github.com/lian/gonky/font/terminus"
+
+	"github.com/lian/gonky/shader"
+	"github.com/lian/gonky/texture"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/llgcode/draw2d/draw2dkit"
+)
+
+// Panel lists the whale trades of all products, newest first.
+type Panel struct {
+	Texture *texture.Texture
+	Image   *image.RGBA
+	infos   map[string]*product_info.Info
+}
+
+func New(program *shader.Program, infos []*product_info.Info, height float64) *Panel {
+	width := float64(font.Width * 58)
+	s := &Panel{
+		infos: map[string]*product_info.Info{},
+		Texture: &texture.Texture{
+			X:      0,
+			Y:      height,
+			Width:  width,
+			Height: height,
+		},
+	}
+	if program != nil {
+		s.Texture.Setup(program)
+	}
+	s.Image = image.NewRGBA(image.Rect(0, 0, int(s.Texture.Width), int(s.Texture.Height)))
+	for _, info := range infos {
+		s.infos[info.DatabaseKey] = info
+	}
+	return s
+}
+
+func (s *Panel) Render(feed []*whales.Whale) {
+	img := s.Image
+	gc := draw2dimg.NewGraphicContext(img)
+
+	bg1 := palette.Current().Bg
+	fg1 := palette.Current().Fg
+	green := palette.Current().BidText
+	red := palette.Current().Ask
+
+	gc.SetFillColor(bg1)
+	gc.SetStrokeColor(fg1)
+	gc.SetLineWidth(1.0)
+	draw2dkit.Rectangle(gc, 0.5, 0.5, s.Texture.Width-0.5, s.Texture.Height-0.5)
+	gc.FillStroke()
+
+	lineHeight := font.Height + 2
+	font.DrawString(img, 10, 5, i18n.Sprintf("whales  %d", len(feed)), fg1)
+
+	y := lineHeight * 2
+	for _, w := range feed {
+		if y+lineHeight > int(s.Texture.Height) {
+			break
+		}
+		var fg color.Color = green
+		if w.Side == orderbook.BidSide {
+			// a sell hit the bid
+			fg = red
+		}
+		price := fmt.Sprintf("%f", w.Price)
+		if info, ok := s.infos[w.Product]; ok {
+			price = info.FormatFloat(w.Price)
+		}
+		line := fmt.Sprintf("%s %-14s %14.4f %12s %5.1fx", w.Time.Format("15:04:05"), w.DisplayName, w.Size, price, w.Ratio)
+		font.DrawString(img, 10, y, line, fg)
+		y += lineHeight
+	}
+
+	s.Texture.Write(&img.Pix)
+}
@@ -13,6 +13,8 @@ type BookLevel struct {
 	MaxQuantity float64
 	OrderCount  int
 	TradeSize   float64
+	// when liquidity appeared at the price, reset after it was empty
+	FirstSeen time.Time
 }
 
 type Side uint8
@@ -25,6 +27,7 @@ type Trade struct {
 	Quantity float64
 	Time     time.Time
 	Side     Side
+	Source   SideSource
 }
 
 type BookLevelList []*BookLevel
@@ -42,15 +45,31 @@ type Book struct {
 	Sequence    uint64
 	Synced      bool
 	ProductInfo product_info.Info
+	TradeSizes  *Percentiles
+	// optional market-by-order estimation, nil when disabled
+	Queues *QueueEstimator
+	// orders of venues publishing them, nil for the others
+	Orders *OrderBook3
+	// filled vs pulled liquidity since the last ResetStats
+	Flow *FlowClassifier
+	// trades and volume per second
+	Tape *TapeSpeed
+	// last mark price of futures, nil for spot products
+	Mark *MarkPrice
+	// when Mark was published
+	MarkTime time.Time
 }
 
 func New(name string) *Book {
 	return &Book{
-		ID:     name,
-		Name:   name,
-		Bid:    []*BookLevel{},
-		Ask:    []*BookLevel{},
-		Trades: []*Trade{},
+		ID:         name,
+		Name:       name,
+		Bid:        []*BookLevel{},
+		Ask:        []*BookLevel{},
+		Trades:     []*Trade{},
+		TradeSizes: NewPercentiles(2000),
+		Flow:       NewFlowClassifier(),
+		Tape:       NewTapeSpeed(5 * time.Minute),
 	}
 }
 
@@ -71,13 +90,23 @@ func (b *Book) GetSide(price float64) uint8 {
 func (b *Book) UpdateBidLevel(t time.Time, price, quantity float64) {
 	var found bool
 
+	if b.Queues != nil {
+		b.Queues.Update(true, price, quantity)
+	}
+
 	for i, current := range b.Bid {
 		if current.Price == price {
+			if quantity < current.Quantity {
+				b.Flow.Decrease(t, true, price, current.Quantity-quantity)
+			}
 			if quantity == 0 {
 				// remove
 				b.Bid[i].Quantity = 0
 			} else {
 				// update
+				if b.Bid[i].Quantity == 0 {
+					b.Bid[i].FirstSeen = t
+				}
 				b.Bid[i].Quantity = quantity
 				if quantity > b.Bid[i].MaxQuantity {
 					b.Bid[i].MaxQuantity = quantity
@@ -91,20 +120,30 @@ func (b *Book) UpdateBidLevel(t time.Time, price, quantity float64) {
 
 	if !found && quantity != 0 {
 		// add
-		b.Bid = append(b.Bid, &BookLevel{Price: price, Quantity: quantity, MaxQuantity: quantity, OrderCount: 1})
+		b.Bid = append(b.Bid, &BookLevel{Price: price, Quantity: quantity, MaxQuantity: quantity, OrderCount: 1, FirstSeen: t})
 	}
 }
 
 func (b *Book) UpdateAskLevel(t time.Time, price, quantity float64) {
 	var found bool
 
+	if b.Queues != nil {
+		b.Queues.Update(false, price, quantity)
+	}
+
 	for i, current := range b.Ask {
 		if current.Price == price {
+			if quantity < current.Quantity {
+				b.Flow.Decrease(t, false, price, current.Quantity-quantity)
+			}
 			if quantity == 0 {
 				// remove
 				b.Ask[i].Quantity = 0
 			} else {
 				// update
+				if b.Ask[i].Quantity == 0 {
+					b.Ask[i].FirstSeen = t
+				}
 				b.Ask[i].Quantity = quantity
 				if quantity > b.Ask[i].MaxQuantity {
 					b.Ask[i].MaxQuantity = quantity
@@ -118,7 +157,7 @@ func (b *Book) UpdateAskLevel(t time.Time, price, quantity float64) {
 
 	if !found && quantity != 0 {
 		// add
-		b.Ask = append(b.Ask, &BookLevel{Price: price, Quantity: quantity, MaxQuantity: quantity, OrderCount: 1})
+		b.Ask = append(b.Ask, &BookLevel{Price: price, Quantity: quantity, MaxQuantity: quantity, OrderCount: 1, FirstSeen: t})
 	}
 }
 
@@ -136,6 +175,12 @@ func (b *Book) AddTrade(t time.Time, side uint8, price, quantity float64) {
 	}
 	trade := &Trade{Price: price, Side: Side(side), Quantity: quantity, Time: t}
 	b.Trades = append(b.Trades, trade)
+	b.TradeSizes.Add(quantity)
+	b.Flow.Trade(t, price, quantity)
+	b.Tape.Add(t, quantity)
+	if b.Queues != nil {
+		b.Queues.Trade(price, quantity)
+	}
 
 	if trade.Side == BidSide {
 		if len(b.Bid) != 0 {
@@ -179,9 +224,37 @@ func (b *Book) Spread() float64 {
 	return spread
 }
 
+// BestBid returns the highest bid still quoted, removed levels stay in Bid
+// with quantity 0 until ResetStats. Nil when no bid is left.
+func (b *Book) BestBid() *BookLevel {
+	for i := len(b.Bid) - 1; i >= 0; i-- {
+		if b.Bid[i].Quantity > 0 {
+			return b.Bid[i]
+		}
+	}
+	return nil
+}
+
+// BestAsk returns the lowest ask still quoted, nil when no ask is left.
+func (b *Book) BestAsk() *BookLevel {
+	for _, level := range b.Ask {
+		if level.Quantity > 0 {
+			return level
+		}
+	}
+	return nil
+}
+
 func (b *Book) Clear() {
 	b.Bid = []*BookLevel{}
 	b.Ask = []*BookLevel{}
+	b.Flow.Clear()
+	if b.Queues != nil {
+		b.Queues.Clear()
+	}
+	if b.Orders != nil {
+		b.Orders.Clear()
+	}
 }
 
 func (b *Book) StateAsStats() *BookMapStatsCopy {
@@ -196,7 +269,13 @@ func (b *Book) StateAsStats() *BookMapStatsCopy {
 		if level.Quantity == 0 {
 			continue
 		}
-		bid := OrderState{Price: level.Price, Size: level.Quantity, OrderCount: level.OrderCount}
+		bid := OrderState{Price: level.Price, Size: level.Quantity, OrderCount: level.OrderCount, FirstSeen: level.FirstSeen}
+		if orders, ok := b.orders(true, level.Price); ok {
+			bid.Queue = orders
+			bid.OrderCount = len(orders)
+		} else if b.Queues != nil {
+			bid.Queue = b.Queues.Orders(true, level.Price)
+		}
 		stats.Bid = append(stats.Bid, bid)
 	}
 
@@ -204,13 +283,28 @@ func (b *Book) StateAsStats() *BookMapStatsCopy {
 		if level.Quantity == 0 {
 			continue
 		}
-		ask := OrderState{Price: level.Price, Size: level.Quantity, OrderCount: level.OrderCount}
+		ask := OrderState{Price: level.Price, Size: level.Quantity, OrderCount: level.OrderCount, FirstSeen: level.FirstSeen}
+		if orders, ok := b.orders(false, level.Price); ok {
+			ask.Queue = orders
+			ask.OrderCount = len(orders)
+		} else if b.Queues != nil {
+			ask.Queue = b.Queues.Orders(false, level.Price)
+		}
 		stats.Ask = append(stats.Ask, ask)
 	}
 
 	return stats
 }
 
+// orders returns the recorded orders of a level, known orders win over
+// the estimated ones.
+func (b *Book) orders(bid bool, price float64) ([]float64, bool) {
+	if b.Orders == nil {
+		return nil, false
+	}
+	return b.Orders.Orders(bid, price)
+}
+
 func (b *Book) ResetStats() {
 	bid := make([]*BookLevel, 0, len(b.Bid))
 	ask := make([]*BookLevel, 0, len(b.Ask))
@@ -233,21 +327,27 @@ func (b *Book) ResetStats() {
 
 	b.Bid = bid
 	b.Ask = ask
+	b.Flow.ResetStats()
+	b.Tape.ResetStats()
 }
 
 func (b *Book) StatsCopy() *BookMapStatsCopy {
 	stats := &BookMapStatsCopy{
-		Bid: make([]OrderState, 0, len(b.Bid)),
-		Ask: make([]OrderState, 0, len(b.Ask)),
+		Bid:      make([]OrderState, 0, len(b.Bid)),
+		Ask:      make([]OrderState, 0, len(b.Ask)),
+		Flow:     b.Flow.Stats,
+		Tape:     b.Tape.Stats,
+		Mark:     b.Mark,
+		MarkTime: b.MarkTime,
 	}
 
 	for _, level := range b.Bid {
-		bid := OrderState{Price: level.Price, Size: level.MaxQuantity, OrderCount: level.OrderCount, TradeSize: level.TradeSize}
+		bid := OrderState{Price: level.Price, Size: level.MaxQuantity, OrderCount: level.OrderCount, TradeSize: level.TradeSize, FirstSeen: level.FirstSeen}
 		stats.Bid = append(stats.Bid, bid)
 	}
 
 	for _, level := range b.Ask {
-		ask := OrderState{Price: level.Price, Size: level.MaxQuantity, OrderCount: level.OrderCount, TradeSize: level.TradeSize}
+		ask := OrderState{Price: level.Price, Size: level.MaxQuantity, OrderCount: level.OrderCount, TradeSize: level.TradeSize, FirstSeen: level.FirstSeen}
 		stats.Ask = append(stats.Ask, ask)
 	}
 
@@ -259,9 +359,17 @@ type OrderState struct {
 	Size       float64
 	OrderCount int
 	TradeSize  float64
+	// estimated orders at the level, front of the queue first
+	Queue     []float64
+	FirstSeen time.Time
 }
 
 type BookMapStatsCopy struct {
-	Bid []OrderState
-	Ask []OrderState
+	Bid  []OrderState
+	Ask  []OrderState
+	Flow FlowStats
+	Tape TapeStats
+	// last mark price so far and when it was published, nil for spot
+	Mark     *MarkPrice
+	MarkTime time.Time
 }
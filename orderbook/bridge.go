@@ -0,0 +1,81 @@
+package orderbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// A bridged GapPacket is a gap followed by
+//
+//	uint64  sequence the book continues from
+//
+// and a DiffPacket turning the book stored before the gap into the one
+// after it. The book is kept over the gap instead of cleared, readers not
+// knowing the trailer clear it until the next sync.
+
+// PackBridgedGap marks the time from..to as not recorded while keeping the
+// book, which continues at sequence + 1.
+func PackBridgedGap(from, to time.Time, sequence uint64) []byte {
+	buf := bytes.NewBuffer(PackGap(from, to))
+	binary.Write(buf, binary.LittleEndian, sequence)
+	return buf.Bytes()
+}
+
+// UnpackBridgedGap returns the sequence a bridged gap continues from, false
+// for plain gaps.
+func UnpackBridgedGap(data []byte) (uint64, bool) {
+	if len(data) < 1+8+8+8 || data[0] != GapPacket {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(data[17:25]), true
+}
+
+// PackBridgeDiff encodes the changes from the sync packet ref to the sync
+// packet full as one diff at the sequence of full.
+func PackBridgeDiff(ref, full []byte) []byte {
+	_, refBids, refAsks := UnpackSync(ref)
+	sequence, bids, asks := UnpackSync(full)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, DiffPacket)
+	binary.Write(buf, binary.LittleEndian, sequence) // sequence
+	binary.Write(buf, binary.LittleEndian, sequence) // first
+	binary.Write(buf, binary.LittleEndian, sequence) // last
+	writeStates(buf, syncLevelDelta(nonEmpty(refBids), bids))
+	writeStates(buf, syncLevelDelta(nonEmpty(refAsks), asks))
+	return buf.Bytes()
+}
+
+func nonEmpty(states []OrderState) []OrderState {
+	result := []OrderState{}
+	for _, state := range states {
+		if state.Size != 0 {
+			result = append(result, state)
+		}
+	}
+	return result
+}
+
+// Hash sums the price and size of the non-empty levels, equal books hash
+// equal no matter how they were built.
+func (b *Book) Hash() uint64 {
+	b.Sort()
+	h := fnv.New64a()
+	word := make([]byte, 8)
+	for _, side := range []BookLevelList{b.Bid, b.Ask} {
+		for _, level := range side {
+			if level.Quantity == 0 {
+				continue
+			}
+			binary.LittleEndian.PutUint64(word, math.Float64bits(level.Price))
+			h.Write(word)
+			binary.LittleEndian.PutUint64(word, math.Float64bits(level.Quantity))
+			h.Write(word)
+		}
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,82 @@
+package orderbook
+
+import (
+	"math"
+	"time"
+)
+
+// trades are matched to level decreases recorded up to this much later,
+// diffs are stored with a delay of up to the diff interval
+const flowTradeWindow = 10 * time.Second
+
+// LevelFlow splits the size removed from one side of the book.
+type LevelFlow struct {
+	// removed by trades at the level
+	Filled float64
+	// removed without a trade, cancelled or moved away
+	Pulled float64
+}
+
+func (f LevelFlow) Total() float64 {
+	return f.Filled + f.Pulled
+}
+
+type FlowStats struct {
+	Bid LevelFlow
+	Ask LevelFlow
+}
+
+type flowTrade struct {
+	Size float64
+	Time time.Time
+}
+
+// FlowClassifier matches level decreases against the trades printed at the
+// same price to tell filled from pulled liquidity.
+type FlowClassifier struct {
+	Stats  FlowStats
+	traded map[float64]*flowTrade
+}
+
+func NewFlowClassifier() *FlowClassifier {
+	return &FlowClassifier{traded: map[float64]*flowTrade{}}
+}
+
+func (f *FlowClassifier) Trade(t time.Time, price, size float64) {
+	trade, ok := f.traded[price]
+	if !ok || t.Sub(trade.Time) > flowTradeWindow {
+		f.traded[price] = &flowTrade{Size: size, Time: t}
+		return
+	}
+	trade.Size += size
+	trade.Time = t
+}
+
+// Decrease classifies size removed from a level.
+func (f *FlowClassifier) Decrease(t time.Time, bid bool, price, size float64) {
+	var filled float64
+	if trade, ok := f.traded[price]; ok {
+		if t.Sub(trade.Time) <= flowTradeWindow {
+			filled = math.Min(trade.Size, size)
+			trade.Size -= filled
+		}
+		if trade.Size <= 0 || t.Sub(trade.Time) > flowTradeWindow {
+			delete(f.traded, price)
+		}
+	}
+
+	flow := &f.Stats.Ask
+	if bid {
+		flow = &f.Stats.Bid
+	}
+	flow.Filled += filled
+	flow.Pulled += size - filled
+}
+
+func (f *FlowClassifier) ResetStats() {
+	f.Stats = FlowStats{}
+}
+
+func (f *FlowClassifier) Clear() {
+	f.traded = map[float64]*flowTrade{}
+}
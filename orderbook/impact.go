@@ -0,0 +1,72 @@
+package orderbook
+
+import "sort"
+
+// FillEstimate is what a market order would have paid against the book.
+// Slippage is in basis points against the mid price, positive is a cost.
+type FillEstimate struct {
+	Side     string  `json:"side"`
+	Size     float64 `json:"size"`
+	Filled   float64 `json:"filled"`
+	Levels   int     `json:"levels"`
+	AvgPrice float64 `json:"avg_price"`
+	// price of the last level the order reached
+	WorstPrice float64 `json:"worst_price"`
+	Mid        float64 `json:"mid"`
+	Slippage   float64 `json:"slippage_bps"`
+	// the book was not deep enough for the size
+	Partial bool `json:"partial"`
+}
+
+// MarketOrder walks the asks for a buy and the bids for a sell until size
+// is filled. Returns nil when the side or the book is empty.
+func (b *Book) MarketOrder(buy bool, size float64) *FillEstimate {
+	bids, asks := b.liveLevels(b.Bid), b.liveLevels(b.Ask)
+	if len(bids) == 0 || len(asks) == 0 || size <= 0 {
+		return nil
+	}
+	mid := bids[len(bids)-1].Price + ((asks[0].Price - bids[len(bids)-1].Price) / 2)
+
+	e := &FillEstimate{Side: "buy", Size: size, Mid: mid}
+	levels, sign := asks, 1.0
+	if !buy {
+		e.Side = "sell"
+		sign = -1
+		// best bid first
+		levels = make([]*BookLevel, 0, len(bids))
+		for i := len(bids) - 1; i >= 0; i -= 1 {
+			levels = append(levels, bids[i])
+		}
+	}
+
+	var notional float64
+	for _, level := range levels {
+		if e.Filled >= size {
+			break
+		}
+		take := level.Quantity
+		if e.Filled+take > size {
+			take = size - e.Filled
+		}
+		e.Filled += take
+		notional += take * level.Price
+		e.WorstPrice = level.Price
+		e.Levels += 1
+	}
+	e.Partial = e.Filled < size
+	e.AvgPrice = notional / e.Filled
+	e.Slippage = sign * (e.AvgPrice - mid) / mid * 10000
+	return e
+}
+
+// liveLevels returns the levels with size sorted by price.
+func (b *Book) liveLevels(list BookLevelList) BookLevelList {
+	levels := make(BookLevelList, 0, len(list))
+	for _, level := range list {
+		if level.Quantity > 0 {
+			levels = append(levels, level)
+		}
+	}
+	sort.Sort(levels)
+	return levels
+}
@@ -0,0 +1,55 @@
+package orderbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// MarkPrice is the mark and index price and the funding of a futures
+// contract, stored as
+//
+//	uint8   MarkPricePacket
+//	float64 mark price
+//	float64 index price
+//	float64 funding rate
+//	int64   unix nano time of the next funding, 0 for none
+type MarkPrice struct {
+	Mark        float64
+	Index       float64
+	FundingRate float64
+	NextFunding time.Time
+}
+
+func PackMarkPrice(m *MarkPrice) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, MarkPricePacket)
+	binary.Write(buf, binary.LittleEndian, m.Mark)
+	binary.Write(buf, binary.LittleEndian, m.Index)
+	binary.Write(buf, binary.LittleEndian, m.FundingRate)
+	var nano int64
+	if !m.NextFunding.IsZero() {
+		nano = m.NextFunding.UnixNano()
+	}
+	binary.Write(buf, binary.LittleEndian, nano)
+	return buf.Bytes()
+}
+
+// UnpackMarkPrice returns the mark price of a packet, nil for other or
+// truncated packets.
+func UnpackMarkPrice(data []byte) *MarkPrice {
+	if len(data) < 1+8+8+8+8 || data[0] != MarkPricePacket {
+		return nil
+	}
+	buf := bytes.NewBuffer(data[1:])
+	m := &MarkPrice{}
+	var nano int64
+	binary.Read(buf, binary.LittleEndian, &m.Mark)
+	binary.Read(buf, binary.LittleEndian, &m.Index)
+	binary.Read(buf, binary.LittleEndian, &m.FundingRate)
+	binary.Read(buf, binary.LittleEndian, &nano)
+	if nano != 0 {
+		m.NextFunding = time.Unix(0, nano)
+	}
+	return m
+}
@@ -0,0 +1,115 @@
+package orderbook
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// OrderBook3 is the market-by-order view of venues publishing every order,
+// like the GDAX full channel. It holds the sizes of the orders resting at
+// each level, front of the queue first, so single orders show up in the
+// bookmap: an iceberg refills at the back, a pulled order leaves a gap.
+//
+// It is filled from orders packets, stored after the diffs and syncs of
+// such venues as
+//
+//	uint8   OrdersPacket
+//	uint64  bid level count
+//	  float64 price
+//	  uint64  order count, 0 when the level was removed
+//	    float64 order size
+//	uint64  ask level count, levels like the bids
+type OrderBook3 struct {
+	Bid map[float64][]float64
+	Ask map[float64][]float64
+}
+
+func NewOrderBook3() *OrderBook3 {
+	return &OrderBook3{
+		Bid: map[float64][]float64{},
+		Ask: map[float64][]float64{},
+	}
+}
+
+func (o *OrderBook3) side(bid bool) map[float64][]float64 {
+	if bid {
+		return o.Bid
+	}
+	return o.Ask
+}
+
+func (o *OrderBook3) Clear() {
+	o.Bid = map[float64][]float64{}
+	o.Ask = map[float64][]float64{}
+}
+
+// Orders returns the order sizes of a level, front of the queue first.
+func (o *OrderBook3) Orders(bid bool, price float64) ([]float64, bool) {
+	orders, ok := o.side(bid)[price]
+	return orders, ok
+}
+
+// Set replaces the orders of a level, none removes it.
+func (o *OrderBook3) Set(bid bool, price float64, orders []float64) {
+	if len(orders) == 0 {
+		delete(o.side(bid), price)
+		return
+	}
+	o.side(bid)[price] = orders
+}
+
+// PackOrders packs the orders of the given levels, by price.
+func PackOrders(bids, asks map[float64][]float64) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, OrdersPacket)
+	for _, levels := range []map[float64][]float64{bids, asks} {
+		binary.Write(buf, binary.LittleEndian, uint64(len(levels)))
+		for price, orders := range levels {
+			binary.Write(buf, binary.LittleEndian, price)
+			binary.Write(buf, binary.LittleEndian, uint64(len(orders)))
+			for _, size := range orders {
+				binary.Write(buf, binary.LittleEndian, size)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// UnpackOrders returns the levels of an orders packet, nil for other
+// packets. Truncated levels are dropped.
+func UnpackOrders(data []byte) (map[float64][]float64, map[float64][]float64) {
+	if len(data) == 0 || data[0] != OrdersPacket {
+		return nil, nil
+	}
+	buf := bytes.NewBuffer(data[1:])
+	sides := []map[float64][]float64{{}, {}}
+	for _, levels := range sides {
+		var count uint64
+		if binary.Read(buf, binary.LittleEndian, &count) != nil {
+			break
+		}
+		for i := uint64(0); i < count && buf.Len() >= 16; i += 1 {
+			var price float64
+			var orders uint64
+			binary.Read(buf, binary.LittleEndian, &price)
+			binary.Read(buf, binary.LittleEndian, &orders)
+			if uint64(buf.Len()) < orders*8 {
+				break
+			}
+			sizes := make([]float64, orders)
+			binary.Read(buf, binary.LittleEndian, sizes)
+			levels[price] = sizes
+		}
+	}
+	return sides[0], sides[1]
+}
+
+func (o *OrderBook3) apply(data []byte) {
+	bids, asks := UnpackOrders(data)
+	for price, orders := range bids {
+		o.Set(true, price, orders)
+	}
+	for price, orders := range asks {
+		o.Set(false, price, orders)
+	}
+}
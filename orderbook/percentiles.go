@@ -0,0 +1,79 @@
+package orderbook
+
+import (
+	"math"
+	"sort"
+)
+
+// Percentiles keeps a rolling window of the last values (e.g. trade sizes)
+// and answers percentile queries over them.
+type Percentiles struct {
+	Window int
+	values []float64
+	next   int
+	sorted []float64
+	dirty  bool
+}
+
+func NewPercentiles(window int) *Percentiles {
+	return &Percentiles{
+		Window: window,
+		values: make([]float64, 0, window),
+	}
+}
+
+func (p *Percentiles) Add(v float64) {
+	if len(p.values) < p.Window {
+		p.values = append(p.values, v)
+	} else {
+		p.values[p.next] = v
+		p.next = (p.next + 1) % p.Window
+	}
+	p.dirty = true
+}
+
+func (p *Percentiles) Count() int {
+	return len(p.values)
+}
+
+// Percentile returns the value below which q (0-100) percent of the window
+// falls, or 0 if nothing was added yet.
+func (p *Percentiles) Percentile(q float64) float64 {
+	if len(p.values) == 0 {
+		return 0
+	}
+	if p.dirty {
+		p.sorted = append(p.sorted[:0], p.values...)
+		sort.Float64s(p.sorted)
+		p.dirty = false
+	}
+
+	i := int(math.Ceil((q/100)*float64(len(p.sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(p.sorted) {
+		i = len(p.sorted) - 1
+	}
+	return p.sorted[i]
+}
+
+// Scale maps v linearly between the low and high percentile to 0..1.
+func (p *Percentiles) Scale(v, low, high float64) float64 {
+	min := p.Percentile(low)
+	max := p.Percentile(high)
+	if max <= min {
+		if v >= max {
+			return 1.0
+		}
+		return 0.0
+	}
+	t := (v - min) / (max - min)
+	if t < 0 {
+		t = 0
+	}
+	if t > 1 {
+		t = 1
+	}
+	return t
+}
@@ -12,8 +12,25 @@ const (
 	SyncPacket  uint8 = iota
 	DiffPacket  uint8 = iota
 	TradePacket uint8 = iota
+	// sync recorded by polling a REST snapshot while the websocket was down
+	DegradedSyncPacket uint8 = iota
+	// marks data removed from the recording, the book is unknown until the next sync
+	GapPacket uint8 = iota
+	// first seen time of every level, stored right after a sync so level ages
+	// survive it
+	LevelAgesPacket uint8 = iota
+	// sync stored as the level changes against an earlier full sync
+	SyncDeltaPacket uint8 = iota
+	// mark price and funding of futures, see MarkPrice
+	MarkPricePacket uint8 = iota
+	// orders resting at the levels of market-by-order venues, see OrderBook3
+	OrdersPacket uint8 = iota
 )
 
+func IsSyncPacket(data []byte) bool {
+	return len(data) > 0 && (data[0] == SyncPacket || data[0] == DegradedSyncPacket)
+}
+
 func UnpackTimeKey(key []byte) time.Time {
 	i, _ := strconv.ParseInt(string(key), 10, 64)
 	return time.Unix(0, i)
@@ -96,7 +113,7 @@ func (book *Book) Process(t time.Time, data []byte) bool {
 
 		book.Sort()
 
-	case SyncPacket:
+	case SyncPacket, DegradedSyncPacket:
 		binary.Read(buf, binary.LittleEndian, &sequence)
 
 		book.Clear()
@@ -120,6 +137,19 @@ func (book *Book) Process(t time.Time, data []byte) bool {
 
 		book.Sort()
 
+	case GapPacket:
+		if sequence, ok := UnpackBridgedGap(data); ok {
+			book.Sequence = sequence
+			break
+		}
+		book.Clear()
+		book.Sequence = 0
+
+	case LevelAgesPacket:
+		bids, asks := UnpackLevelAges(data)
+		applyLevelAges(book.Bid, bids)
+		applyLevelAges(book.Ask, asks)
+
 	case TradePacket:
 		binary.Read(buf, binary.LittleEndian, &sequence)
 		binary.Read(buf, binary.LittleEndian, &side)
@@ -127,6 +157,19 @@ func (book *Book) Process(t time.Time, data []byte) bool {
 		binary.Read(buf, binary.LittleEndian, &size)
 
 		book.AddTrade(t, side, price, size)
+		book.Trades[len(book.Trades)-1].Source = UnpackTradeSource(data)
+
+	case MarkPricePacket:
+		if mark := UnpackMarkPrice(data); mark != nil {
+			book.Mark = mark
+			book.MarkTime = t
+		}
+
+	case OrdersPacket:
+		if book.Orders == nil {
+			book.Orders = NewOrderBook3()
+		}
+		book.Orders.apply(data)
 
 	default:
 		fmt.Println(book.ProductInfo.DatabaseKey, "unkown packetType", packetType)
@@ -135,3 +178,235 @@ func (book *Book) Process(t time.Time, data []byte) bool {
 
 	return true
 }
+
+func UnpackSync(data []byte) (uint64, []OrderState, []OrderState) {
+	buf := bytes.NewBuffer(data)
+
+	var packetType uint8
+	var sequence uint64
+	var count uint64
+	var price float64
+	var size float64
+
+	binary.Read(buf, binary.LittleEndian, &packetType)
+	if packetType != SyncPacket && packetType != DegradedSyncPacket {
+		return 0, nil, nil
+	}
+	binary.Read(buf, binary.LittleEndian, &sequence)
+
+	binary.Read(buf, binary.LittleEndian, &count)
+	bids := []OrderState{}
+	for i := uint64(0); i < count && buf.Len() >= 16; i += 1 {
+		binary.Read(buf, binary.LittleEndian, &price)
+		binary.Read(buf, binary.LittleEndian, &size)
+		bids = append(bids, OrderState{Price: price, Size: size})
+	}
+
+	binary.Read(buf, binary.LittleEndian, &count)
+	asks := []OrderState{}
+	for i := uint64(0); i < count && buf.Len() >= 16; i += 1 {
+		binary.Read(buf, binary.LittleEndian, &price)
+		binary.Read(buf, binary.LittleEndian, &size)
+		asks = append(asks, OrderState{Price: price, Size: size})
+	}
+
+	return sequence, bids, asks
+}
+
+func UnpackDiff(data []byte) (uint64, uint64, []OrderState, []OrderState) {
+	buf := bytes.NewBuffer(data)
+
+	var packetType uint8
+	var sequence uint64
+	var first uint64
+	var last uint64
+	var count uint64
+	var price float64
+	var size float64
+
+	binary.Read(buf, binary.LittleEndian, &packetType)
+	if packetType != DiffPacket {
+		return 0, 0, nil, nil
+	}
+	binary.Read(buf, binary.LittleEndian, &sequence)
+	binary.Read(buf, binary.LittleEndian, &first)
+	binary.Read(buf, binary.LittleEndian, &last)
+
+	binary.Read(buf, binary.LittleEndian, &count)
+	bids := []OrderState{}
+	for i := uint64(0); i < count && buf.Len() >= 16; i += 1 {
+		binary.Read(buf, binary.LittleEndian, &price)
+		binary.Read(buf, binary.LittleEndian, &size)
+		bids = append(bids, OrderState{Price: price, Size: size})
+	}
+
+	binary.Read(buf, binary.LittleEndian, &count)
+	asks := []OrderState{}
+	for i := uint64(0); i < count && buf.Len() >= 16; i += 1 {
+		binary.Read(buf, binary.LittleEndian, &price)
+		binary.Read(buf, binary.LittleEndian, &size)
+		asks = append(asks, OrderState{Price: price, Size: size})
+	}
+
+	return first, last, bids, asks
+}
+
+func UnpackTrade(data []byte) (Side, float64, float64) {
+	buf := bytes.NewBuffer(data)
+
+	var packetType uint8
+	var sequence uint64
+	var side uint8
+	var price float64
+	var size float64
+
+	binary.Read(buf, binary.LittleEndian, &packetType)
+	binary.Read(buf, binary.LittleEndian, &sequence)
+	binary.Read(buf, binary.LittleEndian, &side)
+	binary.Read(buf, binary.LittleEndian, &price)
+	binary.Read(buf, binary.LittleEndian, &size)
+
+	return Side(side), price, size
+}
+
+// SideSource tells how the aggressor side of a trade was known, stored as
+// the last byte of trade packets. Older packets have none.
+type SideSource uint8
+
+const (
+	SideUnknown SideSource = iota
+	// the price matched a level still in the book
+	SideFromLevel
+	// the price against the best bid and ask at the time of the trade
+	SideFromBBO
+	// up or down from the previous trade, for prices inside the spread
+	SideFromTick
+	// taker side or maker flag sent by the venue
+	SideFromVenue
+)
+
+var sideSourceNames = []string{"unknown", "level", "bbo", "tick", "venue"}
+
+func (s SideSource) String() string {
+	if int(s) < len(sideSourceNames) {
+		return sideSourceNames[s]
+	}
+	return fmt.Sprintf("SideSource(%d)", s)
+}
+
+// UnpackTradeSource returns how the side of a trade packet was classified.
+func UnpackTradeSource(data []byte) SideSource {
+	// type, sequence, side, price, size
+	if len(data) <= 1+8+1+8+8 {
+		return SideUnknown
+	}
+	return SideSource(data[1+8+1+8+8])
+}
+
+// PackSync packs the current state of a replayed book as a sync packet.
+func (book *Book) PackSync() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, SyncPacket)
+	binary.Write(buf, binary.LittleEndian, book.Sequence)
+
+	binary.Write(buf, binary.LittleEndian, uint64(len(book.Bid)))
+	for _, level := range book.Bid {
+		binary.Write(buf, binary.LittleEndian, level.Price)
+		binary.Write(buf, binary.LittleEndian, level.Quantity)
+	}
+
+	binary.Write(buf, binary.LittleEndian, uint64(len(book.Ask)))
+	for _, level := range book.Ask {
+		binary.Write(buf, binary.LittleEndian, level.Price)
+		binary.Write(buf, binary.LittleEndian, level.Quantity)
+	}
+
+	return buf.Bytes()
+}
+
+func PackGap(from, to time.Time) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, GapPacket)
+	binary.Write(buf, binary.LittleEndian, from.UnixNano())
+	binary.Write(buf, binary.LittleEndian, to.UnixNano())
+	return buf.Bytes()
+}
+
+func UnpackGap(data []byte) (time.Time, time.Time) {
+	buf := bytes.NewBuffer(data)
+
+	var packetType uint8
+	var from, to int64
+
+	binary.Read(buf, binary.LittleEndian, &packetType)
+	binary.Read(buf, binary.LittleEndian, &from)
+	binary.Read(buf, binary.LittleEndian, &to)
+
+	return time.Unix(0, from), time.Unix(0, to)
+}
+
+// LevelAge is the time liquidity first appeared at a price.
+type LevelAge struct {
+	Price     float64
+	FirstSeen time.Time
+}
+
+func PackLevelAges(bids, asks []LevelAge) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, LevelAgesPacket)
+
+	binary.Write(buf, binary.LittleEndian, uint64(len(bids)))
+	for _, age := range bids {
+		binary.Write(buf, binary.LittleEndian, age.Price)
+		binary.Write(buf, binary.LittleEndian, age.FirstSeen.UnixNano())
+	}
+
+	binary.Write(buf, binary.LittleEndian, uint64(len(asks)))
+	for _, age := range asks {
+		binary.Write(buf, binary.LittleEndian, age.Price)
+		binary.Write(buf, binary.LittleEndian, age.FirstSeen.UnixNano())
+	}
+
+	return buf.Bytes()
+}
+
+func UnpackLevelAges(data []byte) ([]LevelAge, []LevelAge) {
+	buf := bytes.NewBuffer(data)
+
+	var packetType uint8
+	var count uint64
+	var price float64
+	var nano int64
+
+	binary.Read(buf, binary.LittleEndian, &packetType)
+
+	binary.Read(buf, binary.LittleEndian, &count)
+	bids := make([]LevelAge, 0, count)
+	for i := uint64(0); i < count; i += 1 {
+		binary.Read(buf, binary.LittleEndian, &price)
+		binary.Read(buf, binary.LittleEndian, &nano)
+		bids = append(bids, LevelAge{Price: price, FirstSeen: time.Unix(0, nano)})
+	}
+
+	binary.Read(buf, binary.LittleEndian, &count)
+	asks := make([]LevelAge, 0, count)
+	for i := uint64(0); i < count; i += 1 {
+		binary.Read(buf, binary.LittleEndian, &price)
+		binary.Read(buf, binary.LittleEndian, &nano)
+		asks = append(asks, LevelAge{Price: price, FirstSeen: time.Unix(0, nano)})
+	}
+
+	return bids, asks
+}
+
+func applyLevelAges(levels BookLevelList, ages []LevelAge) {
+	firstSeen := make(map[float64]time.Time, len(ages))
+	for _, age := range ages {
+		firstSeen[age.Price] = age.FirstSeen
+	}
+	for _, level := range levels {
+		if t, ok := firstSeen[level.Price]; ok {
+			level.FirstSeen = t
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package orderbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// provenance kinds, leaves room for other trailers of sync packets
+const restSnapshotProvenance uint8 = 1
+
+// SyncProvenance is the REST snapshot a stored sync was built from,
+// appended after the ask levels of sync packets as
+//
+//	uint8   kind (1 REST snapshot)
+//	uint64  sequence or lastUpdateId of the snapshot
+//	int64   unix nano time it was fetched
+//
+// Older packets and books only built from websocket messages have none.
+type SyncProvenance struct {
+	Sequence uint64    `json:"snapshot_sequence"`
+	Fetched  time.Time `json:"snapshot_fetched"`
+	// the sync is the snapshot as fetched, no diffs were applied since
+	Unchanged bool `json:"unchanged"`
+}
+
+func PackSyncProvenance(p *SyncProvenance) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, restSnapshotProvenance)
+	binary.Write(buf, binary.LittleEndian, p.Sequence)
+	binary.Write(buf, binary.LittleEndian, p.Fetched.UnixNano())
+	return buf.Bytes()
+}
+
+// syncTrailer returns what follows the levels of a sync packet.
+func syncTrailer(data []byte) []byte {
+	if !IsSyncPacket(data) {
+		return nil
+	}
+	offset := 1 + 8
+	for side := 0; side < 2; side += 1 {
+		if len(data) < offset+8 {
+			return nil
+		}
+		count := binary.LittleEndian.Uint64(data[offset:])
+		offset += 8
+		if count > uint64(len(data)-offset)/16 {
+			return nil
+		}
+		offset += int(count) * 16
+	}
+	return data[offset:]
+}
+
+// UnpackSyncProvenance returns the snapshot origin of a sync packet, nil
+// when it has none.
+func UnpackSyncProvenance(data []byte) *SyncProvenance {
+	trailer := syncTrailer(data)
+	if len(trailer) < 1+8+8 || trailer[0] != restSnapshotProvenance {
+		return nil
+	}
+	p := &SyncProvenance{
+		Sequence: binary.LittleEndian.Uint64(trailer[1:]),
+		Fetched:  time.Unix(0, int64(binary.LittleEndian.Uint64(trailer[9:]))),
+	}
+	p.Unchanged = binary.LittleEndian.Uint64(data[1:]) == p.Sequence
+	return p
+}
@@ -0,0 +1,136 @@
+package orderbook
+
+import (
+	"math"
+)
+
+// increases larger than this many typical orders are split up
+const queueSplitFactor = 3.0
+
+// typical order size is only trusted after this many samples
+const queueMinSamples = 20
+
+// QueueEstimator approximates the individual orders resting at each price
+// level from L2 size changes, for venues that do not publish order level
+// data. Increases are new orders at the back of the queue, decreases are
+// fills from the front when a trade happened at the price and cancels
+// otherwise.
+type QueueEstimator struct {
+	Bid        map[float64][]float64
+	Ask        map[float64][]float64
+	Traded     map[float64]float64
+	OrderSizes *Percentiles
+}
+
+func NewQueueEstimator() *QueueEstimator {
+	return &QueueEstimator{
+		Bid:        map[float64][]float64{},
+		Ask:        map[float64][]float64{},
+		Traded:     map[float64]float64{},
+		OrderSizes: NewPercentiles(2000),
+	}
+}
+
+func (q *QueueEstimator) side(bid bool) map[float64][]float64 {
+	if bid {
+		return q.Bid
+	}
+	return q.Ask
+}
+
+func (q *QueueEstimator) Clear() {
+	q.Bid = map[float64][]float64{}
+	q.Ask = map[float64][]float64{}
+	q.Traded = map[float64]float64{}
+}
+
+// TypicalSize is the median size of observed orders, 0 while unknown.
+func (q *QueueEstimator) TypicalSize() float64 {
+	if q.OrderSizes.Count() < queueMinSamples {
+		return 0
+	}
+	return q.OrderSizes.Percentile(50)
+}
+
+func (q *QueueEstimator) Orders(bid bool, price float64) []float64 {
+	return q.side(bid)[price]
+}
+
+func (q *QueueEstimator) Trade(price, size float64) {
+	q.Traded[price] += size
+}
+
+func (q *QueueEstimator) Update(bid bool, price, quantity float64) {
+	levels := q.side(bid)
+	if quantity == 0 {
+		delete(levels, price)
+		delete(q.Traded, price)
+		return
+	}
+
+	queue := levels[price]
+	var current float64
+	for _, size := range queue {
+		current += size
+	}
+
+	delta := quantity - current
+	if delta > 0 {
+		levels[price] = q.add(queue, delta)
+	} else if delta < 0 {
+		levels[price] = q.remove(price, queue, -delta)
+	}
+}
+
+func (q *QueueEstimator) add(queue []float64, size float64) []float64 {
+	q.OrderSizes.Add(size)
+
+	typical := q.TypicalSize()
+	if typical == 0 || size <= typical*queueSplitFactor {
+		return append(queue, size)
+	}
+
+	count := int(math.Floor((size / typical) + 0.5))
+	for i := 0; i < count; i++ {
+		queue = append(queue, size/float64(count))
+	}
+	return queue
+}
+
+func (q *QueueEstimator) remove(price float64, queue []float64, size float64) []float64 {
+	// traded volume is taken from the front of the queue
+	if traded := math.Min(q.Traded[price], size); traded > 0 {
+		q.Traded[price] -= traded
+		size -= traded
+		for traded > 0 && len(queue) > 0 {
+			if queue[0] > traded {
+				queue[0] -= traded
+				break
+			}
+			traded -= queue[0]
+			queue = queue[1:]
+		}
+	}
+	if size <= 0 {
+		return queue
+	}
+
+	// a cancel matching a single order removes exactly that order
+	for i := len(queue) - 1; i >= 0; i-- {
+		if math.Abs(queue[i]-size) < 1e-9 {
+			return append(queue[:i], queue[i+1:]...)
+		}
+	}
+
+	// otherwise assume the most recent orders got cancelled
+	for size > 0 && len(queue) > 0 {
+		last := len(queue) - 1
+		if queue[last] > size {
+			queue[last] -= size
+			break
+		}
+		size -= queue[last]
+		queue = queue[:last]
+	}
+	return queue
+}
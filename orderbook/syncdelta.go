@@ -0,0 +1,129 @@
+package orderbook
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// A SyncDeltaPacket is laid out as
+//
+//	uint8   SyncDeltaPacket
+//	uint64  sequence
+//	int64   unix nano key of the keyframe
+//	uint64  bid count, then price/size pairs, size 0 removes the level
+//	uint64  ask count, then price/size pairs
+//	        the trailer of the full sync, see SyncProvenance
+//
+// Readers using util.Cursor get it expanded to a SyncPacket.
+
+func IsSyncDeltaPacket(data []byte) bool {
+	return len(data) > 0 && data[0] == SyncDeltaPacket
+}
+
+func syncLevelDelta(ref, full []OrderState) []OrderState {
+	before := map[float64]float64{}
+	for _, state := range ref {
+		before[state.Price] = state.Size
+	}
+	delta := []OrderState{}
+	for _, state := range full {
+		if size, ok := before[state.Price]; !ok || size != state.Size {
+			delta = append(delta, OrderState{Price: state.Price, Size: state.Size})
+		}
+		delete(before, state.Price)
+	}
+	for price := range before {
+		delta = append(delta, OrderState{Price: price, Size: 0})
+	}
+	return delta
+}
+
+func writeStates(buf *bytes.Buffer, states []OrderState) {
+	binary.Write(buf, binary.LittleEndian, uint64(len(states)))
+	for _, state := range states {
+		binary.Write(buf, binary.LittleEndian, state.Price)
+		binary.Write(buf, binary.LittleEndian, state.Size)
+	}
+}
+
+// PackSyncDelta encodes the sync packet full against the keyframe sync
+// packet ref stored under refNano.
+func PackSyncDelta(ref []byte, refNano int64, full []byte) []byte {
+	_, refBids, refAsks := UnpackSync(ref)
+	sequence, bids, asks := UnpackSync(full)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, SyncDeltaPacket)
+	binary.Write(buf, binary.LittleEndian, sequence)
+	binary.Write(buf, binary.LittleEndian, refNano)
+	writeStates(buf, syncLevelDelta(refBids, bids))
+	writeStates(buf, syncLevelDelta(refAsks, asks))
+	buf.Write(syncTrailer(full))
+	return buf.Bytes()
+}
+
+// SyncDeltaReference returns the unix nano key of the keyframe.
+func SyncDeltaReference(data []byte) int64 {
+	if !IsSyncDeltaPacket(data) || len(data) < 17 {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(data[9:17]))
+}
+
+func applyStates(levels []OrderState, delta []OrderState) []OrderState {
+	sizes := map[float64]float64{}
+	order := []float64{}
+	for _, state := range levels {
+		sizes[state.Price] = state.Size
+		order = append(order, state.Price)
+	}
+	for _, state := range delta {
+		if _, ok := sizes[state.Price]; !ok {
+			order = append(order, state.Price)
+		}
+		sizes[state.Price] = state.Size
+	}
+	result := []OrderState{}
+	for _, price := range order {
+		if sizes[price] != 0 {
+			result = append(result, OrderState{Price: price, Size: sizes[price]})
+		}
+	}
+	return result
+}
+
+// ExpandSyncDelta rebuilds the full sync packet from its keyframe.
+func ExpandSyncDelta(ref, delta []byte) []byte {
+	_, refBids, refAsks := UnpackSync(ref)
+
+	buf := bytes.NewBuffer(delta)
+	var packetType uint8
+	var sequence uint64
+	var refNano int64
+	var count uint64
+	var price, size float64
+
+	binary.Read(buf, binary.LittleEndian, &packetType)
+	binary.Read(buf, binary.LittleEndian, &sequence)
+	binary.Read(buf, binary.LittleEndian, &refNano)
+
+	sides := [2][]OrderState{}
+	for side := range sides {
+		binary.Read(buf, binary.LittleEndian, &count)
+		states := []OrderState{}
+		for i := uint64(0); i < count && buf.Len() >= 16; i += 1 {
+			binary.Read(buf, binary.LittleEndian, &price)
+			binary.Read(buf, binary.LittleEndian, &size)
+			states = append(states, OrderState{Price: price, Size: size})
+		}
+		sides[side] = states
+	}
+
+	out := new(bytes.Buffer)
+	binary.Write(out, binary.LittleEndian, SyncPacket)
+	binary.Write(out, binary.LittleEndian, sequence)
+	writeStates(out, applyStates(refBids, sides[0]))
+	writeStates(out, applyStates(refAsks, sides[1]))
+	out.Write(buf.Bytes())
+	return out.Bytes()
+}
@@ -0,0 +1,69 @@
+package orderbook
+
+import "time"
+
+type TapeStats struct {
+	Trades int
+	Volume float64
+}
+
+type tapeBucket struct {
+	second int64
+	trades int
+	volume float64
+}
+
+// TapeSpeed counts the trades and their volume per second over a rolling
+// window, to tell how fast the tape is running.
+type TapeSpeed struct {
+	// counted since the last ResetStats
+	Stats   TapeStats
+	buckets []tapeBucket
+}
+
+func NewTapeSpeed(window time.Duration) *TapeSpeed {
+	seconds := int(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &TapeSpeed{buckets: make([]tapeBucket, seconds)}
+}
+
+func (s *TapeSpeed) Add(t time.Time, size float64) {
+	second := t.Unix()
+	b := &s.buckets[second%int64(len(s.buckets))]
+	if b.second != second {
+		*b = tapeBucket{second: second}
+	}
+	b.trades += 1
+	b.volume += size
+	s.Stats.Trades += 1
+	s.Stats.Volume += size
+}
+
+// Rate returns the trades and the volume per second over window (at most
+// the window of the TapeSpeed) up to now.
+func (s *TapeSpeed) Rate(now time.Time, window time.Duration) (float64, float64) {
+	seconds := int64(window / time.Second)
+	if seconds > int64(len(s.buckets)) {
+		seconds = int64(len(s.buckets))
+	}
+	if seconds < 1 {
+		seconds = 1
+	}
+	var trades int
+	var volume float64
+	last := now.Unix()
+	for second := last - seconds + 1; second <= last; second++ {
+		b := s.buckets[second%int64(len(s.buckets))]
+		if b.second == second {
+			trades += b.trades
+			volume += b.volume
+		}
+	}
+	return float64(trades) / float64(seconds), volume / float64(seconds)
+}
+
+func (s *TapeSpeed) ResetStats() {
+	s.Stats = TapeStats{}
+}
@@ -0,0 +1,217 @@
+package postgres
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+const maxPendingTrades = 100000
+
+// Sink replays the packets stored by the recorder and mirrors them into
+//
+//	<prefix>_book    top Depth levels of every product, one row per
+//	                 product, side and level, upserted every Interval
+//	<prefix>_trades  every trade, appended every Interval
+//
+// so they can be queried with BI tools.
+type Sink struct {
+	Client   *Client
+	Prefix   string
+	Products []string
+	Depth    int
+	Interval time.Duration
+
+	books  map[string]*orderbook.Book
+	synced map[string]bool
+	trades *bytes.Buffer
+	count  int
+}
+
+func NewSink(client *Client, prefix string, products []string) *Sink {
+	return &Sink{
+		Client:   client,
+		Prefix:   prefix,
+		Products: products,
+		Depth:    20,
+		Interval: 10 * time.Second,
+		books:    map[string]*orderbook.Book{},
+		synced:   map[string]bool{},
+		trades:   &bytes.Buffer{},
+	}
+}
+
+func (s *Sink) table(name string) string {
+	return QuoteIdent(s.Prefix + "_" + name)
+}
+
+func (s *Sink) createTables() error {
+	return s.Client.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	product text NOT NULL,
+	side text NOT NULL,
+	level integer NOT NULL,
+	price double precision NOT NULL,
+	size double precision NOT NULL,
+	time timestamptz NOT NULL,
+	PRIMARY KEY (product, side, level)
+);
+CREATE TABLE IF NOT EXISTS %s (
+	product text NOT NULL,
+	time timestamptz NOT NULL,
+	side text NOT NULL,
+	price double precision NOT NULL,
+	size double precision NOT NULL
+);
+CREATE INDEX IF NOT EXISTS %s ON %s (product, time);`,
+		s.table("book"), s.table("trades"), QuoteIdent(s.Prefix+"_trades_product_time"), s.table("trades")))
+}
+
+// Connect keeps the server connection up and the tables created.
+func (s *Sink) Connect() {
+	for {
+		if !s.Client.Connected() {
+			if err := s.Client.Connect(); err != nil {
+				fmt.Println("postgres connect", err)
+			} else if err := s.createTables(); err != nil {
+				fmt.Println("postgres create tables", err)
+			} else {
+				fmt.Println("postgres connected to", s.Client.Addr)
+			}
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (s *Sink) Run() {
+	topics := []string{}
+	for _, product := range s.Products {
+		s.books[product] = orderbook.New(product)
+		topics = append(topics, util.ProductTopics(product)...)
+	}
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		sub := util.Events.Subscribe(topics, 4096)
+	events:
+		for {
+			select {
+			case ev, ok := <-sub.C:
+				if !ok {
+					break events
+				}
+				s.handle(ev)
+			case <-ticker.C:
+				if err := s.flush(); err != nil {
+					fmt.Println("postgres flush", err)
+				}
+			}
+		}
+		// too slow, wait for the next sync of every book
+		fmt.Println("postgres sink fell behind, resubscribing")
+		for product := range s.synced {
+			s.synced[product] = false
+		}
+	}
+}
+
+func timestamp(t time.Time) string {
+	return Quote(t.UTC().Format("2006-01-02 15:04:05.999999Z07:00"))
+}
+
+func (s *Sink) handle(ev *util.Event) {
+	book, ok := s.books[ev.Bucket]
+	if !ok || len(ev.Data) == 0 {
+		return
+	}
+	t := orderbook.UnpackTimeKey(ev.Key)
+
+	if ev.Data[0] == orderbook.TradePacket {
+		side, price, size := orderbook.UnpackTrade(ev.Data)
+		// the book side the trade happened on
+		name := "bid"
+		if side == orderbook.AskSide {
+			name = "ask"
+		}
+		if s.count > 0 {
+			s.trades.WriteString(",\n")
+		}
+		fmt.Fprintf(s.trades, "(%s, %s, %s, %v, %v)", Quote(ev.Bucket), timestamp(t), Quote(name), price, size)
+		s.count += 1
+	}
+
+	if orderbook.IsSyncPacket(ev.Data) {
+		s.synced[ev.Bucket] = true
+	}
+	if !s.synced[ev.Bucket] {
+		return
+	}
+	if !book.Process(t, ev.Data) {
+		s.synced[ev.Bucket] = false
+	}
+}
+
+// snapshot returns the statements replacing the stored levels of product.
+func (s *Sink) snapshot(product string, now time.Time) string {
+	book := s.books[product]
+	rows := []string{}
+	bids, asks := 0, 0
+	for level := 0; level < s.Depth; level += 1 {
+		if i := len(book.Bid) - 1 - level; i >= 0 {
+			rows = append(rows, fmt.Sprintf("(%s, 'bid', %d, %v, %v, %s)", Quote(product), level, book.Bid[i].Price, book.Bid[i].Quantity, timestamp(now)))
+			bids += 1
+		}
+		if level < len(book.Ask) {
+			rows = append(rows, fmt.Sprintf("(%s, 'ask', %d, %v, %v, %s)", Quote(product), level, book.Ask[level].Price, book.Ask[level].Quantity, timestamp(now)))
+			asks += 1
+		}
+	}
+
+	// levels deeper than the book currently is
+	sql := fmt.Sprintf("DELETE FROM %s WHERE product = %s AND ((side = 'bid' AND level >= %d) OR (side = 'ask' AND level >= %d));\n",
+		s.table("book"), Quote(product), bids, asks)
+	if len(rows) > 0 {
+		sql += fmt.Sprintf("INSERT INTO %s (product, side, level, price, size, time) VALUES\n%s\n"+
+			"ON CONFLICT (product, side, level) DO UPDATE SET price = EXCLUDED.price, size = EXCLUDED.size, time = EXCLUDED.time;\n",
+			s.table("book"), strings.Join(rows, ",\n"))
+	}
+	return sql
+}
+
+// flush writes the book snapshots and the trades since the last flush in
+// one transaction. Trades are kept while the server is away, up to
+// maxPendingTrades.
+func (s *Sink) flush() error {
+	if s.count > maxPendingTrades {
+		fmt.Println("postgres behind, dropping", s.count, "trades")
+		s.trades.Reset()
+		s.count = 0
+	}
+	if !s.Client.Connected() {
+		return nil
+	}
+	now := time.Now()
+	sql := &strings.Builder{}
+	for _, product := range s.Products {
+		if s.synced[product] {
+			sql.WriteString(s.snapshot(product, now))
+		}
+	}
+	if s.count > 0 {
+		fmt.Fprintf(sql, "INSERT INTO %s (product, time, side, price, size) VALUES\n%s;\n", s.table("trades"), s.trades.String())
+	}
+	if sql.Len() == 0 {
+		return nil
+	}
+	if err := s.Client.Exec(sql.String()); err != nil {
+		return err
+	}
+	s.trades.Reset()
+	s.count = 0
+	return nil
+}
@@ -0,0 +1,469 @@
+package rebroadcast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// The lite stream sends a product aggregated into rows of Ticks price
+// increments, the best Depth rows per side, as binary websocket messages
+// of Interval, small enough to follow a heatmap over a mobile connection.
+// A row is the price divided by the row height, rounded down. Sizes are in
+// lots, rounded, levels smaller than a lot count as one.
+//
+//	snapshot  uint8 1, uvarint unix ms, float64 row height, float64 lot,
+//	          then both sides as levels
+//	delta     uint8 2, uvarint ms since the frame before, the changed
+//	          levels of both sides (0 lots removes the row), then trades
+//
+//	levels    uvarint count, per level zigzag varint row minus the row of
+//	          the level before (the row itself for the first), uvarint lots
+//	trades    uvarint count, per trade uint8 side as stored (0 bid, 1
+//	          ask), zigzag varint row minus the row of the trade before
+//	          (the row itself for the first), uvarint lots
+//
+// Bids come before asks. Snapshots are sent first and again when the
+// client would have to apply a delta with more levels than a snapshot has.
+const (
+	LiteSnapshot = 1
+	LiteDelta    = 2
+)
+
+const (
+	liteMaxDepth    = 1000
+	liteMinInterval = 100 * time.Millisecond
+)
+
+type LiteOptions struct {
+	// price increments per row
+	Ticks int
+	// rows per side
+	Depth int
+	// size unit, 0 picks a tenth of the minimum order size
+	Lot      float64
+	Interval time.Duration
+}
+
+type liteTrade struct {
+	side orderbook.Side
+	row  int64
+	lots uint64
+}
+
+// liteView is what one client was sent of a product, lots by row.
+type liteView struct {
+	info    *product_info.Info
+	options LiteOptions
+	height  float64
+	bids    map[int64]uint64
+	asks    map[int64]uint64
+	trades  []liteTrade
+	last    time.Time
+}
+
+func newLiteView(info *product_info.Info, options LiteOptions) *liteView {
+	tick := float64(info.QuoteIncrement)
+	if tick <= 0 {
+		tick = 0.01
+	}
+	if options.Lot <= 0 {
+		options.Lot = float64(info.BaseMinSize) / 10
+		if options.Lot <= 0 {
+			options.Lot = 1e-8
+		}
+	}
+	return &liteView{info: info, options: options, height: tick * float64(options.Ticks)}
+}
+
+func (v *liteView) row(price float64) int64 {
+	// a hair up so exact multiples don't fall into the row below
+	return int64(math.Floor(price/v.height + 1e-9))
+}
+
+func (v *liteView) lots(size float64) uint64 {
+	lots := uint64(math.Round(size / v.options.Lot))
+	if lots == 0 && size > 0 {
+		return 1
+	}
+	return lots
+}
+
+// aggregate returns the best Depth rows of one side of the book.
+func (v *liteView) aggregate(levels orderbook.BookLevelList, bid bool) map[int64]uint64 {
+	sizes := map[int64]float64{}
+	rows := []int64{}
+	for _, level := range levels {
+		if level.Quantity <= 0 {
+			continue
+		}
+		row := v.row(level.Price)
+		if _, ok := sizes[row]; !ok {
+			rows = append(rows, row)
+		}
+		sizes[row] += level.Quantity
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if bid {
+			return rows[i] > rows[j]
+		}
+		return rows[i] < rows[j]
+	})
+	if len(rows) > v.options.Depth {
+		rows = rows[:v.options.Depth]
+	}
+	view := map[int64]uint64{}
+	for _, row := range rows {
+		view[row] = v.lots(sizes[row])
+	}
+	return view
+}
+
+func (v *liteView) trade(data []byte) {
+	side, price, size := orderbook.UnpackTrade(data)
+	v.trades = append(v.trades, liteTrade{side: side, row: v.row(price), lots: v.lots(size)})
+}
+
+// frame encodes the changes since the last frame, nil when there are none.
+func (v *liteView) frame(book *orderbook.Book, now time.Time) []byte {
+	bids := v.aggregate(book.Bid, true)
+	asks := v.aggregate(book.Ask, false)
+
+	buf := &bytes.Buffer{}
+	if v.bids == nil {
+		buf.WriteByte(LiteSnapshot)
+		putUvarint(buf, uint64(now.UnixNano()/int64(time.Millisecond)))
+		binary.Write(buf, binary.LittleEndian, v.height)
+		binary.Write(buf, binary.LittleEndian, v.options.Lot)
+		putLevels(buf, bids)
+		putLevels(buf, asks)
+	} else {
+		bidChanges, askChanges := liteChanges(v.bids, bids), liteChanges(v.asks, asks)
+		if len(bidChanges)+len(askChanges) > len(bids)+len(asks) {
+			v.bids, v.asks = nil, nil
+			return v.frame(book, now)
+		}
+		if len(bidChanges)+len(askChanges)+len(v.trades) == 0 {
+			return nil
+		}
+		buf.WriteByte(LiteDelta)
+		putUvarint(buf, uint64(now.Sub(v.last)/time.Millisecond))
+		putLevels(buf, bidChanges)
+		putLevels(buf, askChanges)
+		putUvarint(buf, uint64(len(v.trades)))
+		var previous int64
+		for _, trade := range v.trades {
+			buf.WriteByte(byte(trade.side))
+			putVarint(buf, trade.row-previous)
+			putUvarint(buf, trade.lots)
+			previous = trade.row
+		}
+	}
+	v.bids, v.asks = bids, asks
+	v.trades = nil
+	v.last = now
+	return buf.Bytes()
+}
+
+// liteChanges returns the rows of to that differ from from, removed rows
+// with 0 lots.
+func liteChanges(from, to map[int64]uint64) map[int64]uint64 {
+	changes := map[int64]uint64{}
+	for row, lots := range to {
+		if from[row] != lots {
+			changes[row] = lots
+		}
+	}
+	for row := range from {
+		if _, ok := to[row]; !ok {
+			changes[row] = 0
+		}
+	}
+	return changes
+}
+
+func putLevels(buf *bytes.Buffer, levels map[int64]uint64) {
+	rows := make([]int64, 0, len(levels))
+	for row := range levels {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i] < rows[j] })
+	putUvarint(buf, uint64(len(rows)))
+	var previous int64
+	for _, row := range rows {
+		putVarint(buf, row-previous)
+		putUvarint(buf, levels[row])
+		previous = row
+	}
+}
+
+func putUvarint(buf *bytes.Buffer, x uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], x)])
+}
+
+func putVarint(buf *bytes.Buffer, x int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutVarint(tmp[:], x)])
+}
+
+// LiteBook is the client side of the lite stream, Apply decodes frames
+// into it.
+type LiteBook struct {
+	Time time.Time
+	// price per row and size per lot
+	Height float64
+	Lot    float64
+	Bids   map[int64]uint64
+	Asks   map[int64]uint64
+	// the trades of the last frame
+	Trades []LiteTrade
+}
+
+type LiteTrade struct {
+	Side orderbook.Side
+	Row  int64
+	Lots uint64
+}
+
+var errLiteFrame = errors.New("malformed lite frame")
+
+func (b *LiteBook) Apply(frame []byte) error {
+	r := bytes.NewReader(frame)
+	kind, err := r.ReadByte()
+	if err != nil {
+		return errLiteFrame
+	}
+	ms, err := binary.ReadUvarint(r)
+	if err != nil {
+		return errLiteFrame
+	}
+
+	switch kind {
+	case LiteSnapshot:
+		b.Time = time.Unix(0, int64(ms)*int64(time.Millisecond))
+		if binary.Read(r, binary.LittleEndian, &b.Height) != nil || binary.Read(r, binary.LittleEndian, &b.Lot) != nil {
+			return errLiteFrame
+		}
+		b.Bids, b.Asks = map[int64]uint64{}, map[int64]uint64{}
+		if readLevels(r, b.Bids) != nil || readLevels(r, b.Asks) != nil {
+			return errLiteFrame
+		}
+		b.Trades = nil
+		return nil
+	case LiteDelta:
+		if b.Bids == nil {
+			return fmt.Errorf("lite delta before the snapshot")
+		}
+		b.Time = b.Time.Add(time.Duration(ms) * time.Millisecond)
+		if readLevels(r, b.Bids) != nil || readLevels(r, b.Asks) != nil {
+			return errLiteFrame
+		}
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return errLiteFrame
+		}
+		b.Trades = []LiteTrade{}
+		var row int64
+		for i := uint64(0); i < count; i++ {
+			side, err := r.ReadByte()
+			if err != nil {
+				return errLiteFrame
+			}
+			delta, err := binary.ReadVarint(r)
+			if err != nil {
+				return errLiteFrame
+			}
+			lots, err := binary.ReadUvarint(r)
+			if err != nil {
+				return errLiteFrame
+			}
+			row += delta
+			b.Trades = append(b.Trades, LiteTrade{Side: orderbook.Side(side), Row: row, Lots: lots})
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown lite frame %d", kind)
+}
+
+func readLevels(r *bytes.Reader, levels map[int64]uint64) error {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	var row int64
+	for i := uint64(0); i < count; i++ {
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return err
+		}
+		lots, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		row += delta
+		if lots == 0 {
+			delete(levels, row)
+		} else {
+			levels[row] = lots
+		}
+	}
+	return nil
+}
+
+func (s *Server) info(product string) *product_info.Info {
+	for _, info := range s.Infos {
+		if info.DatabaseKey == product {
+			return info
+		}
+	}
+	return nil
+}
+
+func parseLiteOptions(r *http.Request) (LiteOptions, error) {
+	query := r.URL.Query()
+	options := LiteOptions{Ticks: 1, Depth: 100, Interval: 250 * time.Millisecond}
+	var err error
+	if value := query.Get("ticks"); value != "" {
+		if options.Ticks, err = strconv.Atoi(value); err != nil || options.Ticks <= 0 {
+			return options, fmt.Errorf("bad ticks")
+		}
+	}
+	if value := query.Get("depth"); value != "" {
+		if options.Depth, err = strconv.Atoi(value); err != nil || options.Depth <= 0 || options.Depth > liteMaxDepth {
+			return options, fmt.Errorf("bad depth, expected 1 to %d", liteMaxDepth)
+		}
+	}
+	if value := query.Get("lot"); value != "" {
+		if options.Lot, err = strconv.ParseFloat(value, 64); err != nil || options.Lot < 0 {
+			return options, fmt.Errorf("bad lot")
+		}
+	}
+	if value := query.Get("interval"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || time.Duration(ms)*time.Millisecond < liteMinInterval {
+			return options, fmt.Errorf("bad interval, expected at least %d ms", liteMinInterval/time.Millisecond)
+		}
+		options.Interval = time.Duration(ms) * time.Millisecond
+	}
+	return options, nil
+}
+
+func (s *Server) handleLite(w http.ResponseWriter, r *http.Request) {
+	info := s.info(r.URL.Query().Get("product"))
+	if info == nil {
+		http.Error(w, "no such product", http.StatusNotFound)
+		return
+	}
+	options, err := parseLiteOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Println("rebroadcast upgrade", err)
+		return
+	}
+	defer conn.Close()
+	fmt.Println("rebroadcast lite subscriber", r.RemoteAddr, info.DatabaseKey, options.Ticks, "ticks", options.Depth, "rows")
+
+	sub := util.Events.Subscribe(util.ProductTopics(info.DatabaseKey), subscriberQueue)
+	defer util.Events.Unsubscribe(sub)
+
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				util.Events.Unsubscribe(sub)
+				return
+			}
+		}
+	}()
+
+	book, last, synced, err := replayLast(s.DB, info.DatabaseKey)
+	if err != nil {
+		fmt.Println("rebroadcast lite", r.RemoteAddr, err)
+		return
+	}
+	view := newLiteView(info, options)
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case pkt, ok := <-sub.C:
+			if !ok {
+				fmt.Println("rebroadcast lite subscriber gone", r.RemoteAddr)
+				return
+			}
+			if bytes.Compare(pkt.Key, last) <= 0 || len(pkt.Data) == 0 {
+				continue
+			}
+			t := orderbook.UnpackTimeKey(pkt.Key)
+			if pkt.Data[0] == orderbook.TradePacket {
+				view.trade(pkt.Data)
+				book.Process(t, pkt.Data)
+				continue
+			}
+			if orderbook.IsSyncPacket(pkt.Data) {
+				synced = true
+			}
+			// until the next sync after a sequence gap the book is empty
+			if synced && !book.Process(t, pkt.Data) {
+				synced = false
+				book.Clear()
+			}
+		case now := <-ticker.C:
+			frame := view.frame(book, now)
+			if frame == nil {
+				continue
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+				fmt.Println("rebroadcast lite", r.RemoteAddr, err)
+				return
+			}
+		}
+	}
+}
+
+// replayLast returns the book of a product as of the last stored packet,
+// its key and if there was a sync to start from.
+func replayLast(db *bolt.DB, product string) (*orderbook.Book, []byte, bool, error) {
+	book := orderbook.New(product)
+	var last []byte
+	synced := false
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return nil
+		}
+		c := util.NewCursor(db, b)
+		key, buf := c.Last()
+		if key == nil {
+			return nil
+		}
+		last = []byte(string(key))
+		for key != nil && !orderbook.IsSyncPacket(buf) {
+			key, buf = c.Prev()
+		}
+		synced = key != nil
+		for ; key != nil; key, buf = c.Next() {
+			book.Process(orderbook.UnpackTimeKey(key), buf)
+		}
+		return nil
+	})
+	return book, last, synced, err
+}
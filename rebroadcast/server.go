@@ -0,0 +1,325 @@
+package rebroadcast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Message is one stored packet, Key is the database key it was stored with.
+type Message struct {
+	Product string `json:"product"`
+	Key     string `json:"key"`
+	Data    []byte `json:"data"`
+}
+
+// Page is a part of a range, Next resumes the range after it and is empty
+// on the last page.
+type Page struct {
+	Messages []*Message `json:"messages"`
+	Next     string     `json:"next"`
+}
+
+// messages per page of a range, unless asked for less
+var RangePageSize = 5000
+
+const maxRangePageSize = 50000
+
+// packets a subscriber may fall behind before it gets disconnected
+const subscriberQueue = 8192
+
+// Server streams the packets a recorder stores to remote viewers.
+//
+//	GET /products                  recorded product infos as JSON
+//	GET /stream?products=A,B       websocket of Messages, starting at the
+//	                               last stored sync of every product
+//	GET /range?product=A&from=&to= a Page of the Messages stored between
+//	                               the unix nano times from and to,
+//	                               starting with a sync of the book at
+//	                               from, &cursor= with the Next of the
+//	                               page before, &limit= its size
+//	GET /lite?product=A            websocket of binary frames of the book
+//	                               aggregated to &ticks= per row, the best
+//	                               &depth= rows, sizes in &lot= units, every
+//	                               &interval= ms, see LiteSnapshot
+type Server struct {
+	Addr     string
+	DB       *bolt.DB
+	Infos    []*product_info.Info
+	Mux      *http.ServeMux
+	upgrader websocket.Upgrader
+}
+
+func NewServer(addr string, db *bolt.DB, infos []*product_info.Info) *Server {
+	s := &Server{
+		Addr:  addr,
+		DB:    db,
+		Infos: infos,
+		Mux:   http.NewServeMux(),
+		// remote viewers ask for permessage-deflate, packets compress well
+		upgrader: websocket.Upgrader{EnableCompression: true},
+	}
+	s.Mux.HandleFunc("/products", s.handleProducts)
+	s.Mux.HandleFunc("/stream", s.handleStream)
+	s.Mux.HandleFunc("/range", s.handleRange)
+	s.Mux.HandleFunc("/lite", s.handleLite)
+	return s
+}
+
+func (s *Server) Run() {
+	fmt.Println("rebroadcast server listening on", s.Addr)
+	if err := http.ListenAndServe(s.Addr, s.Mux); err != nil {
+		fmt.Println("rebroadcast server", err)
+	}
+}
+
+func (s *Server) handleProducts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Infos)
+}
+
+func (s *Server) products(query string) []string {
+	products := []string{}
+	for _, info := range s.Infos {
+		if query == "" {
+			products = append(products, info.DatabaseKey)
+			continue
+		}
+		for _, name := range strings.Split(query, ",") {
+			if name == info.DatabaseKey {
+				products = append(products, info.DatabaseKey)
+			}
+		}
+	}
+	return products
+}
+
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	products := s.products(r.URL.Query().Get("products"))
+	if len(products) == 0 {
+		http.Error(w, "no such products", http.StatusNotFound)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Println("rebroadcast upgrade", err)
+		return
+	}
+	defer conn.Close()
+	fmt.Println("rebroadcast subscriber", r.RemoteAddr, products)
+
+	// subscribe before reading the database so no flushed batch is missed,
+	// packets already sent from the database are skipped below
+	topics := []string{}
+	for _, product := range products {
+		topics = append(topics, util.ProductTopics(product)...)
+	}
+	sub := util.Events.Subscribe(topics, subscriberQueue)
+	defer util.Events.Unsubscribe(sub)
+
+	go func() {
+		// the client never sends anything, reading detects the disconnect
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				util.Events.Unsubscribe(sub)
+				return
+			}
+		}
+	}()
+
+	sent := map[string][]byte{}
+	for _, product := range products {
+		last, err := s.catchUp(conn, product)
+		if err != nil {
+			fmt.Println("rebroadcast", r.RemoteAddr, err)
+			return
+		}
+		sent[product] = last
+	}
+
+	for pkt := range sub.C {
+		if bytes.Compare(pkt.Key, sent[pkt.Bucket]) <= 0 {
+			continue
+		}
+		if err := conn.WriteJSON(&Message{Product: pkt.Bucket, Key: string(pkt.Key), Data: pkt.Data}); err != nil {
+			fmt.Println("rebroadcast", r.RemoteAddr, err)
+			return
+		}
+	}
+	fmt.Println("rebroadcast subscriber gone", r.RemoteAddr)
+}
+
+// catchUp sends everything since the last stored sync of a product and
+// returns the last sent key.
+func (s *Server) catchUp(conn *websocket.Conn, product string) ([]byte, error) {
+	messages := []*Message{}
+	var last []byte
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return nil
+		}
+		c := util.NewCursor(s.DB, b)
+
+		key, buf := c.Last()
+		if key == nil {
+			return nil
+		}
+		last = []byte(string(key))
+		for key != nil && !orderbook.IsSyncPacket(buf) {
+			key, buf = c.Prev()
+		}
+		if key == nil {
+			key, buf = c.First()
+		}
+		for ; key != nil; key, buf = c.Next() {
+			messages = append(messages, &Message{Product: product, Key: string(key), Data: append([]byte{}, buf...)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range messages {
+		if err := conn.WriteJSON(msg); err != nil {
+			return nil, err
+		}
+	}
+	return last, nil
+}
+
+func (s *Server) handleRange(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	products := s.products(query.Get("product"))
+	if query.Get("product") == "" || len(products) != 1 {
+		http.Error(w, "no such product", http.StatusNotFound)
+		return
+	}
+	from, err := strconv.ParseInt(query.Get("from"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad from", http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.ParseInt(query.Get("to"), 10, 64)
+	if err != nil || to <= from {
+		http.Error(w, "bad to", http.StatusBadRequest)
+		return
+	}
+	limit := RangePageSize
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxRangePageSize {
+			http.Error(w, fmt.Sprintf("bad limit, expected 1 to %d", maxRangePageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.writeRange(w, products[0], from, to, query.Get("cursor"), limit); err != nil {
+		// the page is cut off, the client fails to decode it
+		fmt.Println("rebroadcast range", r.RemoteAddr, err)
+	}
+}
+
+// writeRange writes one page of a range as JSON while reading it, so a
+// page is never held in memory. The first page replays the book up to from
+// and starts with it as a sync keyed from, followed by the packets stored
+// after from and before to. Without a sync before from the range starts at
+// the first sync after it. Later pages continue after cursor.
+func (s *Server) writeRange(w io.Writer, product string, from, to int64, cursor string, limit int) error {
+	start := orderbook.PackUnixNanoKey(from)
+	end := orderbook.PackUnixNanoKey(to)
+	enc := json.NewEncoder(w)
+
+	if _, err := io.WriteString(w, `{"messages":[`); err != nil {
+		return err
+	}
+	sent := 0
+	next := ""
+	write := func(msg *Message) error {
+		if sent > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		sent += 1
+		return enc.Encode(msg)
+	}
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return nil
+		}
+		c := util.NewCursor(s.DB, b)
+
+		var key, buf []byte
+		replay := false
+		if cursor != "" {
+			key, buf = c.Seek([]byte(cursor))
+			if key != nil && string(key) == cursor {
+				key, buf = c.Next()
+			}
+		} else {
+			key, buf = c.Seek(start)
+			if key == nil {
+				key, buf = c.Last()
+			}
+			for key != nil && (bytes.Compare(key, start) > 0 || !orderbook.IsSyncPacket(buf)) {
+				key, buf = c.Prev()
+			}
+			replay = key != nil
+			if !replay {
+				key, buf = c.Seek(start)
+				for key != nil && !orderbook.IsSyncPacket(buf) {
+					key, buf = c.Next()
+				}
+			}
+		}
+
+		book := orderbook.New(product)
+		for ; key != nil && bytes.Compare(key, end) < 0; key, buf = c.Next() {
+			if replay && bytes.Compare(key, start) <= 0 {
+				book.Process(orderbook.UnpackTimeKey(key), buf)
+				continue
+			}
+			if sent >= limit {
+				next = cursor
+				return nil
+			}
+			if replay {
+				replay = false
+				if err := write(&Message{Product: product, Key: string(start), Data: book.PackSync()}); err != nil {
+					return err
+				}
+				cursor = string(start)
+				if sent >= limit {
+					next = cursor
+					return nil
+				}
+			}
+			if err := write(&Message{Product: product, Key: string(key), Data: buf}); err != nil {
+				return err
+			}
+			cursor = string(key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, `],"next":%q}`, next)
+	return err
+}
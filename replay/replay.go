@@ -0,0 +1,128 @@
+// Package replay reads database files written by the recorder, for Go
+// programs embedding it next to the bookmap package.
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/bookmap"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Stop ends Events early without an error when returned by its function.
+var Stop = errors.New("stop replay")
+
+type File struct {
+	db *bolt.DB
+}
+
+// Open opens a database file read only, while a recorder may still write
+// it.
+func Open(path string) (*File, error) {
+	return OpenEncrypted(path, "")
+}
+
+// OpenEncrypted opens a database file recorded with a passphrase.
+func OpenEncrypted(path, passphrase string) (*File, error) {
+	db, err := util.OpenDB(path, []string{}, true)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase != "" {
+		if err := util.EnableEncryption(db, passphrase); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &File{db: db}, nil
+}
+
+func (f *File) Close() error {
+	return f.db.Close()
+}
+
+// Products returns the keys of the recorded products, the buckets which
+// are not kept next to them by the recorder and viewer.
+func (f *File) Products() []string {
+	products := []string{}
+	f.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if !metaBucket(string(name)) {
+				products = append(products, string(name))
+			}
+			return nil
+		})
+	})
+	return products
+}
+
+// buckets kept per product as <prefix><product>
+var metaPrefixes = []string{"Annotations-", "Backfill-", "Bookmarks-", "Episodes-", "Levels-", "Quality-", "Series-", "TradeIndex-"}
+
+func metaBucket(name string) bool {
+	switch name {
+	case util.ProductsBucket, util.UIStateBucket, util.RecordingEndsBucket, util.MaintenanceBucket, util.EncryptionBucket, util.ResumeBucket, util.ExternalEventsBucket:
+		return true
+	}
+	for _, prefix := range metaPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// BookAt returns the best depth levels per side (0 for all) of the book
+// of a product at t.
+func (f *File) BookAt(product string, t time.Time, depth int) (*bookmap.Book, error) {
+	book, last, err := util.BookAt(f.db, product, t)
+	if err != nil {
+		return nil, err
+	}
+	return bookmap.BookOf(product, last, book, depth), nil
+}
+
+// Events calls fn with the packets of a product stored from from until to,
+// starting with the last sync at or before from so the book can be built
+// from the events alone. A zero to reads until the end.
+func (f *File) Events(product string, from, to time.Time, fn func(*bookmap.Event) error) error {
+	err := f.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := util.NewCursor(f.db, b)
+
+		key, buf := c.Seek(orderbook.PackTimeKey(from))
+		if key == nil {
+			key, buf = c.Last()
+		}
+		for key != nil && (!orderbook.IsSyncPacket(buf) || orderbook.UnpackTimeKey(key).After(from)) {
+			key, buf = c.Prev()
+		}
+		if key == nil {
+			key, buf = c.Seek(orderbook.PackTimeKey(from))
+		}
+		for ; key != nil; key, buf = c.Next() {
+			t := orderbook.UnpackTimeKey(key)
+			if !to.IsZero() && !t.Before(to) {
+				break
+			}
+			if ev := bookmap.EventOf(product, t, buf); ev != nil {
+				if err := fn(ev); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == Stop {
+		return nil
+	}
+	return err
+}
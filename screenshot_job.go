@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	opengl_bookmap "github.com/lian/gdax-bookmap/opengl/bookmap"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// ScreenshotJob renders a standard view of the last hours of every product
+// offscreen once a day and writes annotated pngs into Dir/<date>/.
+type ScreenshotJob struct {
+	DB     *bolt.DB
+	Infos  []*product_info.Info
+	Dir    string
+	Hours  int
+	At     string // time of day in UTC, e.g. 00:05
+	Width  int
+	Height int
+}
+
+func NewScreenshotJob(db *bolt.DB, infos []*product_info.Info, dir string) *ScreenshotJob {
+	return &ScreenshotJob{
+		DB:     db,
+		Infos:  infos,
+		Dir:    dir,
+		Hours:  4,
+		At:     "00:05",
+		Width:  1600,
+		Height: 900,
+	}
+}
+
+// next returns the next time the job runs after now.
+func (j *ScreenshotJob) next(now time.Time) (time.Time, error) {
+	return nextDaily(j.At, now)
+}
+
+// nextDaily returns the next time after now at the time of day at (UTC),
+// e.g. 00:05.
+func nextDaily(at string, now time.Time) (time.Time, error) {
+	day, err := time.Parse("15:04", at)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), now.Day(), day.Hour(), day.Minute(), 0, 0, time.UTC)
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func (j *ScreenshotJob) Run() {
+	for {
+		t, err := j.next(time.Now())
+		if err != nil {
+			fmt.Println("screenshot job", err)
+			return
+		}
+		time.Sleep(time.Until(t))
+		j.RunOnce(t)
+	}
+}
+
+// RunOnce renders all products for the hours before end.
+func (j *ScreenshotJob) RunOnce(end time.Time) error {
+	dir := filepath.Join(j.Dir, end.UTC().Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	start := end.Add(-time.Duration(j.Hours) * time.Hour)
+
+	for _, info := range j.Infos {
+		bm := opengl_bookmap.NewHeadless(float64(j.Width), float64(j.Height), *info, j.DB)
+		if !bm.RenderView(start, end) {
+			fmt.Println("screenshot job", info.DatabaseKey, "nothing recorded")
+			continue
+		}
+		bm.Annotate(j.annotation(info, start, end)...)
+
+		path := filepath.Join(dir, fmt.Sprintf("%s-%dh.png", strings.ToLower(info.DatabaseKey), j.Hours))
+		if err := writePNG(path, bm.Image); err != nil {
+			return err
+		}
+		fmt.Println("screenshot job", path)
+	}
+	return nil
+}
+
+func (j *ScreenshotJob) annotation(info *product_info.Info, start, end time.Time) []string {
+	lines := []string{
+		fmt.Sprintf("%s  %s - %s UTC", info.DatabaseKey, start.UTC().Format("2006-01-02 15:04"), end.UTC().Format("15:04")),
+	}
+
+	var count int
+	var volume, high float64
+	low := math.MaxFloat64
+	_, err := util.ScanTrades(j.DB, info.DatabaseKey, util.TradeFilter{From: start, To: end}, func(t time.Time, side orderbook.Side, price, size float64) {
+		count += 1
+		volume += size
+		high = math.Max(high, price)
+		low = math.Min(low, price)
+	})
+	if err != nil || count == 0 {
+		return append(lines, "no trades")
+	}
+	return append(lines, fmt.Sprintf("trades %d  volume %.4f  high %s  low %s", count, volume, info.FormatFloat(high), info.FormatFloat(low)))
+}
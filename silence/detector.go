@@ -0,0 +1,98 @@
+package silence
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Detector bookmarks products which stored no packets for MaxSilence,
+// e.g. a feed that stopped without an error or a stuck writer, and again
+// once they are recorded again. The bookmarks go out as alerts.
+type Detector struct {
+	DB         *bolt.DB
+	Infos      []*product_info.Info
+	MaxSilence time.Duration
+	// how often the products are checked
+	Interval time.Duration
+
+	last   map[string]time.Time
+	silent map[string]bool
+}
+
+func NewDetector(db *bolt.DB, infos []*product_info.Info) *Detector {
+	return &Detector{
+		DB:         db,
+		Infos:      infos,
+		MaxSilence: time.Minute,
+		Interval:   5 * time.Second,
+		last:       map[string]time.Time{},
+		silent:     map[string]bool{},
+	}
+}
+
+func (d *Detector) Run() {
+	topics := []string{}
+	start := time.Now()
+	for _, info := range d.Infos {
+		// a product which never stores anything alerts as well
+		d.last[info.DatabaseKey] = start
+		topics = append(topics, util.ProductTopics(info.DatabaseKey)...)
+	}
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		sub := util.Events.Subscribe(topics, 4096)
+		d.watch(sub, ticker.C)
+		// packets are flowing, nothing was missed that matters here
+		fmt.Println("silence detector fell behind, resubscribing")
+	}
+}
+
+func (d *Detector) watch(sub *util.Subscription, tick <-chan time.Time) {
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			d.stored(ev.Bucket, time.Now())
+		case now := <-tick:
+			d.check(now)
+		}
+	}
+}
+
+func (d *Detector) stored(product string, now time.Time) {
+	last, ok := d.last[product]
+	if !ok {
+		return
+	}
+	if d.silent[product] {
+		d.silent[product] = false
+		d.bookmark(product, now, i18n.Sprintf("recording resumed after %s", now.Sub(last).Round(time.Second)))
+	}
+	d.last[product] = now
+}
+
+func (d *Detector) check(now time.Time) {
+	for product, last := range d.last {
+		if d.silent[product] || now.Sub(last) < d.MaxSilence {
+			continue
+		}
+		d.silent[product] = true
+		d.bookmark(product, now, i18n.Sprintf("no packets stored for %s", now.Sub(last).Round(time.Second)))
+	}
+}
+
+func (d *Detector) bookmark(product string, t time.Time, label string) {
+	fmt.Println("silence detector", product, label)
+	if err := util.AddBookmark(d.DB, product, t, label); err != nil {
+		fmt.Println("silence bookmark", err)
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// FastResume is the longest restart the stored book is continued over.
+// The first sync after such a restart is stored as a bridged gap and the
+// diff to the new book, when that is smaller than the sync. 0 always
+// starts over with the sync.
+var FastResume = 5 * time.Minute
+
+// writers by bucket, for SaveResumeStates
+var resumable = struct {
+	sync.Mutex
+	writers map[string]*BookWriter
+}{writers: map[string]*BookWriter{}}
+
+// resumePoint is the book stored before the restart.
+type resumePoint struct {
+	Time     time.Time
+	Sequence uint64
+	Sync     []byte
+}
+
+// loadResumePoint returns the book stored at the last shutdown when
+// nothing was stored since and it still hashes the same, nil otherwise.
+func loadResumePoint(db *bolt.DB, bucket string) *resumePoint {
+	if FastResume <= 0 {
+		return nil
+	}
+	state := util.LoadResumeState(db, bucket)
+	if state == nil || time.Since(state.Time) > FastResume {
+		return nil
+	}
+	book, last, err := util.BookAt(db, bucket, time.Now())
+	if err != nil || last.UnixNano() != state.Key || book.Sequence != state.Sequence || book.Hash() != state.Hash {
+		return nil
+	}
+	return &resumePoint{Time: last, Sequence: state.Sequence, Sync: book.PackSync()}
+}
+
+// bridge returns the bridged gap and diff to store instead of the sync
+// packet buf, nil when the sync has to be stored.
+func (r *resumePoint) bridge(now time.Time, buf []byte) [][]byte {
+	if len(buf) == 0 || buf[0] != orderbook.SyncPacket || now.Sub(r.Time) > FastResume {
+		return nil
+	}
+	sequence, _, _ := orderbook.UnpackSync(buf)
+	if sequence == 0 {
+		return nil
+	}
+	diff := orderbook.PackBridgeDiff(r.Sync, buf)
+	if len(diff) >= len(buf) {
+		return nil
+	}
+	return [][]byte{orderbook.PackBridgedGap(r.Time, now, sequence-1), diff}
+}
+
+// SaveResumeStates stores where every book stands, for the fast resume of
+// the next start. Packets not flushed yet are not part of it and get lost
+// on exit like before.
+func SaveResumeStates() {
+	if FastResume <= 0 {
+		return
+	}
+	resumable.Lock()
+	defer resumable.Unlock()
+	now := time.Now()
+	for bucket, w := range resumable.writers {
+		book, last, err := util.BookAt(w.DB, bucket, now)
+		if err != nil || book.Sequence == 0 {
+			continue
+		}
+		state := &util.ResumeState{Key: last.UnixNano(), Time: now, Sequence: book.Sequence, Hash: book.Hash()}
+		if err := util.SaveResumeState(w.DB, bucket, state); err != nil {
+			fmt.Println("SaveResumeState Error", bucket, err)
+		}
+	}
+}
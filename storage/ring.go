@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// keys deleted per transaction
+const trimBatchSize = 10000
+
+// Ring keeps only the last Window of the recordings of some books, older
+// packets are deleted up to the last sync before the window so the history
+// still starts with a full book. The pages freed are reused by bolt, so the
+// database stops growing once it held one window.
+type Ring struct {
+	DB      *bolt.DB
+	Buckets []string
+	Window  time.Duration
+	// how often old packets are deleted
+	Interval time.Duration
+}
+
+func NewRing(db *bolt.DB, buckets []string, window time.Duration) *Ring {
+	return &Ring{
+		DB:       db,
+		Buckets:  buckets,
+		Window:   window,
+		Interval: time.Minute,
+	}
+}
+
+func (r *Ring) Run() {
+	for now := range time.Tick(r.Interval) {
+		for _, bucket := range r.Buckets {
+			if _, err := r.Trim(bucket, now); err != nil {
+				fmt.Println("ring", bucket, err)
+			}
+		}
+	}
+}
+
+// Trim deletes the packets of bucket before the last sync older than the
+// window and returns how many were deleted. Sync deltas are not expected,
+// their keyframes would be deleted with the packets.
+func (r *Ring) Trim(bucket string, now time.Time) (int, error) {
+	cutoff := orderbook.PackTimeKey(now.Add(-r.Window))
+	var first []byte
+	err := r.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for key, value := c.First(); key != nil && bytes.Compare(key, cutoff) < 0; key, value = c.Next() {
+			plain, err := util.Unseal(r.DB, value)
+			if err != nil {
+				return err
+			}
+			if orderbook.IsSyncPacket(plain) {
+				first = []byte(string(key))
+			}
+		}
+		return nil
+	})
+	if err != nil || first == nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for {
+		n := 0
+		err := r.DB.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(bucket))
+			c := b.Cursor()
+			for key, _ := c.First(); key != nil && bytes.Compare(key, first) < 0 && n < trimBatchSize; key, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				n += 1
+			}
+			index := tx.Bucket([]byte(util.TradeIndexBucket(bucket)))
+			if index == nil {
+				return nil
+			}
+			// entries are keyed by the first packet of their batch, the
+			// last one starting before the kept history may reach into it
+			stale := [][]byte{}
+			ic := index.Cursor()
+			for key, _ := ic.First(); key != nil && bytes.Compare(key, first) < 0; key, _ = ic.Next() {
+				stale = append(stale, []byte(string(key)))
+			}
+			for i := 0; i < len(stale)-1; i++ {
+				if err := index.Delete(stale[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		if n < trimBatchSize {
+			return deleted, nil
+		}
+	}
+}
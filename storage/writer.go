@@ -0,0 +1,379 @@
+// Package storage batches the packets of the exchange clients into the
+// database. A BookWriter per book decides when a diff or a full sync is
+// due, holds the packets back while the book warms up and stores them in
+// batches, which are published on the bus of the database (util.BusOf)
+// once they are committed.
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Chunk is one packet waiting to be stored.
+type Chunk struct {
+	Time time.Time
+	Data []byte
+	// stored instead of Data when set, e.g. a delta sync
+	Stored []byte
+}
+
+// bounds of the adaptive diff interval, equal bounds disable adapting
+var MinDiffInterval = 250 * time.Millisecond
+var MaxDiffInterval = 5 * time.Second
+
+const (
+	diffRateWindow      = 5 * time.Second
+	highMessageRate     = 50.0   // messages per second
+	lowMessageRate      = 5.0    // messages per second
+	highPriceVelocity   = 0.0005 // relative price change per second
+	defaultDiffInterval = 1 * time.Second
+)
+
+// WarmUp delays storing a book until it was synced with a sane spread for
+// this long, so the churn of connecting at startup stays out of the
+// recording. 0 stores right away.
+var WarmUp time.Duration
+
+// spreads wider than this share of the bid are not sane
+const warmUpMaxSpread = 0.05
+
+// ChunkWriter takes the packets of one book in the order they happened.
+type ChunkWriter interface {
+	Write(now time.Time, data []byte)
+}
+
+// Flusher commits what was written so far.
+type Flusher interface {
+	Flush() error
+}
+
+// BestPricer is the book of any exchange client.
+type BestPricer interface {
+	BestPrices() (float64, float64)
+}
+
+// unix nano of the last stored batch, across all books
+var lastWrite int64
+
+// LastWrite tells when any book last stored data.
+func LastWrite() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&lastWrite))
+}
+
+// batches are committed this often
+const defaultFlushInterval = 500 * time.Millisecond
+
+// BookWriter stores the packets of one book into its bucket. Written
+// packets are committed in one transaction every FlushInterval, with the
+// write that finds the interval passed, or by an explicit Flush, e.g. when
+// the connection is lost. Nothing is written before the book warmed up.
+type BookWriter struct {
+	DB     *bolt.DB
+	Bucket string
+	// sequence the next stored diff starts at, set after every stored
+	// diff or sync
+	LastDiffSeq   uint64
+	Count         int
+	FlushInterval time.Duration
+	DiffInterval  time.Duration
+	MinInterval   time.Duration
+	MaxInterval   time.Duration
+
+	batch        []*Chunk
+	bookmarks    []*util.Bookmark
+	flushed      time.Time
+	lastDiff     time.Time
+	messages     int
+	windowStart  time.Time
+	windowPrice  float64
+	lastPrice    float64
+	syncs        int
+	keyframe     []byte
+	keyframeNano int64
+	warm         bool
+	stableSince  time.Time
+	syncDue      bool
+	resume       *resumePoint
+}
+
+// NewBookWriter writes into bucket of db, which has to exist.
+func NewBookWriter(db *bolt.DB, bucket string) *BookWriter {
+	interval := defaultDiffInterval
+	if interval < MinDiffInterval {
+		interval = MinDiffInterval
+	}
+	if interval > MaxDiffInterval {
+		interval = MaxDiffInterval
+	}
+	p := &BookWriter{
+		DB:            db,
+		Bucket:        bucket,
+		batch:         []*Chunk{},
+		FlushInterval: defaultFlushInterval,
+		DiffInterval:  interval,
+		MinInterval:   MinDiffInterval,
+		MaxInterval:   MaxDiffInterval,
+		warm:          WarmUp <= 0,
+	}
+	if db != nil {
+		p.resume = loadResumePoint(db, bucket)
+		resumable.Lock()
+		resumable.writers[bucket] = p
+		resumable.Unlock()
+	}
+	return p
+}
+
+// WarmedUp tells whether the book is stored yet, it has to be called on
+// every message. Nothing is written while warming up, the next sync is
+// stored right after.
+func (p *BookWriter) WarmedUp(now time.Time, book BestPricer) bool {
+	if p.warm {
+		return true
+	}
+	bid, ask := book.BestPrices()
+	if bid <= 0 || ask <= bid || (ask-bid)/bid > warmUpMaxSpread {
+		p.stableSince = time.Time{}
+		return false
+	}
+	if p.stableSince.IsZero() {
+		p.stableSince = now
+	}
+	if now.Sub(p.stableSince) < WarmUp {
+		return false
+	}
+	p.warm = true
+	p.syncDue = true
+	return true
+}
+
+// ResetWarmUp restarts the warm up after a gap, does nothing once the book
+// is stored.
+func (p *BookWriter) ResetWarmUp() {
+	p.stableSince = time.Time{}
+}
+
+func (p *BookWriter) NextSync(now time.Time) bool {
+	if p.syncDue {
+		p.syncDue = false
+		return true
+	}
+	return math.Mod(float64(p.Count), 600) == 0
+}
+
+func (p *BookWriter) NextDiff(now time.Time) bool {
+	p.messages += 1
+	p.adaptDiffInterval(now)
+
+	interval := p.DiffInterval
+	if interval == 0 {
+		interval = defaultDiffInterval
+	}
+	if now.Sub(p.lastDiff) >= interval {
+		p.lastDiff = now
+		return true
+	}
+	return false
+}
+
+// TrackPrice feeds the last traded price into the volatility estimate.
+func (p *BookWriter) TrackPrice(price float64) {
+	p.lastPrice = price
+	if p.windowPrice == 0 {
+		p.windowPrice = price
+	}
+}
+
+// adaptDiffInterval halves the diff interval while the market is busy and
+// doubles it while it is quiet, within MinInterval and MaxInterval.
+func (p *BookWriter) adaptDiffInterval(now time.Time) {
+	if p.MinInterval == p.MaxInterval {
+		return
+	}
+	if p.windowStart.IsZero() {
+		p.windowStart = now
+		return
+	}
+
+	elapsed := now.Sub(p.windowStart)
+	if elapsed < diffRateWindow {
+		return
+	}
+
+	rate := float64(p.messages) / elapsed.Seconds()
+	var velocity float64
+	if p.windowPrice != 0 {
+		velocity = math.Abs(p.lastPrice-p.windowPrice) / p.windowPrice / elapsed.Seconds()
+	}
+
+	if rate >= highMessageRate || velocity >= highPriceVelocity {
+		p.DiffInterval = p.DiffInterval / 2
+	} else if rate <= lowMessageRate && velocity < highPriceVelocity/4 {
+		p.DiffInterval = p.DiffInterval * 2
+	}
+	if p.DiffInterval < p.MinInterval {
+		p.DiffInterval = p.MinInterval
+	}
+	if p.DiffInterval > p.MaxInterval {
+		p.DiffInterval = p.MaxInterval
+	}
+
+	p.messages = 0
+	p.windowStart = now
+	p.windowPrice = p.lastPrice
+}
+
+// Write adds a packet to the batch and commits the batch when
+// FlushInterval passed since the last commit. The first packet after a
+// restart within FastResume may be replaced by a bridge to the book stored
+// before it.
+func (p *BookWriter) Write(now time.Time, buf []byte) {
+	if !p.warm {
+		return
+	}
+	p.Count += 1
+	var bridge [][]byte
+	if p.resume != nil {
+		bridge = p.resume.bridge(now, buf)
+		p.resume = nil
+	}
+	for _, data := range bridge {
+		p.batch = append(p.batch, &Chunk{Time: now, Data: data})
+	}
+	if bridge == nil {
+		p.batch = append(p.batch, &Chunk{Time: now, Data: buf, Stored: p.compressSync(now, buf)})
+	}
+	atomic.StoreInt64(&lastWrite, now.UnixNano())
+
+	if now.Sub(p.flushed) >= p.FlushInterval {
+		p.flushed = now
+		if err := p.Flush(); err != nil {
+			fmt.Println("HandleMessage DB Error", err)
+		}
+	}
+}
+
+// Bookmark adds a bookmark of the product to the batch, stored with the
+// packets instead of a transaction of its own, e.g. for large trades while
+// the book is busy. Dropped while the book warms up.
+func (p *BookWriter) Bookmark(now time.Time, label string) {
+	if !p.warm {
+		return
+	}
+	p.bookmarks = append(p.bookmarks, &util.Bookmark{Time: now, Label: label})
+}
+
+// Pending is the number of written packets not committed yet.
+func (p *BookWriter) Pending() int {
+	return len(p.batch)
+}
+
+// Flush commits the written packets now and publishes them. Packets which
+// fail to store are dropped with the error.
+func (p *BookWriter) Flush() error {
+	if len(p.batch) == 0 && len(p.bookmarks) == 0 || p.DB == nil {
+		return nil
+	}
+	bus := util.BusOf(p.DB)
+	var published []*util.Event
+	if bus.HasSubscribers() {
+		published = make([]*util.Event, 0, len(p.batch)+len(p.bookmarks))
+	}
+	err := p.DB.Update(func(tx *bolt.Tx) error {
+		var err error
+		var key []byte
+		b := tx.Bucket([]byte(p.Bucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", p.Bucket)
+		}
+		b.FillPercent = 0.9
+		index := &util.TradeIndexEntry{}
+		for _, chunk := range p.batch {
+			nano := chunk.Time.UnixNano()
+			// windows system clock resolution https://github.com/golang/go/issues/8687
+			for {
+				key = orderbook.PackUnixNanoKey(nano)
+				if b.Get(key) == nil {
+					break
+				} else {
+					nano += 1
+				}
+			}
+			data := chunk.Data
+			if chunk.Stored != nil {
+				data = chunk.Stored
+			}
+			err = b.Put(key, util.Seal(p.DB, data))
+			if err != nil {
+				fmt.Println("HandleMessage DB Error", err)
+				continue
+			}
+			index.Add(nano, chunk.Data)
+			if published != nil {
+				published = append(published, &util.Event{Topic: util.PacketTopic(p.Bucket, chunk.Data), Bucket: p.Bucket, Key: key, Data: chunk.Data})
+			}
+		}
+		if err := util.PutTradeIndex(tx, p.DB, p.Bucket, index); err != nil {
+			fmt.Println("HandleMessage DB Error", err)
+		}
+		if len(p.bookmarks) > 0 {
+			bucket := util.BookmarksBucket(p.Bucket)
+			bookmarks, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return fmt.Errorf("create bucket: %s %s", bucket, err)
+			}
+			for _, bookmark := range p.bookmarks {
+				key := orderbook.PackTimeKey(bookmark.Time)
+				if err := bookmarks.Put(key, []byte(bookmark.Label)); err != nil {
+					return err
+				}
+				if published != nil {
+					published = append(published, &util.Event{Topic: util.AlertTopic(p.Bucket), Bucket: bucket, Key: key, Data: []byte(bookmark.Label)})
+				}
+			}
+		}
+		return err
+	})
+	if len(published) > 0 {
+		bus.Publish(published)
+	}
+	p.batch = []*Chunk{}
+	p.bookmarks = nil
+	return err
+}
+
+// FlushAll commits the batches of all writers of a client.
+func FlushAll(writers map[string]*BookWriter) {
+	for _, w := range writers {
+		if err := w.Flush(); err != nil {
+			fmt.Println("HandleMessage DB Error", err)
+		}
+	}
+}
+
+// SyncKeyframes stores every n-th sync as a full book and the others as
+// the changes against it, 0 stores all syncs in full.
+var SyncKeyframes = 0
+
+// compressSync returns the delta to store instead of buf for syncs between
+// two keyframes, or nil when buf is stored as it is.
+func (p *BookWriter) compressSync(now time.Time, buf []byte) []byte {
+	if SyncKeyframes <= 1 || len(buf) == 0 || buf[0] != orderbook.SyncPacket {
+		return nil
+	}
+	p.syncs += 1
+	if p.keyframe == nil || p.syncs >= SyncKeyframes {
+		p.keyframe = buf
+		p.keyframeNano = now.UnixNano()
+		p.syncs = 0
+		return nil
+	}
+	return orderbook.PackSyncDelta(p.keyframe, p.keyframeNano, buf)
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/exchanges/common/orderbook"
+	db_orderbook "github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+var _ ChunkWriter = (*BookWriter)(nil)
+var _ Flusher = (*BookWriter)(nil)
+
+const testBucket = "Test-BTC-USD"
+
+// openTestDB opens a database with the test bucket in a temporary
+// directory, removed by the returned func.
+func openTestDB(t *testing.T) (*bolt.DB, func()) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	util.CreateBucketsDB(db, []string{testBucket})
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// stored returns the packets of the test bucket in key order.
+func stored(t *testing.T, db *bolt.DB) [][]byte {
+	packets := [][]byte{}
+	err := db.View(func(tx *bolt.Tx) error {
+		c := util.NewCursor(db, tx.Bucket([]byte(testBucket)))
+		for key, buf := c.First(); key != nil; key, buf = c.Next() {
+			packets = append(packets, buf)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packets
+}
+
+func testDiff(first, last uint64, price float64) []byte {
+	diff := &orderbook.BookLevelDiff{Bid: []*orderbook.LevelDiff{{Price: price, Size: 1}}}
+	return orderbook.PackDiff(first, last, diff)
+}
+
+func TestWriteFlushesEveryInterval(t *testing.T) {
+	db, done := openTestDB(t)
+	defer done()
+	w := NewBookWriter(db, testBucket)
+	w.FlushInterval = time.Minute
+
+	now := time.Unix(1500000000, 0)
+	// the first write finds the interval passed
+	w.Write(now, testDiff(1, 1, 100))
+	if w.Pending() != 0 || len(stored(t, db)) != 1 {
+		t.Fatalf("first write: pending %d stored %d, want 0 and 1", w.Pending(), len(stored(t, db)))
+	}
+
+	w.Write(now.Add(time.Second), testDiff(2, 2, 101))
+	w.Write(now.Add(2*time.Second), testDiff(3, 3, 102))
+	if w.Pending() != 2 || len(stored(t, db)) != 1 {
+		t.Fatalf("within interval: pending %d stored %d, want 2 and 1", w.Pending(), len(stored(t, db)))
+	}
+
+	w.Write(now.Add(time.Minute), testDiff(4, 4, 103))
+	if w.Pending() != 0 || len(stored(t, db)) != 4 {
+		t.Fatalf("after interval: pending %d stored %d, want 0 and 4", w.Pending(), len(stored(t, db)))
+	}
+	if w.Count != 4 {
+		t.Fatalf("count %d, want 4", w.Count)
+	}
+}
+
+func TestFlushKeepsWriteOrder(t *testing.T) {
+	db, done := openTestDB(t)
+	defer done()
+	w := NewBookWriter(db, testBucket)
+	w.FlushInterval = time.Hour
+
+	// same time for all, the keys are made unique in write order
+	now := time.Unix(1500000000, 0)
+	written := [][]byte{}
+	w.Write(now.Add(-time.Hour), testDiff(1, 1, 99))
+	written = append(written, testDiff(1, 1, 99))
+	for i := uint64(2); i < 7; i++ {
+		pkt := testDiff(i, i, 100+float64(i))
+		w.Write(now, pkt)
+		written = append(written, pkt)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Pending() != 0 {
+		t.Fatalf("pending %d after Flush", w.Pending())
+	}
+
+	packets := stored(t, db)
+	if len(packets) != len(written) {
+		t.Fatalf("stored %d packets, want %d", len(packets), len(written))
+	}
+	for i := range written {
+		if !bytes.Equal(packets[i], written[i]) {
+			t.Fatalf("packet %d out of order", i)
+		}
+	}
+
+	// nothing written, nothing stored
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored(t, db)) != len(written) {
+		t.Fatal("empty Flush stored packets")
+	}
+}
+
+func TestLastDiffSeqHandoff(t *testing.T) {
+	db, done := openTestDB(t)
+	defer done()
+	w := NewBookWriter(db, testBucket)
+
+	book := orderbook.New("BTC-USD")
+	book.Sequence = 10
+	book.Bid = []*orderbook.BookLevel{{Price: 99, Size: 1}}
+	book.Ask = []*orderbook.BookLevel{{Price: 101, Size: 1}}
+
+	now := time.Unix(1500000000, 0)
+	// how the clients store a sync and the diffs after it
+	w.Write(now, orderbook.PackSync(book))
+	w.LastDiffSeq = book.Sequence + 1
+	for i := 1; i <= 3; i++ {
+		book.Sequence += 5
+		w.Write(now.Add(time.Duration(i)*time.Second), testDiff(w.LastDiffSeq, book.Sequence, 99-float64(i)))
+		w.LastDiffSeq = book.Sequence + 1
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := uint64(11)
+	for _, buf := range stored(t, db)[1:] {
+		first, last, _, _ := db_orderbook.UnpackDiff(buf)
+		if first != expected {
+			t.Fatalf("diff starts at %d, want %d", first, expected)
+		}
+		expected = last + 1
+	}
+
+	report, err := util.ValidateProduct(db, testBucket, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK || report.Diffs != 3 {
+		t.Fatalf("validation %+v", report.IssueCounts)
+	}
+}
+
+func TestBookmarkStoredWithBatch(t *testing.T) {
+	db, done := openTestDB(t)
+	defer done()
+	w := NewBookWriter(db, testBucket)
+	w.FlushInterval = time.Hour
+
+	now := time.Unix(1500000000, 0)
+	w.Write(now.Add(-time.Hour), testDiff(1, 1, 100))
+	w.Write(now, testDiff(2, 2, 101))
+	w.Bookmark(now, "trade 10 @ 101")
+	if bookmarks := util.ListBookmarks(db, testBucket); len(bookmarks) != 0 {
+		t.Fatalf("%d bookmarks before Flush", len(bookmarks))
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	bookmarks := util.ListBookmarks(db, testBucket)
+	if len(bookmarks) != 1 || bookmarks[0].Label != "trade 10 @ 101" || !bookmarks[0].Time.Equal(now) {
+		t.Fatalf("bookmarks %+v", bookmarks)
+	}
+}
+
+func TestFlushPublishesOnBusOfDB(t *testing.T) {
+	db, done := openTestDB(t)
+	defer done()
+	bus := util.NewBus()
+	util.SetBus(db, bus)
+	defer util.SetBus(db, nil)
+	own := bus.Subscribe(util.ProductTopics(testBucket), 16)
+	global := util.Events.Subscribe(util.ProductTopics(testBucket), 16)
+	defer util.Events.Unsubscribe(global)
+
+	w := NewBookWriter(db, testBucket)
+	w.Write(time.Unix(1500000000, 0), testDiff(1, 1, 100))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(own.C) != 1 {
+		t.Fatalf("%d events on the bus of the db", len(own.C))
+	}
+	if len(global.C) != 0 {
+		t.Fatalf("%d events on util.Events", len(global.C))
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtime_pprof "runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+)
+
+// LogRing keeps the last lines written to stdout and stderr, which is where
+// the recorder and viewer log to.
+type LogRing struct {
+	mu    sync.Mutex
+	lines []string
+	limit int
+}
+
+// CaptureOutput replaces os.Stdout and os.Stderr with pipes copied to the
+// originals and the ring, the standard logger writes to the new stderr.
+func CaptureOutput(limit int) (*LogRing, error) {
+	ring := &LogRing{limit: limit}
+	for _, file := range []**os.File{&os.Stdout, &os.Stderr} {
+		if err := ring.capture(file); err != nil {
+			return nil, err
+		}
+	}
+	log.SetOutput(os.Stderr)
+	return ring, nil
+}
+
+func (l *LogRing) capture(file **os.File) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	original := *file
+	*file = w
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			fmt.Fprintln(original, line)
+			l.add(time.Now().Format("2006-01-02T15:04:05.000 ") + line)
+		}
+	}()
+	return nil
+}
+
+func (l *LogRing) add(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, line)
+	if len(l.lines) > l.limit {
+		l.lines = l.lines[len(l.lines)-l.limit:]
+	}
+}
+
+func (l *LogRing) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.lines...)
+}
+
+// flags and environment variables whose values are never written
+var secretWords = []string{"key", "secret", "pass", "token"}
+
+var supportEnv = []string{
+	"BINANCE_API_KEY",
+	"BINANCE_TESTNET_API_KEY",
+	"DERIBIT_CLIENT_ID",
+	"DERIBIT_CLIENT_SECRET",
+	"BOOKMAP_PASSPHRASE",
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+}
+
+func redact(name, value string) string {
+	for _, word := range secretWords {
+		if value != "" && strings.Contains(strings.ToLower(name), word) {
+			return "[redacted]"
+		}
+	}
+	// credentials in urls, e.g. -postgres or -mqtt
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+// SupportConfig is the configuration written into support bundles.
+type SupportConfig struct {
+	Version string            `json:"version"`
+	GitHash string            `json:"git_hash"`
+	Go      string            `json:"go"`
+	OS      string            `json:"os"`
+	Args    []string          `json:"args"`
+	Flags   map[string]string `json:"flags"`
+	// only whether they are set
+	Env map[string]bool `json:"env"`
+}
+
+func supportConfig() *SupportConfig {
+	config := &SupportConfig{
+		Version: AppVersion,
+		GitHash: AppGitHash,
+		Go:      runtime.Version(),
+		OS:      runtime.GOOS + "/" + runtime.GOARCH,
+		Args:    []string{},
+		Flags:   map[string]string{},
+		Env:     map[string]bool{},
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		config.Flags[f.Name] = redact(f.Name, f.Value.String())
+	})
+	// the values are in Flags already, redacted
+	for _, arg := range os.Args[1:] {
+		if strings.HasPrefix(arg, "-") {
+			config.Args = append(config.Args, strings.SplitN(arg, "=", 2)[0])
+		}
+	}
+	for _, name := range supportEnv {
+		config.Env[name] = os.Getenv(name) != ""
+	}
+	return config
+}
+
+// SupportStats are the feed and runtime numbers of a support bundle.
+type SupportStats struct {
+	Time       time.Time                `json:"time"`
+	Uptime     string                   `json:"uptime"`
+	Goroutines int                      `json:"goroutines"`
+	HeapMB     uint64                   `json:"heap_mb"`
+	GCs        uint32                   `json:"gcs"`
+	Bandwidth  []*common.BandwidthStats `json:"bandwidth"`
+}
+
+var started = time.Now()
+
+// Support writes support bundles: zip files with the recent log, the
+// redacted configuration, a goroutine dump, feed statistics and the last
+// messages received from every platform.
+type Support struct {
+	Dir string
+	Log *LogRing
+
+	mu        sync.Mutex
+	panicOnce sync.Once
+}
+
+// WriteBundle writes a bundle into Dir, reason is added to its name, e.g.
+// panic or request.
+func (s *Support) WriteBundle(reason string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := filepath.Join(s.Dir, fmt.Sprintf("bookmap-support-%s-%s.zip", reason, time.Now().Format("20060102-150405")))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	z := zip.NewWriter(file)
+
+	add := func(name string, fn func(w io.Writer) error) error {
+		w, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		return fn(w)
+	}
+	addJSON := func(name string, v interface{}) error {
+		return add(name, func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(v)
+		})
+	}
+
+	if s.Log != nil {
+		err = add("log.txt", func(w io.Writer) error {
+			for _, line := range s.Log.Lines() {
+				if _, err := fmt.Fprintln(w, line); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := addJSON("config.json", supportConfig()); err != nil {
+		return "", err
+	}
+	err = add("goroutines.txt", func(w io.Writer) error {
+		return runtime_pprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := &SupportStats{
+		Time:       time.Now(),
+		Uptime:     time.Since(started).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapMB:     mem.HeapAlloc >> 20,
+		GCs:        mem.NumGC,
+		Bandwidth:  common.Meter.Stats(),
+	}
+	if err := addJSON("stats.json", stats); err != nil {
+		return "", err
+	}
+
+	messages := common.RecentMessages()
+	platforms := []string{}
+	for platform := range messages {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	for _, platform := range platforms {
+		err = add("messages/"+strings.ToLower(platform)+".jsonl", func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			for _, msg := range messages[platform] {
+				if err := enc.Encode(msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := z.Close(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// OnPanic writes a bundle when the calling goroutine panics and panics
+// again, deferred by main. The other goroutines go through util.Recover.
+func (s *Support) OnPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	s.Panicked(r)
+	panic(r)
+}
+
+// Panicked writes a bundle for a panic, set as util.OnPanic by main. Only
+// the first panic writes one, the process ends with it.
+func (s *Support) Panicked(r interface{}) {
+	s.panicOnce.Do(func() { s.writePanic(r) })
+}
+
+func (s *Support) writePanic(r interface{}) {
+	fmt.Println("panic:", r)
+	if path, err := s.WriteBundle("panic"); err != nil {
+		fmt.Println("support bundle", err)
+	} else {
+		fmt.Println("support bundle written to", path)
+	}
+}
@@ -0,0 +1,99 @@
+package sweeps
+
+import (
+	"time"
+)
+
+const candleInterval = time.Minute
+
+// swings older than this are no longer where stops are expected
+const swingMaxAge = 24 * time.Hour
+
+// swing highs/lows kept per side
+const maxSwings = 20
+
+type candle struct {
+	Start time.Time
+	High  float64
+	Low   float64
+}
+
+type swing struct {
+	Time  time.Time
+	Price float64
+}
+
+// candles builds 1 minute candles from the trades and keeps the swing highs
+// and lows not taken out yet.
+type candles struct {
+	bars  []*candle
+	highs []*swing
+	lows  []*swing
+}
+
+func (c *candles) add(t time.Time, price float64, swingBars int) {
+	start := t.Truncate(candleInterval)
+	if n := len(c.bars); n > 0 && c.bars[n-1].Start.Equal(start) {
+		bar := c.bars[n-1]
+		if price > bar.High {
+			bar.High = price
+		}
+		if price < bar.Low {
+			bar.Low = price
+		}
+		return
+	}
+
+	// a candle closed, the one swingBars before it has its neighbours now
+	c.findSwing(swingBars)
+	c.bars = append(c.bars, &candle{Start: start, High: price, Low: price})
+	if len(c.bars) > 2*swingBars+1 {
+		c.bars = c.bars[len(c.bars)-(2*swingBars+1):]
+	}
+	c.highs = recentSwings(c.highs, t)
+	c.lows = recentSwings(c.lows, t)
+}
+
+func (c *candles) findSwing(swingBars int) {
+	if len(c.bars) < 2*swingBars+1 {
+		return
+	}
+	i := len(c.bars) - 1 - swingBars
+	high, low := true, true
+	for j := i - swingBars; j <= i+swingBars; j += 1 {
+		if j == i {
+			continue
+		}
+		high = high && c.bars[j].High < c.bars[i].High
+		low = low && c.bars[j].Low > c.bars[i].Low
+	}
+	if high {
+		c.highs = append(c.highs, &swing{Time: c.bars[i].Start, Price: c.bars[i].High})
+	}
+	if low {
+		c.lows = append(c.lows, &swing{Time: c.bars[i].Start, Price: c.bars[i].Low})
+	}
+}
+
+func recentSwings(swings []*swing, now time.Time) []*swing {
+	for len(swings) > 0 && (len(swings) > maxSwings || now.Sub(swings[0].Time) > swingMaxAge) {
+		swings = swings[1:]
+	}
+	return swings
+}
+
+// takeSwings removes and returns the swings traded through between low and
+// high, their stops were run.
+func (c *candles) takeSwings(swings *[]*swing, low, high float64) []float64 {
+	taken := []float64{}
+	kept := []*swing{}
+	for _, s := range *swings {
+		if s.Price > low && s.Price < high {
+			taken = append(taken, s.Price)
+		} else {
+			kept = append(kept, s)
+		}
+	}
+	*swings = kept
+	return taken
+}
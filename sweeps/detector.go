@@ -0,0 +1,224 @@
+package sweeps
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Detector replays the packets stored by the recorder and bookmarks
+//
+//	trade-throughs  trades of one side that take out at least MinLevels
+//	                levels which rested for MinRest, each trade within
+//	                Window of the previous one
+//	stop runs       trade-throughs crossing a round number or a swing
+//	                high/low of the 1 minute candles, where stops rest
+//
+// The bookmarks show up on the heatmap and go out as alerts.
+type Detector struct {
+	DB        *bolt.DB
+	Infos     []*product_info.Info
+	MinLevels int
+	MinRest   time.Duration
+	Window    time.Duration
+	// candles on either side of a swing high/low
+	SwingBars int
+
+	products map[string]*product
+}
+
+type product struct {
+	info   *product_info.Info
+	book   *orderbook.Book
+	synced bool
+	run    *run
+	candles
+}
+
+// run is a sequence of trades of one side.
+type run struct {
+	side    orderbook.Side
+	start   time.Time
+	last    time.Time
+	from    float64
+	to      float64
+	resting []float64
+}
+
+func NewDetector(db *bolt.DB, infos []*product_info.Info) *Detector {
+	return &Detector{
+		DB:        db,
+		Infos:     infos,
+		MinLevels: 5,
+		MinRest:   10 * time.Second,
+		Window:    2 * time.Second,
+		SwingBars: 5,
+		products:  map[string]*product{},
+	}
+}
+
+func (d *Detector) Run() {
+	topics := []string{}
+	for _, info := range d.Infos {
+		d.products[info.DatabaseKey] = &product{info: info, book: orderbook.New(info.DatabaseKey)}
+		topics = append(topics, util.ProductTopics(info.DatabaseKey)...)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		sub := util.Events.Subscribe(topics, 4096)
+	events:
+		for {
+			select {
+			case ev, ok := <-sub.C:
+				if !ok {
+					break events
+				}
+				d.handle(ev)
+			case now := <-ticker.C:
+				// runs end when no trade followed within the window
+				for _, p := range d.products {
+					if p.run != nil && now.Sub(p.run.last) > d.Window {
+						d.finish(p)
+					}
+				}
+			}
+		}
+		// too slow, wait for the next sync of every book
+		fmt.Println("sweep detector fell behind, resubscribing")
+		for _, p := range d.products {
+			p.synced = false
+			p.run = nil
+		}
+	}
+}
+
+func (d *Detector) handle(ev *util.Event) {
+	p, ok := d.products[ev.Bucket]
+	if !ok || len(ev.Data) == 0 {
+		return
+	}
+	t := orderbook.UnpackTimeKey(ev.Key)
+
+	if ev.Data[0] == orderbook.TradePacket {
+		side, price, _ := orderbook.UnpackTrade(ev.Data)
+		p.candles.add(t, price, d.SwingBars)
+		if p.synced {
+			d.trade(p, t, side, price)
+		}
+		return
+	}
+
+	if orderbook.IsSyncPacket(ev.Data) {
+		p.synced = true
+	}
+	if p.synced && !p.book.Process(t, ev.Data) {
+		p.synced = false
+	}
+}
+
+// restingLevels returns the prices of the levels of a side that rested for
+// MinRest before t.
+func (d *Detector) restingLevels(book *orderbook.Book, side orderbook.Side, t time.Time) []float64 {
+	levels := book.Bid
+	if side == orderbook.AskSide {
+		levels = book.Ask
+	}
+	prices := []float64{}
+	for _, level := range levels {
+		if level.Quantity > 0 && !level.FirstSeen.IsZero() && t.Sub(level.FirstSeen) >= d.MinRest {
+			prices = append(prices, level.Price)
+		}
+	}
+	return prices
+}
+
+func (d *Detector) trade(p *product, t time.Time, side orderbook.Side, price float64) {
+	if p.run != nil && (p.run.side != side || t.Sub(p.run.last) > d.Window) {
+		d.finish(p)
+	}
+	if p.run == nil {
+		// the book before the run, levels it takes out are still in it
+		p.run = &run{side: side, start: t, from: price, resting: d.restingLevels(p.book, side, t)}
+	}
+	p.run.last = t
+	p.run.to = price
+}
+
+// through returns how many resting levels the run traded through, trades
+// against the asks move up and against the bids down.
+func (r *run) through() int {
+	low, high := math.Min(r.from, r.to), math.Max(r.from, r.to)
+	count := 0
+	for _, price := range r.resting {
+		if price >= low && price <= high {
+			count += 1
+		}
+	}
+	return count
+}
+
+func (d *Detector) finish(p *product) {
+	r := p.run
+	p.run = nil
+	levels := r.through()
+	if levels < d.MinLevels {
+		return
+	}
+
+	format := p.info.FormatFloat
+	stops := []string{}
+	for _, price := range roundNumbers(r.from, r.to) {
+		stops = append(stops, format(price))
+	}
+	if r.side == orderbook.AskSide {
+		for _, price := range p.candles.takeSwings(&p.candles.highs, r.from, r.to) {
+			stops = append(stops, i18n.Sprintf("swing high %s", format(price)))
+		}
+	} else {
+		for _, price := range p.candles.takeSwings(&p.candles.lows, r.to, r.from) {
+			stops = append(stops, i18n.Sprintf("swing low %s", format(price)))
+		}
+	}
+
+	var label string
+	if len(stops) > 0 {
+		label = i18n.Sprintf("stop run %d levels %s-%s through %s", levels, format(r.from), format(r.to), strings.Join(stops, ", "))
+	} else {
+		label = i18n.Sprintf("trade-through %d levels %s-%s", levels, format(r.from), format(r.to))
+	}
+	if err := util.AddBookmark(d.DB, p.info.DatabaseKey, r.start, label); err != nil {
+		fmt.Println("sweep bookmark", err)
+	}
+}
+
+// roundStep is the spacing of round numbers around price, e.g. 100 at
+// 7000 and 10 at 300.
+func roundStep(price float64) float64 {
+	return math.Pow(10, math.Floor(math.Log10(price))-1)
+}
+
+// roundNumbers returns the round numbers crossed moving from one price to
+// another, the start excluded.
+func roundNumbers(from, to float64) []float64 {
+	if from <= 0 || to <= 0 || from == to {
+		return nil
+	}
+	low, high := math.Min(from, to), math.Max(from, to)
+	step := roundStep(low)
+	prices := []float64{}
+	for i := math.Ceil(low / step); i*step <= high; i += 1 {
+		if price := i * step; price != from {
+			prices = append(prices, price)
+		}
+	}
+	return prices
+}
@@ -0,0 +1,105 @@
+package tape
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Detector bookmarks tape acceleration, when the trades per second over
+// Window run at least Factor times faster than over the Baseline before
+// it. At most one bookmark per product and Baseline.
+type Detector struct {
+	DB       *bolt.DB
+	Infos    []*product_info.Info
+	Factor   float64
+	Window   time.Duration
+	Baseline time.Duration
+	// trades within Window needed, a few trades on a quiet tape are no
+	// acceleration
+	MinTrades int
+
+	products map[string]*product
+}
+
+type product struct {
+	info    *product_info.Info
+	tape    *orderbook.TapeSpeed
+	started time.Time
+	alerted time.Time
+}
+
+func NewDetector(db *bolt.DB, infos []*product_info.Info) *Detector {
+	return &Detector{
+		DB:        db,
+		Infos:     infos,
+		Factor:    4,
+		Window:    10 * time.Second,
+		Baseline:  5 * time.Minute,
+		MinTrades: 20,
+		products:  map[string]*product{},
+	}
+}
+
+func (d *Detector) Run() {
+	topics := []string{}
+	for _, info := range d.Infos {
+		d.products[info.DatabaseKey] = &product{info: info, tape: orderbook.NewTapeSpeed(d.Window + d.Baseline)}
+		topics = append(topics, util.TradeTopic(info.DatabaseKey))
+	}
+
+	for {
+		sub := util.Events.Subscribe(topics, 4096)
+		for ev := range sub.C {
+			d.handle(ev)
+		}
+		// too slow, start counting again
+		fmt.Println("tape detector fell behind, resubscribing")
+		for _, p := range d.products {
+			p.tape = orderbook.NewTapeSpeed(d.Window + d.Baseline)
+			p.started = time.Time{}
+		}
+	}
+}
+
+func (d *Detector) handle(ev *util.Event) {
+	p, ok := d.products[ev.Bucket]
+	if !ok || len(ev.Data) == 0 || ev.Data[0] != orderbook.TradePacket {
+		return
+	}
+	t := orderbook.UnpackTimeKey(ev.Key)
+	_, _, size := orderbook.UnpackTrade(ev.Data)
+	p.tape.Add(t, size)
+	if p.started.IsZero() {
+		p.started = t
+	}
+	// no baseline yet
+	if t.Sub(p.started) < d.Window+d.Baseline || t.Sub(p.alerted) < d.Baseline {
+		return
+	}
+
+	trades, volume := p.tape.Rate(t, d.Window)
+	if trades*d.Window.Seconds() < float64(d.MinTrades) {
+		return
+	}
+	total, _ := p.tape.Rate(t, d.Window+d.Baseline)
+	total *= (d.Window + d.Baseline).Seconds()
+	baseline := (total - (trades * d.Window.Seconds())) / d.Baseline.Seconds()
+	if baseline > 0 && trades < d.Factor*baseline {
+		return
+	}
+
+	p.alerted = t
+	label := i18n.Sprintf("tape %.1f trades/s %.4f/s", trades, volume)
+	if baseline > 0 {
+		label = i18n.Sprintf("tape %.1f trades/s (%.1fx) %.4f/s", trades, trades/baseline, volume)
+	}
+	if err := util.AddBookmark(d.DB, p.info.DatabaseKey, t, label); err != nil {
+		fmt.Println("tape bookmark", err)
+	}
+}
@@ -0,0 +1,388 @@
+// Package tiles renders the heatmap of a recorded range into a pyramid of
+// png tiles, like map tiles, with a json manifest, so a static web page can
+// pan and zoom it without the Go renderer.
+package tiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/opengl/palette"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// cells of the deepest zoom level kept in memory at most
+const maxCells = 64 << 20
+
+// Exporter renders the deepest zoom level at Resolution per pixel column
+// and Rows price rows, every level above it halves both. Tiles are written
+// as <dir>/<zoom>/<x>/<y>.png, zoom 0 is a single tile with the whole
+// range, y 0 holds the highest prices. Tiles at the right and bottom edge
+// are cropped to the range.
+type Exporter struct {
+	DB         *bolt.DB
+	TileSize   int
+	Resolution time.Duration
+	Rows       int
+	// price range, both 0 take the traded range with Margin percent room
+	PriceMin float64
+	PriceMax float64
+	Margin   float64
+	// percentile of the non-empty cells of a level drawn at full strength
+	Saturate float64
+}
+
+func NewExporter(db *bolt.DB) *Exporter {
+	return &Exporter{
+		DB:         db,
+		TileSize:   256,
+		Resolution: 10 * time.Second,
+		Rows:       1024,
+		Margin:     1,
+		Saturate:   99,
+	}
+}
+
+// Manifest describes the tiles for the web page.
+type Manifest struct {
+	Product  string    `json:"product"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	PriceMin float64   `json:"price_min"`
+	PriceMax float64   `json:"price_max"`
+	TileSize int       `json:"tile_size"`
+	// url of a tile relative to the manifest
+	Path   string   `json:"path"`
+	Levels []*Level `json:"levels"`
+	Bg     string   `json:"bg"`
+}
+
+type Level struct {
+	Zoom int `json:"zoom"`
+	// size in pixels and tiles
+	Width        int     `json:"width"`
+	Height       int     `json:"height"`
+	Columns      int     `json:"columns"`
+	Rows         int     `json:"rows"`
+	SecondsPerPx float64 `json:"seconds_per_px"`
+	PricePerPx   float64 `json:"price_per_px"`
+	FullStrength float64 `json:"full_strength"`
+}
+
+// grid is one zoom level, sizes summed per price row and sampled at the
+// end of every column, row 0 holds the lowest prices.
+type grid struct {
+	width, height int
+	cells         []float32
+	// row of the best bid and ask per column, -1 unknown
+	bid, ask []int
+}
+
+func newGrid(width, height int) *grid {
+	g := &grid{width: width, height: height, cells: make([]float32, width*height), bid: make([]int, width), ask: make([]int, width)}
+	for x := 0; x < width; x += 1 {
+		g.bid[x], g.ask[x] = -1, -1
+	}
+	return g
+}
+
+// Export writes the tiles of product from..to and manifest.json into dir.
+func (e *Exporter) Export(product string, from, to time.Time, dir string) (*Manifest, error) {
+	if !to.After(from) || e.Resolution <= 0 || e.Rows <= 0 || e.TileSize <= 0 {
+		return nil, fmt.Errorf("nothing to export from %s to %s", from, to)
+	}
+	min, max := e.PriceMin, e.PriceMax
+	if min == 0 && max == 0 {
+		var err error
+		if min, max, err = e.tradedRange(product, from, to); err != nil {
+			return nil, err
+		}
+	}
+	if max <= min {
+		return nil, fmt.Errorf("empty price range %f..%f", min, max)
+	}
+
+	width := int(math.Ceil(float64(to.Sub(from)) / float64(e.Resolution)))
+	if width*e.Rows > maxCells {
+		return nil, fmt.Errorf("%d columns of %d rows are too many, use a coarser resolution or fewer rows", width, e.Rows)
+	}
+	base := newGrid(width, e.Rows)
+	if err := e.render(base, product, from, min, max); err != nil {
+		return nil, err
+	}
+
+	levels := []*grid{base}
+	for g := base; g.width > e.TileSize || g.height > e.TileSize; {
+		g = g.half()
+		levels = append([]*grid{g}, levels...)
+	}
+
+	p := palette.Current()
+	m := &Manifest{
+		Product:  product,
+		From:     from,
+		To:       to,
+		PriceMin: min,
+		PriceMax: max,
+		TileSize: e.TileSize,
+		Path:     "{z}/{x}/{y}.png",
+		Levels:   []*Level{},
+		Bg:       fmt.Sprintf("#%02x%02x%02x", p.Bg.R, p.Bg.G, p.Bg.B),
+	}
+	for zoom, g := range levels {
+		scale := float64(int(1) << uint(len(levels)-1-zoom))
+		level := &Level{
+			Zoom:         zoom,
+			Width:        g.width,
+			Height:       g.height,
+			Columns:      (g.width + e.TileSize - 1) / e.TileSize,
+			Rows:         (g.height + e.TileSize - 1) / e.TileSize,
+			SecondsPerPx: e.Resolution.Seconds() * scale,
+			PricePerPx:   (max - min) / float64(g.height),
+			FullStrength: g.percentile(e.Saturate),
+		}
+		if err := e.writeTiles(dir, level, g, p); err != nil {
+			return nil, err
+		}
+		m.Levels = append(m.Levels, level)
+	}
+
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return m, ioutil.WriteFile(filepath.Join(dir, "manifest.json"), buf, 0644)
+}
+
+// tradedRange returns the lowest and highest trade price, widened by
+// Margin percent.
+func (e *Exporter) tradedRange(product string, from, to time.Time) (float64, float64, error) {
+	min, max := math.Inf(1), math.Inf(-1)
+	err := e.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := util.NewCursor(e.DB, b)
+		for key, buf := c.Seek(orderbook.PackTimeKey(from)); key != nil; key, buf = c.Next() {
+			if orderbook.UnpackTimeKey(key).After(to) {
+				break
+			}
+			if len(buf) == 0 || buf[0] != orderbook.TradePacket {
+				continue
+			}
+			_, price, _ := orderbook.UnpackTrade(buf)
+			min, max = math.Min(min, price), math.Max(max, price)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if math.IsInf(min, 1) {
+		return 0, 0, fmt.Errorf("no trades of %s from %s to %s, set the price range", product, from, to)
+	}
+	margin := (min + max) / 2 * e.Margin / 100
+	return min - margin, max + margin, nil
+}
+
+// render replays the book from the last sync before from and samples it
+// at the end of every column.
+func (e *Exporter) render(g *grid, product string, from time.Time, min, max float64) error {
+	step := (max - min) / float64(g.height)
+	row := func(price float64) int {
+		if price < min || price >= max {
+			return -1
+		}
+		return int((price - min) / step)
+	}
+	book := orderbook.New(product)
+	sample := func(x int) {
+		cells := g.cells[x*g.height : (x+1)*g.height]
+		bid, ask := 0.0, math.Inf(1)
+		for _, level := range book.Bid {
+			if level.Quantity > 0 {
+				bid = math.Max(bid, level.Price)
+			}
+		}
+		for _, level := range book.Ask {
+			if level.Quantity > 0 {
+				ask = math.Min(ask, level.Price)
+			}
+		}
+		for _, side := range []orderbook.BookLevelList{book.Bid, book.Ask} {
+			for _, level := range side {
+				if r := row(level.Price); r >= 0 && level.Quantity > 0 {
+					cells[r] += float32(level.Quantity)
+				}
+			}
+		}
+		if bid > 0 {
+			g.bid[x] = row(bid)
+		}
+		if !math.IsInf(ask, 1) {
+			g.ask[x] = row(ask)
+		}
+	}
+
+	return e.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := util.NewCursor(e.DB, b)
+
+		key, buf := c.Seek(orderbook.PackTimeKey(from))
+		if key == nil {
+			key, buf = c.Last()
+		}
+		for key != nil && (!orderbook.IsSyncPacket(buf) || orderbook.UnpackTimeKey(key).After(from)) {
+			key, buf = c.Prev()
+		}
+		if key == nil {
+			key, buf = c.Seek(orderbook.PackTimeKey(from))
+		}
+
+		x := 0
+		end := from.Add(e.Resolution)
+		for ; key != nil && x < g.width; key, buf = c.Next() {
+			t := orderbook.UnpackTimeKey(key)
+			for !t.Before(end) && x < g.width {
+				sample(x)
+				x += 1
+				end = end.Add(e.Resolution)
+			}
+			book.Process(t, buf)
+		}
+		// the book stays as it was after the last packet
+		for ; x < g.width; x += 1 {
+			sample(x)
+		}
+		return nil
+	})
+}
+
+// half merges 2x2 cells, sizes are summed over the price rows and averaged
+// over the columns.
+func (g *grid) half() *grid {
+	h := newGrid((g.width+1)/2, (g.height+1)/2)
+	for x := 0; x < g.width; x += 1 {
+		columns := float32(2)
+		if x/2 == h.width-1 && g.width%2 == 1 {
+			columns = 1
+		}
+		for y := 0; y < g.height; y += 1 {
+			h.cells[(x/2)*h.height+y/2] += g.cells[x*g.height+y] / columns
+		}
+		if g.bid[x] >= 0 {
+			h.bid[x/2] = g.bid[x] / 2
+		}
+		if g.ask[x] >= 0 {
+			h.ask[x/2] = g.ask[x] / 2
+		}
+	}
+	return h
+}
+
+// percentile returns the size at percent of the non-empty cells.
+func (g *grid) percentile(percent float64) float64 {
+	sizes := []float64{}
+	for _, size := range g.cells {
+		if size > 0 {
+			sizes = append(sizes, float64(size))
+		}
+	}
+	if len(sizes) == 0 {
+		return 0
+	}
+	sort.Float64s(sizes)
+	i := int(math.Ceil(percent/100*float64(len(sizes)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sizes) {
+		i = len(sizes) - 1
+	}
+	return sizes[i]
+}
+
+func (e *Exporter) writeTiles(dir string, level *Level, g *grid, p *palette.Palette) error {
+	for tx := 0; tx < level.Columns; tx += 1 {
+		path := filepath.Join(dir, fmt.Sprint(level.Zoom), fmt.Sprint(tx))
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		for ty := 0; ty < level.Rows; ty += 1 {
+			img := g.tile(tx*e.TileSize, ty*e.TileSize, e.TileSize, level.FullStrength, p)
+			if err := writePNG(filepath.Join(path, fmt.Sprintf("%d.png", ty)), img); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tile draws the cells from column x0 and image row y0, counted from the
+// highest prices down, in the colors of the heatmap of the viewer.
+func (g *grid) tile(x0, y0, size int, full float64, p *palette.Palette) *image.RGBA {
+	width := minInt(size, g.width-x0)
+	height := minInt(size, g.height-y0)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x += 1 {
+		column := x0 + x
+		for y := 0; y < height; y += 1 {
+			row := g.height - 1 - (y0 + y)
+			c := p.Bg
+			if full > 0 {
+				c = blend(float64(g.cells[column*g.height+row])/full, p.Fg, p.Bg)
+			}
+			if row == g.bid[column] {
+				c = p.Bid
+			} else if row == g.ask[column] {
+				c = p.Ask
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func blend(strength float64, fg, bg color.RGBA) color.RGBA {
+	w := math.Min(1, strength)
+	return color.RGBA{
+		R: uint8(float64(fg.R)*w + float64(bg.R)*(1-w)),
+		G: uint8(float64(fg.G)*w + float64(bg.G)*(1-w)),
+		B: uint8(float64(fg.B)*w + float64(bg.B)*(1-w)),
+		A: 0xff,
+	}
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,112 @@
+package trading
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// SimOrder is a simulated limit order resting in a replayed book.
+type SimOrder struct {
+	Side   Side
+	Price  float64
+	Size   float64
+	Filled float64
+	// size estimated in front of the order at its level
+	Ahead float64
+}
+
+func (o *SimOrder) Remaining() float64 {
+	return math.Max(0, o.Size-o.Filled)
+}
+
+// FillModel decides how a resting simulated order moves up its queue and
+// fills as the level it rests at trades and shrinks. Models only see L2
+// sizes, so they differ in how optimistic they are about the orders in
+// front.
+type FillModel interface {
+	Name() string
+	// Join is called when the order starts resting behind ahead size.
+	Join(o *SimOrder, ahead float64)
+	// Trade is called for every trade of size at the price of the order,
+	// level is the size resting there before the trade. It fills the order
+	// and returns the size filled.
+	Trade(o *SimOrder, size, level float64) float64
+	// Cancel is called when size left the level without a trade.
+	Cancel(o *SimOrder, size, level float64)
+}
+
+// FIFOFill is strict price-time priority: trades fill the size ahead
+// first, cancels are assumed to come from behind the order. The most
+// pessimistic model, fills only once the whole queue in front traded.
+type FIFOFill struct{}
+
+func (FIFOFill) Name() string { return "fifo" }
+
+func (FIFOFill) Join(o *SimOrder, ahead float64) {
+	o.Ahead = ahead
+}
+
+func (FIFOFill) Trade(o *SimOrder, size, level float64) float64 {
+	taken := math.Min(o.Ahead, size)
+	o.Ahead -= taken
+	return o.fill(size - taken)
+}
+
+func (FIFOFill) Cancel(o *SimOrder, size, level float64) {
+	// the level can't shrink below the queue in front
+	behind := math.Max(0, level-o.Ahead)
+	if size > behind {
+		o.Ahead = math.Max(0, o.Ahead-(size-behind))
+	}
+}
+
+// ProRataFill splits every trade at the level by size, the order gets its
+// share of the level no matter when it joined, like on pro-rata matching
+// venues. The most optimistic model for small orders at large levels.
+type ProRataFill struct{}
+
+func (ProRataFill) Name() string { return "pro-rata" }
+
+func (ProRataFill) Join(o *SimOrder, ahead float64) {
+	o.Ahead = ahead
+}
+
+func (ProRataFill) Trade(o *SimOrder, size, level float64) float64 {
+	remaining := o.Remaining()
+	if remaining <= 0 {
+		return 0
+	}
+	return o.fill(size * remaining / (level + remaining))
+}
+
+func (ProRataFill) Cancel(o *SimOrder, size, level float64) {
+	if level > 0 {
+		o.Ahead = math.Max(0, o.Ahead-size*o.Ahead/level)
+	}
+}
+
+func (o *SimOrder) fill(size float64) float64 {
+	size = math.Min(math.Max(0, size), o.Remaining())
+	o.Filled += size
+	return size
+}
+
+// FillModels are the models selectable by name.
+var FillModels = map[string]FillModel{
+	FIFOFill{}.Name():    FIFOFill{},
+	ProRataFill{}.Name(): ProRataFill{},
+}
+
+func ParseFillModel(name string) (FillModel, error) {
+	if model, ok := FillModels[name]; ok {
+		return model, nil
+	}
+	names := []string{}
+	for name := range FillModels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown fill model %q, expected one of %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,155 @@
+package trading
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// latency samples kept per exchange and kind
+const latencyWindow = 500
+
+// acked orders are matched to book updates for this long
+const visibleTimeout = 30 * time.Second
+
+// kinds of measured latencies
+const (
+	// local submit until the ack arrived on the user stream, needs Submitted
+	SubmitAck = "submit_ack"
+	// ack arrived until the order showed up in the public book, both local
+	AckVisible = "ack_visible"
+	// order creation until the public book update, both exchange timestamps
+	ExchangeVisible = "exchange_visible"
+)
+
+type LatencyStats struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+type pendingOrder struct {
+	Exchange string
+	Product  string
+	Side     Side
+	Price    float64
+	Size     float64
+	Created  time.Time
+	Acked    time.Time
+}
+
+// Latency estimates order latencies from the authenticated streams and the
+// public book. Book visibility is matched by price and size, so another
+// order of the same size at the same time can be taken for ours.
+type Latency struct {
+	mu        sync.Mutex
+	samples   map[string]map[string]*orderbook.Percentiles
+	submitted map[string]time.Time
+	pending   []*pendingOrder
+}
+
+func NewLatency() *Latency {
+	return &Latency{
+		samples:   map[string]map[string]*orderbook.Percentiles{},
+		submitted: map[string]time.Time{},
+		pending:   []*pendingOrder{},
+	}
+}
+
+func (l *Latency) add(exchange, kind string, d time.Duration) {
+	if _, ok := l.samples[exchange]; !ok {
+		l.samples[exchange] = map[string]*orderbook.Percentiles{}
+	}
+	p, ok := l.samples[exchange][kind]
+	if !ok {
+		p = orderbook.NewPercentiles(latencyWindow)
+		l.samples[exchange][kind] = p
+	}
+	p.Add(float64(d) / float64(time.Millisecond))
+}
+
+// Submitted is called by order entry right before an order is sent.
+func (l *Latency) Submitted(exchange, clientOrderID string, t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.submitted[orderKey(exchange, clientOrderID)] = t
+}
+
+// Acked is called when the user stream confirms a new order. created is the
+// exchange timestamp of the order, received the local time of the event.
+func (l *Latency) Acked(exchange, clientOrderID, product string, side Side, price, size float64, created, received time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := orderKey(exchange, clientOrderID)
+	if t, ok := l.submitted[key]; ok {
+		l.add(exchange, SubmitAck, received.Sub(t))
+		delete(l.submitted, key)
+	}
+	for key, t := range l.submitted {
+		if received.Sub(t) > visibleTimeout {
+			delete(l.submitted, key)
+		}
+	}
+
+	if price == 0 || size == 0 {
+		// market orders never rest in the book
+		return
+	}
+	l.pending = append(l.pending, &pendingOrder{
+		Exchange: exchange,
+		Product:  product,
+		Side:     side,
+		Price:    price,
+		Size:     size,
+		Created:  created,
+		Acked:    received,
+	})
+}
+
+// LevelIncreased is called for public book updates which added size to a
+// level. eventTime is the exchange timestamp of the update.
+func (l *Latency) LevelIncreased(exchange, product string, side Side, price, added float64, eventTime, received time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	matched := false
+	pending := l.pending[:0]
+	for _, order := range l.pending {
+		if received.Sub(order.Acked) > visibleTimeout {
+			continue
+		}
+		if !matched && order.Exchange == exchange && order.Product == product && order.Side == side &&
+			order.Price == price && math.Abs(order.Size-added) < 1e-9 {
+			l.add(exchange, AckVisible, received.Sub(order.Acked))
+			l.add(exchange, ExchangeVisible, eventTime.Sub(order.Created))
+			matched = true
+			continue
+		}
+		pending = append(pending, order)
+	}
+	l.pending = pending
+}
+
+// Stats returns the rolling latency percentiles by exchange and kind.
+func (l *Latency) Stats() map[string]map[string]LatencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := map[string]map[string]LatencyStats{}
+	for exchange, kinds := range l.samples {
+		stats[exchange] = map[string]LatencyStats{}
+		for kind, p := range kinds {
+			stats[exchange][kind] = LatencyStats{
+				Count: p.Count(),
+				P50:   p.Percentile(50),
+				P90:   p.Percentile(90),
+				P99:   p.Percentile(99),
+			}
+		}
+	}
+	return stats
+}
@@ -0,0 +1,115 @@
+package trading
+
+import (
+	"sort"
+)
+
+// assets valued at one dollar
+var USDAssets = map[string]bool{
+	"USD":  true,
+	"USDT": true,
+	"USDC": true,
+	"BUSD": true,
+	"TUSD": true,
+	"PAX":  true,
+}
+
+// Holding is the balance of an asset summed over the exchanges.
+type Holding struct {
+	Asset     string             `json:"asset"`
+	Free      float64            `json:"free"`
+	Locked    float64            `json:"locked"`
+	Exchanges map[string]float64 `json:"exchanges"`
+	Price     float64            `json:"price"`
+	Value     float64            `json:"value"`
+	Priced    bool               `json:"priced"`
+}
+
+func (h *Holding) Total() float64 {
+	return h.Free + h.Locked
+}
+
+// PositionValue is an open position with its unrealized pnl in USD, the
+// margin is already valued with the holdings.
+type PositionValue struct {
+	Position
+	Price  float64 `json:"price"`
+	Value  float64 `json:"value"`
+	Priced bool    `json:"priced"`
+}
+
+type Portfolio struct {
+	Holdings  []*Holding       `json:"holdings"`
+	Positions []*PositionValue `json:"positions"`
+	// holdings and unrealized pnl of the positions, unpriced ones left out
+	Value float64 `json:"value"`
+}
+
+// PriceFunc returns the USD price of an asset from the live feeds.
+type PriceFunc func(asset string) (float64, bool)
+
+func usdPrice(asset string, price PriceFunc) (float64, bool) {
+	if USDAssets[asset] {
+		return 1.0, true
+	}
+	if price != nil {
+		return price(asset)
+	}
+	return 0, false
+}
+
+func BuildPortfolio(balances []Balance, positions []Position, price PriceFunc) *Portfolio {
+	holdings := map[string]*Holding{}
+	for _, balance := range balances {
+		if balance.Total() == 0 {
+			continue
+		}
+		h, ok := holdings[balance.Asset]
+		if !ok {
+			h = &Holding{Asset: balance.Asset, Exchanges: map[string]float64{}}
+			holdings[balance.Asset] = h
+		}
+		h.Free += balance.Free
+		h.Locked += balance.Locked
+		h.Exchanges[balance.Exchange] += balance.Total()
+	}
+
+	portfolio := &Portfolio{Holdings: []*Holding{}, Positions: []*PositionValue{}}
+	for _, h := range holdings {
+		h.Price, h.Priced = usdPrice(h.Asset, price)
+		if h.Priced {
+			h.Value = h.Total() * h.Price
+			portfolio.Value += h.Value
+		}
+		portfolio.Holdings = append(portfolio.Holdings, h)
+	}
+
+	for _, position := range positions {
+		p := &PositionValue{Position: position}
+		p.Price, p.Priced = usdPrice(position.MarginAsset, price)
+		if p.Priced {
+			p.Value = position.UnrealizedPnL * p.Price
+			portfolio.Value += p.Value
+		}
+		portfolio.Positions = append(portfolio.Positions, p)
+	}
+
+	sort.Slice(portfolio.Holdings, func(i, j int) bool {
+		if portfolio.Holdings[i].Value == portfolio.Holdings[j].Value {
+			return portfolio.Holdings[i].Asset < portfolio.Holdings[j].Asset
+		}
+		return portfolio.Holdings[i].Value > portfolio.Holdings[j].Value
+	})
+	sort.Slice(portfolio.Positions, func(i, j int) bool {
+		if portfolio.Positions[i].Exchange == portfolio.Positions[j].Exchange {
+			return portfolio.Positions[i].Product < portfolio.Positions[j].Product
+		}
+		return portfolio.Positions[i].Exchange < portfolio.Positions[j].Exchange
+	})
+
+	return portfolio
+}
+
+func (t *Tracker) Portfolio(price PriceFunc) *Portfolio {
+	return BuildPortfolio(t.AllBalances(), t.AllPositions(), price)
+}
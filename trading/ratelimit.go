@@ -0,0 +1,163 @@
+package trading
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lian/gdax-bookmap/exchanges/common"
+)
+
+// local budget exhausted, also matches common.ErrRateLimited
+var ErrRateLimited = fmt.Errorf("order budget exhausted: %w", common.ErrRateLimited)
+
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// order entry limits as documented by the exchanges
+var DefaultRateLimits = map[string][]RateLimit{
+	"GDAX": []RateLimit{
+		RateLimit{Limit: 5, Window: time.Second},
+	},
+	"Binance": []RateLimit{
+		RateLimit{Limit: 50, Window: 10 * time.Second},
+		RateLimit{Limit: 160000, Window: 24 * time.Hour},
+	},
+	"Bitstamp": []RateLimit{
+		RateLimit{Limit: 8000, Window: 10 * time.Minute},
+	},
+	"Bitfinex": []RateLimit{
+		RateLimit{Limit: 90, Window: time.Minute},
+	},
+}
+
+type rateWindow struct {
+	RateLimit
+	Start time.Time
+	Count int
+}
+
+func (w *rateWindow) roll(now time.Time) {
+	if now.Sub(w.Start) >= w.Window {
+		w.Start = now
+		w.Count = 0
+	}
+}
+
+type BudgetStats struct {
+	Allowed  int `json:"allowed"`
+	Queued   int `json:"queued"`
+	Rejected int `json:"rejected"`
+	// queued orders still waiting for the next window
+	Waiting int `json:"waiting"`
+}
+
+// OrderBudget keeps order submissions per exchange within the exchange
+// rate limits. Orders over budget either wait for the next window or are
+// rejected with ErrRateLimited.
+type OrderBudget struct {
+	MaxQueue int
+	windows  map[string][]*rateWindow
+	stats    map[string]*BudgetStats
+	mu       sync.Mutex
+}
+
+func NewOrderBudget(limits map[string][]RateLimit) *OrderBudget {
+	b := &OrderBudget{
+		MaxQueue: 10,
+		windows:  map[string][]*rateWindow{},
+		stats:    map[string]*BudgetStats{},
+	}
+	for exchange, list := range limits {
+		b.SetLimits(exchange, list)
+	}
+	return b
+}
+
+func (b *OrderBudget) SetLimits(exchange string, limits []RateLimit) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	windows := []*rateWindow{}
+	for _, limit := range limits {
+		windows = append(windows, &rateWindow{RateLimit: limit})
+	}
+	b.windows[exchange] = windows
+	if _, ok := b.stats[exchange]; !ok {
+		b.stats[exchange] = &BudgetStats{}
+	}
+}
+
+// reserve returns how long to wait before the next attempt, or 0 if the
+// order was counted against the budget.
+func (b *OrderBudget) reserve(exchange string, now time.Time) time.Duration {
+	var wait time.Duration
+	windows := b.windows[exchange]
+	for _, w := range windows {
+		w.roll(now)
+		if w.Count >= w.Limit {
+			if d := w.Start.Add(w.Window).Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, w := range windows {
+		w.Count += 1
+	}
+	return 0
+}
+
+// Acquire takes one order from the budget of exchange. With queue set it
+// blocks until the order fits, unless MaxQueue orders are already waiting.
+func (b *OrderBudget) Acquire(exchange string, queue bool) error {
+	b.mu.Lock()
+	stats, ok := b.stats[exchange]
+	if !ok {
+		stats = &BudgetStats{}
+		b.stats[exchange] = stats
+	}
+
+	queued := false
+	for {
+		wait := b.reserve(exchange, time.Now())
+		if wait == 0 {
+			stats.Allowed += 1
+			if queued {
+				stats.Waiting -= 1
+			}
+			b.mu.Unlock()
+			return nil
+		}
+
+		if !queued {
+			if !queue || stats.Waiting >= b.MaxQueue {
+				stats.Rejected += 1
+				b.mu.Unlock()
+				return ErrRateLimited
+			}
+			queued = true
+			stats.Queued += 1
+			stats.Waiting += 1
+		}
+
+		b.mu.Unlock()
+		time.Sleep(wait)
+		b.mu.Lock()
+	}
+}
+
+func (b *OrderBudget) Stats() map[string]BudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := map[string]BudgetStats{}
+	for exchange, s := range b.stats {
+		stats[exchange] = *s
+	}
+	return stats
+}
@@ -0,0 +1,182 @@
+package trading
+
+import (
+	"math"
+	"sort"
+)
+
+// taker fees as a share of the notional, the lowest published tier
+var DefaultTakerFees = map[string]float64{
+	"GDAX":     0.003,
+	"Binance":  0.001,
+	"Bitstamp": 0.0025,
+	"Bitfinex": 0.002,
+}
+
+type Level struct {
+	Price float64 `json:"price"`
+	Size  float64 `json:"size"`
+}
+
+// Venue is one exchange a parent order can be split to.
+type Venue struct {
+	Exchange string
+	Product  string
+	// taker fee as a share of the notional
+	Fee float64
+	// levels of the side the order takes, best first
+	Levels []Level
+	// base for sells, quote including fees for buys, 0 is unlimited
+	Available float64
+	// the exchange is its testnet
+	Sandbox bool
+}
+
+// Child is the part of a parent order sent to one venue.
+type Child struct {
+	Exchange string  `json:"exchange"`
+	Product  string  `json:"product"`
+	Size     float64 `json:"size"`
+	// price of the last level taken, the limit price of the child order
+	Limit    float64 `json:"limit"`
+	AvgPrice float64 `json:"avg_price"`
+	Notional float64 `json:"notional"`
+	Fees     float64 `json:"fees"`
+	Levels   int     `json:"levels"`
+	Sandbox  bool    `json:"sandbox,omitempty"`
+}
+
+// Route is the preview of a parent order split across venues.
+type Route struct {
+	Side     string   `json:"side"`
+	Size     float64  `json:"size"`
+	Filled   float64  `json:"filled"`
+	Children []*Child `json:"children"`
+	// average price including fees, what a unit costs or brings in
+	AvgPrice float64 `json:"avg_price"`
+	Notional float64 `json:"notional"`
+	Fees     float64 `json:"fees"`
+	// the same order on the single best venue, to compare against
+	BestVenue      string  `json:"best_venue"`
+	BestVenuePrice float64 `json:"best_venue_price"`
+	// saved against BestVenue in basis points, 0 when it could not fill
+	Savings float64 `json:"savings_bps"`
+}
+
+type routeLevel struct {
+	venue int
+	Level
+	// price after fees
+	cost float64
+}
+
+// RouteOrder splits a market order of size over the books of venues,
+// taking the levels with the best price after fees first until size is
+// reached or the books and balances run out.
+func RouteOrder(side Side, size float64, venues []*Venue) *Route {
+	route := &Route{Side: "buy", Size: size, Children: []*Child{}}
+	if side == Sell {
+		route.Side = "sell"
+	}
+
+	levels := []*routeLevel{}
+	for i, venue := range venues {
+		for _, level := range venue.Levels {
+			cost := level.Price * (1 + venue.Fee)
+			if side == Sell {
+				cost = level.Price * (1 - venue.Fee)
+			}
+			levels = append(levels, &routeLevel{venue: i, Level: level, cost: cost})
+		}
+	}
+	sort.SliceStable(levels, func(i, j int) bool {
+		if side == Sell {
+			return levels[i].cost > levels[j].cost
+		}
+		return levels[i].cost < levels[j].cost
+	})
+
+	children := make([]*Child, len(venues))
+	spent := make([]float64, len(venues))
+	for _, level := range levels {
+		remaining := size - route.Filled
+		if remaining <= 0 {
+			break
+		}
+		venue := venues[level.venue]
+		take := math.Min(remaining, level.Size)
+		if venue.Available > 0 {
+			left := venue.Available - spent[level.venue]
+			if side == Buy {
+				left = left / level.cost
+			}
+			take = math.Min(take, left)
+		}
+		if take <= 0 {
+			continue
+		}
+
+		child := children[level.venue]
+		if child == nil {
+			child = &Child{Exchange: venue.Exchange, Product: venue.Product, Sandbox: venue.Sandbox}
+			children[level.venue] = child
+		}
+		child.Size += take
+		child.Limit = level.Price
+		child.Notional += take * level.Price
+		child.Fees += take * level.Price * venue.Fee
+		child.Levels += 1
+		if side == Buy {
+			spent[level.venue] += take * level.cost
+		} else {
+			spent[level.venue] += take
+		}
+		route.Filled += take
+	}
+
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		child.AvgPrice = child.Notional / child.Size
+		route.Children = append(route.Children, child)
+		route.Notional += child.Notional
+		route.Fees += child.Fees
+	}
+	route.AvgPrice = routePrice(side, route.Notional, route.Fees, route.Filled)
+
+	if len(venues) > 1 {
+		route.compareVenues(side, venues)
+	}
+	if route.BestVenue != "" && route.Filled >= size {
+		route.Savings = (route.BestVenuePrice - route.AvgPrice) / route.BestVenuePrice * 10000
+		if side == Sell {
+			route.Savings = -route.Savings
+		}
+	}
+	return route
+}
+
+// compareVenues prices the whole order on each venue alone.
+func (route *Route) compareVenues(side Side, venues []*Venue) {
+	for _, venue := range venues {
+		single := RouteOrder(side, route.Size, []*Venue{venue})
+		if single.Filled < route.Size {
+			continue
+		}
+		if route.BestVenue == "" || (side == Buy && single.AvgPrice < route.BestVenuePrice) || (side == Sell && single.AvgPrice > route.BestVenuePrice) {
+			route.BestVenue = venue.Exchange
+			route.BestVenuePrice = single.AvgPrice
+		}
+	}
+}
+
+func routePrice(side Side, notional, fees, size float64) float64 {
+	if size == 0 {
+		return 0
+	}
+	if side == Sell {
+		return (notional - fees) / size
+	}
+	return (notional + fees) / size
+}
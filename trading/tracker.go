@@ -0,0 +1,193 @@
+package trading
+
+import (
+	"sync"
+	"time"
+)
+
+type Side uint8
+
+const (
+	Buy  Side = 0
+	Sell Side = 1
+)
+
+type Order struct {
+	ID        string
+	Exchange  string
+	Product   string
+	Side      Side
+	Type      string
+	Price     float64
+	Size      float64
+	Filled    float64
+	Status    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (o *Order) Open() bool {
+	switch o.Status {
+	case "NEW", "PARTIALLY_FILLED", "open", "pending":
+		return true
+	}
+	return false
+}
+
+type Fill struct {
+	TradeID  string
+	OrderID  string
+	Exchange string
+	Product  string
+	Side     Side
+	Price    float64
+	Size     float64
+	Fee      float64
+	FeeAsset string
+	Maker    bool
+	Time     time.Time
+}
+
+type Balance struct {
+	Exchange string
+	Asset    string
+	Free     float64
+	Locked   float64
+}
+
+func (b Balance) Total() float64 {
+	return b.Free + b.Locked
+}
+
+// Position is an open derivatives position, its margin is part of the
+// balances of the exchange.
+type Position struct {
+	Exchange string `json:"exchange"`
+	Product  string `json:"product"`
+	// asset the pnl is settled in, e.g. USDT or BTC
+	MarginAsset string `json:"margin_asset"`
+	// contracts or base currency like the book of the product, negative
+	// when short
+	Size          float64 `json:"size"`
+	EntryPrice    float64 `json:"entry_price"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+}
+
+// Tracker collects the orders, fills, balances and positions reported by
+// the authenticated exchange streams.
+type Tracker struct {
+	Orders    map[string]*Order
+	Fills     []*Fill
+	Balances  map[string]map[string]Balance
+	Positions map[string]map[string]Position
+	Latency   *Latency
+	mu        sync.Mutex
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		Orders:    map[string]*Order{},
+		Fills:     []*Fill{},
+		Balances:  map[string]map[string]Balance{},
+		Positions: map[string]map[string]Position{},
+		Latency:   NewLatency(),
+	}
+}
+
+func orderKey(exchange, id string) string {
+	return exchange + "-" + id
+}
+
+func (t *Tracker) UpdateOrder(order *Order) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := orderKey(order.Exchange, order.ID)
+	if current, ok := t.Orders[key]; ok && order.CreatedAt.IsZero() {
+		order.CreatedAt = current.CreatedAt
+	}
+	t.Orders[key] = order
+}
+
+func (t *Tracker) AddFill(fill *Fill) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Fills = append(t.Fills, fill)
+}
+
+func (t *Tracker) SetBalance(balance Balance) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.Balances[balance.Exchange]; !ok {
+		t.Balances[balance.Exchange] = map[string]Balance{}
+	}
+	t.Balances[balance.Exchange][balance.Asset] = balance
+}
+
+// SetPosition replaces the position of a product, a size of 0 closes it.
+func (t *Tracker) SetPosition(position Position) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if position.Size == 0 {
+		delete(t.Positions[position.Exchange], position.Product)
+		return
+	}
+	if _, ok := t.Positions[position.Exchange]; !ok {
+		t.Positions[position.Exchange] = map[string]Position{}
+	}
+	t.Positions[position.Exchange][position.Product] = position
+}
+
+func (t *Tracker) OpenOrders() []*Order {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	orders := []*Order{}
+	for _, order := range t.Orders {
+		if order.Open() {
+			orders = append(orders, order)
+		}
+	}
+	return orders
+}
+
+func (t *Tracker) FillsSince(since time.Time) []*Fill {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fills := []*Fill{}
+	for _, fill := range t.Fills {
+		if !fill.Time.Before(since) {
+			fills = append(fills, fill)
+		}
+	}
+	return fills
+}
+
+func (t *Tracker) AllBalances() []Balance {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	balances := []Balance{}
+	for _, assets := range t.Balances {
+		for _, balance := range assets {
+			balances = append(balances, balance)
+		}
+	}
+	return balances
+}
+
+func (t *Tracker) AllPositions() []Position {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	positions := []Position{}
+	for _, products := range t.Positions {
+		for _, position := range products {
+			positions = append(positions, position)
+		}
+	}
+	return positions
+}
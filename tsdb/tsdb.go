@@ -0,0 +1,209 @@
+// Package tsdb stores derived metrics as time series next to the
+// recordings, so analytics share one bucket layout:
+//
+//	Series-<product>        one bucket per product
+//	    <metric>            one nested bucket per metric, e.g. quality.score
+//	        <time key>      float64, little endian
+//
+// Keys are the time keys of the packets.
+package tsdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// Seal and Unseal encrypt the values like the packets, set by util.
+var Seal = func(db *bolt.DB, data []byte) []byte { return data }
+var Unseal = func(db *bolt.DB, data []byte) ([]byte, error) { return data, nil }
+
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Bucket holds the series of a product.
+func Bucket(product string) string {
+	return "Series-" + product
+}
+
+// Append adds one point to a series.
+func Append(db *bolt.DB, product, metric string, t time.Time, value float64) error {
+	return AppendMany(db, product, t, map[string]float64{metric: value})
+}
+
+// AppendMany adds points at the same time to several series of a product
+// in one transaction.
+func AppendMany(db *bolt.DB, product string, t time.Time, values map[string]float64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(Bucket(product)))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s %s", Bucket(product), err)
+		}
+		key := orderbook.PackTimeKey(t)
+		for metric, value := range values {
+			series, err := b.CreateBucketIfNotExists([]byte(metric))
+			if err != nil {
+				return fmt.Errorf("create series: %s %s", metric, err)
+			}
+			// appended in time order most of the time
+			series.FillPercent = 0.9
+			buf := make([]byte, 8)
+			binary.LittleEndian.PutUint64(buf, math.Float64bits(value))
+			if err := series.Put(key, Seal(db, buf)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Range returns the points of a series from from to to, zero times are
+// open ends.
+func Range(db *bolt.DB, product, metric string, from, to time.Time) ([]Point, error) {
+	points := []Point{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(Bucket(product)))
+		if b == nil {
+			return nil
+		}
+		series := b.Bucket([]byte(metric))
+		if series == nil {
+			return nil
+		}
+		c := series.Cursor()
+		key, value := c.First()
+		if !from.IsZero() {
+			key, value = c.Seek(orderbook.PackTimeKey(from))
+		}
+		for ; key != nil; key, value = c.Next() {
+			t := orderbook.UnpackTimeKey(key)
+			if !to.IsZero() && t.After(to) {
+				break
+			}
+			plain, err := Unseal(db, value)
+			if err != nil {
+				return err
+			}
+			if len(plain) != 8 {
+				return fmt.Errorf("%s %s: invalid point at %s", product, metric, t)
+			}
+			points = append(points, Point{Time: t, Value: math.Float64frombits(binary.LittleEndian.Uint64(plain))})
+		}
+		return nil
+	})
+	return points, err
+}
+
+// Metrics returns the series names of a product, sorted.
+func Metrics(db *bolt.DB, product string) []string {
+	metrics := []string{}
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(Bucket(product)))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(key, value []byte) error {
+			// nested buckets have no value
+			if value == nil {
+				metrics = append(metrics, string(key))
+			}
+			return nil
+		})
+	})
+	sort.Strings(metrics)
+	return metrics
+}
+
+type Aggregate int
+
+const (
+	Mean Aggregate = iota
+	Min
+	Max
+	Sum
+	Last
+	Count
+)
+
+var aggregates = map[string]Aggregate{
+	"mean":  Mean,
+	"min":   Min,
+	"max":   Max,
+	"sum":   Sum,
+	"last":  Last,
+	"count": Count,
+}
+
+func ParseAggregate(name string) (Aggregate, error) {
+	if name == "" {
+		return Mean, nil
+	}
+	a, ok := aggregates[name]
+	if !ok {
+		return Mean, fmt.Errorf("unknown aggregate %q, expected mean, min, max, sum, last or count", name)
+	}
+	return a, nil
+}
+
+// Downsample combines the points of every step, aligned to multiples of
+// step, into one point at the start of the step.
+func Downsample(points []Point, step time.Duration, agg Aggregate) []Point {
+	if step <= 0 {
+		return points
+	}
+	out := []Point{}
+	var start time.Time
+	var value float64
+	n := 0
+	flush := func() {
+		if n == 0 {
+			return
+		}
+		switch agg {
+		case Mean:
+			value /= float64(n)
+		case Count:
+			value = float64(n)
+		}
+		out = append(out, Point{Time: start, Value: value})
+	}
+	for _, p := range points {
+		t := p.Time.Truncate(step)
+		if n == 0 || !t.Equal(start) {
+			flush()
+			start, value, n = t, p.Value, 0
+			if agg == Mean || agg == Sum {
+				value = 0
+			}
+		}
+		switch agg {
+		case Mean, Sum:
+			value += p.Value
+		case Min:
+			value = math.Min(value, p.Value)
+		case Max:
+			value = math.Max(value, p.Value)
+		case Last:
+			value = p.Value
+		}
+		n += 1
+	}
+	flush()
+	return out
+}
+
+// Query is Range downsampled to step, 0 keeps every point.
+func Query(db *bolt.DB, product, metric string, from, to time.Time, step time.Duration, agg Aggregate) ([]Point, error) {
+	points, err := Range(db, product, metric, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return Downsample(points, step, agg), nil
+}
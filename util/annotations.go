@@ -0,0 +1,170 @@
+package util
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// AnnotationsVersion is the format of stored Annotations, raised when a
+// field changes its meaning.
+const AnnotationsVersion = 1
+
+// annotation revisions kept per product, older ones are dropped on save
+const annotationRevisions = 50
+
+// AnnotationsBucket holds the revisions of the annotations of a product,
+// keyed by the big endian revision number, next to its packets so they
+// stay with the data when it is copied or extracted.
+func AnnotationsBucket(databaseKey string) string {
+	return "Annotations-" + databaseKey
+}
+
+// Annotations are the analysis of a product besides its bookmarks, which
+// have their own bucket: what was marked on the graph and the alert rules
+// the recorder ran with.
+type Annotations struct {
+	Version  int       `json:"version"`
+	Revision uint64    `json:"revision"`
+	Saved    time.Time `json:"saved"`
+	// column of the market order estimate, zero when there is none
+	Impact     time.Time `json:"impact,omitempty"`
+	ImpactSize float64   `json:"impact_size,omitempty"`
+	// trade selected in the trades panel
+	Highlight *StoredTrade `json:"highlight,omitempty"`
+	Alerts    *AlertRules  `json:"alerts,omitempty"`
+}
+
+// AlertRules are the alert settings of the recorder for a product.
+type AlertRules struct {
+	// bookmark trades of at least this size, 0 disabled
+	TradeSize float64 `json:"trade_size"`
+	// alert after this many seconds without stored packets, 0 disabled
+	Silence int `json:"silence_seconds"`
+}
+
+func revisionKey(revision uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, revision)
+	return key
+}
+
+// UpdateAnnotations applies fn to the latest annotations of a product and
+// stores the result as a new revision.
+func UpdateAnnotations(db *bolt.DB, databaseKey string, fn func(a *Annotations)) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(AnnotationsBucket(databaseKey)))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s %s", AnnotationsBucket(databaseKey), err)
+		}
+		a := &Annotations{}
+		if key, value := b.Cursor().Last(); key != nil {
+			if a, err = unpackAnnotations(db, value); err != nil {
+				return err
+			}
+		}
+		fn(a)
+		a.Version = AnnotationsVersion
+		a.Revision += 1
+		a.Saved = time.Now()
+		buf, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(revisionKey(a.Revision), Seal(db, buf)); err != nil {
+			return err
+		}
+		if a.Revision > annotationRevisions {
+			c := b.Cursor()
+			for key, _ := c.First(); key != nil && binary.BigEndian.Uint64(key) <= a.Revision-annotationRevisions; key, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func unpackAnnotations(db *bolt.DB, value []byte) (*Annotations, error) {
+	plain, err := Unseal(db, value)
+	if err != nil {
+		return nil, err
+	}
+	a := &Annotations{}
+	if err := json.Unmarshal(plain, a); err != nil {
+		return nil, err
+	}
+	if a.Version > AnnotationsVersion {
+		return nil, fmt.Errorf("annotations version %d is newer than %d", a.Version, AnnotationsVersion)
+	}
+	return a, nil
+}
+
+// LoadAnnotations returns the latest annotations of a product, nil if
+// there are none.
+func LoadAnnotations(db *bolt.DB, databaseKey string) (*Annotations, error) {
+	return AnnotationsRevision(db, databaseKey, 0)
+}
+
+// AnnotationsRevision returns a kept revision of the annotations of a
+// product, 0 for the latest.
+func AnnotationsRevision(db *bolt.DB, databaseKey string, revision uint64) (*Annotations, error) {
+	var a *Annotations
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AnnotationsBucket(databaseKey)))
+		if b == nil {
+			return nil
+		}
+		var value []byte
+		if revision == 0 {
+			_, value = b.Cursor().Last()
+		} else {
+			value = b.Get(revisionKey(revision))
+		}
+		if value == nil {
+			return nil
+		}
+		var err error
+		a, err = unpackAnnotations(db, value)
+		return err
+	})
+	return a, err
+}
+
+// ListAnnotationRevisions returns the kept revisions of a product, oldest
+// first.
+func ListAnnotationRevisions(db *bolt.DB, databaseKey string) []*Annotations {
+	list := []*Annotations{}
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AnnotationsBucket(databaseKey)))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(key, value []byte) error {
+			if a, err := unpackAnnotations(db, value); err == nil {
+				list = append(list, a)
+			}
+			return nil
+		})
+	})
+	return list
+}
+
+// SaveAlertRules stores the alert rules of a product, a new revision only
+// when they changed.
+func SaveAlertRules(db *bolt.DB, databaseKey string, rules *AlertRules) error {
+	latest, err := LoadAnnotations(db, databaseKey)
+	if err != nil {
+		return err
+	}
+	if latest != nil && latest.Alerts != nil && *latest.Alerts == *rules {
+		return nil
+	}
+	return UpdateAnnotations(db, databaseKey, func(a *Annotations) {
+		a.Alerts = rules
+	})
+}
@@ -0,0 +1,413 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// An archive holds the packets of one product in a flat file, for
+// recordings moved out of the database. It is read memory mapped, so
+// seeking in multi-GB files is quick and costs no heap.
+//
+//	"BMARCHV1"  magic
+//	uint32      header length, then the header as JSON
+//	records     int64 unix nano key, uint32 length, packet, uint32 length
+//	index       int64 unix nano key, int64 record offset, uint64 flags
+//	trailer     int64 index offset, int64 index entries, int64 records,
+//	            "BMARCHV1"
+//
+// Packets are stored like the database stores them, sealed when the
+// recording was encrypted. Delta syncs are stored expanded. The index has
+// every sync and every archiveIndexStep-th record, sorted by key.
+const archiveMagic = "BMARCHV1"
+
+const archiveIndexStep = 4096
+
+const (
+	archiveRecordHeader = 12
+	archiveIndexEntry   = 24
+	archiveTrailer      = 32
+)
+
+const archiveSyncFlag = 1
+
+var ErrNotArchive = errors.New("not a bookmap archive")
+
+type ArchiveHeader struct {
+	Product string    `json:"product"`
+	Created time.Time `json:"created"`
+	// the data key of an encrypted recording, wrapped like in the database
+	Salt []byte `json:"salt,omitempty"`
+	Key  []byte `json:"key,omitempty"`
+}
+
+// ArchiveWriter appends packets in key order.
+type ArchiveWriter struct {
+	f       *os.File
+	w       *bufio.Writer
+	offset  int64
+	records int64
+	last    int64
+	index   *bytes.Buffer
+}
+
+func CreateArchive(path string, header *ArchiveHeader) (*ArchiveWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := json.Marshal(header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a := &ArchiveWriter{f: f, w: bufio.NewWriterSize(f, 1<<20), index: &bytes.Buffer{}}
+	a.w.WriteString(archiveMagic)
+	binary.Write(a.w, binary.LittleEndian, uint32(len(buf)))
+	a.w.Write(buf)
+	a.offset = int64(len(archiveMagic) + 4 + len(buf))
+	return a, nil
+}
+
+// ArchiveHeaderOf returns a header for product carrying the wrapped data
+// key of db, if it is encrypted.
+func ArchiveHeaderOf(db *bolt.DB, product string) *ArchiveHeader {
+	header := &ArchiveHeader{Product: product, Created: time.Now().UTC()}
+	db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(EncryptionBucket)); b != nil {
+			header.Salt = append([]byte{}, b.Get([]byte("salt"))...)
+			header.Key = append([]byte{}, b.Get([]byte("key"))...)
+		}
+		return nil
+	})
+	return header
+}
+
+// Add appends a packet as stored, sync tells the reader it may start there.
+func (a *ArchiveWriter) Add(nano int64, stored []byte, sync bool) error {
+	if a.records > 0 && nano <= a.last {
+		return fmt.Errorf("archive keys out of order: %d after %d", nano, a.last)
+	}
+	if sync || a.records%archiveIndexStep == 0 {
+		flags := uint64(0)
+		if sync {
+			flags = archiveSyncFlag
+		}
+		binary.Write(a.index, binary.LittleEndian, nano)
+		binary.Write(a.index, binary.LittleEndian, a.offset)
+		binary.Write(a.index, binary.LittleEndian, flags)
+	}
+	binary.Write(a.w, binary.LittleEndian, nano)
+	binary.Write(a.w, binary.LittleEndian, uint32(len(stored)))
+	a.w.Write(stored)
+	if err := binary.Write(a.w, binary.LittleEndian, uint32(len(stored))); err != nil {
+		return err
+	}
+	a.offset += archiveRecordHeader + int64(len(stored)) + 4
+	a.records += 1
+	a.last = nano
+	return nil
+}
+
+func (a *ArchiveWriter) Close() error {
+	indexOffset := a.offset
+	a.w.Write(a.index.Bytes())
+	binary.Write(a.w, binary.LittleEndian, indexOffset)
+	binary.Write(a.w, binary.LittleEndian, int64(a.index.Len()/archiveIndexEntry))
+	binary.Write(a.w, binary.LittleEndian, a.records)
+	a.w.WriteString(archiveMagic)
+	if err := a.w.Flush(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
+
+// Archive is a memory mapped archive file.
+type Archive struct {
+	Header  ArchiveHeader
+	Records int64
+
+	data    []byte
+	start   int64
+	end     int64
+	index   []byte
+	entries int
+	aead    cipher.AEAD
+	close   func() error
+}
+
+func OpenArchive(path string) (*Archive, error) {
+	data, closer, err := mmapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a, err := parseArchive(data)
+	if err != nil {
+		closer()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	a.close = closer
+	return a, nil
+}
+
+func parseArchive(data []byte) (*Archive, error) {
+	n := int64(len(data))
+	if n < int64(len(archiveMagic))+4+archiveTrailer || string(data[:len(archiveMagic)]) != archiveMagic ||
+		string(data[n-int64(len(archiveMagic)):]) != archiveMagic {
+		return nil, ErrNotArchive
+	}
+	a := &Archive{data: data}
+
+	headerLen := int64(binary.LittleEndian.Uint32(data[len(archiveMagic):]))
+	a.start = int64(len(archiveMagic)) + 4 + headerLen
+	if a.start > n {
+		return nil, ErrNotArchive
+	}
+	if err := json.Unmarshal(data[int64(len(archiveMagic))+4:a.start], &a.Header); err != nil {
+		return nil, err
+	}
+
+	trailer := data[n-archiveTrailer:]
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer))
+	entries := int64(binary.LittleEndian.Uint64(trailer[8:]))
+	a.Records = int64(binary.LittleEndian.Uint64(trailer[16:]))
+	a.end = indexOffset
+	if indexOffset < a.start || indexOffset+entries*archiveIndexEntry != n-archiveTrailer {
+		return nil, fmt.Errorf("corrupt archive index")
+	}
+	a.index = data[indexOffset : n-archiveTrailer]
+	a.entries = int(entries)
+	return a, nil
+}
+
+// Unlock opens the data key of an archive of an encrypted recording.
+func (a *Archive) Unlock(passphrase string) error {
+	if a.Header.Key == nil {
+		return ErrNotEncrypted
+	}
+	dataKey, err := unwrapDataKey(a.Header.Salt, a.Header.Key, passphrase)
+	if err != nil {
+		return err
+	}
+	a.aead, err = newGCM(dataKey)
+	return err
+}
+
+func (a *Archive) Close() error {
+	return a.close()
+}
+
+func (a *Archive) indexEntry(i int) (int64, int64, uint64) {
+	e := a.index[i*archiveIndexEntry:]
+	return int64(binary.LittleEndian.Uint64(e)), int64(binary.LittleEndian.Uint64(e[8:])), binary.LittleEndian.Uint64(e[16:])
+}
+
+// record returns the key, packet and offset of the next record of the
+// record at offset.
+func (a *Archive) record(offset int64) (int64, []byte, int64) {
+	nano := int64(binary.LittleEndian.Uint64(a.data[offset:]))
+	length := int64(binary.LittleEndian.Uint32(a.data[offset+8:]))
+	value := a.data[offset+archiveRecordHeader : offset+archiveRecordHeader+length]
+	return nano, value, offset + archiveRecordHeader + length + 4
+}
+
+func (a *Archive) previous(offset int64) int64 {
+	length := int64(binary.LittleEndian.Uint32(a.data[offset-4:]))
+	return offset - 4 - length - archiveRecordHeader
+}
+
+func (a *Archive) unseal(value []byte) []byte {
+	if len(value) == 0 || value[0] != encryptedMarker || a.aead == nil {
+		return value
+	}
+	plain, err := open(a.aead, value[1:])
+	if err != nil {
+		return value
+	}
+	return plain
+}
+
+// Cursor walks the archive like util.Cursor walks a product bucket. Values
+// of unencrypted archives point into the mapped file and are only valid
+// until the archive is closed.
+func (a *Archive) Cursor() *ArchiveCursor {
+	return &ArchiveCursor{a: a, pos: -1}
+}
+
+type ArchiveCursor struct {
+	a   *Archive
+	pos int64
+}
+
+func (c *ArchiveCursor) at(offset int64) ([]byte, []byte) {
+	if offset < c.a.start || offset >= c.a.end {
+		c.pos = -1
+		return nil, nil
+	}
+	c.pos = offset
+	nano, value, _ := c.a.record(offset)
+	return orderbook.PackUnixNanoKey(nano), c.a.unseal(value)
+}
+
+func (c *ArchiveCursor) First() ([]byte, []byte) {
+	return c.at(c.a.start)
+}
+
+func (c *ArchiveCursor) Last() ([]byte, []byte) {
+	if c.a.end == c.a.start {
+		return c.at(-1)
+	}
+	return c.at(c.a.previous(c.a.end))
+}
+
+func (c *ArchiveCursor) Next() ([]byte, []byte) {
+	if c.pos < 0 {
+		return nil, nil
+	}
+	_, _, next := c.a.record(c.pos)
+	return c.at(next)
+}
+
+func (c *ArchiveCursor) Prev() ([]byte, []byte) {
+	if c.pos <= c.a.start {
+		return c.at(-1)
+	}
+	return c.at(c.a.previous(c.pos))
+}
+
+// seekIndex returns the offset of the last index entry at or before nano
+// that is a sync, or any entry when syncOnly is false.
+func (c *ArchiveCursor) seekIndex(nano int64, syncOnly bool) int64 {
+	i := sort.Search(c.a.entries, func(i int) bool {
+		key, _, _ := c.a.indexEntry(i)
+		return key > nano
+	}) - 1
+	for ; i >= 0; i -= 1 {
+		_, offset, flags := c.a.indexEntry(i)
+		if !syncOnly || flags&archiveSyncFlag != 0 {
+			return offset
+		}
+	}
+	return -1
+}
+
+// Seek moves to the first packet at or after key.
+func (c *ArchiveCursor) Seek(seek []byte) ([]byte, []byte) {
+	nano := orderbook.UnpackTimeKey(seek).UnixNano()
+	offset := c.seekIndex(nano, false)
+	if offset < 0 {
+		offset = c.a.start
+	}
+	for offset < c.a.end {
+		key, _, next := c.a.record(offset)
+		if key >= nano {
+			return c.at(offset)
+		}
+		offset = next
+	}
+	return c.at(-1)
+}
+
+// SeekSync moves to the last sync at or before t, where replaying a book
+// for t starts.
+func (c *ArchiveCursor) SeekSync(t time.Time) ([]byte, []byte) {
+	return c.at(c.seekIndex(t.UnixNano(), true))
+}
+
+type ArchiveResult struct {
+	Product   string    `json:"product"`
+	Out       string    `json:"out"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Packets   int       `json:"packets"`
+	Encrypted bool      `json:"encrypted"`
+}
+
+// ArchiveProduct writes the packets of a product into an archive, starting
+// at the last sync before `from` like the extract command. Delta syncs are expanded so
+// every sync of the archive is a starting point.
+func ArchiveProduct(db *bolt.DB, w *ArchiveWriter, product string, from, to time.Time) (*ArchiveResult, error) {
+	result := &ArchiveResult{Product: product}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := NewCursor(db, b)
+
+		key, value := c.First()
+		if !from.IsZero() {
+			key, value = c.Seek(orderbook.PackTimeKey(from))
+			if key == nil {
+				key, value = c.Last()
+			}
+			for key != nil && !orderbook.IsSyncPacket(value) {
+				key, value = c.Prev()
+			}
+			if key == nil {
+				key, value = c.First()
+			}
+		}
+
+		var end []byte
+		if !to.IsZero() {
+			end = orderbook.PackTimeKey(to)
+		}
+
+		for ; key != nil; key, value = c.Next() {
+			if end != nil && bytes.Compare(key, end) > 0 {
+				break
+			}
+			t := orderbook.UnpackTimeKey(key)
+			if result.Packets == 0 {
+				result.From = t
+			}
+			result.To = t
+			result.Packets += 1
+			if err := w.Add(t.UnixNano(), Seal(db, value), orderbook.IsSyncPacket(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ExportArchive writes a product into a new archive file at path, removed
+// again on errors.
+func ExportArchive(db *bolt.DB, path, product string, from, to time.Time) (*ArchiveResult, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+	header := ArchiveHeaderOf(db, product)
+	w, err := CreateArchive(path, header)
+	if err != nil {
+		return nil, err
+	}
+	result, err := ArchiveProduct(db, w, product, from, to)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	result.Out = path
+	result.Encrypted = header.Key != nil
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// BackfillBucket records which ranges of a product were filled in from a
+// peer recorder instead of being recorded locally, keyed by start time.
+func BackfillBucket(databaseKey string) string {
+	return "Backfill-" + databaseKey
+}
+
+type Backfill struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Peer    string    `json:"peer"`
+	Packets int       `json:"packets"`
+	Filled  time.Time `json:"filled"`
+}
+
+// PutBackfill stores f within the transaction writing the filled packets.
+func PutBackfill(tx *bolt.Tx, databaseKey string, f *Backfill) error {
+	buf, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(BackfillBucket(databaseKey)))
+	if err != nil {
+		return err
+	}
+	return b.Put(orderbook.PackTimeKey(f.From), buf)
+}
+
+func ListBackfills(db *bolt.DB, databaseKey string) []*Backfill {
+	backfills := []*Backfill{}
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BackfillBucket(databaseKey)))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(key, value []byte) error {
+			f := &Backfill{}
+			if err := json.Unmarshal(value, f); err == nil {
+				backfills = append(backfills, f)
+			}
+			return nil
+		})
+	})
+	return backfills
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+type Bookmark struct {
+	Time  time.Time
+	Label string
+}
+
+func BookmarksBucket(databaseKey string) string {
+	return "Bookmarks-" + databaseKey
+}
+
+// AddBookmark stores a bookmark in a transaction of its own, for user
+// actions and the detectors. The clients add theirs to the batch of the
+// book with storage.BookWriter.Bookmark.
+func AddBookmark(db *bolt.DB, databaseKey string, t time.Time, label string) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BookmarksBucket(databaseKey)))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s %s", BookmarksBucket(databaseKey), err)
+		}
+		return b.Put(orderbook.PackTimeKey(t), []byte(label))
+	})
+	if bus := BusOf(db); err == nil && bus.HasSubscribers() {
+		bus.Publish([]*Event{{Topic: AlertTopic(databaseKey), Bucket: BookmarksBucket(databaseKey), Key: orderbook.PackTimeKey(t), Data: []byte(label)}})
+	}
+	return err
+}
+
+func RemoveBookmark(db *bolt.DB, databaseKey string, t time.Time) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BookmarksBucket(databaseKey)))
+		if b == nil {
+			return nil
+		}
+		return b.Delete(orderbook.PackTimeKey(t))
+	})
+}
+
+// ListBookmarks returns all bookmarks of a product sorted by time.
+func ListBookmarks(db *bolt.DB, databaseKey string) []*Bookmark {
+	bookmarks := []*Bookmark{}
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BookmarksBucket(databaseKey)))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(key, value []byte) error {
+			bookmarks = append(bookmarks, &Bookmark{Time: orderbook.UnpackTimeKey(key), Label: string(value)})
+			return nil
+		})
+	})
+	return bookmarks
+}
@@ -0,0 +1,185 @@
+package util
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// Event is published on the Bus. Events of stored packets carry the bucket
+// and key they were written to and the unencrypted packet.
+//
+// Topics are dot separated:
+//
+//	book.<product>.<kind>  book packets, kind is one of sync, diff,
+//	                       degraded_sync, gap, level_ages, sync_delta,
+//	                       mark_price or orders
+//	trade.<product>        trade packets
+//	alert.<product>        bookmarks, e.g. large trades
+//	external               events posted to the webhook
+type Event struct {
+	Topic  string
+	Bucket string
+	Key    []byte
+	Data   []byte
+}
+
+// Subscription receives the events matching its topic patterns. C is
+// closed when the subscriber could not keep up or unsubscribed.
+type Subscription struct {
+	C        chan *Event
+	patterns []string
+}
+
+// Bus connects the recorders with the consumers of their packets.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+}
+
+// Events gets every batch stored by a storage.BookWriter after it was
+// flushed, unless its database has a bus of its own.
+var Events = NewBus()
+
+func NewBus() *Bus {
+	return &Bus{subs: map[*Subscription]bool{}}
+}
+
+var buses = map[*bolt.DB]*Bus{}
+var busesMu sync.RWMutex
+
+// SetBus publishes what is stored into db on bus instead of Events, so
+// recorders of one process keep their events apart. nil goes back to
+// Events.
+func SetBus(db *bolt.DB, bus *Bus) {
+	busesMu.Lock()
+	defer busesMu.Unlock()
+	if bus == nil {
+		delete(buses, db)
+		return
+	}
+	buses[db] = bus
+}
+
+// BusOf returns the bus the events of db are published on.
+func BusOf(db *bolt.DB) *Bus {
+	busesMu.RLock()
+	defer busesMu.RUnlock()
+	if bus, ok := buses[db]; ok {
+		return bus
+	}
+	return Events
+}
+
+var packetTopics = map[uint8]string{
+	orderbook.SyncPacket:         "sync",
+	orderbook.DiffPacket:         "diff",
+	orderbook.DegradedSyncPacket: "degraded_sync",
+	orderbook.GapPacket:          "gap",
+	orderbook.LevelAgesPacket:    "level_ages",
+	orderbook.SyncDeltaPacket:    "sync_delta",
+	orderbook.MarkPricePacket:    "mark_price",
+	orderbook.OrdersPacket:       "orders",
+}
+
+// PacketTopic returns the topic of a packet stored for product.
+func PacketTopic(product string, data []byte) string {
+	if len(data) > 0 && data[0] == orderbook.TradePacket {
+		return TradeTopic(product)
+	}
+	kind := "unknown"
+	if len(data) > 0 && packetTopics[data[0]] != "" {
+		kind = packetTopics[data[0]]
+	}
+	return "book." + product + "." + kind
+}
+
+// ProductTopics are the patterns of all packets stored for product, in the
+// order they were stored.
+func ProductTopics(product string) []string {
+	return []string{"book." + product + ".*", TradeTopic(product)}
+}
+
+func TradeTopic(product string) string {
+	return "trade." + product
+}
+
+func AlertTopic(product string) string {
+	return "alert." + product
+}
+
+// TopicMatch tells if topic matches pattern. A * matches one part of the
+// topic, a * at the end of pattern matches all remaining parts.
+func TopicMatch(pattern, topic string) bool {
+	p := strings.Split(pattern, ".")
+	t := strings.Split(topic, ".")
+	for i, part := range p {
+		if part == "*" && i == len(p)-1 {
+			return len(t) >= len(p)
+		}
+		if i >= len(t) || (part != "*" && part != t[i]) {
+			return false
+		}
+	}
+	return len(t) == len(p)
+}
+
+func (s *Subscription) matches(topic string) bool {
+	for _, pattern := range s.patterns {
+		if TopicMatch(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bus) Subscribe(patterns []string, queueSize int) *Subscription {
+	sub := &Subscription{
+		C:        make(chan *Event, queueSize),
+		patterns: patterns,
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = true
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.C)
+	}
+}
+
+// Publish never blocks the recorder, slow subscribers get dropped instead.
+func (b *Bus) Publish(events []*Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		for _, ev := range events {
+			if !sub.matches(ev.Topic) {
+				continue
+			}
+			select {
+			case sub.C <- ev:
+			default:
+				delete(b.subs, sub)
+				close(sub.C)
+			}
+			if !b.subs[sub] {
+				break
+			}
+		}
+	}
+}
+
+func (b *Bus) HasSubscribers() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs) > 0
+}
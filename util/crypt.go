@@ -0,0 +1,266 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/tsdb"
+)
+
+// EncryptionBucket holds the salt and the data key of an encrypted database,
+// the data key itself is encrypted with a key derived from the passphrase.
+const EncryptionBucket = "Encryption"
+
+// encrypted values start with a byte no packet type uses, so files with
+// data recorded before encryption was enabled stay readable
+const encryptedMarker byte = 0xfe
+
+const kdfIterations = 200000
+
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+var ErrNotEncrypted = errors.New("database is not encrypted")
+
+var ciphers = map[*bolt.DB]cipher.AEAD{}
+var ciphersMu sync.RWMutex
+
+// pbkdf2 with HMAC-SHA256 (RFC 8018)
+func deriveKey(passphrase, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+	key := []byte{}
+	block := make([]byte, 4)
+	for i := uint32(1); len(key) < keyLen; i++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(block, i)
+		prf.Write(block)
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, data []byte) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return aead.Seal(nonce, nonce, data, nil)
+}
+
+func open(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted value too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+func wrapKey(passphrase string, dataKey []byte) ([]byte, []byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	kek, err := newGCM(deriveKey([]byte(passphrase), salt, kdfIterations, 32))
+	if err != nil {
+		return nil, nil, err
+	}
+	return salt, seal(kek, dataKey), nil
+}
+
+func unwrapKey(b *bolt.Bucket, passphrase string) ([]byte, error) {
+	return unwrapDataKey(b.Get([]byte("salt")), b.Get([]byte("key")), passphrase)
+}
+
+func unwrapDataKey(salt, wrapped []byte, passphrase string) ([]byte, error) {
+	if salt == nil || wrapped == nil {
+		return nil, ErrNotEncrypted
+	}
+	kek, err := newGCM(deriveKey([]byte(passphrase), salt, kdfIterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(kek, wrapped)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return dataKey, nil
+}
+
+// EnableEncryption unlocks the data key of db with the passphrase, writable
+// databases without a key get a new random one. Afterwards all packets
+// written by a storage.BookWriter are encrypted and Cursor decrypts them.
+func EnableEncryption(db *bolt.DB, passphrase string) error {
+	var dataKey []byte
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(EncryptionBucket))
+		if b == nil {
+			return nil
+		}
+		var err error
+		dataKey, err = unwrapKey(b, passphrase)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if dataKey == nil {
+		if db.IsReadOnly() {
+			return ErrNotEncrypted
+		}
+		dataKey = make([]byte, 32)
+		if _, err := rand.Read(dataKey); err != nil {
+			return err
+		}
+		salt, wrapped, err := wrapKey(passphrase, dataKey)
+		if err != nil {
+			return err
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte(EncryptionBucket))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("salt"), salt); err != nil {
+				return err
+			}
+			return b.Put([]byte("key"), wrapped)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	ciphersMu.Lock()
+	ciphers[db] = aead
+	ciphersMu.Unlock()
+	return nil
+}
+
+// RotatePassphrase re-wraps the data key, recorded data stays untouched.
+func RotatePassphrase(db *bolt.DB, oldPassphrase, newPassphrase string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(EncryptionBucket))
+		if b == nil {
+			return ErrNotEncrypted
+		}
+		dataKey, err := unwrapKey(b, oldPassphrase)
+		if err != nil {
+			return err
+		}
+		salt, wrapped, err := wrapKey(newPassphrase, dataKey)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("salt"), salt); err != nil {
+			return err
+		}
+		return b.Put([]byte("key"), wrapped)
+	})
+}
+
+func init() {
+	tsdb.Seal = Seal
+	tsdb.Unseal = Unseal
+}
+
+func dbCipher(db *bolt.DB) cipher.AEAD {
+	ciphersMu.RLock()
+	defer ciphersMu.RUnlock()
+	return ciphers[db]
+}
+
+// Seal encrypts a value when encryption is enabled for db.
+func Seal(db *bolt.DB, data []byte) []byte {
+	aead := dbCipher(db)
+	if aead == nil {
+		return data
+	}
+	return append([]byte{encryptedMarker}, seal(aead, data)...)
+}
+
+// Unseal decrypts a value written by Seal, unencrypted values are returned
+// as they are.
+func Unseal(db *bolt.DB, data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != encryptedMarker {
+		return data, nil
+	}
+	aead := dbCipher(db)
+	if aead == nil {
+		return data, fmt.Errorf("encrypted value, no passphrase given")
+	}
+	return open(aead, data[1:])
+}
+
+// Cursor is a bolt cursor which decrypts values and expands delta syncs.
+// Values which fail to decrypt or expand are returned as stored and show up
+// as unknown packets.
+type Cursor struct {
+	db *bolt.DB
+	b  *bolt.Bucket
+	c  *bolt.Cursor
+}
+
+func NewCursor(db *bolt.DB, b *bolt.Bucket) *Cursor {
+	return &Cursor{db: db, b: b, c: b.Cursor()}
+}
+
+func (c *Cursor) unseal(key, value []byte) ([]byte, []byte) {
+	plain, err := Unseal(c.db, value)
+	if err != nil {
+		return key, value
+	}
+	if full, err := ExpandSync(c.db, c.b, plain); err == nil {
+		return key, full
+	}
+	return key, plain
+}
+
+func (c *Cursor) First() ([]byte, []byte) {
+	return c.unseal(c.c.First())
+}
+
+func (c *Cursor) Last() ([]byte, []byte) {
+	return c.unseal(c.c.Last())
+}
+
+func (c *Cursor) Next() ([]byte, []byte) {
+	return c.unseal(c.c.Next())
+}
+
+func (c *Cursor) Prev() ([]byte, []byte) {
+	return c.unseal(c.c.Prev())
+}
+
+func (c *Cursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.unseal(c.c.Seek(seek))
+}
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// Episode is a stretch of high volatility found in the recording of a
+// product, tagged with what made it stand out: velocity (of the price),
+// trades (per second) and pulls (liquidity removed without trades).
+type Episode struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Tags  []string  `json:"tags"`
+	// price change from start to end in percent
+	Move float64 `json:"move"`
+	// largest price change within one scan step, in percent per minute
+	Velocity float64 `json:"velocity"`
+	// most trades per second of one scan step
+	Trades float64 `json:"trades"`
+	// size pulled from the book during the episode
+	Pulled float64 `json:"pulled"`
+	// how many times the baseline the strongest step ran
+	Score float64 `json:"score"`
+}
+
+// EpisodesBucket keeps the episodes of a product keyed by their start.
+func EpisodesBucket(databaseKey string) string {
+	return "Episodes-" + databaseKey
+}
+
+// PutEpisodes replaces the episodes of a product starting between from and
+// to with episodes, so scanning a range again leaves no duplicates.
+func PutEpisodes(db *bolt.DB, databaseKey string, from, to time.Time, episodes []*Episode) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(EpisodesBucket(databaseKey)))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s %s", EpisodesBucket(databaseKey), err)
+		}
+		stale := [][]byte{}
+		c := b.Cursor()
+		for key, _ := c.Seek(orderbook.PackTimeKey(from)); key != nil && orderbook.UnpackTimeKey(key).Before(to); key, _ = c.Next() {
+			stale = append(stale, append([]byte{}, key...))
+		}
+		for _, key := range stale {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		for _, e := range episodes {
+			buf, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(orderbook.PackTimeKey(e.Start), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListEpisodes returns the episodes of a product starting between from and
+// to sorted by time, zero times leave the range open.
+func ListEpisodes(db *bolt.DB, databaseKey string, from, to time.Time) []*Episode {
+	episodes := []*Episode{}
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(EpisodesBucket(databaseKey)))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		key, value := c.First()
+		if !from.IsZero() {
+			key, value = c.Seek(orderbook.PackTimeKey(from))
+		}
+		for ; key != nil; key, value = c.Next() {
+			if !to.IsZero() && !orderbook.UnpackTimeKey(key).Before(to) {
+				break
+			}
+			e := &Episode{}
+			if err := json.Unmarshal(value, e); err == nil {
+				episodes = append(episodes, e)
+			}
+		}
+		return nil
+	})
+	return episodes
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// ExternalEventsBucket holds events of other sources posted to the webhook,
+// e.g. news headlines or economic releases, keyed by their time. They are
+// shared by all products unless they name some.
+const ExternalEventsBucket = "ExternalEvents"
+
+// ExternalTopic is the bus topic of newly stored external events.
+const ExternalTopic = "external"
+
+type ExternalEvent struct {
+	Time time.Time `json:"time"`
+	// who sent it, e.g. newsfeed, and what it is, e.g. headline or cpi
+	Source string `json:"source,omitempty"`
+	Kind   string `json:"kind,omitempty"`
+	Title  string `json:"title"`
+	URL    string `json:"url,omitempty"`
+	// product keys or base currencies it applies to, empty for all
+	Products []string `json:"products,omitempty"`
+}
+
+// AppliesTo tells whether the event belongs on the graph of a product.
+func (e *ExternalEvent) AppliesTo(databaseKey, baseCurrency string) bool {
+	if len(e.Products) == 0 {
+		return true
+	}
+	for _, p := range e.Products {
+		if p == databaseKey || p == baseCurrency {
+			return true
+		}
+	}
+	return false
+}
+
+// AddExternalEvents stores events, events at the same time are kept apart
+// by a nanosecond.
+func AddExternalEvents(db *bolt.DB, events []*ExternalEvent) error {
+	published := []*Event{}
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(ExternalEventsBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s %s", ExternalEventsBucket, err)
+		}
+		for _, e := range events {
+			buf, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			nano := e.Time.UnixNano()
+			for b.Get(orderbook.PackUnixNanoKey(nano)) != nil {
+				nano += 1
+			}
+			key := orderbook.PackUnixNanoKey(nano)
+			if err := b.Put(key, buf); err != nil {
+				return err
+			}
+			published = append(published, &Event{Topic: ExternalTopic, Bucket: ExternalEventsBucket, Key: key, Data: buf})
+		}
+		return nil
+	})
+	if bus := BusOf(db); err == nil && bus.HasSubscribers() {
+		bus.Publish(published)
+	}
+	return err
+}
+
+// ListExternalEvents returns the events from from until to sorted by time.
+func ListExternalEvents(db *bolt.DB, from, to time.Time) []*ExternalEvent {
+	events := []*ExternalEvent{}
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ExternalEventsBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		key, value := c.First()
+		if !from.IsZero() {
+			key, value = c.Seek(orderbook.PackTimeKey(from))
+		}
+		for ; key != nil; key, value = c.Next() {
+			if orderbook.UnpackTimeKey(key).After(to) {
+				break
+			}
+			e := &ExternalEvent{}
+			if err := json.Unmarshal(value, e); err == nil {
+				events = append(events, e)
+			}
+		}
+		return nil
+	})
+	return events
+}
@@ -0,0 +1,236 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// LevelIndexBucket holds the optional per level index of a product: the
+// indexed UTC days in "days" and every size change in "sizes", keyed by
+// price and time so the series of one level is a range scan.
+func LevelIndexBucket(databaseKey string) string {
+	return "Levels-" + databaseKey
+}
+
+type LevelPoint struct {
+	Time time.Time `json:"time"`
+	Size float64   `json:"size"`
+}
+
+func levelKey(price float64, t time.Time) []byte {
+	key := make([]byte, 16)
+	// positive floats sort like their bits
+	binary.BigEndian.PutUint64(key, math.Float64bits(price))
+	binary.BigEndian.PutUint64(key[8:], uint64(t.UnixNano()))
+	return key
+}
+
+// replayLevels calls fn with the size of every level at from and then with
+// every change until to, replayed from the last sync before from.
+func replayLevels(db *bolt.DB, product string, from, to time.Time, fn func(t time.Time, price, size float64)) error {
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := NewCursor(db, b)
+
+		key, buf := c.Seek(orderbook.PackTimeKey(from))
+		if key == nil {
+			key, buf = c.Last()
+		}
+		for key != nil && (!orderbook.IsSyncPacket(buf) || orderbook.UnpackTimeKey(key).After(from)) {
+			key, buf = c.Prev()
+		}
+		if key == nil {
+			key, buf = c.Seek(orderbook.PackTimeKey(from))
+		}
+
+		sizes := map[float64]float64{}
+		started := false
+		set := func(t time.Time, price, size float64) {
+			if sizes[price] == size {
+				return
+			}
+			if size == 0 {
+				delete(sizes, price)
+			} else {
+				sizes[price] = size
+			}
+			if started {
+				fn(t, price, size)
+			}
+		}
+
+		for ; key != nil; key, buf = c.Next() {
+			t := orderbook.UnpackTimeKey(key)
+			if t.After(to) {
+				break
+			}
+			if !started && !t.Before(from) {
+				started = true
+				for price, size := range sizes {
+					fn(from, price, size)
+				}
+			}
+			if len(buf) == 0 {
+				continue
+			}
+
+			switch {
+			case orderbook.IsSyncPacket(buf):
+				_, bids, asks := orderbook.UnpackSync(buf)
+				synced := map[float64]float64{}
+				for _, list := range [][]orderbook.OrderState{bids, asks} {
+					for _, state := range list {
+						synced[state.Price] = state.Size
+					}
+				}
+				for price := range sizes {
+					if _, ok := synced[price]; !ok {
+						set(t, price, 0)
+					}
+				}
+				for price, size := range synced {
+					set(t, price, size)
+				}
+			case buf[0] == orderbook.DiffPacket:
+				_, _, bids, asks := orderbook.UnpackDiff(buf)
+				for _, list := range [][]orderbook.OrderState{bids, asks} {
+					for _, state := range list {
+						set(t, state.Price, state.Size)
+					}
+				}
+			case buf[0] == orderbook.GapPacket:
+				// unknown until the next sync, bridged gaps keep the book
+				if _, ok := orderbook.UnpackBridgedGap(buf); ok {
+					break
+				}
+				for price := range sizes {
+					set(t, price, 0)
+				}
+			}
+		}
+		if !started {
+			for price, size := range sizes {
+				fn(from, price, size)
+			}
+		}
+		return nil
+	})
+}
+
+// IndexLevels adds one UTC day of a product to the level index.
+func IndexLevels(db *bolt.DB, product string, day time.Time) (int, error) {
+	day = day.UTC().Truncate(QualityDay)
+	type change struct {
+		t           time.Time
+		price, size float64
+	}
+	changes := []change{}
+	err := replayLevels(db, product, day, day.Add(QualityDay-time.Nanosecond), func(t time.Time, price, size float64) {
+		changes = append(changes, change{t, price, size})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(LevelIndexBucket(product)))
+		if err != nil {
+			return err
+		}
+		sizes, err := b.CreateBucketIfNotExists([]byte("sizes"))
+		if err != nil {
+			return err
+		}
+		days, err := b.CreateBucketIfNotExists([]byte("days"))
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			// bolt keeps the slice until the commit
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, math.Float64bits(c.size))
+			if err := sizes.Put(levelKey(c.price, c.t), Seal(db, value)); err != nil {
+				return err
+			}
+		}
+		return days.Put([]byte(day.Format("2006-01-02")), []byte{1})
+	})
+	return len(changes), err
+}
+
+// indexedLevels reads the series from the level index, false if a day of
+// the range is not indexed.
+func indexedLevels(db *bolt.DB, product string, price float64, from, to time.Time) ([]*LevelPoint, bool) {
+	points := []*LevelPoint{}
+	indexed := false
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(LevelIndexBucket(product)))
+		if b == nil || b.Bucket([]byte("days")) == nil || b.Bucket([]byte("sizes")) == nil {
+			return nil
+		}
+		days := b.Bucket([]byte("days"))
+		for day := from.UTC().Truncate(QualityDay); !day.After(to); day = day.Add(QualityDay) {
+			if days.Get([]byte(day.Format("2006-01-02"))) == nil {
+				return nil
+			}
+		}
+		indexed = true
+
+		prefix := levelKey(price, from)[:8]
+		c := b.Bucket([]byte("sizes")).Cursor()
+		// every indexed day starts with the sizes at midnight, the size
+		// at from is the last change of the day before it
+		pk, pv := c.Seek(levelKey(price, from))
+		if pk == nil {
+			pk, pv = c.Last()
+		} else {
+			pk, pv = c.Prev()
+		}
+		if pk != nil && bytes.HasPrefix(pk, prefix) && bytes.Compare(pk, levelKey(price, from.UTC().Truncate(QualityDay))) >= 0 {
+			if size, ok := levelSize(db, pv); ok {
+				points = append(points, &LevelPoint{Time: from, Size: size})
+			}
+		}
+		end := levelKey(price, to)
+		for key, value := c.Seek(levelKey(price, from)); key != nil && bytes.Compare(key, end) <= 0; key, value = c.Next() {
+			if size, ok := levelSize(db, value); ok {
+				points = append(points, &LevelPoint{Time: time.Unix(0, int64(binary.BigEndian.Uint64(key[8:]))), Size: size})
+			}
+		}
+		return nil
+	})
+	return points, indexed
+}
+
+func levelSize(db *bolt.DB, value []byte) (float64, bool) {
+	buf, err := Unseal(db, value)
+	if err != nil || len(buf) != 8 {
+		return 0, false
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(buf)), true
+}
+
+// LevelSeries returns the size of one price level from `from` to `to`,
+// the size at from first and then every change. Reads the level index
+// when it covers the range and replays the recording otherwise.
+func LevelSeries(db *bolt.DB, product string, price float64, from, to time.Time) ([]*LevelPoint, error) {
+	if points, ok := indexedLevels(db, product, price, from, to); ok {
+		return points, nil
+	}
+	points := []*LevelPoint{}
+	err := replayLevels(db, product, from, to, func(t time.Time, p, size float64) {
+		if p == price {
+			points = append(points, &LevelPoint{Time: t, Size: size})
+		}
+	})
+	return points, err
+}
@@ -0,0 +1,76 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// RecordingEndsBucket holds when and why the recording of a product ended
+// for good, keyed by database key.
+const RecordingEndsBucket = "RecordingEnds"
+
+// RecordingEnd marks the end of a product which was delisted or renamed by
+// its venue, nothing is recorded for it after Time.
+type RecordingEnd struct {
+	Product string    `json:"product"`
+	Time    time.Time `json:"time"`
+	Reason  string    `json:"reason"`
+	// symbol listed since the product was last seen which looks like its
+	// new name, empty if there is none
+	Successor string `json:"successor,omitempty"`
+}
+
+func EndRecording(db *bolt.DB, end *RecordingEnd) error {
+	buf, err := json.Marshal(end)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(RecordingEndsBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s %s", RecordingEndsBucket, err)
+		}
+		return b.Put([]byte(end.Product), buf)
+	})
+}
+
+// RecordingEnded returns the end of a product, nil while it is recorded.
+func RecordingEnded(db *bolt.DB, databaseKey string) *RecordingEnd {
+	var end *RecordingEnd
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(RecordingEndsBucket))
+		if b == nil {
+			return nil
+		}
+		if value := b.Get([]byte(databaseKey)); value != nil {
+			e := &RecordingEnd{}
+			if err := json.Unmarshal(value, e); err == nil {
+				end = e
+			}
+		}
+		return nil
+	})
+	return end
+}
+
+// ListRecordingEnds returns the ended products sorted by database key.
+func ListRecordingEnds(db *bolt.DB) []*RecordingEnd {
+	ends := []*RecordingEnd{}
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(RecordingEndsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(key, value []byte) error {
+			e := &RecordingEnd{}
+			if err := json.Unmarshal(value, e); err == nil {
+				ends = append(ends, e)
+			}
+			return nil
+		})
+	})
+	return ends
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// MaintenanceBucket holds the maintenance windows of all venues, keyed by
+// platform and start time.
+const MaintenanceBucket = "Maintenance"
+
+type MaintenanceWindow struct {
+	Platform string    `json:"platform"`
+	Start    time.Time `json:"start"`
+	// zero while an announced maintenance is still going on
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason"`
+}
+
+func (w *MaintenanceWindow) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && (w.End.IsZero() || t.Before(w.End))
+}
+
+func maintenanceKey(platform string, start time.Time) []byte {
+	return []byte(platform + "-" + string(orderbook.PackTimeKey(start)))
+}
+
+func SaveMaintenance(db *bolt.DB, w *MaintenanceWindow) error {
+	buf, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(MaintenanceBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(maintenanceKey(w.Platform, w.Start), buf)
+	})
+}
+
+// ListMaintenance returns the maintenance windows of a platform sorted by
+// start time.
+func ListMaintenance(db *bolt.DB, platform string) []*MaintenanceWindow {
+	windows := []*MaintenanceWindow{}
+	prefix := []byte(platform + "-")
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(MaintenanceBucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for key, value := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = c.Next() {
+			w := &MaintenanceWindow{}
+			if err := json.Unmarshal(value, w); err == nil {
+				windows = append(windows, w)
+			}
+		}
+		return nil
+	})
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	return windows
+}
@@ -0,0 +1,26 @@
+package util
+
+// OnPanic is called with the value of a panic caught by Recover before the
+// panic goes on, main writes a support bundle with it.
+var OnPanic func(r interface{})
+
+// Recover is deferred by the long running goroutines, so their panics are
+// reported like the ones of main before they end the process.
+func Recover() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if OnPanic != nil {
+		OnPanic(r)
+	}
+	panic(r)
+}
+
+// Go runs fn on a new goroutine under Recover.
+func Go(fn func()) {
+	go func() {
+		defer Recover()
+		fn()
+	}()
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+)
+
+// ProductsBucket holds the product infos of data which was not recorded by
+// an exchange client, e.g. imported from csv, keyed by DatabaseKey.
+const ProductsBucket = "Products"
+
+func SaveProductInfo(db *bolt.DB, info *product_info.Info) error {
+	buf, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(ProductsBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(info.DatabaseKey), buf)
+	})
+}
+
+func LoadProductInfos(db *bolt.DB) []*product_info.Info {
+	infos := []*product_info.Info{}
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ProductsBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(key, value []byte) error {
+			info := &product_info.Info{}
+			if err := json.Unmarshal(value, info); err == nil {
+				infos = append(infos, info)
+			}
+			return nil
+		})
+	})
+	return infos
+}
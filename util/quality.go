@@ -0,0 +1,170 @@
+package util
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/tsdb"
+)
+
+const QualityDay = 24 * time.Hour
+
+// QualityBucket kept the recording quality of a product per UTC day, keyed
+// by the date (2006-01-02), before it moved into the quality.* series.
+func QualityBucket(databaseKey string) string {
+	return "Quality-" + databaseKey
+}
+
+type Quality struct {
+	Day string `json:"day"`
+	// share of the day with packets no further apart than the allowed silence
+	Uptime float64 `json:"uptime"`
+	// times without packets for longer than the allowed silence
+	Gaps int `json:"gaps"`
+	// REST resyncs and sequence gaps the book had to be resynced after
+	Resyncs int `json:"resyncs"`
+	// failed book checks, e.g. crossed books or invalid trades
+	Failures int       `json:"failures"`
+	Packets  int       `json:"packets"`
+	Score    float64   `json:"score"`
+	Computed time.Time `json:"computed"`
+}
+
+// Rate scores the day from 0 to 100, uptime counts most, every gap costs 2
+// points, every resync 1 and every failed check 5, each capped.
+func (q *Quality) Rate() float64 {
+	score := 100 * q.Uptime
+	score -= math.Min(20, float64(2*q.Gaps))
+	score -= math.Min(20, float64(q.Resyncs))
+	score -= math.Min(30, float64(5*q.Failures))
+	q.Score = math.Max(0, math.Round(score*10)/10)
+	return q.Score
+}
+
+// PutQuality stores a day as quality.* series at the start of the day.
+func PutQuality(db *bolt.DB, databaseKey string, q *Quality) error {
+	day, err := time.Parse("2006-01-02", q.Day)
+	if err != nil {
+		return err
+	}
+	return tsdb.AppendMany(db, databaseKey, day, map[string]float64{
+		"quality.uptime":   q.Uptime,
+		"quality.gaps":     float64(q.Gaps),
+		"quality.resyncs":  float64(q.Resyncs),
+		"quality.failures": float64(q.Failures),
+		"quality.packets":  float64(q.Packets),
+		"quality.score":    q.Score,
+		"quality.computed": float64(q.Computed.Unix()),
+	})
+}
+
+// ListQuality returns the stored days of a product sorted by date, days
+// stored in QualityBucket by older versions included.
+func ListQuality(db *bolt.DB, databaseKey string) []*Quality {
+	days := map[string]*Quality{}
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(QualityBucket(databaseKey)))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(key, value []byte) error {
+			q := &Quality{}
+			if err := json.Unmarshal(value, q); err == nil {
+				days[q.Day] = q
+			}
+			return nil
+		})
+	})
+
+	series := func(metric string, fn func(q *Quality, v float64)) {
+		points, err := tsdb.Range(db, databaseKey, "quality."+metric, time.Time{}, time.Time{})
+		if err != nil {
+			return
+		}
+		for _, p := range points {
+			day := p.Time.UTC().Format("2006-01-02")
+			if days[day] == nil {
+				days[day] = &Quality{Day: day}
+			}
+			fn(days[day], p.Value)
+		}
+	}
+	series("uptime", func(q *Quality, v float64) { q.Uptime = v })
+	series("gaps", func(q *Quality, v float64) { q.Gaps = int(v) })
+	series("resyncs", func(q *Quality, v float64) { q.Resyncs = int(v) })
+	series("failures", func(q *Quality, v float64) { q.Failures = int(v) })
+	series("packets", func(q *Quality, v float64) { q.Packets = int(v) })
+	series("score", func(q *Quality, v float64) { q.Score = v })
+	series("computed", func(q *Quality, v float64) { q.Computed = time.Unix(int64(v), 0).UTC() })
+
+	list := []*Quality{}
+	for _, q := range days {
+		list = append(list, q)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Day < list[j].Day })
+	return list
+}
+
+// coverage returns how long no packet was stored for longer than
+// maxSilence between from and to, and how often.
+func coverage(db *bolt.DB, product string, from, to time.Time, maxSilence time.Duration) (time.Duration, int) {
+	var down time.Duration
+	var silences int
+	last := from
+	silence := func(t time.Time) {
+		if t.Sub(last) > maxSilence {
+			down += t.Sub(last)
+			silences += 1
+		}
+		last = t
+	}
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return nil
+		}
+		// keys only, nothing has to be unsealed
+		c := b.Cursor()
+		for key, _ := c.Seek(orderbook.PackTimeKey(from)); key != nil; key, _ = c.Next() {
+			t := orderbook.UnpackTimeKey(key)
+			if !t.Before(to) {
+				break
+			}
+			silence(t)
+		}
+		return nil
+	})
+	silence(to)
+	return down, silences
+}
+
+// RateDay scores one UTC day of a product.
+func RateDay(db *bolt.DB, product string, start time.Time, maxSilence time.Duration) (*Quality, error) {
+	end := start.Add(QualityDay)
+	report, err := ValidateProduct(db, product, start, end.Add(-time.Nanosecond))
+	if err != nil {
+		return nil, err
+	}
+	down, silences := coverage(db, product, start, end, maxSilence)
+
+	q := &Quality{
+		Day:      start.Format("2006-01-02"),
+		Uptime:   1 - (down.Seconds() / QualityDay.Seconds()),
+		Gaps:     silences,
+		Resyncs:  report.DegradedSyncs + report.IssueCounts["sequence_gap"],
+		Packets:  report.Packets,
+		Computed: time.Now().UTC(),
+	}
+	for kind, count := range report.IssueCounts {
+		// gaps count as resyncs
+		if kind != "sequence_gap" {
+			q.Failures += count
+		}
+	}
+	q.Rate()
+	return q, nil
+}
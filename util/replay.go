@@ -0,0 +1,71 @@
+package util
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// BookAt replays a product from the last sync before t and returns the
+// book as it was at t, with the time of the last packet applied.
+func BookAt(db *bolt.DB, product string, t time.Time) (*orderbook.Book, time.Time, error) {
+	book := orderbook.New(product)
+	var last time.Time
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := NewCursor(db, b)
+
+		key, buf := c.Seek(orderbook.PackTimeKey(t))
+		if key == nil {
+			key, buf = c.Last()
+		}
+		for key != nil && (!orderbook.IsSyncPacket(buf) || orderbook.UnpackTimeKey(key).After(t)) {
+			key, buf = c.Prev()
+		}
+		if key == nil {
+			return fmt.Errorf("no sync of %s before %s", product, t)
+		}
+		book.Mark, book.MarkTime = MarkBefore(db, b, key)
+
+		for ; key != nil; key, buf = c.Next() {
+			pt := orderbook.UnpackTimeKey(key)
+			if pt.After(t) {
+				break
+			}
+			book.Process(pt, buf)
+			last = pt
+		}
+		return nil
+	})
+	return book, last, err
+}
+
+// mark prices are published every few seconds, older ones are stale
+const markLookback = time.Minute
+
+// MarkBefore returns the last mark price stored in b within a minute
+// before key, so a replay starting at a sync has the mark of futures right
+// away like the live book instead of after the next update. nil for spot.
+func MarkBefore(db *bolt.DB, b *bolt.Bucket, key []byte) (*orderbook.MarkPrice, time.Time) {
+	c := NewCursor(db, b)
+	limit := orderbook.UnpackTimeKey(key).Add(-markLookback)
+	k, buf := c.Seek(key)
+	if k == nil {
+		k, buf = c.Last()
+	}
+	for ; k != nil; k, buf = c.Prev() {
+		t := orderbook.UnpackTimeKey(k)
+		if t.Before(limit) {
+			break
+		}
+		if mark := orderbook.UnpackMarkPrice(buf); mark != nil {
+			return mark, t
+		}
+	}
+	return nil, time.Time{}
+}
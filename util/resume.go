@@ -0,0 +1,59 @@
+package util
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ResumeBucket holds where the recording of every product stood when the
+// recorder last shut down, keyed by DatabaseKey, so a quick restart can
+// continue the stored book instead of starting over with a full sync.
+const ResumeBucket = "Resume"
+
+type ResumeState struct {
+	// unix nano key of the last stored packet
+	Key int64 `json:"key"`
+	// when the recorder shut down
+	Time     time.Time `json:"time"`
+	Sequence uint64    `json:"sequence"`
+	// orderbook.Book.Hash of the book after the last packet
+	Hash uint64 `json:"hash"`
+}
+
+func SaveResumeState(db *bolt.DB, product string, state *ResumeState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(ResumeBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(product), buf)
+	})
+}
+
+// LoadResumeState returns the state saved at the last shutdown, nil if
+// there is none.
+func LoadResumeState(db *bolt.DB, product string) *ResumeState {
+	var state *ResumeState
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ResumeBucket))
+		if b == nil {
+			return nil
+		}
+		value := b.Get([]byte(product))
+		if value == nil {
+			return nil
+		}
+		s := &ResumeState{}
+		if err := json.Unmarshal(value, s); err == nil {
+			state = s
+		}
+		return nil
+	})
+	return state
+}
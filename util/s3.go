@@ -0,0 +1,119 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// S3Upload puts a file to an s3://bucket/prefix destination, signed with
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and the optional
+// AWS_SESSION_TOKEN for AWS_REGION (default us-east-1). AWS_ENDPOINT_URL
+// points it at another S3 compatible store, with path style requests.
+func S3Upload(dest, name, path string) error {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return fmt.Errorf("invalid s3 destination %q, e.g. s3://bucket/prefix", dest)
+	}
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return fmt.Errorf("set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for %s", dest)
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	key := strings.Trim(strings.Trim(u.Path, "/")+"/"+name, "/")
+	host := fmt.Sprintf("%s.s3.%s.amazonaws.com", u.Host, region)
+	uri := "/" + key
+	scheme := "https"
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		e, err := url.Parse(endpoint)
+		if err != nil {
+			return err
+		}
+		scheme, host, uri = e.Scheme, e.Host, "/"+u.Host+"/"+key
+	}
+	segments := strings.Split(uri, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	uri = strings.Join(segments, "/")
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", scheme+"://"+host+uri, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+
+	// archives are large, the payload is not hashed, TLS protects it
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	headers := map[string]string{
+		"host":                 host,
+		"x-amz-content-sha256": "UNSIGNED-PAYLOAD",
+		"x-amz-date":           amzDate,
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		headers["x-amz-security-token"] = token
+	}
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if _, ok := headers["x-amz-security-token"]; ok {
+		names = append(names, "x-amz-security-token")
+	}
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+		if name != "host" {
+			req.Header.Set(name, headers[name])
+		}
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{"PUT", uri, "", canonicalHeaders, signedHeaders, "UNSIGNED-PAYLOAD"}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	scope := now.Format("20060102") + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	signingKey := []byte("AWS4" + secretKey)
+	for _, part := range []string{now.Format("20060102"), region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("s3 upload %s: %s %s", key, res.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"hash/fnv"
+	"runtime"
+)
+
+// Shards runs work on a fixed set of workers. Work for the same key always
+// lands on the same worker, so messages of one book stay in order while
+// different books are handled in parallel.
+type Shards struct {
+	queues []chan func()
+}
+
+// NewShards starts count workers, or one per available CPU when count is 0.
+func NewShards(count, queueSize int) *Shards {
+	if count <= 0 {
+		count = runtime.GOMAXPROCS(0)
+	}
+	s := &Shards{queues: make([]chan func(), count)}
+	for i := range s.queues {
+		s.queues[i] = make(chan func(), queueSize)
+		go s.work(s.queues[i])
+	}
+	return s
+}
+
+func (s *Shards) work(queue chan func()) {
+	defer Recover()
+	for fn := range queue {
+		fn()
+	}
+}
+
+func (s *Shards) queue(key string) chan func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.queues[h.Sum32()%uint32(len(s.queues))]
+}
+
+// Do queues fn on the worker of key, blocks while that queue is full.
+// Without shards fn runs right away.
+func (s *Shards) Do(key string, fn func()) {
+	if s == nil {
+		fn()
+		return
+	}
+	s.queue(key) <- fn
+}
+
+// Flush waits until all work queued for key so far is done.
+func (s *Shards) Flush(key string) {
+	if s == nil {
+		return
+	}
+	done := make(chan bool)
+	s.queue(key) <- func() { close(done) }
+	<-done
+}
+
+func (s *Shards) Count() int {
+	return len(s.queues)
+}
+
+// Queued is the number of waiting work items over all workers.
+func (s *Shards) Queued() int {
+	var n int
+	for _, queue := range s.queues {
+		n += len(queue)
+	}
+	return n
+}
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// the keyframe key can be moved a few nanoseconds by key collisions
+const keyframeSearchSteps = 16
+
+// ExpandSync returns the full sync packet for a delta sync, other packets
+// are returned as they are.
+func ExpandSync(db *bolt.DB, b *bolt.Bucket, data []byte) ([]byte, error) {
+	if !orderbook.IsSyncDeltaPacket(data) {
+		return data, nil
+	}
+	ref := orderbook.SyncDeltaReference(data)
+	c := b.Cursor()
+	key, value := c.Seek(orderbook.PackUnixNanoKey(ref))
+	for i := 0; key != nil && i < keyframeSearchSteps; i += 1 {
+		plain, err := Unseal(db, value)
+		if err != nil {
+			return data, err
+		}
+		if len(plain) > 0 && plain[0] == orderbook.SyncPacket {
+			return orderbook.ExpandSyncDelta(plain, data), nil
+		}
+		key, value = c.Next()
+	}
+	return data, fmt.Errorf("keyframe %d of delta sync not found", ref)
+}
@@ -0,0 +1,223 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+// TradeIndexBucket holds one TradeIndexEntry per stored batch of a product,
+// keyed by the first key of the batch.
+func TradeIndexBucket(databaseKey string) string {
+	return "TradeIndex-" + databaseKey
+}
+
+// TradeIndexEntry summarizes the trades of a key range so trade queries can
+// skip it without reading the packets.
+type TradeIndexEntry struct {
+	First    int64
+	Last     int64
+	Packets  uint32
+	Trades   uint32
+	MinPrice float64
+	MaxPrice float64
+	MaxSize  float64
+}
+
+func (e *TradeIndexEntry) Add(nano int64, data []byte) {
+	if e.Packets == 0 {
+		e.First = nano
+	}
+	e.Last = nano
+	e.Packets += 1
+
+	if len(data) == 0 || data[0] != orderbook.TradePacket {
+		return
+	}
+	_, price, size := orderbook.UnpackTrade(data)
+	if e.Trades == 0 {
+		e.MinPrice, e.MaxPrice = price, price
+	}
+	e.Trades += 1
+	e.MinPrice = math.Min(e.MinPrice, price)
+	e.MaxPrice = math.Max(e.MaxPrice, price)
+	e.MaxSize = math.Max(e.MaxSize, size)
+}
+
+func (e *TradeIndexEntry) pack() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, e.Last)
+	binary.Write(buf, binary.LittleEndian, e.Packets)
+	binary.Write(buf, binary.LittleEndian, e.Trades)
+	binary.Write(buf, binary.LittleEndian, e.MinPrice)
+	binary.Write(buf, binary.LittleEndian, e.MaxPrice)
+	binary.Write(buf, binary.LittleEndian, e.MaxSize)
+	return buf.Bytes()
+}
+
+func unpackTradeIndexEntry(db *bolt.DB, key, value []byte) (*TradeIndexEntry, error) {
+	plain, err := Unseal(db, value)
+	if err != nil {
+		return nil, err
+	}
+	e := &TradeIndexEntry{First: orderbook.UnpackTimeKey(key).UnixNano()}
+	buf := bytes.NewBuffer(plain)
+	binary.Read(buf, binary.LittleEndian, &e.Last)
+	binary.Read(buf, binary.LittleEndian, &e.Packets)
+	binary.Read(buf, binary.LittleEndian, &e.Trades)
+	binary.Read(buf, binary.LittleEndian, &e.MinPrice)
+	binary.Read(buf, binary.LittleEndian, &e.MaxPrice)
+	binary.Read(buf, binary.LittleEndian, &e.MaxSize)
+	return e, nil
+}
+
+// PutTradeIndex stores the entry within the transaction writing its packets.
+func PutTradeIndex(tx *bolt.Tx, db *bolt.DB, databaseKey string, e *TradeIndexEntry) error {
+	if e.Packets == 0 {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(TradeIndexBucket(databaseKey)))
+	if err != nil {
+		return err
+	}
+	b.FillPercent = 0.9
+	return b.Put(orderbook.PackUnixNanoKey(e.First), Seal(db, e.pack()))
+}
+
+type TradeFilter struct {
+	From     time.Time
+	To       time.Time
+	MinSize  float64
+	MinPrice float64
+	// 0 for no upper bound
+	MaxPrice float64
+}
+
+func (f *TradeFilter) Match(price, size float64) bool {
+	return size >= f.MinSize && price >= f.MinPrice && (f.MaxPrice == 0 || price <= f.MaxPrice)
+}
+
+func (f *TradeFilter) skip(e *TradeIndexEntry) bool {
+	return e.Trades == 0 || e.MaxSize < f.MinSize || e.MaxPrice < f.MinPrice ||
+		(f.MaxPrice != 0 && e.MinPrice > f.MaxPrice)
+}
+
+type TradeScanStats struct {
+	Chunks  int `json:"chunks"`
+	Skipped int `json:"skipped_chunks"`
+	Packets int `json:"packets_read"`
+}
+
+// ScanTrades calls fn for every trade of a product matching the filter.
+// Indexed batches without a matching trade are skipped, data stored without
+// an index is read in full.
+func ScanTrades(db *bolt.DB, databaseKey string, filter TradeFilter, fn func(t time.Time, side orderbook.Side, price, size float64)) (*TradeScanStats, error) {
+	stats := &TradeScanStats{}
+	from := int64(0)
+	if !filter.From.IsZero() {
+		from = filter.From.UnixNano()
+	}
+	to := int64(math.MaxInt64)
+	if !filter.To.IsZero() {
+		to = filter.To.UnixNano()
+	}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(databaseKey))
+		if b == nil {
+			return nil
+		}
+		c := NewCursor(db, b)
+
+		scan := func(first, last int64) {
+			if last > to {
+				last = to
+			}
+			for key, buf := c.Seek(orderbook.PackUnixNanoKey(first)); key != nil; key, buf = c.Next() {
+				if orderbook.UnpackTimeKey(key).UnixNano() > last {
+					break
+				}
+				stats.Packets += 1
+				if len(buf) == 0 || buf[0] != orderbook.TradePacket {
+					continue
+				}
+				side, price, size := orderbook.UnpackTrade(buf)
+				if filter.Match(price, size) {
+					fn(orderbook.UnpackTimeKey(key), side, price, size)
+				}
+			}
+		}
+
+		pos := from
+		if idx := tx.Bucket([]byte(TradeIndexBucket(databaseKey))); idx != nil {
+			ic := idx.Cursor()
+			start := orderbook.PackUnixNanoKey(from)
+			key, value := ic.Seek(start)
+			// the batch before may reach into the range
+			if pk, pv := ic.Prev(); pk != nil {
+				if e, err := unpackTradeIndexEntry(db, pk, pv); err == nil && e.Last >= from {
+					key, value = pk, pv
+				} else {
+					key, value = ic.Seek(start)
+				}
+			} else {
+				key, value = ic.Seek(start)
+			}
+
+			for ; key != nil && pos <= to; key, value = ic.Next() {
+				e, err := unpackTradeIndexEntry(db, key, value)
+				if err != nil {
+					return err
+				}
+				if e.First > to {
+					break
+				}
+				if e.First > pos {
+					scan(pos, e.First-1)
+				}
+				stats.Chunks += 1
+				if filter.skip(e) {
+					stats.Skipped += 1
+				} else if e.Last >= pos {
+					if e.First > pos {
+						pos = e.First
+					}
+					scan(pos, e.Last)
+				}
+				if e.Last+1 > pos {
+					pos = e.Last + 1
+				}
+			}
+		}
+		if pos <= to {
+			scan(pos, to)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// StoredTrade is a trade packet, identified by the product and its key.
+type StoredTrade struct {
+	Time  time.Time      `json:"time"`
+	Side  orderbook.Side `json:"side"`
+	Price float64        `json:"price"`
+	Size  float64        `json:"size"`
+}
+
+// LocateTrade finds the stored trade at t and price through the index,
+// nil when there is none.
+func LocateTrade(db *bolt.DB, databaseKey string, t time.Time, price float64) (*StoredTrade, error) {
+	var found *StoredTrade
+	filter := TradeFilter{From: t, To: t, MinPrice: price, MaxPrice: price}
+	_, err := ScanTrades(db, databaseKey, filter, func(t time.Time, side orderbook.Side, price, size float64) {
+		if found == nil {
+			found = &StoredTrade{Time: t, Side: side, Price: price, Size: size}
+		}
+	})
+	return found, err
+}
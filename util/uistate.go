@@ -0,0 +1,62 @@
+package util
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// UIStateBucket holds the view of every product when the viewer was last
+// used, keyed by DatabaseKey, so review sessions resume after a restart.
+const UIStateBucket = "UIState"
+
+type UIState struct {
+	// start of the viewport while replaying, zero when following live data
+	Replay       time.Time `json:"replay"`
+	PriceSteps   float64   `json:"price_steps"`
+	ViewportStep int       `json:"viewport_step"`
+	ColumnWidth  float64   `json:"column_width"`
+	// name of the active aggregation preset, empty after zooming by hand
+	Aggregation string `json:"aggregation"`
+	AutoScroll  bool   `json:"auto_scroll"`
+	// only used without AutoScroll
+	PriceScrollPosition float64 `json:"price_scroll_position"`
+	// center of the price axis locked by hand, zero when not locked
+	LockedPrice float64 `json:"locked_price,omitempty"`
+}
+
+func SaveUIState(db *bolt.DB, product string, state *UIState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(UIStateBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(product), buf)
+	})
+}
+
+// LoadUIState returns the saved view of a product, nil if there is none.
+func LoadUIState(db *bolt.DB, product string) *UIState {
+	var state *UIState
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(UIStateBucket))
+		if b == nil {
+			return nil
+		}
+		value := b.Get([]byte(product))
+		if value == nil {
+			return nil
+		}
+		s := &UIState{}
+		if err := json.Unmarshal(value, s); err == nil {
+			state = s
+		}
+		return nil
+	})
+	return state
+}
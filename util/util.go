@@ -33,6 +33,32 @@ func OpenDB(path string, buckets []string, readOnly bool) (*bolt.DB, error) {
 	return db, nil
 }
 
+// OpenScratchDB opens an empty database in a temporary file for history
+// that is not kept, without syncing to disk. CloseScratchDB removes it.
+func OpenScratchDB() (*bolt.DB, error) {
+	file, err := os.CreateTemp("", "gdax-bookmap-*.db")
+	if err != nil {
+		return nil, err
+	}
+	path := file.Name()
+	file.Close()
+	// bolt initializes empty files only
+	os.Remove(path)
+
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	db.NoSync = true
+	return db, nil
+}
+
+func CloseScratchDB(db *bolt.DB) {
+	path := db.Path()
+	db.Close()
+	os.Remove(path)
+}
+
 func CreateBucketsDB(db *bolt.DB, buckets []string) {
 	db.Update(func(tx *bolt.Tx) error {
 		for _, name := range buckets {
@@ -0,0 +1,221 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/orderbook"
+)
+
+type ValidationIssue struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail"`
+}
+
+type ValidationReport struct {
+	Product       string            `json:"product"`
+	From          time.Time         `json:"from"`
+	To            time.Time         `json:"to"`
+	Packets       int               `json:"packets"`
+	Syncs         int               `json:"syncs"`
+	DegradedSyncs int               `json:"degraded_syncs"`
+	Diffs         int               `json:"diffs"`
+	Trades        int               `json:"trades"`
+	Gaps          int               `json:"gaps"`
+	IssueCounts   map[string]int    `json:"issue_counts"`
+	Issues        []ValidationIssue `json:"issues"`
+	OK            bool              `json:"ok"`
+}
+
+const maxReportedIssues = 1000
+
+func (r *ValidationReport) Add(t time.Time, kind, detail string) {
+	r.IssueCounts[kind] += 1
+	if len(r.Issues) < maxReportedIssues {
+		r.Issues = append(r.Issues, ValidationIssue{Time: t, Kind: kind, Detail: detail})
+	}
+}
+
+type validationSide struct {
+	Levels map[float64]float64
+	Best   float64
+	Bid    bool
+}
+
+func newValidationSide(bid bool) *validationSide {
+	return &validationSide{Levels: map[float64]float64{}, Bid: bid}
+}
+
+func (s *validationSide) better(a, b float64) bool {
+	if s.Bid {
+		return a > b
+	}
+	return a < b
+}
+
+func (s *validationSide) Update(price, size float64) {
+	if size == 0 {
+		delete(s.Levels, price)
+		if price == s.Best {
+			s.Best = 0
+			for p := range s.Levels {
+				if s.Best == 0 || s.better(p, s.Best) {
+					s.Best = p
+				}
+			}
+		}
+		return
+	}
+	s.Levels[price] = size
+	if s.Best == 0 || s.better(price, s.Best) {
+		s.Best = price
+	}
+}
+
+func ValidateProduct(db *bolt.DB, product string, from, to time.Time) (*ValidationReport, error) {
+	result := &ValidationReport{Product: product, IssueCounts: map[string]int{}, Issues: []ValidationIssue{}}
+
+	var bid, ask *validationSide
+	var expected uint64
+	var synced bool
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(product))
+		if b == nil {
+			return fmt.Errorf("product %s not found", product)
+		}
+		c := NewCursor(db, b)
+
+		key, buf := c.First()
+		if !from.IsZero() {
+			// start at the last sync before from, the packets up to from
+			// only build the book and are left out of the report
+			key, buf = c.Seek(orderbook.PackTimeKey(from))
+			if key == nil {
+				key, buf = c.Last()
+			}
+			for key != nil && !orderbook.IsSyncPacket(buf) {
+				key, buf = c.Prev()
+			}
+			if key == nil {
+				key, buf = c.First()
+			}
+		}
+		lead := &ValidationReport{IssueCounts: map[string]int{}}
+		var report *ValidationReport
+
+		for ; key != nil; key, buf = c.Next() {
+			t := orderbook.UnpackTimeKey(key)
+			if !to.IsZero() && t.After(to) {
+				break
+			}
+			if t.Before(from) {
+				report = lead
+			} else {
+				report = result
+			}
+			if report.Packets == 0 {
+				report.From = t
+			}
+			report.To = t
+			report.Packets += 1
+
+			if len(buf) == 0 {
+				report.Add(t, "empty_packet", "")
+				continue
+			}
+
+			switch buf[0] {
+			case orderbook.SyncPacket, orderbook.DegradedSyncPacket:
+				if buf[0] == orderbook.SyncPacket {
+					report.Syncs += 1
+				} else {
+					report.DegradedSyncs += 1
+				}
+				seq, bids, asks := orderbook.UnpackSync(buf)
+				bid, ask = newValidationSide(true), newValidationSide(false)
+				for _, state := range bids {
+					if state.Size < 0 {
+						report.Add(t, "negative_size", fmt.Sprintf("sync bid %f size %f", state.Price, state.Size))
+					}
+					bid.Update(state.Price, state.Size)
+				}
+				for _, state := range asks {
+					if state.Size < 0 {
+						report.Add(t, "negative_size", fmt.Sprintf("sync ask %f size %f", state.Price, state.Size))
+					}
+					ask.Update(state.Price, state.Size)
+				}
+				expected = seq + 1
+				synced = true
+
+			case orderbook.DiffPacket:
+				report.Diffs += 1
+				first, last, bids, asks := orderbook.UnpackDiff(buf)
+				if !synced {
+					report.Add(t, "diff_before_sync", fmt.Sprintf("first %d last %d", first, last))
+					continue
+				}
+				if first != expected {
+					report.Add(t, "sequence_gap", fmt.Sprintf("expected %d got %d", expected, first))
+				}
+				if last < first {
+					report.Add(t, "sequence_order", fmt.Sprintf("first %d after last %d", first, last))
+				}
+				expected = last + 1
+
+				for _, state := range bids {
+					if state.Size < 0 {
+						report.Add(t, "negative_size", fmt.Sprintf("diff bid %f size %f", state.Price, state.Size))
+					}
+					bid.Update(state.Price, state.Size)
+				}
+				for _, state := range asks {
+					if state.Size < 0 {
+						report.Add(t, "negative_size", fmt.Sprintf("diff ask %f size %f", state.Price, state.Size))
+					}
+					ask.Update(state.Price, state.Size)
+				}
+
+			case orderbook.TradePacket:
+				report.Trades += 1
+				_, price, size := orderbook.UnpackTrade(buf)
+				if size <= 0 || price <= 0 || math.IsNaN(price) {
+					report.Add(t, "invalid_trade", fmt.Sprintf("price %f size %f", price, size))
+				}
+				continue
+
+			case orderbook.LevelAgesPacket, orderbook.MarkPricePacket, orderbook.OrdersPacket:
+				continue
+
+			case orderbook.GapPacket:
+				report.Gaps += 1
+				if seq, ok := orderbook.UnpackBridgedGap(buf); ok && synced {
+					expected = seq + 1
+					continue
+				}
+				synced = false
+				bid, ask = nil, nil
+				continue
+
+			default:
+				report.Add(t, "unknown_packet", fmt.Sprintf("type %d", buf[0]))
+				continue
+			}
+
+			if bid.Best != 0 && ask.Best != 0 && bid.Best >= ask.Best {
+				report.Add(t, "crossed_book", fmt.Sprintf("bid %f ask %f", bid.Best, ask.Best))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.OK = len(result.IssueCounts) == 0
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+
+	"github.com/lian/gdax-bookmap/storage"
+)
+
+// Watchdog notices when the render loop stops producing frames while the
+// recorders still store data, which usually means a GPU/driver hang.
+type Watchdog struct {
+	Timeout  time.Duration
+	DumpDir  string
+	Recreate bool
+
+	lastFrame int64
+	stalled   int32
+	recreate  int32
+}
+
+func NewWatchdog(timeout time.Duration, dumpDir string) *Watchdog {
+	return &Watchdog{
+		Timeout:   timeout,
+		DumpDir:   dumpDir,
+		lastFrame: time.Now().UnixNano(),
+	}
+}
+
+// Frame is called by the render loop after every finished frame.
+func (w *Watchdog) Frame() {
+	atomic.StoreInt64(&w.lastFrame, time.Now().UnixNano())
+	if atomic.SwapInt32(&w.stalled, 0) == 1 {
+		fmt.Println("watchdog: render loop recovered")
+	}
+}
+
+// NeedsRecreate reports once per stall if the GL context should be rebuilt.
+func (w *Watchdog) NeedsRecreate() bool {
+	return atomic.SwapInt32(&w.recreate, 0) == 1
+}
+
+func (w *Watchdog) Run() {
+	ticker := time.NewTicker(w.Timeout / 4)
+	for now := range ticker.C {
+		lastFrame := time.Unix(0, atomic.LoadInt64(&w.lastFrame))
+		if now.Sub(lastFrame) < w.Timeout {
+			continue
+		}
+		// no data either, nothing to render
+		if now.Sub(storage.LastWrite()) > w.Timeout {
+			continue
+		}
+		if !atomic.CompareAndSwapInt32(&w.stalled, 0, 1) {
+			continue
+		}
+
+		fmt.Println("watchdog: no frame since", lastFrame.Format(time.RFC3339))
+		if path, err := w.dumpStacks(now); err != nil {
+			fmt.Println("watchdog: dump stacks", err)
+		} else {
+			fmt.Println("watchdog: goroutine stacks written to", path)
+		}
+		if w.Recreate {
+			atomic.StoreInt32(&w.recreate, 1)
+		}
+	}
+}
+
+func (w *Watchdog) dumpStacks(now time.Time) (string, error) {
+	path := filepath.Join(w.DumpDir, fmt.Sprintf("bookmap-stall-%s.txt", now.Format("20060102-150405")))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return path, pprof.Lookup("goroutine").WriteTo(file, 2)
+}
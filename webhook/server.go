@@ -0,0 +1,142 @@
+// Package webhook takes timestamped events of other sources, e.g. news
+// headlines, economic releases or on-chain alerts, and stores them to be
+// drawn as markers on the graphs.
+package webhook
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// largest request body taken
+const maxBody = 1 << 20
+
+// Server stores the events posted to it.
+//
+//	POST /events   one ExternalEvent or a list of them as JSON, a missing
+//	               time is the time of the request
+//	GET  /events   the stored events as JSON, &from= and &to= limit them
+//	               to an RFC3339 range
+//
+// With a Token requests need the header Authorization: Bearer <token>.
+type Server struct {
+	Addr  string
+	DB    *bolt.DB
+	Token string
+	Mux   *http.ServeMux
+}
+
+func NewServer(addr string, db *bolt.DB, token string) *Server {
+	s := &Server{
+		Addr:  addr,
+		DB:    db,
+		Token: token,
+		Mux:   http.NewServeMux(),
+	}
+	s.Mux.HandleFunc("/events", s.handleEvents)
+	return s
+}
+
+func (s *Server) Run() {
+	fmt.Println("webhook server listening on", s.Addr)
+	if err := http.ListenAndServe(s.Addr, s.Mux); err != nil {
+		fmt.Println("webhook server", err)
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Token == "" {
+		return true
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.Token)) == 1
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		s.handlePost(w, r)
+	case http.MethodGet:
+		s.handleList(w, r)
+	default:
+		http.Error(w, "expected GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handlePost(w http.ResponseWriter, r *http.Request) {
+	events, err := decodeEvents(io.LimitReader(r.Body, maxBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	for _, e := range events {
+		if e.Title == "" {
+			http.Error(w, "event without title", http.StatusBadRequest)
+			return
+		}
+		if e.Time.IsZero() {
+			e.Time = now
+		}
+	}
+	if err := util.AddExternalEvents(s.DB, events); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"stored": len(events)})
+}
+
+// decodeEvents reads one event or a list of them.
+func decodeEvents(r io.Reader) ([]*util.ExternalEvent, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	events := []*util.ExternalEvent{}
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &events)
+	} else {
+		e := &util.ExternalEvent{}
+		err = json.Unmarshal(body, e)
+		events = append(events, e)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid events: %s", err)
+	}
+	return events, nil
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	var from, to time.Time
+	var err error
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	to = time.Now().Add(24 * time.Hour)
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(util.ListExternalEvents(s.DB, from, to))
+}
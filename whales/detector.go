@@ -0,0 +1,131 @@
+package whales
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/lian/gdax-bookmap/i18n"
+	"github.com/lian/gdax-bookmap/orderbook"
+	"github.com/lian/gdax-bookmap/orderbook/product_info"
+	"github.com/lian/gdax-bookmap/util"
+)
+
+// Whale is a trade at or above the size threshold of its product.
+type Whale struct {
+	Product     string
+	DisplayName string
+	Time        time.Time
+	Side        orderbook.Side
+	Price       float64
+	Size        float64
+	// threshold the trade was measured against, and its size in multiples
+	// of it
+	Threshold float64
+	Ratio     float64
+}
+
+// Detector collects the big trades of all products into one feed. The
+// threshold of each product is the Percentile of its last Window trade
+// sizes, so thin and busy markets are compared against themselves. Every
+// whale is bookmarked, which alerts over mqtt.
+type Detector struct {
+	DB         *bolt.DB
+	Infos      []*product_info.Info
+	Percentile float64
+	Window     int
+	// trades seen before a product has a threshold
+	MinTrades int
+	// whales kept for the feed
+	Limit int
+
+	products map[string]*product
+	mu       sync.Mutex
+	feed     []*Whale
+}
+
+type product struct {
+	info  *product_info.Info
+	sizes *orderbook.Percentiles
+}
+
+func NewDetector(db *bolt.DB, infos []*product_info.Info) *Detector {
+	return &Detector{
+		DB:         db,
+		Infos:      infos,
+		Percentile: 99.9,
+		Window:     5000,
+		MinTrades:  500,
+		Limit:      100,
+		products:   map[string]*product{},
+		feed:       []*Whale{},
+	}
+}
+
+func (d *Detector) Run() {
+	topics := []string{}
+	for _, info := range d.Infos {
+		d.products[info.DatabaseKey] = &product{info: info, sizes: orderbook.NewPercentiles(d.Window)}
+		topics = append(topics, util.TradeTopic(info.DatabaseKey))
+	}
+
+	for {
+		sub := util.Events.Subscribe(topics, 4096)
+		for ev := range sub.C {
+			d.handle(ev)
+		}
+		// the sizes seen so far are still a fair threshold
+		fmt.Println("whale detector fell behind, resubscribing")
+	}
+}
+
+func (d *Detector) handle(ev *util.Event) {
+	p, ok := d.products[ev.Bucket]
+	if !ok || len(ev.Data) == 0 || ev.Data[0] != orderbook.TradePacket {
+		return
+	}
+	t := orderbook.UnpackTimeKey(ev.Key)
+	side, price, size := orderbook.UnpackTrade(ev.Data)
+
+	// measured against the trades before it
+	threshold := p.sizes.Percentile(d.Percentile)
+	ready := p.sizes.Count() >= d.MinTrades
+	p.sizes.Add(size)
+	if !ready || threshold <= 0 || size < threshold {
+		return
+	}
+
+	w := &Whale{
+		Product:     p.info.DatabaseKey,
+		DisplayName: p.info.DisplayName,
+		Time:        t,
+		Side:        side,
+		Price:       price,
+		Size:        size,
+		Threshold:   threshold,
+		Ratio:       size / threshold,
+	}
+	d.mu.Lock()
+	d.feed = append(d.feed, w)
+	if len(d.feed) > d.Limit {
+		d.feed = d.feed[len(d.feed)-d.Limit:]
+	}
+	d.mu.Unlock()
+
+	label := i18n.Sprintf("whale %.4f @ %s (%.1fx)", size, p.info.FormatFloat(price), w.Ratio)
+	if err := util.AddBookmark(d.DB, p.info.DatabaseKey, t, label); err != nil {
+		fmt.Println("whale bookmark", err)
+	}
+}
+
+// Feed returns the kept whales of all products, newest first.
+func (d *Detector) Feed() []*Whale {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	feed := make([]*Whale, 0, len(d.feed))
+	for i := len(d.feed) - 1; i >= 0; i-- {
+		feed = append(feed, d.feed[i])
+	}
+	return feed
+}
@@ -11,6 +11,8 @@ import (
 )
 
 type KeyCallback func(*Window, glfw.Key, glfw.Action, glfw.ModifierKey)
+type MouseCallback func(*Window, glfw.MouseButton, glfw.Action, float64, float64)
+type CursorCallback func(*Window, float64, float64)
 
 type Window struct {
 	Width      int
@@ -21,6 +23,8 @@ type Window struct {
 	redrawChan        chan bool
 	redrawChanHalfLen int
 	KeyCallbacks      []KeyCallback
+	MouseCallbacks    []MouseCallback
+	CursorCallbacks   []CursorCallback
 }
 
 func NewWindow(width, height int) (*Window, error) {
@@ -41,12 +45,32 @@ func NewWindow(width, height int) (*Window, error) {
 		return nil, err
 	}
 
+	w.setupGL()
+
+	return w, nil
+}
+
+func (w *Window) setupGL() {
 	// configure global settings
 	gl.Enable(gl.DEPTH_TEST)
 	gl.DepthFunc(gl.LESS)
 	gl.ClearColor(0.18, 0.23, 0.27, 1.0)
+}
 
-	return w, nil
+// Recreate destroys the window together with its GL context and opens a
+// new one. Textures have to be set up again afterwards.
+func (w *Window) Recreate() error {
+	w.glfwWindow.Destroy()
+
+	var err error
+	if err = w.InitGL(); err != nil {
+		return err
+	}
+	if err = w.InitShader(); err != nil {
+		return err
+	}
+	w.setupGL()
+	return nil
 }
 
 func (w *Window) TriggerRedraw() {
@@ -88,6 +112,8 @@ func (w *Window) InitGL() error {
 	w.glfwWindow.SetRefreshCallback(w.refreshCallback)
 	w.glfwWindow.SetFocusCallback(w.focusCallback)
 	w.glfwWindow.SetKeyCallback(w.keyCallback)
+	w.glfwWindow.SetMouseButtonCallback(w.mouseButtonCallback)
+	w.glfwWindow.SetCursorPosCallback(w.cursorPosCallback)
 
 	if err = gl.Init(); err != nil {
 		return err
@@ -137,6 +163,28 @@ func (w *Window) AddKeyCallback(cb KeyCallback) {
 	w.KeyCallbacks = append(w.KeyCallbacks, cb)
 }
 
+func (w *Window) mouseButtonCallback(_ *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+	x, y := w.glfwWindow.GetCursorPos()
+	for _, cb := range w.MouseCallbacks {
+		cb(w, button, action, x, y)
+	}
+	w.TriggerRedraw()
+}
+
+func (w *Window) AddMouseCallback(cb MouseCallback) {
+	w.MouseCallbacks = append(w.MouseCallbacks, cb)
+}
+
+func (w *Window) cursorPosCallback(_ *glfw.Window, x, y float64) {
+	for _, cb := range w.CursorCallbacks {
+		cb(w, x, y)
+	}
+}
+
+func (w *Window) AddCursorCallback(cb CursorCallback) {
+	w.CursorCallbacks = append(w.CursorCallbacks, cb)
+}
+
 func (w *Window) SetupPerspective(width, height int, program *shader.Program) {
 	program.Use()
 